@@ -0,0 +1,529 @@
+// Package arc seals messages with Authenticated Received Chain header fields,
+// RFC 8617, so that the authentication results of a message survive when it
+// is forwarded or relayed. It also validates the ARC sets already present.
+package arc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/rs/zerolog"
+)
+
+const (
+	HeaderAAR = "ARC-Authentication-Results"
+	HeaderAMS = "ARC-Message-Signature"
+	HeaderAS  = "ARC-Seal"
+
+	// Algorithm is the only signing algorithm of RFC 8617
+	Algorithm = "rsa-sha256"
+	// MaxInstance is the maximum number of ARC sets in a message
+	MaxInstance = 50
+)
+
+// ChainStatus is the validation status of the ARC chain, the cv tag
+type ChainStatus string
+
+const (
+	ChainStatusNone ChainStatus = "none"
+	ChainStatusPass ChainStatus = "pass"
+	ChainStatusFail ChainStatus = "fail"
+)
+
+var (
+	// ErrChainFailed is returned when sealing a message whose last ARC set
+	// already has cv=fail, as no further sets should be added
+	ErrChainFailed = errors.New("arc chain already failed")
+	// ErrTooManySets is returned when the message has MaxInstance ARC sets
+	ErrTooManySets = errors.New("arc chain has the maximum number of sets")
+
+	// DefaultHeaders are signed by the ARC-Message-Signature when the selector
+	// does not list headers
+	DefaultHeaders = []string{
+		"from", "to", "cc", "subject", "date", "message-id",
+		"reply-to", "content-type", "mime-version", "dkim-signature",
+	}
+)
+
+// Set is one ARC set, the three header fields with the same instance
+type Set struct {
+	Instance int
+	AAR      *Header
+	AMS      *Header
+	AS       *Header
+}
+
+// Chain is the result of validating the ARC sets of a message
+type Chain struct {
+	Sets   []Set
+	Status ChainStatus
+	// LastCV is the cv tag of the ARC-Seal of the last set
+	LastCV ChainStatus
+	// MaxInstance is the highest instance found, also if the chain is invalid
+	MaxInstance int
+	Err         error
+}
+
+// Validate validates the ARC sets of a message: the sets must be complete and
+// numbered from 1, the last ARC-Message-Signature must verify against the
+// message, and every ARC-Seal must verify.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - slogger: Logger passed to the mox dkim lookups
+//   - resolver: Resolver for the public keys
+//   - hdrs: The header fields of the message
+//   - body: The body of the message
+//
+// Returns:
+//   - Chain: The sets and the status, with Err set when the status is fail
+func Validate(
+	ctx context.Context,
+	slogger *slog.Logger,
+	resolver moxDns.Resolver,
+	hdrs []Header,
+	body []byte,
+) Chain {
+	logger := zerolog.Ctx(ctx)
+	result := Chain{Status: ChainStatusNone}
+
+	sets := make(map[int]*Set)
+	for i := range hdrs {
+		h := &hdrs[i]
+		if h.LKey != strings.ToLower(HeaderAAR) &&
+			h.LKey != strings.ToLower(HeaderAMS) &&
+			h.LKey != strings.ToLower(HeaderAS) {
+			continue
+		}
+		instance, err := parseInstance(h)
+		if err != nil {
+			return result.fail(err)
+		}
+		result.MaxInstance = max(result.MaxInstance, instance)
+		set, ok := sets[instance]
+		if !ok {
+			set = &Set{Instance: instance}
+			sets[instance] = set
+		}
+		var target **Header
+		switch h.LKey {
+		case strings.ToLower(HeaderAAR):
+			target = &set.AAR
+		case strings.ToLower(HeaderAMS):
+			target = &set.AMS
+		default:
+			target = &set.AS
+		}
+		if *target != nil {
+			return result.fail(fmt.Errorf("duplicate %s for instance %d", h.Key, instance))
+		}
+		*target = h
+	}
+	if len(sets) == 0 {
+		return result
+	}
+
+	for instance := 1; instance <= result.MaxInstance; instance++ {
+		set, ok := sets[instance]
+		if !ok || set.AAR == nil || set.AMS == nil || set.AS == nil {
+			return result.fail(fmt.Errorf("incomplete arc set for instance %d", instance))
+		}
+		result.Sets = append(result.Sets, *set)
+	}
+	if result.MaxInstance > MaxInstance {
+		return result.fail(fmt.Errorf("more than %d arc sets", MaxInstance))
+	}
+
+	for _, set := range result.Sets {
+		tags, err := parseTags(set.AS.Value)
+		if err != nil {
+			return result.fail(fmt.Errorf("arc-seal %d: %w", set.Instance, err))
+		}
+		cv := ChainStatus(tags["cv"])
+		result.LastCV = cv
+		if set.Instance == 1 && cv != ChainStatusNone ||
+			set.Instance > 1 && cv != ChainStatusPass {
+			return result.fail(fmt.Errorf("arc-seal %d has cv=%s", set.Instance, cv))
+		}
+	}
+
+	last := result.Sets[len(result.Sets)-1]
+	err := verifyAMS(ctx, slogger, resolver, hdrs, body, last.AMS)
+	if err != nil {
+		return result.fail(fmt.Errorf("arc-message-signature %d: %w", last.Instance, err))
+	}
+	for i := len(result.Sets) - 1; i >= 0; i-- {
+		err = verifyAS(ctx, slogger, resolver, result.Sets[:i+1])
+		if err != nil {
+			return result.fail(fmt.Errorf("arc-seal %d: %w", result.Sets[i].Instance, err))
+		}
+	}
+
+	logger.Debug().Int("instances", len(result.Sets)).Msg("arc.Validate: pass")
+	result.Status = ChainStatusPass
+	return result
+}
+
+func (c Chain) fail(err error) Chain {
+	c.Status = ChainStatusFail
+	c.Err = err
+	return c
+}
+
+// parseInstance returns the i tag of an ARC header field. The
+// ARC-Authentication-Results is not a tag list, but starts with it.
+func parseInstance(h *Header) (int, error) {
+	value := unfold(h.Value)
+	first, _, _ := strings.Cut(value, ";")
+	key, instanceStr, found := strings.Cut(strings.TrimSpace(first), "=")
+	if !found || strings.TrimSpace(key) != "i" {
+		return 0, fmt.Errorf("%s without instance", h.Key)
+	}
+	instance, err := strconv.Atoi(strings.TrimSpace(instanceStr))
+	if err != nil || instance < 1 {
+		return 0, fmt.Errorf("%s with invalid instance %q", h.Key, instanceStr)
+	}
+	return instance, nil
+}
+
+// amsData returns the data signed by an ARC-Message-Signature: the signed
+// header fields, selected from the bottom of the message, and the
+// ARC-Message-Signature itself without signature
+func amsData(hdrs []Header, signed []string, ams Header, relaxed bool) []byte {
+	var b bytes.Buffer
+	used := make(map[int]bool)
+	for _, name := range signed {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			if used[i] || hdrs[i].LKey != name {
+				continue
+			}
+			used[i] = true
+			b.WriteString(canonicalHeader(hdrs[i], hdrs[i].Value, relaxed))
+			b.WriteString("\r\n")
+			break
+		}
+	}
+	b.WriteString(canonicalHeader(ams, stripSignature(ams.Value), relaxed))
+	return b.Bytes()
+}
+
+// asData returns the data signed by the ARC-Seal of the last set: all the ARC
+// header fields in order of instance, and the last ARC-Seal without signature
+func asData(sets []Set) []byte {
+	var b bytes.Buffer
+	for i, set := range sets {
+		b.WriteString(canonicalHeader(*set.AAR, set.AAR.Value, true))
+		b.WriteString("\r\n")
+		b.WriteString(canonicalHeader(*set.AMS, set.AMS.Value, true))
+		b.WriteString("\r\n")
+		if i < len(sets)-1 {
+			b.WriteString(canonicalHeader(*set.AS, set.AS.Value, true))
+			b.WriteString("\r\n")
+			continue
+		}
+		b.WriteString(canonicalHeader(*set.AS, stripSignature(set.AS.Value), true))
+	}
+	return b.Bytes()
+}
+
+func verifyAMS(
+	ctx context.Context,
+	slogger *slog.Logger,
+	resolver moxDns.Resolver,
+	hdrs []Header,
+	body []byte,
+	ams *Header,
+) error {
+	tags, err := parseTags(ams.Value)
+	if err != nil {
+		return err
+	}
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	if headerCanon == "" {
+		headerCanon = "simple"
+	}
+	if bodyCanon == "" {
+		bodyCanon = "simple"
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon == "relaxed"))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return fmt.Errorf("body hash does not match")
+	}
+	signed := strings.Split(tags["h"], ":")
+	data := amsData(hdrs, signed, *ams, headerCanon == "relaxed")
+	return verifySignature(ctx, slogger, resolver, tags, data)
+}
+
+func verifyAS(
+	ctx context.Context,
+	slogger *slog.Logger,
+	resolver moxDns.Resolver,
+	sets []Set,
+) error {
+	tags, err := parseTags(sets[len(sets)-1].AS.Value)
+	if err != nil {
+		return err
+	}
+	return verifySignature(ctx, slogger, resolver, tags, asData(sets))
+}
+
+func verifySignature(
+	ctx context.Context,
+	slogger *slog.Logger,
+	resolver moxDns.Resolver,
+	tags map[string]string,
+	data []byte,
+) error {
+	if tags["a"] != Algorithm {
+		return fmt.Errorf("unsupported algorithm %q", tags["a"])
+	}
+	domain, err := moxDns.ParseDomain(tags["d"])
+	if err != nil {
+		return fmt.Errorf("invalid domain: %w", err)
+	}
+	selector, err := moxDns.ParseDomain(tags["s"])
+	if err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	_, record, _, _, err := moxDkim.Lookup(ctx, slogger, resolver, selector, domain)
+	if err != nil {
+		return fmt.Errorf("key %s._domainkey.%s: %w", selector.ASCII, domain.ASCII, err)
+	}
+	publicKey, ok := record.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("key %s._domainkey.%s is not an rsa key", selector.ASCII, domain.ASCII)
+	}
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+}
+
+// Sealer adds an ARC set to messages
+type Sealer struct {
+	// AuthServID is the authentication service identifier of the
+	// Authentication-Results header fields to copy, usually the hostname
+	AuthServID string
+	Domain     moxDns.Domain
+	Resolver   moxDns.Resolver
+	Selector   moxDkim.Selector
+	Slogger    *slog.Logger
+	Now        func() time.Time
+}
+
+// Seal validates the ARC chain of the message and returns the header fields
+// of the next ARC set, to be prepended to the message: ARC-Seal,
+// ARC-Message-Signature and ARC-Authentication-Results. The latter holds the
+// Authentication-Results of AuthServID and the status of the chain.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - header: The header section of the message
+//   - body: The body of the message
+//
+// Returns:
+//   - string: The header fields of the new set, each ending in CRLF
+//   - Chain: The validation result of the existing chain
+//   - error: ErrChainFailed or ErrTooManySets if no set should be added, or
+//     a parse or signing error
+func (s *Sealer) Seal(
+	ctx context.Context,
+	header, body []byte,
+) (string, Chain, error) {
+	return s.SealReceived(ctx, header, header, body)
+}
+
+// SealReceived is Seal for a message whose header section was rewritten
+// after it was received. The chain is validated, and the
+// Authentication-Results copied, from the header section as received, while
+// the ARC-Message-Signature signs the header section as sent, which must
+// hold the ARC sets of the received one.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - received: The header section of the message as received
+//   - header: The header section of the message as sent
+//   - body: The body of the message
+//
+// Returns:
+//   - string: The header fields of the new set, each ending in CRLF
+//   - Chain: The validation result of the existing chain
+//   - error: As for Seal
+func (s *Sealer) SealReceived(
+	ctx context.Context,
+	received, header, body []byte,
+) (string, Chain, error) {
+	logger := zerolog.Ctx(ctx)
+
+	hdrs, err := ParseHeaders(received)
+	if err != nil {
+		return "", Chain{}, err
+	}
+	sentHdrs, err := ParseHeaders(header)
+	if err != nil {
+		return "", Chain{}, err
+	}
+	chain := Validate(ctx, s.Slogger, s.Resolver, hdrs, body)
+	if chain.LastCV == ChainStatusFail {
+		return "", chain, ErrChainFailed
+	}
+	if chain.MaxInstance >= MaxInstance {
+		return "", chain, ErrTooManySets
+	}
+	if chain.Err != nil {
+		logger.Warn().Err(chain.Err).Msg("arc.Seal: chain validation failed")
+	}
+	instance := chain.MaxInstance + 1
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	if s.Selector.PrivateKey == nil {
+		return "", chain, fmt.Errorf("no private key loaded for selector %s", s.Selector.Domain.ASCII)
+	}
+
+	// ARC-Authentication-Results
+	results := []string{}
+	for _, h := range hdrs {
+		if h.LKey != "authentication-results" {
+			continue
+		}
+		authServID, authResults, _ := strings.Cut(unfold(h.Value), ";")
+		if !strings.EqualFold(strings.TrimSpace(authServID), s.AuthServID) {
+			continue
+		}
+		authResults = collapseWSP(authResults)
+		if authResults != "" && authResults != "none" {
+			results = append(results, authResults)
+		}
+	}
+	if instance > 1 {
+		results = append(results, "arc="+string(chain.Status))
+	}
+	if len(results) == 0 {
+		results = append(results, "none")
+	}
+	aarRaw := fmt.Sprintf("%s: i=%d; %s; %s\r\n",
+		HeaderAAR, instance, s.AuthServID, strings.Join(results, "; "))
+
+	// ARC-Message-Signature
+	signed := slices.DeleteFunc(slices.Clone(s.Selector.Headers), func(name string) bool {
+		return strings.HasPrefix(strings.ToLower(name), "arc-")
+	})
+	if len(signed) == 0 {
+		signed = DefaultHeaders
+	}
+	headerCanon, bodyCanon := "simple", "simple"
+	if s.Selector.HeaderRelaxed {
+		headerCanon = "relaxed"
+	}
+	if s.Selector.BodyRelaxed {
+		bodyCanon = "relaxed"
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body, s.Selector.BodyRelaxed))
+	amsTags := []string{
+		fmt.Sprintf("i=%d", instance),
+		"a=" + Algorithm,
+		"c=" + headerCanon + "/" + bodyCanon,
+		"d=" + s.Domain.ASCII,
+		"s=" + s.Selector.Domain.ASCII,
+		fmt.Sprintf("t=%d", now.Unix()),
+		"h=" + strings.ToLower(strings.Join(signed, ":")),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	amsRaw := foldTags(HeaderAMS, amsTags)
+	ams, err := parseHeader(amsRaw)
+	if err != nil {
+		return "", chain, err
+	}
+	signature, err := s.sign(amsData(sentHdrs, signed, ams, s.Selector.HeaderRelaxed))
+	if err != nil {
+		return "", chain, err
+	}
+	amsRaw += foldSignature(signature) + "\r\n"
+
+	// ARC-Seal
+	cv := ChainStatusNone
+	if instance > 1 {
+		cv = chain.Status
+	}
+	asTags := []string{
+		fmt.Sprintf("i=%d", instance),
+		"a=" + Algorithm,
+		fmt.Sprintf("t=%d", now.Unix()),
+		"cv=" + string(cv),
+		"d=" + s.Domain.ASCII,
+		"s=" + s.Selector.Domain.ASCII,
+		"b=",
+	}
+	asRaw := foldTags(HeaderAS, asTags)
+	newSet, err := parseSet(instance, aarRaw, amsRaw, asRaw)
+	if err != nil {
+		return "", chain, err
+	}
+	signature, err = s.sign(asData(append(chain.Sets, newSet)))
+	if err != nil {
+		return "", chain, err
+	}
+	asRaw += foldSignature(signature) + "\r\n"
+
+	logger.Debug().
+		Int("instance", instance).
+		Str("cv", string(cv)).
+		Msg("arc.Seal")
+	return asRaw + amsRaw + aarRaw, chain, nil
+}
+
+func (s *Sealer) sign(data []byte) (string, error) {
+	digest := sha256.Sum256(data)
+	signature, err := s.Selector.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func parseHeader(raw string) (Header, error) {
+	hdrs, err := ParseHeaders([]byte(raw + "\r\n"))
+	if err != nil {
+		return Header{}, err
+	}
+	if len(hdrs) != 1 {
+		return Header{}, fmt.Errorf("expected a single header field, got %d", len(hdrs))
+	}
+	return hdrs[0], nil
+}
+
+func parseSet(instance int, aarRaw, amsRaw, asRaw string) (Set, error) {
+	aar, err := parseHeader(strings.TrimSuffix(aarRaw, "\r\n"))
+	if err != nil {
+		return Set{}, err
+	}
+	ams, err := parseHeader(strings.TrimSuffix(amsRaw, "\r\n"))
+	if err != nil {
+		return Set{}, err
+	}
+	as, err := parseHeader(asRaw)
+	if err != nil {
+		return Set{}, err
+	}
+	return Set{Instance: instance, AAR: &aar, AMS: &ams, AS: &as}, nil
+}
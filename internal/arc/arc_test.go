@@ -0,0 +1,237 @@
+package arc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantRelaxed string
+		wantSimple  string
+	}{
+		{
+			name:        "empty",
+			body:        "",
+			wantRelaxed: "",
+			wantSimple:  "\r\n",
+		},
+		{
+			name:        "trailing empty lines",
+			body:        "hello\r\n\r\n\r\n",
+			wantRelaxed: "hello\r\n",
+			wantSimple:  "hello\r\n",
+		},
+		{
+			name:        "whitespace",
+			body:        " a \t b  \r\nc\t\r\n",
+			wantRelaxed: " a b\r\nc\r\n",
+			wantSimple:  " a \t b  \r\nc\t\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantRelaxed, string(canonicalBody([]byte(tt.body), true)))
+			assert.Equal(t, tt.wantSimple, string(canonicalBody([]byte(tt.body), false)))
+		})
+	}
+}
+
+func TestCanonicalHeader(t *testing.T) {
+	hdrs, err := ParseHeaders([]byte("Subject:  hello \r\n\t world \r\nX-Test: a\r\n\r\nbody"))
+	require.NoError(t, err)
+	require.Len(t, hdrs, 2)
+	assert.Equal(t, "subject:hello world", canonicalHeader(hdrs[0], hdrs[0].Value, true))
+	assert.Equal(t, "Subject:  hello \r\n\t world ", canonicalHeader(hdrs[0], hdrs[0].Value, false))
+	assert.Equal(t, "x-test", hdrs[1].LKey)
+
+	_, err = ParseHeaders([]byte(" continued\r\n"))
+	assert.Error(t, err)
+}
+
+func TestSealAndValidate(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	forwarderKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	relayKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	record := func(key *rsa.PrivateKey) string {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	}
+	txts := map[string][]string{
+		"arc001._domainkey.forwarder.example.": {record(forwarderKey)},
+		"arc002._domainkey.relay.example.":     {record(relayKey)},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := dns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, name string) ([]string, adns.Result, error) {
+			result, ok := txts[name]
+			if !ok {
+				return nil, adns.Result{}, &adns.DNSError{Err: "no such host", IsNotFound: true}
+			}
+			return result, adns.Result{}, nil
+		}).
+		AnyTimes()
+
+	newSealer := func(domain, selector, authServID string, key *rsa.PrivateKey) *Sealer {
+		return &Sealer{
+			AuthServID: authServID,
+			Domain:     moxDns.Domain{ASCII: domain},
+			Resolver:   resolver,
+			Selector: moxDkim.Selector{
+				BodyRelaxed:   true,
+				Domain:        moxDns.Domain{ASCII: selector},
+				Hash:          config.HashSHA256,
+				HeaderRelaxed: true,
+				Headers:       []string{"from", "to", "subject", "arc-seal"},
+				PrivateKey:    key,
+			},
+			Slogger: slogger,
+			Now:     func() time.Time { return time.Unix(1700000000, 0) },
+		}
+	}
+
+	header := []byte("Authentication-Results: mx.forwarder.example; spf=pass smtp.mailfrom=sender.example\r\n" +
+		"From: sender@sender.example\r\n" +
+		"To: list@forwarder.example\r\n" +
+		"Subject: a rather long subject line that needs to be folded by the mail user agent\r\n" +
+		"\r\n")
+	body := []byte("hello\r\n\r\n")
+
+	// First hop
+	arcHeaders, chain, err := newSealer("forwarder.example", "arc001", "mx.forwarder.example", forwarderKey).
+		Seal(ctx, header, body)
+	require.NoError(t, err)
+	assert.Equal(t, ChainStatusNone, chain.Status)
+	assert.Contains(t, arcHeaders, "ARC-Seal: i=1; a=rsa-sha256; t=1700000000; cv=none;")
+	assert.Contains(t, arcHeaders, "ARC-Authentication-Results: i=1; mx.forwarder.example; spf=pass smtp.mailfrom=sender.example\r\n")
+	assert.Contains(t, arcHeaders, "h=from:to:subject;")
+	header = append([]byte(arcHeaders), header...)
+
+	hdrs, err := ParseHeaders(header)
+	require.NoError(t, err)
+	chain = Validate(ctx, slogger, resolver, hdrs, body)
+	require.NoError(t, chain.Err)
+	assert.Equal(t, ChainStatusPass, chain.Status)
+	assert.Len(t, chain.Sets, 1)
+
+	// Second hop, chained to the first set
+	arcHeaders, chain, err = newSealer("relay.example", "arc002", "mx.relay.example", relayKey).
+		Seal(ctx, header, body)
+	require.NoError(t, err)
+	assert.Equal(t, ChainStatusPass, chain.Status)
+	assert.Contains(t, arcHeaders, "ARC-Seal: i=2; a=rsa-sha256; t=1700000000; cv=pass;")
+	assert.Contains(t, arcHeaders, "ARC-Authentication-Results: i=2; mx.relay.example; arc=pass\r\n")
+	header = append([]byte(arcHeaders), header...)
+
+	hdrs, err = ParseHeaders(header)
+	require.NoError(t, err)
+	chain = Validate(ctx, slogger, resolver, hdrs, body)
+	require.NoError(t, chain.Err)
+	assert.Equal(t, ChainStatusPass, chain.Status)
+	assert.Len(t, chain.Sets, 2)
+
+	// Modified body breaks the last message signature
+	chain = Validate(ctx, slogger, resolver, hdrs, []byte("changed\r\n"))
+	assert.Equal(t, ChainStatusFail, chain.Status)
+	assert.ErrorContains(t, chain.Err, "body hash")
+
+	// A failed chain is sealed with cv=fail, and not sealed again after that
+	arcHeaders, chain, err = newSealer("relay.example", "arc002", "mx.relay.example", relayKey).
+		Seal(ctx, header, []byte("changed\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ChainStatusFail, chain.Status)
+	assert.Contains(t, arcHeaders, "ARC-Seal: i=3; a=rsa-sha256; t=1700000000; cv=fail;")
+	header = append([]byte(arcHeaders), header...)
+	_, _, err = newSealer("relay.example", "arc002", "mx.relay.example", relayKey).
+		Seal(ctx, header, []byte("changed\r\n"))
+	assert.ErrorIs(t, err, ErrChainFailed)
+}
+
+func TestValidateStructure(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := dns.NewMockResolver(ctrl)
+
+	tests := []struct {
+		name    string
+		header  string
+		want    ChainStatus
+		wantErr string
+	}{
+		{
+			name:   "no arc sets",
+			header: "From: a@example.com\r\n",
+			want:   ChainStatusNone,
+		},
+		{
+			name: "missing seal",
+			header: "ARC-Authentication-Results: i=1; mx.example.com; none\r\n" +
+				"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=arc; b=\r\n",
+			want:    ChainStatusFail,
+			wantErr: "incomplete arc set for instance 1",
+		},
+		{
+			name:    "missing instance",
+			header:  "ARC-Seal: a=rsa-sha256; cv=none; b=\r\n",
+			want:    ChainStatusFail,
+			wantErr: "without instance",
+		},
+		{
+			name: "gap in instances",
+			header: "ARC-Authentication-Results: i=2; mx.example.com; none\r\n" +
+				"ARC-Message-Signature: i=2; a=rsa-sha256; b=\r\n" +
+				"ARC-Seal: i=2; a=rsa-sha256; cv=pass; b=\r\n",
+			want:    ChainStatusFail,
+			wantErr: "incomplete arc set for instance 1",
+		},
+		{
+			name: "first seal with cv=pass",
+			header: "ARC-Authentication-Results: i=1; mx.example.com; none\r\n" +
+				"ARC-Message-Signature: i=1; a=rsa-sha256; b=\r\n" +
+				"ARC-Seal: i=1; a=rsa-sha256; cv=pass; b=\r\n",
+			want:    ChainStatusFail,
+			wantErr: "arc-seal 1 has cv=pass",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdrs, err := ParseHeaders([]byte(tt.header))
+			require.NoError(t, err)
+			chain := Validate(ctx, slogger, resolver, hdrs, nil)
+			assert.Equal(t, tt.want, chain.Status)
+			if tt.wantErr == "" {
+				assert.NoError(t, chain.Err)
+				return
+			}
+			assert.ErrorContains(t, chain.Err, tt.wantErr)
+		})
+	}
+}
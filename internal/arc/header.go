@@ -0,0 +1,223 @@
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Header is a header field of a message, as found in the message
+type Header struct {
+	// Key is the name of the header field, as written in the message
+	Key string
+	// LKey is the lowercase name
+	LKey string
+	// Value is everything after the colon, with folding, without the final CRLF
+	Value []byte
+	// Raw is the complete header field, including the final CRLF
+	Raw []byte
+}
+
+// ParseHeaders splits the header section of a message into its header fields.
+// Parsing stops at the empty line that ends the header section.
+//
+// Parameters:
+//   - header: The header section, optionally followed by the empty line
+//
+// Returns:
+//   - []Header: The header fields in the order of the message
+//   - error: Non-nil if a line is not a header field
+func ParseHeaders(header []byte) ([]Header, error) {
+	result := []Header{}
+	rest := header
+	for len(rest) > 0 {
+		var line []byte
+		idx := bytes.IndexByte(rest, '\n')
+		if idx < 0 {
+			line, rest = rest, nil
+		} else {
+			line, rest = rest[:idx+1], rest[idx+1:]
+		}
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if len(trimmed) == 0 {
+			break
+		}
+		if trimmed[0] == ' ' || trimmed[0] == '\t' {
+			if len(result) == 0 {
+				return nil, fmt.Errorf("continuation line without header field")
+			}
+			last := &result[len(result)-1]
+			last.Raw = append(last.Raw, line...)
+			continue
+		}
+		colon := bytes.IndexByte(trimmed, ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("invalid header field %q", trimmed)
+		}
+		key := string(bytes.TrimRight(trimmed[:colon], " \t"))
+		result = append(result, Header{
+			Key:  key,
+			LKey: strings.ToLower(key),
+			Raw:  append([]byte{}, line...),
+		})
+	}
+	for i := range result {
+		colon := bytes.IndexByte(result[i].Raw, ':')
+		result[i].Value = bytes.TrimRight(result[i].Raw[colon+1:], "\r\n")
+	}
+	return result, nil
+}
+
+// canonicalHeader returns the header field in relaxed or simple header
+// canonicalization, RFC 6376 section 3.4, without the final CRLF
+func canonicalHeader(h Header, value []byte, relaxed bool) string {
+	if !relaxed {
+		colon := bytes.IndexByte(h.Raw, ':')
+		return string(h.Raw[:colon+1]) + string(value)
+	}
+	return h.LKey + ":" + collapseWSP(unfold(value))
+}
+
+func unfold(value []byte) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(string(value))
+}
+
+// collapseWSP replaces runs of spaces and tabs with a single space and
+// removes them at the start and end
+func collapseWSP(s string) string {
+	var b strings.Builder
+	space := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// canonicalBody returns the body in relaxed or simple body canonicalization,
+// RFC 6376 section 3.4
+func canonicalBody(body []byte, relaxed bool) []byte {
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(collapseWSPKeepLeading(line), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSPKeepLeading replaces runs of spaces and tabs with a single space,
+// also at the start of the line, as body canonicalization does
+func collapseWSPKeepLeading(s string) string {
+	var b strings.Builder
+	space := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(c)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// parseTags parses a tag list, e.g. "i=1; a=rsa-sha256; d=example.com".
+// Whitespace is removed from the values of b and bh.
+func parseTags(value []byte) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, part := range strings.Split(unfold(value), ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, tagValue, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid tag %q", part)
+		}
+		key = strings.TrimSpace(key)
+		tagValue = strings.TrimSpace(tagValue)
+		if key == "b" || key == "bh" {
+			tagValue = strings.Join(strings.Fields(tagValue), "")
+		}
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("duplicate tag %s", key)
+		}
+		result[key] = tagValue
+	}
+	return result, nil
+}
+
+// stripSignature empties the value of the b tag, keeping everything else as is
+func stripSignature(value []byte) []byte {
+	parts := bytes.Split(value, []byte(";"))
+	for i, part := range parts {
+		eq := bytes.IndexByte(part, '=')
+		if eq < 0 {
+			continue
+		}
+		if string(bytes.TrimSpace(part[:eq])) == "b" {
+			parts[i] = part[:eq+1]
+		}
+	}
+	return bytes.Join(parts, []byte(";"))
+}
+
+// foldTags formats a header field from its tags, folding lines before 78
+// characters. The last tag is expected to be the signature, which is folded
+// separately.
+func foldTags(key string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(key + ":")
+	lineLen := b.Len()
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+		if lineLen+1+len(tag) > 78 {
+			b.WriteString("\r\n\t")
+			lineLen = 1
+		} else {
+			b.WriteString(" ")
+			lineLen++
+		}
+		b.WriteString(tag)
+		lineLen += len(tag)
+	}
+	return b.String()
+}
+
+// foldSignature folds the base64 signature in lines of 72 characters
+func foldSignature(signature string) string {
+	var b strings.Builder
+	for len(signature) > 72 {
+		b.WriteString(signature[:72])
+		b.WriteString("\r\n\t")
+		signature = signature[72:]
+	}
+	b.WriteString(signature)
+	return b.String()
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/crypto"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/domaincheck"
+	"github.com/stlimtat/remiges-smtp/internal/intmail"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+)
+
+// checkDomainCmd represents the command for auditing the DNS setup of a
+// sending domain before it is onboarded.
+type checkDomainCmd struct {
+	cmd *cobra.Command
+}
+
+// newCheckDomainCmd creates and initializes a new domain check command.
+// It sets up command flags, validation, and execution logic.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *checkDomainCmd: The initialized command structure
+//   - *cobra.Command: The Cobra command for CLI integration
+func newCheckDomainCmd(
+	ctx context.Context,
+) (*checkDomainCmd, *cobra.Command) {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	result := &checkDomainCmd{}
+	result.cmd = &cobra.Command{
+		Use:   "checkdomain",
+		Short: "Check SPF, DKIM, DMARC and reverse DNS of a sending domain",
+		Long: `Check that SPF authorizes the source IPs, the DKIM records match the
+configured keys, DMARC aligns and the EHLO hostname has forward-confirmed
+reverse DNS. Exits with an error if any check fails.`,
+		Args: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			cmdLogger := zerolog.Ctx(ctx)
+			cfg := config.NewCheckDomainConfig(ctx)
+			if len(cfg.Domain) < 1 {
+				cmdLogger.Fatal().
+					Err(fmt.Errorf("domain fail")).
+					Interface("cfg", cfg).
+					Msg("Missing fields")
+			}
+			ctx = config.SetContextConfig(ctx, cfg)
+			cmd.SetContext(ctx)
+			return nil
+		},
+		// Logs go to stderr, so that the results are readable
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			cmdCtx, _ := telemetry.GetLogger(cmd.Context(), cmd.ErrOrStderr())
+			cmd.SetContext(cmdCtx)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result := newCheckDomainSvc(cmd, args)
+			err = result.Run(cmd, args)
+			if err != nil {
+				logger.Error().Err(err).Msg("checkdomain.Run")
+				return err
+			}
+			return nil
+		},
+	}
+
+	result.cmd.Flags().String("domain", "", "Sending domain to check")
+	result.cmd.Flags().String("ehlo-hostname", "", "Hostname announced in EHLO, defaults to the domain")
+	result.cmd.Flags().StringSlice("source-ip", nil, "IP address mail is sent from, can be repeated")
+	err = viper.BindPFlag("check-domain", result.cmd.Flags().Lookup("domain"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("viper.BindPFlag - domain")
+	}
+	err = viper.BindPFlag("ehlo-hostname", result.cmd.Flags().Lookup("ehlo-hostname"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("viper.BindPFlag - ehlo-hostname")
+	}
+	err = viper.BindPFlag("source-ips", result.cmd.Flags().Lookup("source-ip"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("viper.BindPFlag - source-ip")
+	}
+	return result, result.cmd
+}
+
+// CheckDomainSvc handles the service layer for the domain checks.
+// It loads the configured DKIM keys and runs the checks against DNS.
+type CheckDomainSvc struct {
+	Cfg         config.CheckDomainConfig
+	Checker     *domaincheck.Checker
+	MoxResolver moxDns.Resolver
+	Slogger     *slog.Logger
+}
+
+// newCheckDomainSvc creates a new domain check service instance.
+// Failing to load the DKIM keys is reported as a failed check, not fatal.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - *CheckDomainSvc: The initialized service instance
+func newCheckDomainSvc(
+	cmd *cobra.Command,
+	_ []string,
+) *CheckDomainSvc {
+	var err error
+	result := &CheckDomainSvc{}
+	ctx := cmd.Context()
+	logger := zerolog.Ctx(ctx)
+	result.Cfg = config.GetContextConfig(ctx).(config.CheckDomainConfig)
+	result.Slogger = telemetry.GetSLogger(ctx)
+	result.MoxResolver = dns.NewConfiguredResolver(
+		ctx,
+		result.Cfg.DNS,
+		result.Slogger,
+		nil,
+	)
+
+	selectors, selectorsErr := loadDKIMSelectors(ctx, result.Cfg.MailProcessors, result.Cfg.Domain)
+	result.Checker, err = domaincheck.NewChecker(
+		ctx,
+		result.Cfg,
+		result.MoxResolver,
+		selectors,
+		result.Slogger,
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("newCheckDomainSvc.NewChecker")
+	}
+	result.Checker.SelectorsErr = selectorsErr
+	return result
+}
+
+// loadDKIMSelectors initializes the dkim processors of the configuration and
+// collects the selectors of the domain, with the private keys loaded
+func loadDKIMSelectors(
+	ctx context.Context,
+	cfgs []config.MailProcessorConfig,
+	domain string,
+) (map[string]moxDkim.Selector, error) {
+	domainCfgs, err := loadDKIMDomains(ctx, cfgs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]moxDkim.Selector)
+	for _, domainCfg := range domainCfgs {
+		if domainCfg.Domain.ASCII != domain {
+			continue
+		}
+		for name, selector := range domainCfg.DKIM.Selectors {
+			result[name] = selector
+		}
+	}
+	return result, nil
+}
+
+// loadDKIMDomains initializes the dkim processors of the configuration and
+// returns the configs of their signing domains, with the private keys loaded
+func loadDKIMDomains(
+	ctx context.Context,
+	cfgs []config.MailProcessorConfig,
+) ([]*config.DomainConfig, error) {
+	// Keys are only loaded, the writer is never used
+	tempDir, err := os.MkdirTemp("", "remiges-smtp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	keyWriter, err := crypto.NewKeyWriter(ctx, tempDir)
+	if err != nil {
+		return nil, err
+	}
+	cryptoFactory := &crypto.CryptoFactory{}
+	_, err = cryptoFactory.Init(ctx, keyWriter)
+	if err != nil {
+		return nil, err
+	}
+	processorFactory, err := intmail.NewDefaultMailProcessorFactory(ctx, cfgs, cryptoFactory)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*config.DomainConfig, 0)
+	for _, cfg := range cfgs {
+		if cfg.Type != intmail.DKIMProcessorType {
+			continue
+		}
+		processor, err := processorFactory.NewMailProcessor(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		dkimProcessor := processor.(*intmail.DKIMProcessor)
+		for _, domainCfg := range dkimProcessor.Domains {
+			result = append(result, domainCfg)
+		}
+	}
+	return result, nil
+}
+
+// Run executes the checks and prints a line per check.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - error: Non-nil if any check failed
+func (c *CheckDomainSvc) Run(
+	cmd *cobra.Command,
+	_ []string,
+) error {
+	ctx := cmd.Context()
+	logger := zerolog.Ctx(ctx)
+
+	results := c.Checker.Check(ctx)
+	err := writeCheckResults(cmd.OutOrStdout(), c.Cfg.Domain, results)
+	if err != nil {
+		logger.Error().Err(err).Msg("checkdomain.write")
+		return err
+	}
+	if domaincheck.Failed(results) {
+		return fmt.Errorf("checks failed for %s", c.Cfg.Domain)
+	}
+	return nil
+}
+
+func writeCheckResults(w io.Writer, domain string, results []domaincheck.Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "DOMAIN\t%s\n", domain)
+	fmt.Fprintln(tw, "CHECK\tSUBJECT\tRESULT\tDETAIL")
+	for _, result := range results {
+		subject := result.Subject
+		if subject == "" {
+			subject = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Check, subject, result.Status, result.Detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mjl-/adns"
+	"github.com/spf13/cobra"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/domaincheck"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewCheckDomainCmd(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	cmd, cobraCmd := newCheckDomainCmd(ctx)
+	require.NotNil(t, cmd)
+	require.NotNil(t, cobraCmd)
+
+	for _, name := range []string{"domain", "ehlo-hostname", "source-ip"} {
+		flag := cobraCmd.Flags().Lookup(name)
+		require.NotNil(t, flag, "%s flag not found", name)
+	}
+	assert.Equal(t, "stringSlice", cobraCmd.Flags().Lookup("source-ip").Value.Type())
+}
+
+func TestCheckDomainSvc_Run(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	cfg := config.CheckDomainConfig{
+		Domain:    "example.com",
+		SourceIPs: []string{"192.0.2.1"},
+	}
+	require.NoError(t, cfg.Transform(ctx))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+	resolver := dns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), gomock.Any()).
+		Return(nil, adns.Result{}, notFound).
+		AnyTimes()
+	resolver.EXPECT().
+		LookupIP(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, adns.Result{}, notFound).
+		AnyTimes()
+
+	checker, err := domaincheck.NewChecker(ctx, cfg, resolver, nil, slogger)
+	require.NoError(t, err)
+	svc := &CheckDomainSvc{
+		Cfg:     cfg,
+		Checker: checker,
+	}
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(ctx)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+
+	err = svc.Run(cmd, nil)
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "DOMAIN  example.com")
+	assert.Contains(t, out.String(), "no dkim selectors configured")
+	for _, check := range []string{"spf", "dkim", "dmarc", "fcrdns"} {
+		assert.Contains(t, out.String(), check)
+	}
+}
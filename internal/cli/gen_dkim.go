@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/rs/zerolog"
@@ -68,12 +69,20 @@ func newGenDKIMCmd(
 		},
 	}
 
+	_, rotateCmd := newGenDKIMRotateCmd(ctx)
+	result.cmd.AddCommand(rotateCmd)
+
 	result.cmd.Flags().String("algorithm", "rsa", "Key type to generate DKIM keys, dns record and config")
 	result.cmd.Flags().Int("bit-size", 2048, "Bit size of the DKIM keys")
 	result.cmd.Flags().String("dkim-domain", "", "Domain to generate DKIM keys, dns record and config")
+	result.cmd.Flags().String("format", config.GenDKIMFormatText, "Output format, text, bind, json or yaml")
 	result.cmd.Flags().String("hash", "sha256", "Hash algorithm to use for DKIM keys, dns record and config")
 	result.cmd.Flags().String("out-path", "./config", "Path to write DKIM keys, dns record and config")
+	result.cmd.Flags().StringSlice("record-flags", nil, "Flags of the TXT record t= tag, y for testing, s for strict")
+	result.cmd.Flags().StringSlice("record-hashes", nil, "Hash algorithms of the TXT record h= tag")
+	result.cmd.Flags().StringSlice("record-services", nil, "Service types of the TXT record s= tag, email or *")
 	result.cmd.Flags().String("selector", "key001", "Selector for DKIM keys")
+	result.cmd.Flags().String("write-config", "", "Config file to add the selector to")
 	err = viper.BindPFlag("algorithm", result.cmd.Flags().Lookup("algorithm"))
 	if err != nil {
 		logger.Fatal().Err(err).Msg("viper.BindPFlag - algorithm")
@@ -102,6 +111,18 @@ func newGenDKIMCmd(
 	if err != nil {
 		logger.Fatal().Err(err).Msg("viper.BindPFlag - selector")
 	}
+	for key, name := range map[string]string{
+		"record-flags":    "record-flags",
+		"record-format":   "format",
+		"record-hashes":   "record-hashes",
+		"record-services": "record-services",
+		"write-config":    "write-config",
+	} {
+		err = viper.BindPFlag(key, result.cmd.Flags().Lookup(name))
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("viper.BindPFlag - %s", name)
+		}
+	}
 	return result, result.cmd
 }
 
@@ -142,7 +163,7 @@ func newGenDKIMSvc(
 func (_ *GenDKIMSvc) Run(
 	cmd *cobra.Command,
 	_ []string,
-) error { //nolint:unparam // result 0 is always nil in this
+) error {
 	ctx := cmd.Context()
 	logger := zerolog.Ctx(ctx)
 
@@ -176,58 +197,73 @@ func (_ *GenDKIMSvc) Run(
 		return err
 	}
 
-	txtEntry, err := txtGen.Generate(ctx, cfg.Domain, cfg.Algorithm, cfg.Selector, publicKeyPEM)
-	if err != nil {
-		logger.Error().Err(err).Msg("dkim.TxtGen.Generate")
-		return err
-	}
-
-	fmt.Printf(
-		GenDKIMResult,
-		cfg.Domain,
-		txtEntry,
+	record, err := txtGen.Record(
+		ctx,
 		cfg.Domain,
-		cfg.Selector,
 		cfg.Algorithm,
-		cfg.Hash,
-		privateKeyPath,
 		cfg.Selector,
+		publicKeyPEM,
+		dkim.TxtRecordOptions{
+			Flags:    cfg.RecordFlags,
+			Hashes:   cfg.RecordHashes,
+			Services: cfg.RecordServices,
+		},
 	)
+	if err != nil {
+		logger.Error().Err(err).Msg("dkim.TxtGen.Record")
+		return err
+	}
+
+	// The key type falls back to rsa for an unknown algorithm
+	selector := config.DKIMSelectorArgs(record.KeyType, cfg.Hash, privateKeyPath, cfg.Selector)
+	processorYAML, err := config.DKIMProcessorYAML(cfg.Domain, cfg.Selector, selector)
+	if err != nil {
+		logger.Error().Err(err).Msg("config.DKIMProcessorYAML")
+		return err
+	}
 
-	return nil
+	if cfg.WriteConfig != "" {
+		err = config.AddDKIMSelector(ctx, cfg.WriteConfig, cfg.Domain, cfg.Selector, selector)
+		if err != nil {
+			logger.Error().Err(err).Msg("config.AddDKIMSelector")
+			return err
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	switch cfg.Format {
+	case config.GenDKIMFormatBIND:
+		fmt.Fprintln(out, record.BIND())
+	case config.GenDKIMFormatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(record)
+	case config.GenDKIMFormatYAML:
+		_, err = out.Write(processorYAML)
+	default:
+		configStep := fmt.Sprintf(GenDKIMConfigResult, processorYAML)
+		if cfg.WriteConfig != "" {
+			configStep = fmt.Sprintf(GenDKIMWrittenResult, cfg.Selector, cfg.WriteConfig)
+		}
+		fmt.Fprintf(out, GenDKIMResult, cfg.Domain, record.BIND(), configStep)
+	}
+	return err
 }
 
 const GenDKIMResult = `To enable DKIM for %s, add the following TXT record to your DNS:
 
 %s
 
-To ensure that DKIM is working for the smtpclient, you need to add the following to
-the smtpclient config, and this should be after merge_body:
+%s
+Then restart the smtpclient.
+`
+
+const GenDKIMConfigResult = `To ensure that DKIM is working for the smtpclient, you need to add the following to
+the smtpclient config, after mergeHeaders and before mergeBody:
 
 ` + "```" + `yaml
-mail_processors:
-  - type: dkim
-    index: 100
-    args:
-      domain-str: %s
-      dkim:
-        selectors:
-          %s:
-            algorithm: %s
-            body-relaxed: true
-            expiration: 72h
-            hash: %s
-            header-relaxed: true
-            headers:
-              - from
-              - to
-              - subject
-              - date
-              - message-id
-              - content-type
-            private-key-file: %s
-            seal-headers: false
-            selector-domain: %s
-` + "```" + `
-Then restart the smtpclient.
+%s` + "```" + `
+`
+
+const GenDKIMWrittenResult = `Selector %s has been added to the dkim mail processor in %s.
 `
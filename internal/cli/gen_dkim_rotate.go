@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/crypto"
+	"github.com/stlimtat/remiges-smtp/internal/dkim"
+)
+
+// genDKIMRotateCmd represents the command for rotating the DKIM key of a
+// domain. It generates the key of the next selector, and prints its DNS record
+// and the schedule to switch over to it.
+type genDKIMRotateCmd struct {
+	cmd *cobra.Command
+}
+
+// newGenDKIMRotateCmd creates and initializes a new DKIM rotation command.
+// The flags share their keys with gendkim, so they are bound when the
+// command runs.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *genDKIMRotateCmd: The initialized command structure
+//   - *cobra.Command: The Cobra command for CLI integration
+func newGenDKIMRotateCmd(
+	ctx context.Context,
+) (*genDKIMRotateCmd, *cobra.Command) {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	result := &genDKIMRotateCmd{}
+	result.cmd = &cobra.Command{
+		Use:   "rotate",
+		Short: "Generate the key of the next DKIM selector and the schedule to switch to it",
+		Long: `Generate the key of the next DKIM selector, print its TXT record and the
+selectors config that switches over to it at active-from. The current selector
+keeps signing for the overlap after that. The new selector is only used once
+its TXT record has propagated, and the current selector keeps signing until then,
+without restarting the server.`,
+		Args: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			cmdLogger := zerolog.Ctx(ctx)
+			err := bindGenDKIMRotateFlags(cmd)
+			if err != nil {
+				cmdLogger.Fatal().Err(err).Msg("bindGenDKIMRotateFlags")
+			}
+			cfg := config.NewGenDKIMRotateConfig(ctx)
+			if len(cfg.Domain) < 1 {
+				cmdLogger.Fatal().
+					Err(fmt.Errorf("domain fail")).
+					Interface("cfg", cfg).
+					Msg("Missing fields")
+			}
+			ctx = config.SetContextConfig(ctx, cfg)
+			cmd.SetContext(ctx)
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			result := newGenDKIMRotateSvc(cmd, args)
+			err = result.Run(cmd, args)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("genDKIMRotate.Run")
+			}
+		},
+	}
+
+	result.cmd.Flags().String("active-from", "", "Time the next selector starts signing, RFC 3339, defaults to now + propagation-delay")
+	result.cmd.Flags().String("algorithm", "rsa", "Key type of the next selector")
+	result.cmd.Flags().Int("bit-size", 2048, "Bit size of the next selector key")
+	result.cmd.Flags().String("dkim-domain", "", "Domain to rotate the DKIM key of")
+	result.cmd.Flags().String("hash", "sha256", "Hash algorithm of the next selector")
+	result.cmd.Flags().String("next-selector", "", "Next selector, defaults to incrementing the number of the current selector")
+	result.cmd.Flags().String("out-path", "./config", "Path to write the next selector key")
+	result.cmd.Flags().Duration("overlap", config.DefaultDKIMRotateOverlap, "Time the current selector keeps signing after active-from")
+	result.cmd.Flags().Duration("propagation-delay", config.DefaultDKIMPropagationDelay, "Time allowed for the TXT record to propagate")
+	result.cmd.Flags().String("selector", "key001", "Current selector")
+	return result, result.cmd
+}
+
+func bindGenDKIMRotateFlags(cmd *cobra.Command) error {
+	for _, name := range []string{
+		"active-from",
+		"algorithm",
+		"bit-size",
+		"dkim-domain",
+		"hash",
+		"next-selector",
+		"out-path",
+		"overlap",
+		"propagation-delay",
+		"selector",
+	} {
+		err := viper.BindPFlag(name, cmd.Flags().Lookup(name))
+		if err != nil {
+			return fmt.Errorf("viper.BindPFlag - %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// GenDKIMRotateSvc handles the service layer for DKIM key rotation.
+type GenDKIMRotateSvc struct {
+	Cfg config.GenDKIMRotateConfig
+}
+
+// newGenDKIMRotateSvc creates a new DKIM rotation service instance.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - *GenDKIMRotateSvc: The initialized service instance
+func newGenDKIMRotateSvc(
+	cmd *cobra.Command,
+	_ []string,
+) *GenDKIMRotateSvc {
+	result := &GenDKIMRotateSvc{}
+	ctx := cmd.Context()
+	result.Cfg = config.GetContextConfig(ctx).(config.GenDKIMRotateConfig)
+	return result
+}
+
+// Run generates the key of the next selector and prints its TXT record and
+// the rotation schedule.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - error: Non-nil if the key cannot be generated or written
+func (s *GenDKIMRotateSvc) Run(
+	cmd *cobra.Command,
+	_ []string,
+) error {
+	ctx := cmd.Context()
+	logger := zerolog.Ctx(ctx)
+
+	factory := &crypto.CryptoFactory{}
+	keyWriter, err := crypto.NewKeyWriter(ctx, s.Cfg.OutPath)
+	if err != nil {
+		logger.Error().Err(err).Msg("crypto.NewKeyWriter")
+		return err
+	}
+	_, err = factory.Init(ctx, keyWriter)
+	if err != nil {
+		logger.Error().Err(err).Msg("crypto.CryptoFactory.Init")
+		return err
+	}
+
+	publicKeyPEM, privateKeyPEM, err := factory.GenerateKey(ctx, s.Cfg.BitSize, s.Cfg.Domain, s.Cfg.Algorithm)
+	if err != nil {
+		logger.Error().Err(err).Msg("crypto.CryptoFactory.GenerateKey")
+		return err
+	}
+	// The key of the current selector is kept until the overlap ends
+	keyID := s.Cfg.Domain + "." + s.Cfg.NextSelector
+	_, privateKeyPath, err := factory.WriteKey(ctx, keyID, publicKeyPEM, privateKeyPEM)
+	if err != nil {
+		logger.Error().Err(err).Msg("crypto.CryptoFactory.WriteKey")
+		return err
+	}
+
+	txtGen := &dkim.TxtGen{}
+	txtEntry, err := txtGen.Generate(ctx, s.Cfg.Domain, s.Cfg.Algorithm, s.Cfg.NextSelector, publicKeyPEM)
+	if err != nil {
+		logger.Error().Err(err).Msg("dkim.TxtGen.Generate")
+		return err
+	}
+
+	fmt.Fprintf(
+		cmd.OutOrStdout(),
+		GenDKIMRotateResult,
+		s.Cfg.NextSelector,
+		s.Cfg.Domain,
+		txtEntry,
+		s.Cfg.ActiveFrom.Format(time.RFC3339),
+		s.Cfg.Selector,
+		s.Cfg.Selector,
+		s.Cfg.ActiveFrom.Add(s.Cfg.Overlap).Format(time.RFC3339),
+		s.Cfg.NextSelector,
+		s.Cfg.ActiveFrom.Format(time.RFC3339),
+		s.Cfg.Algorithm,
+		s.Cfg.Hash,
+		privateKeyPath,
+		s.Cfg.NextSelector,
+		s.Cfg.Selector,
+	)
+	return nil
+}
+
+const GenDKIMRotateResult = `To rotate to selector %s for %s, add the following TXT record to your DNS now:
+
+%s
+
+Then update the selectors of the dkim mail processor and deploy the config before %s.
+The switch happens at that time without a restart. The new selector is only used
+once its TXT record has propagated, and %s keeps signing until then:
+
+` + "```" + `yaml
+      dkim:
+        selectors:
+          %s:
+            # keep the current settings
+            active-until: %s
+          %s:
+            active-from: %s
+            algorithm: %s
+            body-relaxed: true
+            expiration: 72h
+            hash: %s
+            header-relaxed: true
+            headers:
+              - from
+              - to
+              - subject
+              - date
+              - message-id
+              - content-type
+            private-key-file: %s
+            seal-headers: false
+            selector-domain: %s
+` + "```" + `
+Remove the TXT record and key of %s once active-until has passed.
+`
@@ -1,15 +1,19 @@
 package cli
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/internal/crypto"
+	"github.com/stlimtat/remiges-smtp/internal/dkim"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,9 +53,14 @@ func TestNewGenDKIMCmd(t *testing.T) {
 				{"algorithm", "", "string"},
 				{"bit-size", "", "int"},
 				{"dkim-domain", "", "string"},
+				{"format", "", "string"},
 				{"hash", "", "string"},
 				{"out-path", "", "string"},
+				{"record-flags", "", "stringSlice"},
+				{"record-hashes", "", "stringSlice"},
+				{"record-services", "", "stringSlice"},
 				{"selector", "", "string"},
+				{"write-config", "", "string"},
 			}
 
 			for _, flag := range flags {
@@ -59,6 +68,13 @@ func TestNewGenDKIMCmd(t *testing.T) {
 				require.NotNil(t, f, "flag %s not found", flag.name)
 				assert.Equal(t, flag.valueType, f.Value.Type(), "flag %s has wrong type", flag.name)
 			}
+
+			subcmds := cobraCmd.Commands()
+			require.Len(t, subcmds, 1)
+			assert.Equal(t, "rotate", subcmds[0].Name())
+			for _, name := range []string{"active-from", "next-selector", "overlap", "propagation-delay"} {
+				assert.NotNil(t, subcmds[0].Flags().Lookup(name), "rotate flag %s not found", name)
+			}
 		})
 	}
 }
@@ -137,6 +153,136 @@ func TestGenDKIMSvc_Run(t *testing.T) {
 	}
 }
 
+func TestGenDKIMSvc_RunFormats(t *testing.T) {
+	tests := []struct {
+		name         string
+		algorithm    string
+		format       string
+		flags        []string
+		writeConfig  bool
+		wantContains []string
+		wantJSON     bool
+	}{
+		{
+			name:         "text",
+			algorithm:    crypto.KeyTypeRSA,
+			format:       config.GenDKIMFormatText,
+			wantContains: []string{"key001._domainkey.example.com IN TXT (", "mail-processors:", "selector-domain: key001"},
+		},
+		{
+			name:         "bind ed25519 testing",
+			algorithm:    crypto.KeyTypeEd25519,
+			format:       config.GenDKIMFormatBIND,
+			flags:        []string{"y"},
+			wantContains: []string{"key001._domainkey.example.com IN TXT \"v=DKIM1; k=ed25519; t=y; p="},
+		},
+		{
+			name:      "json",
+			algorithm: crypto.KeyTypeRSA,
+			format:    config.GenDKIMFormatJSON,
+			wantJSON:  true,
+		},
+		{
+			name:         "yaml",
+			algorithm:    crypto.KeyTypeEd25519,
+			format:       config.GenDKIMFormatYAML,
+			wantContains: []string{"mail-processors:", "algorithm: ed25519"},
+		},
+		{
+			name:         "write config",
+			algorithm:    crypto.KeyTypeRSA,
+			format:       config.GenDKIMFormatText,
+			writeConfig:  true,
+			wantContains: []string{"Selector key001 has been added to the dkim mail processor"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			telemetry.SetGlobalLogLevel(zerolog.ErrorLevel)
+			tmpDir := t.TempDir()
+
+			cfg := config.GenDKIMConfig{
+				Algorithm:   tt.algorithm,
+				BitSize:     2048,
+				Domain:      "example.com",
+				Format:      tt.format,
+				Hash:        "sha256",
+				OutPath:     tmpDir,
+				RecordFlags: tt.flags,
+				Selector:    "key001",
+			}
+			if tt.writeConfig {
+				cfg.WriteConfig = filepath.Join(tmpDir, "config.yaml")
+				require.NoError(t, os.WriteFile(cfg.WriteConfig, []byte("mail-processors: []\n"), 0600))
+			}
+			ctx = config.SetContextConfig(ctx, cfg)
+			cmd := &cobra.Command{}
+			cmd.SetContext(ctx)
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+
+			svc := newGenDKIMSvc(cmd, nil)
+			err := svc.Run(cmd, nil)
+			require.NoError(t, err)
+			for _, want := range tt.wantContains {
+				assert.Contains(t, out.String(), want)
+			}
+			if tt.wantJSON {
+				var record dkim.TxtRecord
+				require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+				assert.Equal(t, "key001._domainkey.example.com.", record.Name)
+				assert.Len(t, record.Strings, 2)
+			}
+			if tt.writeConfig {
+				data, err := os.ReadFile(cfg.WriteConfig)
+				require.NoError(t, err)
+				assert.Contains(t, string(data), "private-key-file: "+filepath.Join(tmpDir, "example.com.pem"))
+			}
+		})
+	}
+}
+
+func TestGenDKIMRotateSvc_Run(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	telemetry.SetGlobalLogLevel(zerolog.ErrorLevel)
+	tmpDir := t.TempDir()
+
+	cfg := config.GenDKIMRotateConfig{
+		GenDKIMConfig: config.GenDKIMConfig{
+			Algorithm: crypto.KeyTypeEd25519,
+			Domain:    "example.com",
+			Hash:      "sha256",
+			OutPath:   tmpDir,
+			Selector:  "key001",
+		},
+		ActiveFromStr: "2025-07-01T00:00:00Z",
+		Overlap:       24 * time.Hour,
+	}
+	require.NoError(t, cfg.Transform(ctx, time.Now()))
+	ctx = config.SetContextConfig(ctx, cfg)
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(ctx)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+
+	svc := newGenDKIMRotateSvc(cmd, nil)
+	err := svc.Run(cmd, nil)
+	require.NoError(t, err)
+
+	for _, file := range []string{"example.com.key002.pem", "example.com.key002.pub"} {
+		_, err := os.Stat(filepath.Join(tmpDir, file))
+		assert.NoError(t, err, "file %s should exist", file)
+	}
+	assert.Contains(t, out.String(), "key002._domainkey.example.com IN TXT")
+	assert.Contains(t, out.String(), "active-until: 2025-07-02T00:00:00Z")
+	assert.Contains(t, out.String(), "active-from: 2025-07-01T00:00:00Z")
+	assert.Contains(t, out.String(), "key001 keeps signing until then")
+	assert.NotContains(t, out.String(), "%!")
+}
+
 // func TestGenDKIMCmd_ArgsValidation(t *testing.T) {
 // 	tests := []struct {
 // 		name        string
@@ -27,18 +27,23 @@ type GenericSvc struct {
 	Cfg                    config.SendMailConfig
 	CryptoFactory          *crypto.CryptoFactory
 	DialerFactory          sendmail.INetDialerFactory
+	FileQueue              file.IFileQueue
 	FileReader             file.IFileReader
 	FileReadTracker        file.IFileReadTracker
 	KeyWriter              crypto.IKeyWriter
+	Lifecycle              file.IFileLifecycle
 	MailProcessor          intmail.IMailProcessor
 	MailSender             sendmail.IMailSender
 	MailTransformerFactory *file_mail.MailTransformerFactory
 	MoxResolver            moxDns.Resolver
+	MXCache                dns.IMXCache
 	MyOutput               output.IOutput
 	MyResolver             dns.IResolver
 	RedisClient            *redis.Client
+	ResultStore            output.IResultStore
 	SendMailService        *sendmail.SendMailService
 	Slogger                *slog.Logger
+	TTLObserver            *dns.TTLObserver
 }
 
 // newGenericSvc initializes a new generic service with all required dependencies.
@@ -78,14 +83,103 @@ func newGenericSvc( //nolint:funlen // this sets up a generic application contex
 		ctx,
 		result.RedisClient,
 	)
-	result.FileReader, err = file.NewDefaultFileReader(
-		ctx,
-		result.Cfg.ReadFileConfig.InPath,
-		result.FileReadTracker,
-	)
+	switch {
+	case result.Cfg.Input.Type == config.InputTypeEML:
+		result.FileReader, err = file.NewEMLFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+		)
+	case result.Cfg.Input.Type == config.InputTypeJob:
+		result.FileReader, err = file.NewJobFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+		)
+	case result.Cfg.Input.Type == config.InputTypeMaildir:
+		result.FileReader, err = file.NewMaildirFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+		)
+	case result.Cfg.Input.Type == config.InputTypeMbox:
+		result.FileReader, err = file.NewMboxFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.Cfg.Input.MboxFormat,
+			result.Cfg.Input.MboxID,
+			result.Cfg.Input.ProgressFile,
+			result.FileReadTracker,
+		)
+	case result.Cfg.Input.Type == config.InputTypeStream:
+		result.FileReader, err = file.NewStreamFileReader(
+			ctx,
+			result.RedisClient,
+			result.Cfg.ReadFileConfig.InPath,
+			result.Cfg.Input.Stream,
+			result.Cfg.Input.Group,
+			result.Cfg.Input.Consumer,
+			result.Cfg.Input.ClaimTimeout,
+			result.Cfg.Input.MaxDeliveries,
+			result.Cfg.Input.DeadLetterStream,
+			result.FileReadTracker,
+		)
+	case result.Cfg.ReadFileConfig.Incremental:
+		result.FileReader, err = file.NewScanFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+			result.Cfg.ReadFileConfig.ShardDepth,
+			result.Cfg.ReadFileConfig.Order,
+			result.Cfg.ReadFileConfig.FullScanInterval,
+		)
+	case result.Cfg.ReadFileConfig.Watch:
+		result.FileReader, err = file.NewWatchFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+			result.Cfg.ReadFileConfig.RescanInterval,
+			result.Cfg.ReadFileConfig.SettleDelay,
+		)
+	default:
+		result.FileReader, err = file.NewDefaultFileReader(
+			ctx,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+		)
+	}
 	if err != nil {
 		logger.Fatal().Err(err).Msg("newSendMailSvc.FileReader")
 	}
+	if result.Cfg.Lanes.Enabled {
+		laneRules := make([]file.LaneRule, 0, len(result.Cfg.Lanes.Lanes))
+		for _, lane := range result.Cfg.Lanes.Lanes {
+			laneRules = append(laneRules, file.LaneRule{
+				Name:        lane.Name,
+				MaxPriority: lane.MaxPriority,
+			})
+		}
+		result.FileReader, err = file.NewLaneFileReader(
+			ctx,
+			result.FileReader,
+			result.Cfg.ReadFileConfig.InPath,
+			result.FileReadTracker,
+			result.Cfg.Lanes.Source,
+			result.Cfg.Lanes.Header,
+			result.Cfg.Lanes.DefaultPriority,
+			laneRules,
+		)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("newSendMailSvc.LaneFileReader")
+		}
+	}
+	// The messages api and the smtp server hand their messages to the
+	// workers through the queue
+	if (result.Cfg.API.Enabled && result.Cfg.API.Mode == config.APIModeQueue) ||
+		(result.Cfg.SMTPServer.Enabled && result.Cfg.SMTPServer.Mode == config.SMTPServerModeQueue) {
+		result.FileQueue = file.NewQueueFileReader(ctx, result.FileReader, result.FileReadTracker)
+		result.FileReader = result.FileQueue
+	}
 	result.MailTransformerFactory = file_mail.NewMailTransformerFactory(
 		ctx,
 		result.Cfg.ReadFileConfig.FileMails,
@@ -94,9 +188,11 @@ func newGenericSvc( //nolint:funlen // this sets up a generic application contex
 	if err != nil {
 		logger.Fatal().Err(err).Msg("newSendMailSvc.MailTransformerFactory.Init")
 	}
+	result.ResultStore = output.NewRedisResultStore(ctx, result.RedisClient)
 	outputFactory := output.NewOutputFactory(
 		ctx,
 		result.FileReadTracker,
+		result.ResultStore,
 	)
 	_, err = outputFactory.NewOutputs(ctx, result.Cfg.Outputs)
 	if err != nil {
@@ -131,13 +227,28 @@ func newGenericSvc( //nolint:funlen // this sets up a generic application contex
 	}
 	result.MailProcessor = mailProcessorFactory
 
-	result.MoxResolver = moxDns.StrictResolver{
-		Log: result.Slogger,
+	result.TTLObserver = dns.NewTTLObserver(ctx)
+	result.MoxResolver = dns.NewConfiguredResolver(
+		ctx,
+		result.Cfg.DNS,
+		result.Slogger,
+		result.TTLObserver,
+	)
+	switch {
+	case !result.Cfg.DNS.Cache.Enabled:
+		result.MXCache = nil
+	case result.Cfg.DNS.Cache.Backend == config.DNSCacheBackendRedis:
+		result.MXCache = dns.NewRedisMXCache(ctx, result.RedisClient)
+	default:
+		result.MXCache = dns.NewMemoryMXCache(ctx)
 	}
 	result.MyResolver = dns.NewResolver(
 		ctx,
 		result.MoxResolver,
 		result.Slogger,
+		result.MXCache,
+		result.Cfg.DNS.Cache,
+		result.TTLObserver,
 	)
 	result.MailSender = sendmail.NewMailSender(
 		ctx,
@@ -156,8 +267,47 @@ func newGenericSvc( //nolint:funlen // this sets up a generic application contex
 		result.MailTransformerFactory,
 		result.MyOutput,
 		result.Cfg.ReadFileConfig.PollInterval,
+		sendmail.NewSandbox(ctx, result.Cfg.Sandbox),
 	)
+	// The workers of the lanes replace the concurrency workers
+	if result.Cfg.Lanes.Enabled {
+		for _, lane := range result.Cfg.Lanes.Lanes {
+			result.SendMailService.Lanes = append(result.SendMailService.Lanes, sendmail.NewLane(
+				ctx,
+				lane.Name,
+				lane.Concurrency,
+				lane.Rate,
+				lane.Burst,
+			))
+		}
+	}
+	// A mail sent again is only sent to the recipients left
+	if recipients, ok := result.FileReadTracker.(file.IRecipientTracker); ok {
+		result.SendMailService.Recipients = recipients
+	}
+	if result.Cfg.Lifecycle.Enabled {
+		result.Lifecycle, err = file.NewFileLifecycle(
+			ctx,
+			result.Cfg.Lifecycle.Path,
+			result.Cfg.Lifecycle.Dated,
+			result.Cfg.Lifecycle.Gzip,
+			result.Cfg.Lifecycle.Retention,
+			result.Cfg.Lifecycle.CleanupInterval,
+		)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("newGenericSvc.Lifecycle")
+		}
+		result.SendMailService.Lifecycle = result.Lifecycle
+	}
 
+	// The alignment processor checks dmarc with the signing domains of the
+	// dkim processor
+	var dkimSigner intmail.IDKIMSigner
+	for _, mailProcessor := range mailProcessorFactory.Processors {
+		if dkimProcessor, ok := mailProcessor.(*intmail.DKIMProcessor); ok {
+			dkimSigner = dkimProcessor
+		}
+	}
 	// This is a hack to inject the crypto factory into the dkim processor
 	for _, mailProcessor := range mailProcessorFactory.Processors {
 		if reflect.TypeOf(mailProcessor) == reflect.TypeOf(&intmail.DKIMProcessor{}) {
@@ -166,6 +316,21 @@ func newGenericSvc( //nolint:funlen // this sets up a generic application contex
 			if err != nil {
 				logger.Fatal().Err(err).Msg("newGenericSvc.DKIMProcessor.InitDKIMCrypto")
 			}
+			dkimProcessor.InitResolver(ctx, result.MoxResolver, result.Slogger)
+		}
+		// The alignment processor checks dns with the configured resolver
+		if alignmentProcessor, ok := mailProcessor.(*intmail.AlignmentProcessor); ok {
+			alignmentProcessor.InitResolver(ctx, result.MoxResolver, result.Slogger)
+			if dkimSigner != nil {
+				alignmentProcessor.InitSigner(ctx, dkimSigner)
+			}
+		}
+		if arcProcessor, ok := mailProcessor.(*intmail.ARCProcessor); ok {
+			err = arcProcessor.InitARCCrypto(ctx, result.CryptoFactory)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("newGenericSvc.ARCProcessor.InitARCCrypto")
+			}
+			arcProcessor.InitResolver(ctx, result.MoxResolver, result.Slogger)
 		}
 	}
 	return result
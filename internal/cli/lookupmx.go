@@ -2,9 +2,12 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"strings"
+	"text/tabwriter"
 
 	moxDns "github.com/mjl-/mox/dns"
 	"github.com/rs/zerolog"
@@ -12,19 +15,19 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/internal/dns"
-	"github.com/stlimtat/remiges-smtp/internal/sendmail"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
 )
 
-// lookupMXCmd represents the command for looking up MX (Mail Exchange) DNS records
-// for a given domain. It provides functionality to query and display the mail server
-// configuration for email domains.
+// lookupMXCmd represents the command for reporting the mail routing of domains:
+// MX records, mail host addresses, DNSSEC, TLSA, MTA-STS and TLS-RPT.
 type lookupMXCmd struct {
 	cmd *cobra.Command
 }
 
 // newLookupMXCmd creates and initializes a new MX lookup command.
 // It sets up command flags, validation, and execution logic.
+// Domains can be passed as arguments, with --lookup-domain or in --domains-file.
 //
 // Parameters:
 //   - ctx: Context for logging and cancellation
@@ -40,14 +43,17 @@ func newLookupMXCmd(
 
 	result := &lookupMXCmd{}
 	result.cmd = &cobra.Command{
-		Use:   "lookupmx",
-		Short: "Lookup MX DNS records for provided domain",
-		Long:  `Lookup MX DNS records for provided domain`,
-		Args: func(cmd *cobra.Command, _ []string) error {
+		Use:   "lookupmx [domain...]",
+		Short: "Report MX, TLS and MTA-STS records for provided domains",
+		Long: `Report the MX records of the provided domains, with the addresses,
+DNSSEC status and TLSA records of each mail host, the MTA-STS policy and
+the TLS-RPT record. The report is printed as a table or as json.`,
+		Args: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 			cmdLogger := zerolog.Ctx(ctx)
+			viper.Set("domains", append(viper.GetStringSlice("domains"), args...))
 			cfg := config.NewLookupMXConfig(ctx)
-			if len(cfg.Domain) < 1 {
+			if len(cfg.Domains) < 1 {
 				cmdLogger.Fatal().
 					Err(fmt.Errorf("domain fail")).
 					Interface("cfg", cfg).
@@ -57,6 +63,11 @@ func newLookupMXCmd(
 			cmd.SetContext(ctx)
 			return nil
 		},
+		// Logs go to stderr, so that the report can be piped
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			cmdCtx, _ := telemetry.GetLogger(cmd.Context(), cmd.ErrOrStderr())
+			cmd.SetContext(cmdCtx)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			result := newLookupMXSvc(cmd, args)
 			err = result.Run(cmd, args)
@@ -69,23 +80,30 @@ func newLookupMXCmd(
 	}
 
 	result.cmd.Flags().StringP("lookup-domain", "l", "", "Domain to lookup mx entries")
+	result.cmd.Flags().StringP("domains-file", "f", "", "File with one domain per line to lookup mx entries")
+	result.cmd.Flags().StringP("output", "o", config.LookupMXOutputTable, "Output format, table or json")
 	err = viper.BindPFlag("domain", result.cmd.Flags().Lookup("lookup-domain"))
 	if err != nil {
 		logger.Fatal().Err(err).Msg("viper.BindPFlag")
 	}
+	err = viper.BindPFlag("domains-file", result.cmd.Flags().Lookup("domains-file"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("viper.BindPFlag")
+	}
+	err = viper.BindPFlag("output", result.cmd.Flags().Lookup("output"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("viper.BindPFlag")
+	}
 	return result, result.cmd
 }
 
 // LookupMXSvc handles the service layer for MX record lookups.
-// It manages DNS resolution and provides functionality to query MX records
-// for email domains.
+// It manages DNS resolution and reports the mail routing of domains.
 type LookupMXSvc struct {
-	Cfg           config.LookupMXConfig
-	DialerFactory sendmail.INetDialerFactory
-	MailSender    sendmail.IMailSender
-	MoxResolver   moxDns.Resolver
-	MyResolver    dns.IResolver
-	Slogger       *slog.Logger
+	Cfg         config.LookupMXConfig
+	MoxResolver moxDns.Resolver
+	Reporter    *dns.MXReporter
+	Slogger     *slog.Logger
 }
 
 // newLookupMXSvc creates a new MX lookup service instance.
@@ -106,10 +124,13 @@ func newLookupMXSvc(
 	ctx := cmd.Context()
 	result.Cfg = config.GetContextConfig(ctx).(config.LookupMXConfig)
 	result.Slogger = telemetry.GetSLogger(ctx)
-	result.MoxResolver = moxDns.StrictResolver{
-		Log: result.Slogger,
-	}
-	result.MyResolver = dns.NewResolver(
+	result.MoxResolver = dns.NewConfiguredResolver(
+		ctx,
+		result.Cfg.DNS,
+		result.Slogger,
+		nil,
+	)
+	result.Reporter = dns.NewMXReporter(
 		ctx,
 		result.MoxResolver,
 		result.Slogger,
@@ -117,16 +138,15 @@ func newLookupMXSvc(
 	return result
 }
 
-// Run executes the MX record lookup process.
-// It queries the DNS system for MX records of the specified domain
-// and logs the results.
+// Run reports the mail routing of the configured domains, and writes the
+// reports to the command output in the configured format.
 //
 // Parameters:
 //   - cmd: The Cobra command instance
 //   - args: Command arguments
 //
 // Returns:
-//   - error: Non-nil if the lookup process fails
+//   - error: Non-nil if a domain is invalid, or a lookup failed temporarily
 func (l *LookupMXSvc) Run(
 	cmd *cobra.Command,
 	_ []string,
@@ -134,27 +154,114 @@ func (l *LookupMXSvc) Run(
 	ctx := cmd.Context()
 	logger := zerolog.Ctx(ctx)
 
-	if l.Cfg.Domain == "" {
+	if len(l.Cfg.Domains) < 1 {
 		logger.Warn().Msg("domain is empty")
 		return fmt.Errorf("domain is empty")
 	}
-	sublogger := logger.With().Str("domain", l.Cfg.Domain).Logger()
 
-	domain, err := moxDns.ParseDomain(l.Cfg.Domain)
-	if err != nil {
-		sublogger.Error().Err(err).Msg("moxDns.ParseDomain")
-		return err
+	// Validate all domains before doing any lookups
+	domains := make([]moxDns.Domain, 0, len(l.Cfg.Domains))
+	for _, domainStr := range l.Cfg.Domains {
+		domain, err := moxDns.ParseDomain(domainStr)
+		if err != nil {
+			logger.Error().Err(err).Str("domain", domainStr).Msg("moxDns.ParseDomain")
+			return err
+		}
+		domains = append(domains, domain)
 	}
-	if !strings.HasSuffix(domain.ASCII, ".") {
-		domain.ASCII += "."
+
+	reports := make([]*dns.MXReport, 0, len(domains))
+	failed := 0
+	for _, domain := range domains {
+		report := l.Reporter.Report(ctx, domain)
+		if report.Status == dn.MX_STATUS_TEMP_FAILURE.String() {
+			failed++
+		}
+		reports = append(reports, report)
 	}
 
-	result, err := l.MyResolver.LookupMX(ctx, domain)
+	var err error
+	switch l.Cfg.Output {
+	case config.LookupMXOutputJSON:
+		err = writeMXReportsJSON(cmd.OutOrStdout(), reports)
+	default:
+		err = writeMXReportsTable(cmd.OutOrStdout(), reports)
+	}
 	if err != nil {
-		sublogger.Error().Err(err).Msg("mailSender.LookupMX")
+		logger.Error().Err(err).Msg("lookupmx.write")
 		return err
 	}
-
-	sublogger.Info().Interface("result", result).Msg("LookupMX")
+	if failed > 0 {
+		return fmt.Errorf("lookup failed for %d of %d domains", failed, len(reports))
+	}
 	return nil
 }
+
+func writeMXReportsJSON(w io.Writer, reports []*dns.MXReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
+
+func writeMXReportsTable(w io.Writer, reports []*dns.MXReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "DOMAIN\t%s\n", report.Domain)
+		fmt.Fprintf(tw, "STATUS\t%s\n", report.Status)
+		fmt.Fprintf(tw, "DNSSEC\t%s\n", yesNo(report.DNSSEC))
+		if report.Error != "" {
+			fmt.Fprintf(tw, "ERROR\t%s\n", report.Error)
+		}
+		if len(report.Hosts) > 0 {
+			fmt.Fprintln(tw, "PREF\tHOST\tADDRESSES\tDNSSEC\tTLSA")
+			for _, host := range report.Hosts {
+				addresses := strings.Join(host.Addresses, ", ")
+				if host.Error != "" {
+					addresses = "error: " + host.Error
+				}
+				tlsa := strings.Join(host.TLSA, ", ")
+				if tlsa == "" {
+					tlsa = "-"
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+					host.Preference, host.Host, addresses, yesNo(host.DNSSEC), tlsa)
+			}
+		}
+		fmt.Fprintf(tw, "MTA-STS\t%s\n", mtastsSummary(report.MTASTS))
+		fmt.Fprintf(tw, "TLS-RPT\t%s\n", tlsrptSummary(report.TLSRPT))
+	}
+	return tw.Flush()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func mtastsSummary(report dns.MTASTSReport) string {
+	switch {
+	case report.Mode != "":
+		return fmt.Sprintf("%s, max-age %d, mx %s",
+			report.Mode, report.MaxAge, strings.Join(report.MX, ", "))
+	case report.Error != "":
+		return "error: " + report.Error
+	default:
+		return "-"
+	}
+}
+
+func tlsrptSummary(report dns.TLSRPTReport) string {
+	switch {
+	case report.Error != "":
+		return "error: " + report.Error
+	case len(report.RUAs) > 0:
+		return strings.Join(report.RUAs, ", ")
+	default:
+		return "-"
+	}
+}
@@ -1,14 +1,19 @@
 package cli
 
 import (
+	"bytes"
 	"context"
+	"net"
 	"testing"
 
+	"github.com/mjl-/adns"
 	"github.com/spf13/cobra"
 	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
 )
 
 func TestNewLookupMXCmd(t *testing.T) {
@@ -40,6 +45,11 @@ func TestNewLookupMXCmd(t *testing.T) {
 			flag := cobraCmd.Flags().Lookup("lookup-domain")
 			require.NotNil(t, flag, "lookup-domain flag not found")
 			assert.Equal(t, "string", flag.Value.Type(), "lookup-domain flag has wrong type")
+			flag = cobraCmd.Flags().Lookup("domains-file")
+			require.NotNil(t, flag, "domains-file flag not found")
+			flag = cobraCmd.Flags().Lookup("output")
+			require.NotNil(t, flag, "output flag not found")
+			assert.Equal(t, config.LookupMXOutputTable, flag.DefValue)
 		})
 	}
 }
@@ -72,10 +82,10 @@ func TestNewLookupMXSvc(t *testing.T) {
 
 			if tt.expectError {
 				// Verify that critical components are nil
-				assert.Nil(t, svc.MyResolver)
+				assert.Nil(t, svc.Reporter)
 			} else {
 				// Verify that all components are initialized
-				assert.NotNil(t, svc.MyResolver)
+				assert.NotNil(t, svc.Reporter)
 				assert.NotNil(t, svc.MoxResolver)
 				assert.NotNil(t, svc.Slogger)
 				assert.Equal(t, "example.com", svc.Cfg.Domain)
@@ -85,24 +95,54 @@ func TestNewLookupMXSvc(t *testing.T) {
 }
 
 func TestLookupMXSvc_Run(t *testing.T) {
+	notFound := &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true}
+
 	tests := []struct {
-		name        string
-		domain      string
-		expectError bool
+		name         string
+		domains      []string
+		output       string
+		mxErr        error
+		wantContains []string
+		expectError  bool
 	}{
 		{
-			name:        "successful lookup",
-			domain:      "example.com",
-			expectError: false,
+			name:    "table output",
+			domains: []string{"example.com", "example.org"},
+			output:  config.LookupMXOutputTable,
+			wantContains: []string{
+				"DOMAIN   example.com",
+				"DOMAIN   example.org",
+				"STATUS   found",
+				"10       mail.example.com  192.0.2.1  no      -",
+				"MTA-STS  -",
+			},
+		},
+		{
+			name:    "json output",
+			domains: []string{"example.com"},
+			output:  config.LookupMXOutputJSON,
+			wantContains: []string{
+				`"domain": "example.com"`,
+				`"status": "found"`,
+				`"host": "mail.example.com"`,
+				`"preference": 10`,
+			},
+		},
+		{
+			name:        "temporary failure",
+			domains:     []string{"example.com"},
+			output:      config.LookupMXOutputTable,
+			mxErr:       &adns.DNSError{Err: "server misbehaving", Name: "example.com.", IsTemporary: true},
+			expectError: true,
 		},
 		{
 			name:        "invalid domain",
-			domain:      "invalid domain",
+			domains:     []string{"example.com", "invalid domain"},
 			expectError: true,
 		},
 		{
 			name:        "empty domain",
-			domain:      "",
+			domains:     []string{},
 			expectError: true,
 		},
 	}
@@ -113,20 +153,48 @@ func TestLookupMXSvc_Run(t *testing.T) {
 			ctx, _ = telemetry.InitLogger(ctx)
 
 			cfg := config.LookupMXConfig{
-				Domain: tt.domain,
+				Domains: tt.domains,
+				Output:  tt.output,
 			}
+			require.NoError(t, cfg.Transform(ctx))
 			ctx = config.SetContextConfig(ctx, cfg)
 
 			cmd := &cobra.Command{}
 			cmd.SetContext(ctx)
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			resolver := dns.NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupMX(gomock.Any(), gomock.Any()).
+				Return([]*net.MX{{Host: "mail.example.com.", Pref: 10}}, adns.Result{}, tt.mxErr).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupIP(gomock.Any(), "ip", "mail.example.com.").
+				Return([]net.IP{net.ParseIP("192.0.2.1")}, adns.Result{}, nil).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupTLSA(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil, adns.Result{}, notFound).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), gomock.Any()).
+				Return(nil, adns.Result{}, notFound).
+				AnyTimes()
 
 			svc := newLookupMXSvc(cmd, nil)
+			svc.Reporter.Resolver = resolver
 
 			err := svc.Run(cmd, nil)
 			if tt.expectError {
 				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, want := range tt.wantContains {
+				assert.Contains(t, out.String(), want)
 			}
 		})
 	}
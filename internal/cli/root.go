@@ -60,18 +60,22 @@ func NewRootCmd(ctx context.Context) *rootCmd {
 	if err != nil {
 		logger.Fatal().Err(err).Msg("viper.BindPFlag.debug")
 	}
+	_, checkDomainCmd := newCheckDomainCmd(ctx)
 	_, genDKIMCmd := newGenDKIMCmd(ctx)
 	_, lookupMXCmd := newLookupMXCmd(ctx)
 	_, readFileCmd := newReadFileCmd(ctx)
 	_, sendMailCmd := newSendMailCmd(ctx)
 	_, serverCmd := newServerCmd(ctx)
+	_, verifyDKIMCmd := newVerifyDKIMCmd(ctx)
 
 	result.cmd.AddCommand(
+		checkDomainCmd,
 		genDKIMCmd,
 		lookupMXCmd,
 		readFileCmd,
 		sendMailCmd,
 		serverCmd,
+		verifyDKIMCmd,
 	)
 
 	return result
@@ -20,11 +20,13 @@ func TestNewRootCmd(t *testing.T) {
 			name: "normal context",
 			ctx:  context.Background(),
 			expectedSubcmds: []string{
+				"checkdomain",
 				"gendkim",
 				"lookupmx",
 				"readfile",
 				"sendmail",
 				"server",
+				"verify-dkim",
 			},
 		},
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	rhttp "github.com/stlimtat/remiges-smtp/internal/http"
+	"github.com/stlimtat/remiges-smtp/internal/smtpd"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -48,7 +49,8 @@ func newServerCmd(ctx context.Context) (*serverCmd, *cobra.Command) {
 type Server struct {
 	AdminSvr *http.Server
 	*GenericSvc
-	Gin *gin.Engine
+	Gin     *gin.Engine
+	SMTPSvr *smtpd.Server
 }
 
 func newServer(
@@ -74,6 +76,40 @@ func newServer(
 	if err != nil {
 		logger.Fatal().Err(err).Msg("http.NewAdminRoutes")
 	}
+	if result.Cfg.API.Enabled {
+		messagesHandler, err := rhttp.NewMessagesHandler(
+			ctx,
+			result.Cfg.API,
+			result.Cfg.ReadFileConfig.InPath,
+			result.Cfg.Input.Type,
+			result.FileQueue,
+			result.FileReadTracker,
+			result.ResultStore,
+		)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("http.NewMessagesHandler")
+		}
+		err = rhttp.RegisterMessageRoutes(ctx, result.Gin, messagesHandler)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("http.RegisterMessageRoutes")
+		}
+	}
+
+	if result.Cfg.SMTPServer.Enabled {
+		var spooler smtpd.ISpooler
+		if result.Cfg.SMTPServer.Mode == config.SMTPServerModeQueue {
+			spooler, err = smtpd.NewQueueSpooler(ctx, result.Cfg.ReadFileConfig.InPath, result.FileQueue)
+		} else {
+			spooler, err = smtpd.NewDirSpooler(ctx, result.Cfg.ReadFileConfig.InPath)
+		}
+		if err != nil {
+			logger.Fatal().Err(err).Msg("smtpd.NewSpooler")
+		}
+		result.SMTPSvr, err = smtpd.NewServer(ctx, result.Cfg.SMTPServer, spooler)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("smtpd.NewServer")
+		}
+	}
 
 	result.AdminSvr = &http.Server{
 		Addr:              ":8000",
@@ -109,6 +145,17 @@ func (s *Server) Run(ctx context.Context) error {
 		return err
 	})
 
+	if s.SMTPSvr != nil {
+		eg.Go(func() error {
+			// The smtp server stops accepting on ctx.Done, and waits for its sessions
+			err := s.SMTPSvr.ListenAndServe(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("SMTPSvr.ListenAndServe")
+			}
+			return err
+		})
+	}
+
 	eg.Go(func() error {
 		// fileReader is able to stop based on ctx.Done
 		return s.GenericSvc.SendMailService.Run(ctx)
@@ -0,0 +1,294 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dkim"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+)
+
+// verifyDKIMCmd represents the command for verifying the DKIM signatures of a
+// message file.
+type verifyDKIMCmd struct {
+	cmd *cobra.Command
+}
+
+// newVerifyDKIMCmd creates and initializes a new DKIM verification command.
+// The output flag shares its key with lookupmx, so the flags are bound when
+// the command runs.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *verifyDKIMCmd: The initialized command structure
+//   - *cobra.Command: The Cobra command for CLI integration
+func newVerifyDKIMCmd(
+	ctx context.Context,
+) (*verifyDKIMCmd, *cobra.Command) {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	result := &verifyDKIMCmd{}
+	result.cmd = &cobra.Command{
+		Use:   "verify-dkim <file.eml>",
+		Short: "Verify the DKIM signatures of a message file",
+		Long: `Verify every DKIM-Signature of a message file, and report per signature
+the header signature, the body hash, the canonicalization and the key, with
+warnings for weak keys and risky settings. Public keys are looked up in DNS,
+except for selectors given with --key selector=path, and with --config-keys
+the selectors of the configured dkim processors. Exits with an error if a
+signature does not pass.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			err := cobra.ExactArgs(1)(cmd, args)
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+			cmdLogger := zerolog.Ctx(ctx)
+			err = bindVerifyDKIMFlags(cmd)
+			if err != nil {
+				cmdLogger.Fatal().Err(err).Msg("bindVerifyDKIMFlags")
+			}
+			cfg := config.NewVerifyDKIMConfig(ctx, args[0])
+			ctx = config.SetContextConfig(ctx, cfg)
+			cmd.SetContext(ctx)
+			return nil
+		},
+		// Logs go to stderr, so that the results are readable
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			cmdCtx, _ := telemetry.GetLogger(cmd.Context(), cmd.ErrOrStderr())
+			cmd.SetContext(cmdCtx)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result := newVerifyDKIMSvc(cmd, args)
+			err = result.Run(cmd, args)
+			if err != nil {
+				logger.Error().Err(err).Msg("verify-dkim.Run")
+				return err
+			}
+			return nil
+		},
+	}
+
+	result.cmd.Flags().Bool("config-keys", false, "Use the keys of the configured dkim selectors instead of DNS")
+	result.cmd.Flags().StringArray("key", nil, "Public or private key PEM of a selector, as selector=path, can be repeated")
+	result.cmd.Flags().StringP("output", "o", config.VerifyDKIMOutputText, "Output format, text or json")
+	return result, result.cmd
+}
+
+func bindVerifyDKIMFlags(cmd *cobra.Command) error {
+	for key, name := range map[string]string{
+		"config-keys": "config-keys",
+		"output":      "output",
+		"verify-keys": "key",
+	} {
+		err := viper.BindPFlag(key, cmd.Flags().Lookup(name))
+		if err != nil {
+			return fmt.Errorf("viper.BindPFlag - %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// VerifyDKIMSvc handles the service layer for the DKIM verification of a
+// message file.
+type VerifyDKIMSvc struct {
+	Cfg      config.VerifyDKIMConfig
+	Verifier *dkim.Verifier
+}
+
+// newVerifyDKIMSvc creates a new DKIM verification service instance, with
+// the local keys loaded.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - *VerifyDKIMSvc: The initialized service instance
+func newVerifyDKIMSvc(
+	cmd *cobra.Command,
+	_ []string,
+) *VerifyDKIMSvc {
+	result := &VerifyDKIMSvc{}
+	ctx := cmd.Context()
+	logger := zerolog.Ctx(ctx)
+	result.Cfg = config.GetContextConfig(ctx).(config.VerifyDKIMConfig)
+	slogger := telemetry.GetSLogger(ctx)
+	keyResolver := dkim.NewKeyResolver(dns.NewConfiguredResolver(
+		ctx,
+		result.Cfg.DNS,
+		slogger,
+		nil,
+	))
+	err := loadVerifyKeys(ctx, result.Cfg, keyResolver)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("newVerifyDKIMSvc.loadVerifyKeys")
+	}
+	result.Verifier = &dkim.Verifier{
+		Resolver: keyResolver,
+		Slogger:  slogger,
+	}
+	return result
+}
+
+// loadVerifyKeys adds the keys of the configured selectors, with
+// config-keys, and of the key files to the resolver. Key files take
+// precedence.
+func loadVerifyKeys(
+	ctx context.Context,
+	cfg config.VerifyDKIMConfig,
+	keyResolver *dkim.KeyResolver,
+) error {
+	if cfg.ConfigKeys {
+		domainCfgs, err := loadDKIMDomains(ctx, cfg.MailProcessors)
+		if err != nil {
+			return err
+		}
+		for _, domainCfg := range domainCfgs {
+			for _, selector := range domainCfg.DKIM.Selectors {
+				if selector.PrivateKey == nil {
+					continue
+				}
+				err = keyResolver.AddDomainKey(
+					selector.Domain.ASCII,
+					domainCfg.Domain.ASCII,
+					selector.PrivateKey.Public(),
+					dkim.KeySourceConfig,
+				)
+				if err != nil {
+					return fmt.Errorf("selector %s of %s: %w", selector.Domain.ASCII, domainCfg.Domain.ASCII, err)
+				}
+			}
+		}
+	}
+	for selector, path := range cfg.KeyFiles {
+		data, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		publicKey, err := dkim.ParsePublicKeyPEM(data)
+		if err != nil {
+			return fmt.Errorf("key of selector %s in %s: %w", selector, path, err)
+		}
+		err = keyResolver.AddSelectorKey(selector, publicKey, dkim.KeySourceFile)
+		if err != nil {
+			return fmt.Errorf("key of selector %s in %s: %w", selector, path, err)
+		}
+	}
+	return nil
+}
+
+// VerifyDKIMReport is the json output of verify-dkim
+type VerifyDKIMReport struct {
+	File       string              `json:"file"`
+	Signatures []dkim.VerifyResult `json:"signatures"`
+}
+
+// Run verifies the signatures of the message file and writes a report in the
+// configured format.
+//
+// Parameters:
+//   - cmd: The Cobra command instance
+//   - args: Command arguments
+//
+// Returns:
+//   - error: Non-nil if the message has no signature, or a signature does not pass
+func (s *VerifyDKIMSvc) Run(
+	cmd *cobra.Command,
+	_ []string,
+) error {
+	ctx := cmd.Context()
+	logger := zerolog.Ctx(ctx)
+
+	data, err := os.ReadFile(filepath.Clean(s.Cfg.File))
+	if err != nil {
+		logger.Error().Err(err).Msg("verify-dkim.ReadFile")
+		return err
+	}
+	// Files saved by mail clients often have bare LF line endings
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+	results, err := s.Verifier.Verify(ctx, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	report := VerifyDKIMReport{File: s.Cfg.File, Signatures: results}
+	switch s.Cfg.Output {
+	case config.VerifyDKIMOutputJSON:
+		err = writeVerifyDKIMJSON(cmd.OutOrStdout(), report)
+	default:
+		err = writeVerifyDKIMText(cmd.OutOrStdout(), report)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("verify-dkim.write")
+		return err
+	}
+
+	if len(results) < 1 {
+		return fmt.Errorf("no DKIM-Signature in %s", s.Cfg.File)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Status != dkim.CheckPass {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d signatures did not pass", failed, len(results))
+	}
+	return nil
+}
+
+func writeVerifyDKIMJSON(w io.Writer, report VerifyDKIMReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeVerifyDKIMText(w io.Writer, report VerifyDKIMReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\t%s\n", report.File)
+	if len(report.Signatures) < 1 {
+		fmt.Fprintln(tw, "SIGNATURES\tnone")
+	}
+	for i, result := range report.Signatures {
+		key := result.KeySource
+		if result.KeyType != "" {
+			key = fmt.Sprintf("%s %d bits, from %s", result.KeyType, result.KeyBits, result.KeySource)
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "SIGNATURE\t%d\n", i+1)
+		fmt.Fprintf(tw, "DOMAIN\t%s\n", result.Domain)
+		fmt.Fprintf(tw, "SELECTOR\t%s\n", result.Selector)
+		fmt.Fprintf(tw, "STATUS\t%s\n", result.Status)
+		fmt.Fprintf(tw, "HEADER SIGNATURE\t%s\n", result.HeaderSignature)
+		fmt.Fprintf(tw, "BODY HASH\t%s\n", result.BodyHash)
+		fmt.Fprintf(tw, "ALGORITHM\t%s\n", result.Algorithm)
+		fmt.Fprintf(tw, "CANONICALIZATION\t%s\n", result.Canonicalization)
+		fmt.Fprintf(tw, "SIGNED HEADERS\t%s\n", strings.Join(result.SignedHeaders, ":"))
+		fmt.Fprintf(tw, "KEY\t%s\n", key)
+		if result.Error != "" {
+			fmt.Fprintf(tw, "ERROR\t%s\n", result.Error)
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(tw, "WARNING\t%s\n", warning)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/spf13/cobra"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dkim"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVerifyDKIMCmd(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	cmd, cobraCmd := newVerifyDKIMCmd(ctx)
+	require.NotNil(t, cmd)
+	require.NotNil(t, cobraCmd)
+
+	for _, name := range []string{"config-keys", "key", "output"} {
+		flag := cobraCmd.Flags().Lookup(name)
+		require.NotNil(t, flag, "%s flag not found", name)
+	}
+	assert.Equal(t, "stringArray", cobraCmd.Flags().Lookup("key").Value.Type())
+	assert.Error(t, cobraCmd.Args(cobraCmd, []string{}))
+}
+
+func TestVerifyDKIMSvc_Run(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	tmpDir := t.TempDir()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyPath := filepath.Join(tmpDir, "key001.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "ED25519 PRIVATE KEY",
+		Bytes: privateKey,
+	}), 0o600))
+
+	msg := "From: sender@example.com\r\n" +
+		"To: recipient@example.org\r\n" +
+		"Subject: test subject\r\n" +
+		"\r\n" +
+		"test body\r\n"
+	dkimHeaders, err := moxDkim.Sign(
+		ctx,
+		telemetry.GetSLogger(ctx),
+		smtp.Localpart("sender"),
+		moxDns.Domain{ASCII: "example.com"},
+		[]moxDkim.Selector{{
+			Hash:          "sha256",
+			PrivateKey:    privateKey,
+			Headers:       []string{"From", "To", "Subject"},
+			Domain:        moxDns.Domain{ASCII: "key001"},
+			HeaderRelaxed: true,
+			BodyRelaxed:   true,
+		}},
+		false,
+		strings.NewReader(msg),
+	)
+	require.NoError(t, err)
+	// Saved with bare LF line endings, as mail clients do
+	signed := strings.ReplaceAll(dkimHeaders+msg, "\r\n", "\n")
+
+	tests := []struct {
+		name     string
+		message  string
+		output   string
+		wantErr  bool
+		wantText []string
+	}{
+		{
+			name:     "text pass",
+			message:  signed,
+			output:   config.VerifyDKIMOutputText,
+			wantText: []string{"STATUS            pass", "KEY               ed25519 256 bits, from file"},
+		},
+		{
+			name:     "json pass",
+			message:  signed,
+			output:   config.VerifyDKIMOutputJSON,
+			wantText: []string{`"body_hash": "pass"`},
+		},
+		{
+			name:     "body changed",
+			message:  signed + "appended\n",
+			output:   config.VerifyDKIMOutputText,
+			wantErr:  true,
+			wantText: []string{"BODY HASH         fail"},
+		},
+		{
+			name:     "not signed",
+			message:  strings.ReplaceAll(msg, "\r\n", "\n"),
+			output:   config.VerifyDKIMOutputText,
+			wantErr:  true,
+			wantText: []string{"SIGNATURES  none"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emlPath := filepath.Join(tmpDir, "message.eml")
+			require.NoError(t, os.WriteFile(emlPath, []byte(tt.message), 0o600))
+			cfg := config.VerifyDKIMConfig{
+				File:   emlPath,
+				Keys:   []string{"key001=" + keyPath},
+				Output: tt.output,
+			}
+			require.NoError(t, cfg.Transform(ctx))
+
+			keyResolver := dkim.NewKeyResolver(nil)
+			require.NoError(t, loadVerifyKeys(ctx, cfg, keyResolver))
+			svc := &VerifyDKIMSvc{
+				Cfg: cfg,
+				Verifier: &dkim.Verifier{
+					Resolver: keyResolver,
+					Slogger:  telemetry.GetSLogger(ctx),
+				},
+			}
+
+			cmd := &cobra.Command{}
+			cmd.SetContext(ctx)
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+			err := svc.Run(cmd, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, text := range tt.wantText {
+				assert.Contains(t, out.String(), text)
+			}
+			if tt.output == config.VerifyDKIMOutputJSON {
+				report := VerifyDKIMReport{}
+				require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+				require.Len(t, report.Signatures, 1)
+				assert.Equal(t, "key001", report.Signatures[0].Selector)
+			}
+		})
+	}
+}
+
+func TestVerifyDKIMConfigTransform(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	cfg := config.VerifyDKIMConfig{File: "message.eml", Keys: []string{"Key001 = /tmp/key.pem"}}
+	require.NoError(t, cfg.Transform(ctx))
+	assert.Equal(t, map[string]string{"key001": "/tmp/key.pem"}, cfg.KeyFiles)
+	assert.Equal(t, config.VerifyDKIMOutputText, cfg.Output)
+
+	for _, bad := range []config.VerifyDKIMConfig{
+		{},
+		{File: "message.eml", Keys: []string{"key001"}},
+		{File: "message.eml", Output: "yaml"},
+	} {
+		assert.Error(t, bad.Transform(ctx))
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	AlignmentModeReject = "reject"
+	AlignmentModeTag    = "tag"
+	AlignmentModeWarn   = "warn"
+
+	DefaultAlignmentCacheTTL = 10 * time.Minute
+)
+
+var (
+	SupportedAlignmentModes = []string{AlignmentModeReject, AlignmentModeTag, AlignmentModeWarn}
+)
+
+// AlignmentConfig configures the alignment processor, which checks SPF and
+// DMARC of the From domain before sending, e.g.
+//
+//	mail-processors:
+//	  - type: alignment
+//	    index: 99
+//	    args:
+//	      mode: reject
+//	      source-ips:
+//	        - 192.0.2.1
+//
+// DKIMDomains are the domains mail will be signed with. When empty, the
+// signing domain the dkim processor chooses for the From domain is used, and
+// without a dkim processor the mail is taken as unsigned.
+type AlignmentConfig struct {
+	CacheTTL      time.Duration `mapstructure:"cache-ttl,omitempty"`
+	DKIMDomains   []string      `mapstructure:"dkim-domains,omitempty"`
+	EHLOHostname  string        `mapstructure:"ehlo-hostname,omitempty"`
+	Mode          string        `mapstructure:"mode,omitempty"`
+	SourceIPs     []string      `mapstructure:"source-ips"`
+	SourceIPAddrs []net.IP      `mapstructure:",omitempty"`
+}
+
+func (c *AlignmentConfig) Transform(_ context.Context) error {
+	if c.Mode == "" {
+		c.Mode = AlignmentModeWarn
+	}
+	if !slices.Contains(SupportedAlignmentModes, c.Mode) {
+		return &errors.ConfigError{
+			Field: "Alignment.Mode",
+			Message: fmt.Sprintf("unsupported mode %s, supported: %v",
+				c.Mode, SupportedAlignmentModes),
+		}
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = DefaultAlignmentCacheTTL
+	}
+	c.EHLOHostname = NormalizeDomain(c.EHLOHostname)
+	for i, domain := range c.DKIMDomains {
+		c.DKIMDomains[i] = NormalizeDomain(domain)
+	}
+
+	if len(c.SourceIPs) < 1 {
+		return &errors.ConfigError{
+			Field:   "Alignment.SourceIPs",
+			Message: "at least one source ip is required",
+		}
+	}
+	c.SourceIPAddrs = make([]net.IP, 0, len(c.SourceIPs))
+	for _, ipStr := range c.SourceIPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return &errors.ConfigError{
+				Field:   "Alignment.SourceIPs",
+				Message: fmt.Sprintf("invalid source ip %s", ipStr),
+			}
+		}
+		c.SourceIPAddrs = append(c.SourceIPAddrs, ip)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	// APIModeSpool writes each message atomically into read-file.in-path
+	APIModeSpool = "spool"
+	// APIModeQueue hands each message to the workers in memory, it is lost
+	// if the server stops before it is sent
+	APIModeQueue = "queue"
+
+	DefaultAPIMaxRequestSize int64 = 10 << 20
+	DefaultAPIMaxBatch       int   = 100
+)
+
+var (
+	SupportedAPIModes = []string{APIModeSpool, APIModeQueue}
+	// SupportedAPIInputTypes are the inputs which read the messages of the api,
+	// raw RFC 5322 messages for both, and mail jobs for the job input
+	SupportedAPIInputTypes = []string{InputTypeJob, InputTypeEML}
+)
+
+// APIConfig configures the messages api of the admin server
+//
+//	api:
+//	  enabled: true
+//	  tokens: [secret-token]
+//	  mode: spool
+//	  max-request-size: 10485760
+//	  max-batch: 100
+type APIConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Tokens are the bearer tokens accepted by the api, at least one
+	Tokens []string `mapstructure:"tokens"`
+	// Mode is spool or queue, spool by default
+	Mode string `mapstructure:"mode,omitempty"`
+	// MaxRequestSize is the limit of a request body in bytes, 10 MiB by default
+	MaxRequestSize int64 `mapstructure:"max-request-size,omitempty"`
+	// MaxBatch is the limit of messages of a batch, 100 by default
+	MaxBatch int `mapstructure:"max-batch,omitempty"`
+}
+
+// Transform sets the defaults of an enabled api, and checks that the input
+// reads the messages it writes
+func (c *APIConfig) Transform(_ context.Context, input InputConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	tokens := make([]string, 0, len(c.Tokens))
+	for _, token := range c.Tokens {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return &errors.ConfigError{
+			Field:   "API.Tokens",
+			Message: "at least one token is required",
+		}
+	}
+	c.Tokens = tokens
+	c.Mode = strings.ToLower(c.Mode)
+	if c.Mode == "" {
+		c.Mode = APIModeSpool
+	}
+	if !slices.Contains(SupportedAPIModes, c.Mode) {
+		return &errors.ConfigError{
+			Field: "API.Mode",
+			Message: fmt.Sprintf("unsupported api mode %s, supported: %v",
+				c.Mode, SupportedAPIModes),
+		}
+	}
+	if c.MaxRequestSize <= 0 {
+		c.MaxRequestSize = DefaultAPIMaxRequestSize
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = DefaultAPIMaxBatch
+	}
+	if !slices.Contains(SupportedAPIInputTypes, input.Type) {
+		return &errors.ConfigError{
+			Field: "API.Enabled",
+			Message: fmt.Sprintf("the api requires the input type to be one of %v, not %s",
+				SupportedAPIInputTypes, input.Type),
+		}
+	}
+	return nil
+}
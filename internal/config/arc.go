@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+// ARCConfig holds the args of the arc mail processor, which adds an ARC set
+// (RFC 8617) to relayed mail. The selectors use the same model as the dkim
+// processor, and are restricted to rsa-sha256, e.g.
+//
+//	authserv-id: mx.example.com
+//	domain-str: example.com
+//	arc:
+//	  selectors:
+//	    arc001:
+//	      algorithm: rsa
+//	      hash: sha256
+//	      ...
+type ARCConfig struct {
+	ARC        *DKIMConfig   `mapstructure:"arc,omitempty"`
+	AuthServID string        `mapstructure:"authserv-id,omitempty"`
+	Domain     moxDns.Domain `mapstructure:",omitempty"`
+	DomainStr  string        `mapstructure:"domain-str,omitempty"`
+}
+
+func (c *ARCConfig) Transform(
+	ctx context.Context,
+) error {
+	logger := zerolog.Ctx(ctx)
+	if c.AuthServID == "" {
+		return &errors.ConfigError{
+			Field:   "AuthServID",
+			Message: "authserv-id is required",
+		}
+	}
+	if c.DomainStr == "" {
+		return &errors.ConfigError{
+			Field:   "DomainStr",
+			Message: "domain-str is required",
+		}
+	}
+	c.Domain = moxDns.Domain{ASCII: NormalizeDomain(c.DomainStr)}
+	if c.ARC == nil {
+		return &errors.ConfigError{
+			Field:   "ARC",
+			Message: "arc selectors are required",
+		}
+	}
+	for selectorName, moxSelector := range c.ARC.MoxSelectors {
+		if moxSelector.Algorithm != AlgorithmRSA || moxSelector.Hash != HashSHA256 {
+			return &errors.ConfigError{
+				Field: fmt.Sprintf("Selectors[%s].Algorithm", selectorName),
+				Message: fmt.Sprintf("unsupported algorithm %s-%s, arc only supports rsa-sha256",
+					moxSelector.Algorithm, moxSelector.Hash),
+			}
+		}
+	}
+	err := c.ARC.Transform(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("ARCConfig.Transform.ARC")
+		return err
+	}
+	return nil
+}
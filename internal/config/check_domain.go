@@ -0,0 +1,71 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+// CheckDomainConfig configures the checkdomain audit of a sending domain.
+// SourceIPs are the addresses mail is sent from, which SPF must authorize.
+// EHLOHostname is the name announced in EHLO, the domain itself when empty,
+// as the sender uses the From domain.
+// The DKIM selectors are taken from the dkim processors in MailProcessors.
+type CheckDomainConfig struct {
+	DNS            DNSConfig             `mapstructure:"dns"`
+	Domain         string                `mapstructure:"check-domain"`
+	EHLOHostname   string                `mapstructure:"ehlo-hostname,omitempty"`
+	MailProcessors []MailProcessorConfig `mapstructure:"mail-processors"`
+	SourceIPs      []string              `mapstructure:"source-ips,omitempty"`
+	SourceIPAddrs  []net.IP              `mapstructure:",omitempty"`
+}
+
+func NewCheckDomainConfig(ctx context.Context) CheckDomainConfig {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	result := CheckDomainConfig{
+		DNS:            DefaultDNSConfig(),
+		MailProcessors: DefaultMailProcessorConfigs(),
+	}
+	err = viper.Unmarshal(&result)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Unmarshal")
+	}
+
+	err = result.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("CheckDomainConfig.Transform")
+	}
+
+	logger.Info().
+		Interface("viper.AllSettings", viper.AllSettings()).
+		Interface("result", result).
+		Msg("CheckDomainConfig init")
+
+	return result
+}
+
+func (c *CheckDomainConfig) Transform(ctx context.Context) error {
+	c.Domain = NormalizeDomain(c.Domain)
+	c.EHLOHostname = NormalizeDomain(c.EHLOHostname)
+	if c.EHLOHostname == "" {
+		c.EHLOHostname = c.Domain
+	}
+	c.SourceIPAddrs = make([]net.IP, 0, len(c.SourceIPs))
+	for _, ipStr := range c.SourceIPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return &errors.ConfigError{
+				Field:   "SourceIPs",
+				Message: fmt.Sprintf("invalid source ip %s", ipStr),
+			}
+		}
+		c.SourceIPAddrs = append(c.SourceIPAddrs, ip)
+	}
+	return c.DNS.Transform(ctx)
+}
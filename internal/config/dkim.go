@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"slices"
 	"time"
 
@@ -17,27 +18,72 @@ const (
 	AlgorithmED25519 = "ed25519"
 	HashSHA1         = "sha1"
 	HashSHA256       = "sha256"
+
+	DKIMVerifyModeReject = "reject"
+	DKIMVerifyModeTag    = "tag"
+
+	KeySourceEncryptedFile = "encrypted-file"
+	KeySourceEnv           = "env"
+	KeySourceFile          = "file"
+	KeySourceHTTP          = "http"
 )
 
 var (
-	SupportedAlgorithms = []string{AlgorithmRSA, AlgorithmED25519}
-	SupportedHashes     = []string{HashSHA1, HashSHA256}
+	SupportedAlgorithms  = []string{AlgorithmRSA, AlgorithmED25519}
+	SupportedHashes      = []string{HashSHA1, HashSHA256}
+	SupportedVerifyModes = []string{DKIMVerifyModeReject, DKIMVerifyModeTag}
+	SupportedKeySources  = []string{KeySourceEncryptedFile, KeySourceEnv, KeySourceFile, KeySourceHTTP}
 )
 
+// DKIMConfig holds the selectors used to sign mail.
+// A selector signs only within its active-from/active-until window, when set,
+// so that keys can be rotated on a schedule, e.g.
+//
+//	selectors:
+//	  key001:
+//	    active-until: 2025-07-02T00:00:00Z
+//	  key002:
+//	    active-from: 2025-07-01T00:00:00Z
+//
+// A selector with an active-from is only used once its DNS record publishes
+// the public key of its private key, and the selectors it replaces keep
+// signing until then. check-dns: false switches to it at active-from as is.
 type DKIMConfig struct {
+	CheckDNS     *bool                       `mapstructure:"check-dns,omitempty"`
 	Selectors    map[string]moxDkim.Selector `mapstructure:",omitempty"`
 	MoxSelectors map[string]MoxSelector      `mapstructure:"selectors,omitempty"`
 }
 type MoxSelector struct {
-	Algorithm      string        `mapstructure:"algorithm"`
-	BodyRelaxed    bool          `mapstructure:"body-relaxed"`
-	Expiration     time.Duration `mapstructure:"expiration,omitempty"`
-	Hash           string        `mapstructure:"hash"`
-	HeaderRelaxed  bool          `mapstructure:"header-relaxed"`
-	Headers        []string      `mapstructure:"headers,omitempty"`
-	PrivateKeyFile string        `mapstructure:"private-key-file,omitempty"`
-	SealHeaders    bool          `mapstructure:"seal-headers,omitempty"`
-	SelectorDomain string        `mapstructure:"selector-domain"`
+	ActiveFrom     time.Time       `mapstructure:"active-from,omitempty"`
+	ActiveUntil    time.Time       `mapstructure:"active-until,omitempty"`
+	Algorithm      string          `mapstructure:"algorithm"`
+	BodyRelaxed    bool            `mapstructure:"body-relaxed"`
+	Expiration     time.Duration   `mapstructure:"expiration,omitempty"`
+	Hash           string          `mapstructure:"hash"`
+	HeaderRelaxed  bool            `mapstructure:"header-relaxed"`
+	Headers        []string        `mapstructure:"headers,omitempty"`
+	KeySource      KeySourceConfig `mapstructure:"key-source,omitempty"`
+	PrivateKeyFile string          `mapstructure:"private-key-file,omitempty"`
+	SealHeaders    bool            `mapstructure:"seal-headers,omitempty"`
+	SelectorDomain string          `mapstructure:"selector-domain"`
+}
+
+// KeySourceConfig selects where the private key of a selector is loaded from:
+//   - file: the PKCS#1, PKCS#8 or raw ed25519 PEM in private-key-file
+//   - encrypted-file: the passphrase encrypted PEM in private-key-file, with
+//     the passphrase in the environment variable passphrase-env, or else in
+//     the file passphrase-file
+//   - env: the PEM, or base64 of a PEM or DER key, in the environment variable env
+//   - http: the remote signer at url, with the bearer token in the
+//     environment variable token-env
+type KeySourceConfig struct {
+	Env            string        `mapstructure:"env,omitempty"`
+	PassphraseEnv  string        `mapstructure:"passphrase-env,omitempty"`
+	PassphraseFile string        `mapstructure:"passphrase-file,omitempty"`
+	Timeout        time.Duration `mapstructure:"timeout,omitempty"`
+	TokenEnv       string        `mapstructure:"token-env,omitempty"`
+	Type           string        `mapstructure:"type,omitempty"`
+	URL            string        `mapstructure:"url,omitempty"`
 }
 
 func DefaultDKIMConfig(
@@ -95,6 +141,24 @@ func (c *DKIMConfig) Transform(ctx context.Context) error {
 			}
 		}
 
+		if !moxSelector.ActiveFrom.IsZero() &&
+			!moxSelector.ActiveUntil.IsZero() &&
+			!moxSelector.ActiveUntil.After(moxSelector.ActiveFrom) {
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("Selectors[%s].ActiveUntil", selectorName),
+				Message: "active-until must be after active-from",
+			}
+		}
+
+		err := moxSelector.KeySource.Transform(ctx, moxSelector.PrivateKeyFile)
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("Selectors[%s].KeySource", selectorName),
+				Message: "invalid key source",
+				Err:     err,
+			}
+		}
+
 		// Transform selector with detailed error handling
 		if err := c.TransformSelector(ctx, selectorName, &moxSelector); err != nil {
 			return &errors.ConfigError{
@@ -142,3 +206,103 @@ func (c *DKIMConfig) TransformSelector(
 	c.Selectors[selectorName] = result
 	return nil
 }
+
+// Transform checks the settings of the key source type are given, where no
+// type is file
+func (c *KeySourceConfig) Transform(_ context.Context, privateKeyFile string) error {
+	var missing string
+	switch c.Type {
+	case "", KeySourceFile:
+	case KeySourceEncryptedFile:
+		switch {
+		case privateKeyFile == "":
+			missing = "private-key-file"
+		case c.PassphraseEnv == "" && c.PassphraseFile == "":
+			missing = "passphrase-env or passphrase-file"
+		}
+	case KeySourceEnv:
+		if c.Env == "" {
+			missing = "env"
+		}
+	case KeySourceHTTP:
+		if c.URL == "" {
+			missing = "url"
+			break
+		}
+		parsed, err := url.Parse(c.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return &errors.ConfigError{
+				Field:   "URL",
+				Message: fmt.Sprintf("invalid signer url %s", c.URL),
+				Err:     err,
+			}
+		}
+	default:
+		return &errors.ConfigError{
+			Field: "Type",
+			Message: fmt.Sprintf("unsupported key source %s, supported: %v",
+				c.Type, SupportedKeySources),
+		}
+	}
+	if missing != "" {
+		return &errors.ConfigError{
+			Field:   "Type",
+			Message: fmt.Sprintf("key source %s requires %s", c.Type, missing),
+		}
+	}
+	return nil
+}
+
+// IsActive returns true if the selector signs at the given time
+func (s MoxSelector) IsActive(now time.Time) bool {
+	if !s.ActiveFrom.IsZero() && now.Before(s.ActiveFrom) {
+		return false
+	}
+	if !s.ActiveUntil.IsZero() && !now.Before(s.ActiveUntil) {
+		return false
+	}
+	return true
+}
+
+// DNSCheck returns true if the DNS records of the scheduled selectors are
+// checked before they sign, which is the default
+func (c *DKIMConfig) DNSCheck() bool {
+	return c.CheckDNS == nil || *c.CheckDNS
+}
+
+// ActiveSelectors returns the sorted names of the selectors that sign at the
+// given time
+func (c *DKIMConfig) ActiveSelectors(now time.Time) []string {
+	result := make([]string, 0, len(c.MoxSelectors))
+	for selectorName, moxSelector := range c.MoxSelectors {
+		if moxSelector.IsActive(now) {
+			result = append(result, selectorName)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// DKIMVerifyConfig enables verifying the signatures of each mail right after
+// signing, so that a misconfigured selector is found before the mail is sent.
+// The public key is derived from the loaded private key, or looked up in DNS
+// with dns. A mail that fails is rejected, or tagged in its metadata.
+type DKIMVerifyConfig struct {
+	DNS     bool   `mapstructure:"dns,omitempty"`
+	Enabled bool   `mapstructure:"enabled,omitempty"`
+	Mode    string `mapstructure:"mode,omitempty"`
+}
+
+func (c *DKIMVerifyConfig) Transform(_ context.Context) error {
+	if c.Mode == "" {
+		c.Mode = DKIMVerifyModeReject
+	}
+	if !slices.Contains(SupportedVerifyModes, c.Mode) {
+		return &errors.ConfigError{
+			Field: "Verify.Mode",
+			Message: fmt.Sprintf("unsupported mode %s, supported: %v",
+				c.Mode, SupportedVerifyModes),
+		}
+	}
+	return nil
+}
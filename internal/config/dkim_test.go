@@ -158,3 +158,145 @@ selectors:
 		})
 	}
 }
+
+func TestDKIMConfigActiveSelectors(t *testing.T) {
+	switchover := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	moxSelector := func(activeFrom, activeUntil time.Time) MoxSelector {
+		return MoxSelector{
+			ActiveFrom:     activeFrom,
+			ActiveUntil:    activeUntil,
+			Algorithm:      AlgorithmED25519,
+			Hash:           HashSHA256,
+			SelectorDomain: "key001",
+		}
+	}
+
+	tests := []struct {
+		name         string
+		moxSelectors map[string]MoxSelector
+		now          time.Time
+		want         []string
+		wantErr      bool
+	}{
+		{
+			name: "without windows",
+			moxSelectors: map[string]MoxSelector{
+				"key002": moxSelector(time.Time{}, time.Time{}),
+				"key001": moxSelector(time.Time{}, time.Time{}),
+			},
+			now:  switchover,
+			want: []string{"key001", "key002"},
+		},
+		{
+			name: "before switchover",
+			moxSelectors: map[string]MoxSelector{
+				"key001": moxSelector(time.Time{}, switchover.Add(24*time.Hour)),
+				"key002": moxSelector(switchover, time.Time{}),
+			},
+			now:  switchover.Add(-time.Second),
+			want: []string{"key001"},
+		},
+		{
+			name: "overlap",
+			moxSelectors: map[string]MoxSelector{
+				"key001": moxSelector(time.Time{}, switchover.Add(24*time.Hour)),
+				"key002": moxSelector(switchover, time.Time{}),
+			},
+			now:  switchover,
+			want: []string{"key001", "key002"},
+		},
+		{
+			name: "after overlap",
+			moxSelectors: map[string]MoxSelector{
+				"key001": moxSelector(time.Time{}, switchover.Add(24*time.Hour)),
+				"key002": moxSelector(switchover, time.Time{}),
+			},
+			now:  switchover.Add(24 * time.Hour),
+			want: []string{"key002"},
+		},
+		{
+			name: "active-until before active-from",
+			moxSelectors: map[string]MoxSelector{
+				"key001": moxSelector(switchover, switchover.Add(-time.Hour)),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			dkimCfg := DKIMConfig{MoxSelectors: tt.moxSelectors}
+			err := dkimCfg.Transform(ctx)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, dkimCfg.ActiveSelectors(tt.now))
+		})
+	}
+}
+
+func TestKeySourceConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		keySource      KeySourceConfig
+		privateKeyFile string
+		wantErr        bool
+	}{
+		{
+			name:           "default file",
+			privateKeyFile: "/tmp/key001.pem",
+		},
+		{
+			name:           "encrypted file",
+			keySource:      KeySourceConfig{Type: KeySourceEncryptedFile, PassphraseEnv: "DKIM_PASSPHRASE"},
+			privateKeyFile: "/tmp/key001.pem",
+		},
+		{
+			name:           "encrypted file without passphrase",
+			keySource:      KeySourceConfig{Type: KeySourceEncryptedFile},
+			privateKeyFile: "/tmp/key001.pem",
+			wantErr:        true,
+		},
+		{
+			name:      "encrypted file without file",
+			keySource: KeySourceConfig{Type: KeySourceEncryptedFile, PassphraseFile: "/tmp/passphrase"},
+			wantErr:   true,
+		},
+		{
+			name:      "env",
+			keySource: KeySourceConfig{Type: KeySourceEnv, Env: "DKIM_KEY001"},
+		},
+		{
+			name:      "env without variable",
+			keySource: KeySourceConfig{Type: KeySourceEnv},
+			wantErr:   true,
+		},
+		{
+			name:      "http",
+			keySource: KeySourceConfig{Type: KeySourceHTTP, URL: "https://signer.example.com/keys/key001"},
+		},
+		{
+			name:      "http invalid url",
+			keySource: KeySourceConfig{Type: KeySourceHTTP, URL: "signer.example.com"},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported",
+			keySource: KeySourceConfig{Type: "vault"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			err := tt.keySource.Transform(ctx, tt.privateKeyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
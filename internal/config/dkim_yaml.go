@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultDKIMProcessorIndex places the dkim processor after the
+	// mergeHeaders and before the mergeBody of the sample config
+	DefaultDKIMProcessorIndex = 12
+
+	mailProcessorsKey = "mail-processors"
+)
+
+// dkimProcessorYAML is a dkim mail processor, with the fields in the order
+// of the sample config
+type dkimProcessorYAML struct {
+	Type  string         `yaml:"type"`
+	Index int            `yaml:"index"`
+	Args  map[string]any `yaml:"args"`
+}
+
+// DKIMSelectorArgs returns the args of a selector of the dkim processor
+//
+// Parameters:
+//   - algorithm: The key type, rsa or ed25519
+//   - hash: The hash algorithm
+//   - privateKeyFile: The path of the private key
+//   - selector: The selector name
+//
+// Returns:
+//   - map[string]any: The selector args
+func DKIMSelectorArgs(algorithm, hash, privateKeyFile, selector string) map[string]any {
+	return map[string]any{
+		"algorithm":        algorithm,
+		"body-relaxed":     true,
+		"expiration":       "72h",
+		"hash":             hash,
+		"header-relaxed":   true,
+		"headers":          []string{"from", "to", "subject", "date", "message-id", "content-type"},
+		"private-key-file": privateKeyFile,
+		"seal-headers":     false,
+		"selector-domain":  selector,
+	}
+}
+
+// DKIMProcessorYAML returns the mail-processors config of a dkim processor
+// signing the domain with the selector, ready to paste into a config file
+//
+// Parameters:
+//   - domain: The signing domain
+//   - selectorName: The selector name
+//   - selector: The selector args, see DKIMSelectorArgs
+//
+// Returns:
+//   - []byte: The yaml
+//   - error: Non-nil if the selector cannot be marshalled
+func DKIMProcessorYAML(domain, selectorName string, selector map[string]any) ([]byte, error) {
+	return marshalYAML(map[string][]dkimProcessorYAML{
+		mailProcessorsKey: {newDKIMProcessorYAML(domain, selectorName, selector)},
+	})
+}
+
+// marshalYAML marshals with the indent of the sample config
+func marshalYAML(value any) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	err := encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func newDKIMProcessorYAML(domain, selectorName string, selector map[string]any) dkimProcessorYAML {
+	return dkimProcessorYAML{
+		Type:  "dkim",
+		Index: DefaultDKIMProcessorIndex,
+		Args: map[string]any{
+			"domain-str": domain,
+			"dkim": map[string]any{
+				"selectors": map[string]any{selectorName: selector},
+			},
+		},
+	}
+}
+
+// AddDKIMSelector adds the selector to the config file, keeping the rest of
+// the file and its comments. The selector is added to the dkim processor of
+// the domain, or one of its domains. Otherwise the domain is added to the
+// domains of the first dkim processor, or a dkim processor is added.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - path: The config file
+//   - domain: The signing domain
+//   - selectorName: The selector name, replaced if it exists
+//   - selector: The selector args, see DKIMSelectorArgs
+//
+// Returns:
+//   - error: Non-nil if the file cannot be read, parsed or written
+func AddDKIMSelector(
+	ctx context.Context,
+	path string,
+	domain, selectorName string,
+	selector map[string]any,
+) error {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("path", path).
+		Str("domain", domain).
+		Str("selector", selectorName).
+		Logger()
+
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a yaml mapping", path)
+	}
+
+	processors := mappingValue(root, mailProcessorsKey)
+	if processors == nil {
+		processors = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(root, mailProcessorsKey, processors)
+	}
+	if processors.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s of %s is not a list", mailProcessorsKey, path)
+	}
+
+	target := dkimDomainArgs(processors, domain)
+	selectorNode := &yaml.Node{}
+	err = selectorNode.Encode(selector)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		logger.Info().Msg("adding dkim processor")
+		processor := &yaml.Node{}
+		err = processor.Encode(newDKIMProcessorYAML(domain, selectorName, selector))
+		if err != nil {
+			return err
+		}
+		insertProcessor(processors, processor, DefaultDKIMProcessorIndex)
+	} else {
+		selectors := ensureMapping(ensureMapping(target, "dkim"), "selectors")
+		setMappingValue(selectors, selectorName, selectorNode)
+	}
+
+	out, err := marshalYAML(&doc)
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once, so that a running server never reads half of it
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(out)
+	if err == nil {
+		err = tmpFile.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return err
+	}
+	logger.Info().Msg("added dkim selector")
+	return nil
+}
+
+// dkimDomainArgs returns the args of the dkim processor, or of the entry of
+// its domains, that signs the domain. If none does, the domain is added to
+// the domains of the first dkim processor. Nil is returned without a dkim
+// processor.
+func dkimDomainArgs(processors *yaml.Node, domain string) *yaml.Node {
+	var first *yaml.Node
+	for _, processor := range processors.Content {
+		if processor.Kind != yaml.MappingNode {
+			continue
+		}
+		processorType := mappingValue(processor, "type")
+		if processorType == nil || processorType.Value != "dkim" {
+			continue
+		}
+		args := ensureMapping(processor, "args")
+		if first == nil {
+			first = args
+		}
+		if domainStr := mappingValue(args, "domain-str"); domainStr != nil &&
+			strings.EqualFold(domainStr.Value, domain) {
+			return args
+		}
+		domains := mappingValue(args, "domains")
+		if domains == nil || domains.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 1; i < len(domains.Content); i += 2 {
+			entry := domains.Content[i]
+			if domainStr := mappingValue(entry, "domain-str"); domainStr != nil &&
+				strings.EqualFold(domainStr.Value, domain) {
+				return entry
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+
+	// Yaml map keys cannot contain a dot, so the domain is set with domain-str
+	entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(entry, "domain-str", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: domain})
+	domains := ensureMapping(first, "domains")
+	setMappingValue(domains, strings.ReplaceAll(domain, ".", "-"), entry)
+	return entry
+}
+
+// insertProcessor inserts the processor before the first processor with a
+// larger index
+func insertProcessor(processors, processor *yaml.Node, index int) {
+	for i, existing := range processors.Content {
+		indexNode := mappingValue(existing, "index")
+		if indexNode == nil {
+			continue
+		}
+		existingIndex, err := strconv.Atoi(indexNode.Value)
+		if err == nil && existingIndex > index {
+			processors.Content = append(processors.Content[:i],
+				append([]*yaml.Node{processor}, processors.Content[i:]...)...)
+			return
+		}
+	}
+	processors.Content = append(processors.Content, processor)
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+// ensureMapping returns the mapping of the key, replacing a value that is
+// not a mapping
+func ensureMapping(mapping *yaml.Node, key string) *yaml.Node {
+	value := mappingValue(mapping, key)
+	if value != nil && value.Kind == yaml.MappingNode {
+		return value
+	}
+	value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(mapping, key, value)
+	return value
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMProcessorYAML(t *testing.T) {
+	selector := DKIMSelectorArgs("ed25519", "sha256", "/app/config/example.com.pem", "key002")
+	got, err := DKIMProcessorYAML("example.com", "key002", selector)
+	require.NoError(t, err)
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewReader(got)))
+	var cfg struct {
+		MailProcessors []MailProcessorConfig `mapstructure:"mail-processors"`
+	}
+	require.NoError(t, v.Unmarshal(&cfg))
+	require.Len(t, cfg.MailProcessors, 1)
+	assert.Equal(t, "dkim", cfg.MailProcessors[0].Type)
+	assert.Equal(t, DefaultDKIMProcessorIndex, cfg.MailProcessors[0].Index)
+	assert.Equal(t, "example.com", cfg.MailProcessors[0].Args["domain-str"])
+	assert.Contains(t, string(got), "private-key-file: /app/config/example.com.pem")
+}
+
+func TestAddDKIMSelector(t *testing.T) {
+	const processors = `# the pipeline
+mail-processors:
+  - type: mergeHeaders
+    index: 11
+  - type: dkim
+    index: 12
+    args:
+      domain-str: example.com
+      dkim:
+        selectors:
+          key001:
+            algorithm: rsa # the first key
+  - type: mergeBody
+    index: 99
+`
+
+	tests := []struct {
+		name         string
+		content      string
+		domain       string
+		wantContains []string
+		wantSelector []string
+	}{
+		{
+			name:         "processor of the domain",
+			content:      processors,
+			domain:       "example.com",
+			wantContains: []string{"# the pipeline", "algorithm: rsa # the first key"},
+			wantSelector: []string{"args", "dkim", "selectors"},
+		},
+		{
+			name:         "other domain",
+			content:      processors,
+			domain:       "brand.example",
+			wantContains: []string{"brand-example:", "domain-str: brand.example"},
+			wantSelector: []string{"args", "domains", "brand-example", "dkim", "selectors"},
+		},
+		{
+			name: "no dkim processor",
+			content: `mail-processors:
+  - type: mergeHeaders
+    index: 11
+  - type: mergeBody
+    index: 99
+`,
+			domain:       "example.com",
+			wantSelector: []string{"args", "dkim", "selectors"},
+		},
+		{
+			name:         "empty file",
+			domain:       "example.com",
+			wantSelector: []string{"args", "dkim", "selectors"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0640))
+
+			selector := DKIMSelectorArgs("rsa", "sha256", "/app/config/key002.pem", "key002")
+			err := AddDKIMSelector(ctx, path, tt.domain, "key002", selector)
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			for _, want := range tt.wantContains {
+				assert.Contains(t, string(data), want)
+			}
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+			v := viper.New()
+			v.SetConfigType("yaml")
+			require.NoError(t, v.ReadConfig(bytes.NewReader(data)))
+			var cfg struct {
+				MailProcessors []MailProcessorConfig `mapstructure:"mail-processors"`
+			}
+			require.NoError(t, v.Unmarshal(&cfg))
+			var dkimProcessor *MailProcessorConfig
+			for i, processor := range cfg.MailProcessors {
+				if processor.Type == "dkim" {
+					dkimProcessor = &cfg.MailProcessors[i]
+				}
+			}
+			require.NotNil(t, dkimProcessor)
+			// The dkim processor stays before mergeBody
+			if len(cfg.MailProcessors) > 1 {
+				assert.NotEqual(t, "dkim", cfg.MailProcessors[len(cfg.MailProcessors)-1].Type)
+			}
+
+			var node any = map[string]any(dkimProcessor.Args)
+			for _, key := range tt.wantSelector[1:] {
+				mapping, ok := node.(map[string]any)
+				require.True(t, ok, "%s is not a mapping", key)
+				node = mapping[key]
+			}
+			selectors, ok := node.(map[string]any)
+			require.True(t, ok)
+			require.Contains(t, selectors, "key002")
+			assert.Equal(t, "/app/config/key002.pem", selectors["key002"].(map[string]any)["private-key-file"])
+		})
+	}
+}
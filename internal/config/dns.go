@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	DNSCacheBackendMemory = "memory"
+	DNSCacheBackendRedis  = "redis"
+
+	DefaultDNSCacheMinTTL      = time.Minute
+	DefaultDNSCacheMaxTTL      = time.Hour
+	DefaultDNSCacheNegativeTTL = 5 * time.Minute
+
+	DefaultDNSAttempts = 1
+	DefaultDNSPort     = "53"
+)
+
+var (
+	SupportedDNSCacheBackends = []string{DNSCacheBackendMemory, DNSCacheBackendRedis}
+)
+
+// DNSConfig configures the resolver used for MX lookups.
+// When Nameservers is empty, the nameservers of the system are used.
+// Each lookup is tried up to Attempts times, each attempt limited to Timeout.
+// With RequireDNSSEC, responses that are not DNSSEC-authenticated are rejected,
+// which requires a validating resolver.
+type DNSConfig struct {
+	Attempts      int                 `mapstructure:"attempts,omitempty"`
+	Cache         DNSCacheConfig      `mapstructure:"cache"`
+	Nameservers   []string            `mapstructure:"nameservers,omitempty"`
+	Overrides     []DNSOverrideConfig `mapstructure:"overrides,omitempty"`
+	RequireDNSSEC bool                `mapstructure:"require-dnssec"`
+	Timeout       time.Duration       `mapstructure:"timeout,omitempty"`
+}
+
+// DNSOverrideConfig statically resolves a domain, for testing and lab setups.
+// MX lists the mail hosts of the domain, IPs the addresses of the domain
+// or mail host. A domain with only IPs is its own implicit mail host.
+//
+//	overrides:
+//	  - domain: example.com
+//	    mx:
+//	      - mx1.lab.local
+//	  - domain: mx1.lab.local
+//	    ips:
+//	      - 10.0.0.5
+type DNSOverrideConfig struct {
+	Domain  string   `mapstructure:"domain"`
+	IPs     []string `mapstructure:"ips,omitempty"`
+	IPAddrs []net.IP `mapstructure:",omitempty"`
+	MX      []string `mapstructure:"mx,omitempty"`
+}
+
+// DNSCacheConfig configures the MX lookup cache.
+// Positive results are cached for the DNS TTL, clamped to [MinTTL, MaxTTL].
+// Negative results (NXDOMAIN, null MX) are cached for NegativeTTL.
+// The redis backend shares the cache between instances using read-file.redis-addr.
+type DNSCacheConfig struct {
+	Backend     string        `mapstructure:"backend,omitempty"`
+	Enabled     bool          `mapstructure:"enabled"`
+	MaxTTL      time.Duration `mapstructure:"max-ttl,omitempty"`
+	MinTTL      time.Duration `mapstructure:"min-ttl,omitempty"`
+	NegativeTTL time.Duration `mapstructure:"negative-ttl,omitempty"`
+}
+
+func DefaultDNSConfig() DNSConfig {
+	return DNSConfig{
+		Attempts: DefaultDNSAttempts,
+		Cache: DNSCacheConfig{
+			Backend:     DNSCacheBackendMemory,
+			Enabled:     true,
+			MaxTTL:      DefaultDNSCacheMaxTTL,
+			MinTTL:      DefaultDNSCacheMinTTL,
+			NegativeTTL: DefaultDNSCacheNegativeTTL,
+		},
+	}
+}
+
+func (c *DNSConfig) Transform(ctx context.Context) error {
+	var err error
+
+	if c.Attempts <= 0 {
+		c.Attempts = DefaultDNSAttempts
+	}
+	if c.Timeout < 0 {
+		return &errors.ConfigError{
+			Field:   "DNS.Timeout",
+			Message: fmt.Sprintf("timeout %s must not be negative", c.Timeout),
+		}
+	}
+	for i, nameserver := range c.Nameservers {
+		c.Nameservers[i], err = normalizeNameserver(nameserver)
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   "DNS.Nameservers",
+				Message: fmt.Sprintf("invalid nameserver %s, expecting ip or ip:port", nameserver),
+				Err:     err,
+			}
+		}
+	}
+	for i := range c.Overrides {
+		err = c.Overrides[i].Transform(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Cache.Transform(ctx)
+}
+
+func normalizeNameserver(nameserver string) (string, error) {
+	if net.ParseIP(nameserver) != nil {
+		return net.JoinHostPort(nameserver, DefaultDNSPort), nil
+	}
+	host, port, err := net.SplitHostPort(nameserver)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("nameserver host %s is not an ip address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func (c *DNSOverrideConfig) Transform(_ context.Context) error {
+	c.Domain = NormalizeDomain(c.Domain)
+	if c.Domain == "" {
+		return &errors.ConfigError{
+			Field:   "DNS.Overrides.Domain",
+			Message: "domain is required",
+		}
+	}
+	if len(c.IPs) < 1 && len(c.MX) < 1 {
+		return &errors.ConfigError{
+			Field:   "DNS.Overrides",
+			Message: fmt.Sprintf("override for %s needs mx or ips", c.Domain),
+		}
+	}
+	for i, mx := range c.MX {
+		c.MX[i] = NormalizeDomain(mx)
+	}
+	c.IPAddrs = make([]net.IP, 0, len(c.IPs))
+	for _, ipStr := range c.IPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return &errors.ConfigError{
+				Field:   "DNS.Overrides.IPs",
+				Message: fmt.Sprintf("invalid ip %s for %s", ipStr, c.Domain),
+			}
+		}
+		c.IPAddrs = append(c.IPAddrs, ip)
+	}
+	return nil
+}
+
+// NormalizeDomain lowercases the domain and removes the trailing dot
+func NormalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+func (c *DNSCacheConfig) Transform(_ context.Context) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Backend == "" {
+		c.Backend = DNSCacheBackendMemory
+	}
+	if !slices.Contains(SupportedDNSCacheBackends, c.Backend) {
+		return &errors.ConfigError{
+			Field: "DNS.Cache.Backend",
+			Message: fmt.Sprintf("unsupported backend %s, supported: %v",
+				c.Backend, SupportedDNSCacheBackends),
+		}
+	}
+	if c.MinTTL <= 0 {
+		c.MinTTL = DefaultDNSCacheMinTTL
+	}
+	if c.MaxTTL <= 0 {
+		c.MaxTTL = DefaultDNSCacheMaxTTL
+	}
+	if c.NegativeTTL <= 0 {
+		c.NegativeTTL = DefaultDNSCacheNegativeTTL
+	}
+	if c.MinTTL > c.MaxTTL {
+		return &errors.ConfigError{
+			Field:   "DNS.Cache.MinTTL",
+			Message: fmt.Sprintf("min-ttl %s is greater than max-ttl %s", c.MinTTL, c.MaxTTL),
+		}
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package config
 
 import (
 	"context"
+	"fmt"
 
 	moxConfig "github.com/mjl-/mox/config"
 	moxDns "github.com/mjl-/mox/dns"
 	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
 )
 
 const (
@@ -51,7 +53,7 @@ func (c *DomainConfig) Transform(
 		}
 	}
 	if c.DomainStr != "" {
-		c.Domain = moxDns.Domain{ASCII: c.DomainStr}
+		c.Domain = moxDns.Domain{ASCII: NormalizeDomain(c.DomainStr)}
 	}
 	c.MoxDomain = moxConfig.Domain{
 		Domain:                     c.Domain,
@@ -61,3 +63,60 @@ func (c *DomainConfig) Transform(
 	}
 	return nil
 }
+
+// DKIMSigningConfig holds the domains mail is DKIM-signed for, each with its
+// own selectors and keys. Mail is signed by the domain config of its From
+// domain. Without one, ParentFallback signs with the closest parent domain,
+// e.g. example.com for news.example.com, and DefaultDomain names the domain
+// that signs all other mail, e.g. a third-party signing domain.
+// Yaml map keys cannot have a dot, so the domain is set with domain-str, e.g.
+//
+//	domains:
+//	  brand1:
+//	    domain-str: brand1.com
+//	    dkim:
+//	      selectors: ...
+//	default-domain: brand1.com
+//	parent-fallback: true
+//
+// Verify checks the signatures after signing, see DKIMVerifyConfig.
+type DKIMSigningConfig struct {
+	DefaultDomain  string                   `mapstructure:"default-domain,omitempty"`
+	Domains        map[string]*DomainConfig `mapstructure:"domains,omitempty"`
+	ParentFallback bool                     `mapstructure:"parent-fallback,omitempty"`
+	Verify         DKIMVerifyConfig         `mapstructure:"verify,omitempty"`
+}
+
+func (c *DKIMSigningConfig) Transform(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	c.DefaultDomain = NormalizeDomain(c.DefaultDomain)
+	err := c.Verify.Transform(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("DKIMSigningConfig.Transform.Verify")
+		return err
+	}
+	for name, domainCfg := range c.Domains {
+		if domainCfg == nil || domainCfg.DomainStr == "" {
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("Domains[%s].DomainStr", name),
+				Message: "domain-str is required",
+			}
+		}
+		if domainCfg.DKIM == nil {
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("Domains[%s].DKIM", name),
+				Message: "dkim selectors are required",
+			}
+		}
+		err := domainCfg.Transform(ctx)
+		if err != nil {
+			logger.Error().Err(err).Str("domain", name).Msg("DKIMSigningConfig.Transform")
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("Domains[%s]", name),
+				Message: "failed to transform domain",
+				Err:     err,
+			}
+		}
+	}
+	return nil
+}
@@ -49,3 +49,88 @@ func DefaultFileMailConfigs() []FileMailConfig {
 		},
 	}
 }
+
+// DefaultEMLFileMailConfigs are the file mails of the inputs where each mail
+// is a single file, which holds the headers and the body
+func DefaultEMLFileMailConfigs() []FileMailConfig {
+	return []FileMailConfig{
+		{
+			Args:  map[string]any{},
+			Index: 0,
+			Type:  "eml",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 1,
+			Type:  "header_from",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 2,
+			Type:  "header_to",
+		},
+		{
+			Args: map[string]any{
+				"default": "no subject",
+			},
+			Index: 3,
+			Type:  "header_subject",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 4,
+			Type:  "header_contenttype",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 5,
+			Type:  "header_msgid",
+		},
+	}
+}
+
+// DefaultJobFileMailConfigs are the file mails of the job input, where the
+// job transformer sets every field of the mail
+func DefaultJobFileMailConfigs() []FileMailConfig {
+	return []FileMailConfig{
+		{
+			Args:  map[string]any{},
+			Index: 0,
+			Type:  "job",
+		},
+	}
+}
+
+// DefaultQfFileMailConfigs are the file mails of the df/qf pairs of the smtp
+// listener, where the qf holds the headers and the envelope
+func DefaultQfFileMailConfigs() []FileMailConfig {
+	return []FileMailConfig{
+		{
+			Args:  map[string]any{},
+			Index: 0,
+			Type:  "qf",
+		},
+		{
+			Args: map[string]any{
+				"default": "no subject",
+			},
+			Index: 1,
+			Type:  "header_subject",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 2,
+			Type:  "header_contenttype",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 3,
+			Type:  "header_msgid",
+		},
+		{
+			Args:  map[string]any{},
+			Index: 4,
+			Type:  "body",
+		},
+	}
+}
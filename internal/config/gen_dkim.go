@@ -2,18 +2,49 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
 )
 
+const (
+	DefaultDKIMPropagationDelay = 48 * time.Hour
+	DefaultDKIMRotateOverlap    = 24 * time.Hour
+
+	GenDKIMFormatBIND = "bind"
+	GenDKIMFormatJSON = "json"
+	GenDKIMFormatText = "text"
+	GenDKIMFormatYAML = "yaml"
+)
+
+var (
+	SupportedGenDKIMFormats = []string{GenDKIMFormatBIND, GenDKIMFormatJSON, GenDKIMFormatText, GenDKIMFormatYAML}
+)
+
+// GenDKIMConfig configures gendkim. Format selects the output: text with
+// the TXT record and the mail-processors config, bind for the zone file
+// line, json for DNS APIs, or yaml for the mail-processors config only.
+// The Record fields set the optional h, s and t tags of the TXT record.
+// With WriteConfig, the selector is also added to that config file.
 type GenDKIMConfig struct {
-	Algorithm string `mapstructure:"algorithm,omitempty"`
-	BitSize   int    `mapstructure:"bit-size,omitempty"`
-	Domain    string `mapstructure:"dkim-domain"`
-	Hash      string `mapstructure:"hash,omitempty"`
-	OutPath   string `mapstructure:"out-path"`
-	Selector  string `mapstructure:"selector"`
+	Algorithm      string   `mapstructure:"algorithm,omitempty"`
+	BitSize        int      `mapstructure:"bit-size,omitempty"`
+	Domain         string   `mapstructure:"dkim-domain"`
+	Format         string   `mapstructure:"record-format,omitempty"`
+	Hash           string   `mapstructure:"hash,omitempty"`
+	OutPath        string   `mapstructure:"out-path"`
+	RecordFlags    []string `mapstructure:"record-flags,omitempty"`
+	RecordHashes   []string `mapstructure:"record-hashes,omitempty"`
+	RecordServices []string `mapstructure:"record-services,omitempty"`
+	Selector       string   `mapstructure:"selector"`
+	WriteConfig    string   `mapstructure:"write-config,omitempty"`
 }
 
 func NewGenDKIMConfig(ctx context.Context) GenDKIMConfig {
@@ -28,6 +59,11 @@ func NewGenDKIMConfig(ctx context.Context) GenDKIMConfig {
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Unmarshal")
 	}
+
+	err = result.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("GenDKIMConfig.Transform")
+	}
 	allSettings := viper.AllSettings()
 
 	logger.Info().
@@ -37,3 +73,107 @@ func NewGenDKIMConfig(ctx context.Context) GenDKIMConfig {
 
 	return result
 }
+
+func (c *GenDKIMConfig) Transform(_ context.Context) error {
+	c.Format = strings.ToLower(c.Format)
+	if c.Format == "" {
+		c.Format = GenDKIMFormatText
+	}
+	if !slices.Contains(SupportedGenDKIMFormats, c.Format) {
+		return &errors.ConfigError{
+			Field: "Format",
+			Message: fmt.Sprintf("unsupported format %s, supported: %v",
+				c.Format, SupportedGenDKIMFormats),
+		}
+	}
+	return nil
+}
+
+// GenDKIMRotateConfig configures gendkim rotate, which generates the key of
+// the selector that replaces Selector. The new selector becomes active at
+// ActiveFrom, by default after PropagationDelay, and the current selector
+// keeps signing for Overlap after that.
+type GenDKIMRotateConfig struct {
+	GenDKIMConfig    `mapstructure:",squash"`
+	ActiveFrom       time.Time     `mapstructure:",omitempty"`
+	ActiveFromStr    string        `mapstructure:"active-from,omitempty"`
+	NextSelector     string        `mapstructure:"next-selector,omitempty"`
+	Overlap          time.Duration `mapstructure:"overlap,omitempty"`
+	PropagationDelay time.Duration `mapstructure:"propagation-delay,omitempty"`
+}
+
+func NewGenDKIMRotateConfig(ctx context.Context) GenDKIMRotateConfig {
+	logger := zerolog.Ctx(ctx)
+	var err error
+	var result GenDKIMRotateConfig
+	viper.SetDefault("algorithm", "rsa")
+	viper.SetDefault("bit-size", 2048)
+	viper.SetDefault("hash", "sha256")
+	viper.SetDefault("out-path", "./config")
+	viper.SetDefault("overlap", DefaultDKIMRotateOverlap)
+	viper.SetDefault("propagation-delay", DefaultDKIMPropagationDelay)
+	err = viper.Unmarshal(&result)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Unmarshal")
+	}
+
+	err = result.Transform(ctx, time.Now())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("GenDKIMRotateConfig.Transform")
+	}
+
+	logger.Info().
+		Interface("allSettings", viper.AllSettings()).
+		Interface("result", result).
+		Msg("GenDKIMRotateConfig init")
+
+	return result
+}
+
+func (c *GenDKIMRotateConfig) Transform(_ context.Context, now time.Time) error {
+	var err error
+	if c.NextSelector == "" {
+		c.NextSelector, err = NextSelectorName(c.Selector)
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   "NextSelector",
+				Message: "next-selector is required",
+				Err:     err,
+			}
+		}
+	}
+	if c.NextSelector == c.Selector {
+		return &errors.ConfigError{
+			Field:   "NextSelector",
+			Message: fmt.Sprintf("next-selector must differ from selector %s", c.Selector),
+		}
+	}
+	if c.ActiveFromStr == "" {
+		c.ActiveFrom = now.Add(c.PropagationDelay).UTC().Truncate(time.Second)
+		return nil
+	}
+	c.ActiveFrom, err = time.Parse(time.RFC3339, c.ActiveFromStr)
+	if err != nil {
+		return &errors.ConfigError{
+			Field:   "ActiveFrom",
+			Message: fmt.Sprintf("invalid active-from %s, expected RFC 3339", c.ActiveFromStr),
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// NextSelectorName increments the number at the end of a selector, keeping
+// its width, e.g. key001 becomes key002
+func NextSelectorName(selector string) (string, error) {
+	prefix := strings.TrimRightFunc(selector, unicode.IsDigit)
+	digits := selector[len(prefix):]
+	if digits == "" {
+		return "", fmt.Errorf("selector %q does not end with a number", selector)
+	}
+	number, err := strconv.Atoi(digits)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%0*d", prefix, len(digits), number+1), nil
+}
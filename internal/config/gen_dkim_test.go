@@ -0,0 +1,71 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDKIMRotateConfig(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		cfg              GenDKIMRotateConfig
+		wantNextSelector string
+		wantActiveFrom   time.Time
+		wantErr          bool
+	}{
+		{
+			name: "increment selector",
+			cfg: GenDKIMRotateConfig{
+				GenDKIMConfig:    GenDKIMConfig{Selector: "key009"},
+				PropagationDelay: 48 * time.Hour,
+			},
+			wantNextSelector: "key010",
+			wantActiveFrom:   now.Add(48 * time.Hour),
+		},
+		{
+			name: "explicit next selector and active-from",
+			cfg: GenDKIMRotateConfig{
+				GenDKIMConfig: GenDKIMConfig{Selector: "default"},
+				ActiveFromStr: "2025-08-01T00:00:00Z",
+				NextSelector:  "2025q3",
+			},
+			wantNextSelector: "2025q3",
+			wantActiveFrom:   time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "selector without number",
+			cfg: GenDKIMRotateConfig{
+				GenDKIMConfig: GenDKIMConfig{Selector: "default"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid active-from",
+			cfg: GenDKIMRotateConfig{
+				GenDKIMConfig: GenDKIMConfig{Selector: "key001"},
+				ActiveFromStr: "tomorrow",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			err := tt.cfg.Transform(ctx, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNextSelector, tt.cfg.NextSelector)
+			assert.Equal(t, tt.wantActiveFrom, tt.cfg.ActiveFrom)
+		})
+	}
+}
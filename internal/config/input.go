@@ -0,0 +1,179 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	// InputTypeSendmail reads df/qf pairs of a sendmail queue directory
+	InputTypeSendmail = "sendmail"
+	// InputTypeEML reads whole RFC 5322 .eml files from a directory
+	InputTypeEML = "eml"
+	// InputTypeMaildir reads the new messages of a Maildir, moving them to cur
+	InputTypeMaildir = "maildir"
+	// InputTypeMbox reads the messages of an mbox file
+	InputTypeMbox = "mbox"
+	// InputTypeJob reads the .json, .yaml and .yml mail jobs of a directory
+	InputTypeJob = "job"
+	// InputTypeStream reads the mail jobs of a redis stream, with a consumer
+	// group
+	InputTypeStream = "stream"
+
+	MboxFormatMboxrd  = "mboxrd"
+	MboxFormatMboxcl2 = "mboxcl2"
+	MboxIDOffset      = "offset"
+	MboxIDMessageID   = "message-id"
+
+	DefaultInputStream        = "remiges-smtp:jobs"
+	DefaultInputGroup         = "remiges-smtp"
+	DefaultInputClaimTimeout  = 5 * time.Minute
+	DefaultInputMaxDeliveries = 5
+	// DefaultInputDeadLetterSuffix is appended to the stream for the default
+	// dead-letter stream
+	DefaultInputDeadLetterSuffix = ":dead"
+)
+
+var (
+	SupportedInputTypes = []string{
+		InputTypeSendmail, InputTypeEML, InputTypeMaildir, InputTypeMbox, InputTypeJob, InputTypeStream,
+	}
+	SupportedMboxFormats = []string{MboxFormatMboxrd, MboxFormatMboxcl2}
+	SupportedMboxIDs     = []string{MboxIDOffset, MboxIDMessageID}
+)
+
+// InputConfig selects the file reader of read-file.in-path, which is the
+// mbox file for the mbox input. The stream input reads the redis of
+// read-file.redis-addr instead.
+//
+//	input:
+//	  type: mbox
+//	  mbox-format: mboxrd
+//	  mbox-id: message-id
+//	  progress-file: /app/data/archive.mbox.progress
+//
+//	input:
+//	  type: stream
+//	  stream: remiges-smtp:jobs
+//	  group: remiges-smtp
+//	  consumer: worker-1
+//	  claim-timeout: 5m
+//	  max-deliveries: 5
+//	  dead-letter-stream: remiges-smtp:jobs:dead
+type InputConfig struct {
+	Type string `mapstructure:"type"`
+	// MboxFormat is mboxrd or mboxcl2, mboxrd by default
+	MboxFormat string `mapstructure:"mbox-format,omitempty"`
+	// MboxID identifies the messages by offset or message-id, offset by default
+	MboxID string `mapstructure:"mbox-id,omitempty"`
+	// ProgressFile records the progress of the mbox import, the mbox path
+	// with .progress by default
+	ProgressFile string `mapstructure:"progress-file,omitempty"`
+	// Stream is the redis stream of the stream input, remiges-smtp:jobs by
+	// default
+	Stream string `mapstructure:"stream,omitempty"`
+	// Group is the consumer group of the stream, remiges-smtp by default
+	Group string `mapstructure:"group,omitempty"`
+	// Consumer is the name of this instance in the group, the hostname by
+	// default. It must be unique, and stable across restarts.
+	Consumer string `mapstructure:"consumer,omitempty"`
+	// ClaimTimeout is how long an entry may stay pending before another
+	// consumer claims it, 5m by default. It must be longer than a delivery.
+	ClaimTimeout time.Duration `mapstructure:"claim-timeout,omitempty"`
+	// MaxDeliveries is how many times an entry is read before it is moved to
+	// the dead-letter stream, 5 by default
+	MaxDeliveries int64 `mapstructure:"max-deliveries,omitempty"`
+	// DeadLetterStream holds the entries given up on, with their error, the
+	// stream with :dead by default
+	DeadLetterStream string `mapstructure:"dead-letter-stream,omitempty"`
+}
+
+func (c *InputConfig) Transform(_ context.Context) error {
+	c.Type = strings.ToLower(c.Type)
+	if c.Type == "" {
+		c.Type = InputTypeSendmail
+	}
+	if !slices.Contains(SupportedInputTypes, c.Type) {
+		return &errors.ConfigError{
+			Field: "Input.Type",
+			Message: fmt.Sprintf("unsupported input type %s, supported: %v",
+				c.Type, SupportedInputTypes),
+		}
+	}
+	if c.Type == InputTypeStream {
+		return c.transformStream()
+	}
+	if c.Type != InputTypeMbox {
+		return nil
+	}
+	c.MboxFormat = strings.ToLower(c.MboxFormat)
+	if c.MboxFormat == "" {
+		c.MboxFormat = MboxFormatMboxrd
+	}
+	if !slices.Contains(SupportedMboxFormats, c.MboxFormat) {
+		return &errors.ConfigError{
+			Field: "Input.MboxFormat",
+			Message: fmt.Sprintf("unsupported mbox format %s, supported: %v",
+				c.MboxFormat, SupportedMboxFormats),
+		}
+	}
+	c.MboxID = strings.ToLower(c.MboxID)
+	if c.MboxID == "" {
+		c.MboxID = MboxIDOffset
+	}
+	if !slices.Contains(SupportedMboxIDs, c.MboxID) {
+		return &errors.ConfigError{
+			Field: "Input.MboxID",
+			Message: fmt.Sprintf("unsupported mbox id %s, supported: %v",
+				c.MboxID, SupportedMboxIDs),
+		}
+	}
+	return nil
+}
+
+// transformStream sets the defaults of the stream input
+func (c *InputConfig) transformStream() error {
+	if c.Stream == "" {
+		c.Stream = DefaultInputStream
+	}
+	if c.Group == "" {
+		c.Group = DefaultInputGroup
+	}
+	if c.Consumer == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   "Input.Consumer",
+				Message: fmt.Sprintf("no consumer, and no hostname: %v", err),
+			}
+		}
+		c.Consumer = hostname
+	}
+	if c.ClaimTimeout <= 0 {
+		c.ClaimTimeout = DefaultInputClaimTimeout
+	}
+	if c.MaxDeliveries <= 0 {
+		c.MaxDeliveries = DefaultInputMaxDeliveries
+	}
+	if c.DeadLetterStream == "" {
+		c.DeadLetterStream = c.Stream + DefaultInputDeadLetterSuffix
+	}
+	if c.DeadLetterStream == c.Stream {
+		return &errors.ConfigError{
+			Field:   "Input.DeadLetterStream",
+			Message: "the dead-letter stream must differ from the stream",
+		}
+	}
+	return nil
+}
+
+// SingleFile tells whether each mail is a single file, without a qf file
+func (c *InputConfig) SingleFile() bool {
+	return c.Type != InputTypeSendmail
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	// LanePrioritySourceQf reads the priority from the P record of the qf file
+	LanePrioritySourceQf = "qf"
+	// LanePrioritySourceHeader reads the priority from a header of the qf file
+	LanePrioritySourceHeader = "header"
+	// LanePrioritySourceSubdir sorts the files of a subdirectory of
+	// read-file.in-path named after a lane into that lane
+	LanePrioritySourceSubdir = "subdir"
+
+	DefaultLanePriorityHeader = "X-Priority"
+)
+
+var SupportedLanePrioritySources = []string{LanePrioritySourceQf, LanePrioritySourceHeader, LanePrioritySourceSubdir}
+
+// LanesConfig sorts the mails into lanes by their priority, each with its own
+// workers and rate. The lanes are in order of urgency, the workers of a lane
+// also send the mails of the more urgent lanes first.
+//
+//	lanes:
+//	  enabled: true
+//	  source: header
+//	  header: X-Priority
+//	  default-priority: 3
+//	  lanes:
+//	    - name: transactional
+//	      max-priority: 2
+//	      concurrency: 4
+//	    - name: bulk
+//	      concurrency: 2
+//	      rate: 10
+//	      burst: 10
+type LanesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Source is where the priority is read, qf, header or subdir, qf by default
+	Source string `mapstructure:"source"`
+	// Header holds the priority, for the header source, X-Priority by default
+	Header string `mapstructure:"header,omitempty"`
+	// DefaultPriority is the priority of the mails without one
+	DefaultPriority int64 `mapstructure:"default-priority"`
+	// Lanes are the lanes, most urgent first
+	Lanes []LaneConfig `mapstructure:"lanes"`
+}
+
+// LaneConfig is a lane of mails, with its workers and rate
+type LaneConfig struct {
+	Name string `mapstructure:"name"`
+	// MaxPriority is the least urgent priority of the lane, the lower the
+	// more urgent, ignored for the last lane which takes the mails left
+	MaxPriority int64 `mapstructure:"max-priority"`
+	// Concurrency is the number of workers of the lane, 1 by default
+	Concurrency int `mapstructure:"concurrency"`
+	// Rate is the number of mails sent per second, unlimited when 0
+	Rate float64 `mapstructure:"rate,omitempty"`
+	// Burst is the number of mails sent at once within the rate, the rate
+	// rounded up by default
+	Burst int `mapstructure:"burst,omitempty"`
+}
+
+// Transform sets the defaults of enabled lanes, which read the df/qf pairs of
+// the sendmail input. The messages queued in memory by the api or the smtp
+// listener have no lane, so queued rejects the lanes.
+func (c *LanesConfig) Transform(_ context.Context, input InputConfig, readFile ReadFileConfig, queued bool) error {
+	if !c.Enabled {
+		return nil
+	}
+	if queued {
+		return &errors.ConfigError{
+			Field:   "Lanes.Enabled",
+			Message: "the lanes cannot be combined with the queue mode of the api or the smtp server",
+		}
+	}
+	if input.Type != InputTypeSendmail || readFile.Watch {
+		return &errors.ConfigError{
+			Field:   "Lanes.Enabled",
+			Message: fmt.Sprintf("the lanes only support the %s input, without watch", InputTypeSendmail),
+		}
+	}
+	if c.Source == "" {
+		c.Source = LanePrioritySourceQf
+	}
+	if !slices.Contains(SupportedLanePrioritySources, c.Source) {
+		return &errors.ConfigError{
+			Field: "Lanes.Source",
+			Message: fmt.Sprintf("unsupported source %s, supported: %v",
+				c.Source, SupportedLanePrioritySources),
+		}
+	}
+	if c.Source == LanePrioritySourceSubdir && readFile.ShardDepth < 1 {
+		return &errors.ConfigError{
+			Field:   "Lanes.Source",
+			Message: "the subdir source needs a read-file.shard-depth of at least 1",
+		}
+	}
+	if c.Header == "" {
+		c.Header = DefaultLanePriorityHeader
+	}
+	if len(c.Lanes) == 0 {
+		return &errors.ConfigError{
+			Field:   "Lanes.Lanes",
+			Message: "at least one lane is required",
+		}
+	}
+	for i := range c.Lanes {
+		lane := &c.Lanes[i]
+		if lane.Name == "" {
+			return &errors.ConfigError{
+				Field:   "Lanes.Lanes.Name",
+				Message: fmt.Sprintf("lane %d has no name", i),
+			}
+		}
+		if slices.ContainsFunc(c.Lanes[:i], func(other LaneConfig) bool { return other.Name == lane.Name }) {
+			return &errors.ConfigError{
+				Field:   "Lanes.Lanes.Name",
+				Message: fmt.Sprintf("the lane %s is repeated", lane.Name),
+			}
+		}
+		if lane.Concurrency <= 0 {
+			lane.Concurrency = 1
+		}
+		if lane.Rate < 0 || lane.Burst < 0 {
+			return &errors.ConfigError{
+				Field:   "Lanes.Lanes.Rate",
+				Message: fmt.Sprintf("the rate and burst of the lane %s cannot be negative", lane.Name),
+			}
+		}
+		if lane.Rate > 0 && lane.Burst == 0 {
+			lane.Burst = int(math.Ceil(lane.Rate))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	DefaultLifecycleCleanupInterval = time.Hour
+)
+
+// SupportedLifecycleInputTypes are the inputs which read files of their own
+// from read-file.in-path
+var SupportedLifecycleInputTypes = []string{InputTypeSendmail, InputTypeEML, InputTypeJob}
+
+// LifecycleConfig moves the processed files out of read-file.in-path, into
+// the archive, failed and quarantine directories of path
+//
+//	lifecycle:
+//	  enabled: true
+//	  path: /var/spool/remiges-smtp
+//	  dated: true
+//	  gzip: true
+//	  retention: 720h
+//	  cleanup-interval: 1h
+type LifecycleConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path holds the archive, failed and quarantine directories,
+	// read-file.in-path by default
+	Path string `mapstructure:"path,omitempty"`
+	// Dated archives into year/month/day subdirectories
+	Dated bool `mapstructure:"dated"`
+	// Gzip compresses the archived files
+	Gzip bool `mapstructure:"gzip"`
+	// Retention is how long the moved files are kept, forever when 0
+	Retention time.Duration `mapstructure:"retention,omitempty"`
+	// CleanupInterval is how often the old files are removed, 1h by default
+	CleanupInterval time.Duration `mapstructure:"cleanup-interval,omitempty"`
+}
+
+// Transform sets the defaults of an enabled lifecycle, and checks that the
+// input reads files of its own
+func (c *LifecycleConfig) Transform(_ context.Context, input InputConfig, inPath string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if !slices.Contains(SupportedLifecycleInputTypes, input.Type) {
+		return &errors.ConfigError{
+			Field: "Lifecycle.Enabled",
+			Message: fmt.Sprintf("the lifecycle does not support the %s input, supported: %v",
+				input.Type, SupportedLifecycleInputTypes),
+		}
+	}
+	if c.Path == "" {
+		c.Path = inPath
+	}
+	if c.Path == "" {
+		return &errors.ConfigError{
+			Field:   "Lifecycle.Path",
+			Message: "a path or read-file.in-path is required",
+		}
+	}
+	if c.Retention < 0 {
+		return &errors.ConfigError{
+			Field:   "Lifecycle.Retention",
+			Message: "the retention cannot be negative",
+		}
+	}
+	if c.CleanupInterval <= 0 {
+		c.CleanupInterval = DefaultLifecycleCleanupInterval
+	}
+	return nil
+}
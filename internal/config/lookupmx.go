@@ -1,26 +1,56 @@
 package config
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
 )
 
+const (
+	LookupMXOutputJSON  = "json"
+	LookupMXOutputTable = "table"
+)
+
+var (
+	SupportedLookupMXOutputs = []string{LookupMXOutputJSON, LookupMXOutputTable}
+)
+
+// LookupMXConfig configures the lookupmx report.
+// Domain, Domains and the lines of DomainsFile are merged into Domains by Transform.
+// In DomainsFile, empty lines and lines starting with # are ignored.
 type LookupMXConfig struct {
-	Domain string `mapstructure:"domain"`
+	DNS         DNSConfig `mapstructure:"dns"`
+	Domain      string    `mapstructure:"domain"`
+	Domains     []string  `mapstructure:"domains,omitempty"`
+	DomainsFile string    `mapstructure:"domains-file,omitempty"`
+	Output      string    `mapstructure:"output,omitempty"`
 }
 
 func NewLookupMXConfig(ctx context.Context) LookupMXConfig {
 	logger := zerolog.Ctx(ctx)
 	var err error
 
-	var result LookupMXConfig
+	result := LookupMXConfig{
+		DNS:    DefaultDNSConfig(),
+		Output: LookupMXOutputTable,
+	}
 	err = viper.Unmarshal(&result)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Unmarshal")
 	}
 
+	err = result.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("LookupMXConfig.Transform")
+	}
+
 	logger.Info().
 		Interface("viper.AllSettings", viper.AllSettings()).
 		Interface("result", result).
@@ -28,3 +58,62 @@ func NewLookupMXConfig(ctx context.Context) LookupMXConfig {
 
 	return result
 }
+
+func (c *LookupMXConfig) Transform(ctx context.Context) error {
+	if c.Output == "" {
+		c.Output = LookupMXOutputTable
+	}
+	if !slices.Contains(SupportedLookupMXOutputs, c.Output) {
+		return &errors.ConfigError{
+			Field: "Output",
+			Message: fmt.Sprintf("unsupported output %s, supported: %v",
+				c.Output, SupportedLookupMXOutputs),
+		}
+	}
+
+	domains := make([]string, 0, len(c.Domains)+1)
+	if c.Domain != "" {
+		domains = append(domains, c.Domain)
+	}
+	domains = append(domains, c.Domains...)
+	if c.DomainsFile != "" {
+		fileDomains, err := readDomainsFile(c.DomainsFile)
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   "DomainsFile",
+				Message: fmt.Sprintf("cannot read domains from %s", c.DomainsFile),
+				Err:     err,
+			}
+		}
+		domains = append(domains, fileDomains...)
+	}
+	c.Domains = make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = NormalizeDomain(domain)
+		if domain == "" || slices.Contains(c.Domains, domain) {
+			continue
+		}
+		c.Domains = append(c.Domains, domain)
+	}
+
+	return c.DNS.Transform(ctx)
+}
+
+func readDomainsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result, scanner.Err()
+}
@@ -5,6 +5,7 @@ import "context"
 const (
 	ConfigOutputTypeFile             string = "file"
 	ConfigOutputTypeFileTracker      string = "file_tracker"
+	ConfigOutputTypeResults          string = "results"
 	ConfigArgPath                    string = "path"
 	ConfigArgFileNameType            string = "file_name_type"
 	ConfigArgFileNameTypeDate        string = "date"
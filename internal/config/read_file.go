@@ -2,10 +2,14 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
 )
 
 type ConfigType int
@@ -16,8 +20,19 @@ const (
 
 	ConfigTypeHeadersStr = "headers"
 	ConfigTypeDefaultStr = "default"
+
+	// ReadFileOrderFilename reads the files in the order of their names
+	ReadFileOrderFilename = "filename"
+	// ReadFileOrderMtime reads the oldest files first
+	ReadFileOrderMtime = "mtime"
+	// ReadFileOrderPriority reads the lowest qf priority first, then the oldest
+	ReadFileOrderPriority = "priority"
+
+	DefaultReadFileFullScanInterval = time.Hour
 )
 
+var SupportedReadFileOrders = []string{ReadFileOrderFilename, ReadFileOrderMtime, ReadFileOrderPriority}
+
 type ReadFileConfig struct {
 	Concurrency  int              `mapstructure:"concurrency"`
 	DefaultFrom  string           `mapstructure:"from"`
@@ -25,6 +40,66 @@ type ReadFileConfig struct {
 	InPath       string           `mapstructure:"in-path"`
 	PollInterval time.Duration    `mapstructure:"poll-interval"`
 	RedisAddr    string           `mapstructure:"redis-addr"`
+	// Watch queues df/qf pairs on filesystem events instead of polling
+	Watch bool `mapstructure:"watch"`
+	// RescanInterval is how often a watched directory is rescanned
+	RescanInterval time.Duration `mapstructure:"rescan-interval"`
+	// SettleDelay is how long a watched pair must be unchanged before it is read
+	SettleDelay time.Duration `mapstructure:"settle-delay"`
+	// Incremental only lists the directories that changed since the last scan
+	Incremental bool `mapstructure:"incremental"`
+	// ShardDepth is the number of levels of hashed subdirectories, e.g. 2 for
+	// in-path/ab/cd/dfXYZ, it implies incremental
+	ShardDepth int `mapstructure:"shard-depth"`
+	// Order is filename, mtime or priority, it implies incremental
+	Order string `mapstructure:"order"`
+	// FullScanInterval is how often an incremental scan lists every directory,
+	// and queues again the files left, 1h by default
+	FullScanInterval time.Duration `mapstructure:"full-scan-interval"`
+}
+
+// Transform sets the defaults of the incremental scan, which reads the
+// df/qf pairs of the sendmail input
+func (c *ReadFileConfig) Transform(_ context.Context, input InputConfig) error {
+	c.Order = strings.ToLower(c.Order)
+	if c.ShardDepth != 0 || c.Order != "" {
+		c.Incremental = true
+	}
+	if !c.Incremental {
+		return nil
+	}
+	if input.Type != InputTypeSendmail {
+		return &errors.ConfigError{
+			Field:   "ReadFile.Incremental",
+			Message: fmt.Sprintf("the incremental scan does not support the %s input", input.Type),
+		}
+	}
+	if c.Watch {
+		return &errors.ConfigError{
+			Field:   "ReadFile.Incremental",
+			Message: "the incremental scan cannot be combined with watch",
+		}
+	}
+	if c.ShardDepth < 0 {
+		return &errors.ConfigError{
+			Field:   "ReadFile.ShardDepth",
+			Message: "the shard depth cannot be negative",
+		}
+	}
+	if c.Order == "" {
+		c.Order = ReadFileOrderFilename
+	}
+	if !slices.Contains(SupportedReadFileOrders, c.Order) {
+		return &errors.ConfigError{
+			Field: "ReadFile.Order",
+			Message: fmt.Sprintf("unsupported order %s, supported: %v",
+				c.Order, SupportedReadFileOrders),
+		}
+	}
+	if c.FullScanInterval <= 0 {
+		c.FullScanInterval = DefaultReadFileFullScanInterval
+	}
+	return nil
 }
 
 func NewReadFileConfig(ctx context.Context) ReadFileConfig {
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	SandboxModeDrop    = "drop"
+	SandboxModeRewrite = "rewrite"
+)
+
+var (
+	SupportedSandboxModes = []string{SandboxModeDrop, SandboxModeRewrite}
+)
+
+// SandboxConfig restricts the recipients that mail can be delivered to.
+// Recipients matching an allowlist pattern are delivered as-is, all others
+// are either rewritten to the catch-all address or dropped.
+//
+// Patterns containing an "@" are matched against the full address, all other
+// patterns are matched against the recipient domain, e.g.
+//
+//	allowlist:
+//	  - "*@stlim.net"
+//	  - "qa-*@example.com"
+//	  - "*.test"
+type SandboxConfig struct {
+	Allowlist    []string     `mapstructure:"allowlist,omitempty"`
+	CatchAll     string       `mapstructure:"catch-all,omitempty"`
+	CatchAllAddr smtp.Address `mapstructure:",omitempty"`
+	Enabled      bool         `mapstructure:"enabled"`
+	Mode         string       `mapstructure:"mode,omitempty"`
+}
+
+func (c *SandboxConfig) Transform(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	if !c.Enabled {
+		return nil
+	}
+	if c.Mode == "" {
+		c.Mode = SandboxModeDrop
+	}
+	if !slices.Contains(SupportedSandboxModes, c.Mode) {
+		return &errors.ConfigError{
+			Field: "Sandbox.Mode",
+			Message: fmt.Sprintf("unsupported mode %s, supported: %v",
+				c.Mode, SupportedSandboxModes),
+		}
+	}
+	if c.Mode == SandboxModeRewrite {
+		c.CatchAllAddr, err = smtp.ParseAddress(c.CatchAll)
+		if err != nil {
+			logger.Error().Err(err).Msg("SandboxConfig.Transform.CatchAll")
+			return &errors.ConfigError{
+				Field:   "Sandbox.CatchAll",
+				Message: "catch-all address is required for rewrite mode",
+				Err:     err,
+			}
+		}
+	}
+	return nil
+}
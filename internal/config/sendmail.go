@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,10 +16,15 @@ import (
 )
 
 type SendMailConfig struct {
+	API            APIConfig             `mapstructure:"api"`
 	Debug          bool                  `mapstructure:"debug"`
 	Dialer         DialerConfig          `mapstructure:"dialer"`
+	DNS            DNSConfig             `mapstructure:"dns"`
 	From           string                `mapstructure:"from"`
 	FromAddr       smtp.Address          `mapstructure:",omitempty"`
+	Input          InputConfig           `mapstructure:"input"`
+	Lanes          LanesConfig           `mapstructure:"lanes"`
+	Lifecycle      LifecycleConfig       `mapstructure:"lifecycle"`
 	To             string                `mapstructure:"to"`
 	ToAddr         smtp.Address          `mapstructure:",omitempty"`
 	Msg            string                `mapstructure:"msg"`
@@ -27,6 +33,8 @@ type SendMailConfig struct {
 	Outputs        []OutputConfig        `mapstructure:"outputs"`
 	PollInterval   time.Duration         `mapstructure:"poll-interval"`
 	ReadFileConfig ReadFileConfig        `mapstructure:"read-file"`
+	Sandbox        SandboxConfig         `mapstructure:"sandbox"`
+	SMTPServer     SMTPServerConfig      `mapstructure:"smtp-server"`
 }
 
 type DialerConfig struct {
@@ -58,6 +66,7 @@ func NewSendMailConfig(ctx context.Context) SendMailConfig {
 
 	// setting up default values
 	result := SendMailConfig{
+		DNS:            DefaultDNSConfig(),
 		MailProcessors: DefaultMailProcessorConfigs(),
 		Outputs:        DefaultOutputConfig(ctx),
 		ReadFileConfig: ReadFileConfig{
@@ -90,6 +99,68 @@ func NewSendMailConfig(ctx context.Context) SendMailConfig {
 
 	result.MsgBytes = []byte(result.Msg)
 
+	err = result.DNS.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("DNS.Transform")
+	}
+
+	err = result.Input.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Input.Transform")
+	}
+	err = result.ReadFileConfig.Transform(ctx, result.Input)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("ReadFileConfig.Transform")
+	}
+	// Single file mails hold their headers, unless other file mails are configured
+	if result.Input.SingleFile() && !viper.IsSet("read-file.file-mails") {
+		result.ReadFileConfig.FileMails = DefaultEMLFileMailConfigs()
+		if result.Input.Type == InputTypeJob || result.Input.Type == InputTypeStream {
+			result.ReadFileConfig.FileMails = DefaultJobFileMailConfigs()
+		}
+	}
+
+	err = result.API.Transform(ctx, result.Input)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("API.Transform")
+	}
+	// The api reads the results of the messages from the results output
+	if result.API.Enabled && !slices.ContainsFunc(result.Outputs, func(output OutputConfig) bool {
+		return output.Type == ConfigOutputTypeResults
+	}) {
+		result.Outputs = append(result.Outputs, OutputConfig{Type: ConfigOutputTypeResults})
+	}
+
+	// The envelope of the smtp listener is in the qf files it writes
+	if result.SMTPServer.Enabled && !viper.IsSet("read-file.file-mails") {
+		result.ReadFileConfig.FileMails = DefaultQfFileMailConfigs()
+	}
+	err = result.SMTPServer.Transform(ctx, result.Input, result.ReadFileConfig)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("SMTPServer.Transform")
+	}
+
+	err = result.Lanes.Transform(
+		ctx,
+		result.Input,
+		result.ReadFileConfig,
+		(result.API.Enabled && result.API.Mode == APIModeQueue) ||
+			(result.SMTPServer.Enabled && result.SMTPServer.Mode == SMTPServerModeQueue),
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Lanes.Transform")
+	}
+
+	err = result.Lifecycle.Transform(ctx, result.Input, result.ReadFileConfig.InPath)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Lifecycle.Transform")
+	}
+
+	err = result.Sandbox.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Sandbox.Transform")
+	}
+
 	logger.Info().
 		Interface("allSettings", allSettings).
 		Interface("result", result).
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	// SMTPServerModeSpool writes each message as a df/qf pair into
+	// read-file.in-path
+	SMTPServerModeSpool = "spool"
+	// SMTPServerModeQueue hands each message to the workers in memory, it is
+	// lost if the server stops before it is sent
+	SMTPServerModeQueue = "queue"
+
+	DefaultSMTPServerAddr                = ":2525"
+	DefaultSMTPServerMaxSize       int64 = 10 << 20
+	DefaultSMTPServerMaxRecipients int   = 100
+	DefaultSMTPServerTimeout             = 5 * time.Minute
+)
+
+var (
+	SupportedSMTPServerModes = []string{SMTPServerModeSpool, SMTPServerModeQueue}
+	// DefaultSMTPServerAllowedCIDRs only accepts local clients
+	DefaultSMTPServerAllowedCIDRs = []string{"127.0.0.0/8", "::1/128"}
+)
+
+// SMTPServerConfig configures the smtp listener of the server, which accepts
+// the messages of the applications that only speak smtp
+//
+//	smtp-server:
+//	  enabled: true
+//	  addr: ":2525"
+//	  hostname: relay.example.com
+//	  cert-file: /app/config/tls.crt
+//	  key-file: /app/config/tls.key
+//	  require-tls: true
+//	  require-auth: true
+//	  users:
+//	    - username: legacy-app
+//	      password: secret
+//	  max-size: 10485760
+//	  allowed-cidrs: [10.0.0.0/8]
+//	  mode: spool
+type SMTPServerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Addr is the listen address, :2525 by default
+	Addr string `mapstructure:"addr,omitempty"`
+	// Hostname is announced in the greeting, the host name by default
+	Hostname string `mapstructure:"hostname,omitempty"`
+	// CertFile and KeyFile enable STARTTLS
+	CertFile string `mapstructure:"cert-file,omitempty"`
+	KeyFile  string `mapstructure:"key-file,omitempty"`
+	// RequireTLS refuses mail before STARTTLS
+	RequireTLS bool `mapstructure:"require-tls,omitempty"`
+	// RequireAuth refuses mail before AUTH
+	RequireAuth bool `mapstructure:"require-auth,omitempty"`
+	// AllowInsecureAuth offers AUTH without TLS
+	AllowInsecureAuth bool `mapstructure:"allow-insecure-auth,omitempty"`
+	// Users are accepted by AUTH PLAIN and AUTH LOGIN
+	Users []SMTPUserConfig `mapstructure:"users,omitempty"`
+	// MaxSize is the limit of a message in bytes, 10 MiB by default
+	MaxSize int64 `mapstructure:"max-size,omitempty"`
+	// MaxRecipients is the limit of recipients of a message, 100 by default
+	MaxRecipients int `mapstructure:"max-recipients,omitempty"`
+	// AllowedCIDRs are the networks of the clients, the loopback by default
+	AllowedCIDRs []string `mapstructure:"allowed-cidrs,omitempty"`
+	// Mode is spool or queue, spool by default
+	Mode string `mapstructure:"mode,omitempty"`
+	// Timeout is the limit of each command and of the data, 5m by default
+	Timeout time.Duration `mapstructure:"timeout,omitempty"`
+
+	// AllowedPrefixes are the parsed AllowedCIDRs
+	AllowedPrefixes []netip.Prefix `mapstructure:"-"`
+}
+
+// SMTPUserConfig is a user of the smtp listener
+type SMTPUserConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Transform sets the defaults of an enabled smtp listener, and checks that
+// the input reads the df/qf pairs it writes, with the qf transformer for the
+// envelope in the qf
+func (c *SMTPServerConfig) Transform(_ context.Context, input InputConfig, readFile ReadFileConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Addr == "" {
+		c.Addr = DefaultSMTPServerAddr
+	}
+	if c.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		c.Hostname = hostname
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return &errors.ConfigError{
+			Field:   "SMTPServer.CertFile",
+			Message: "cert-file and key-file must be set together",
+		}
+	}
+	if c.RequireTLS && c.CertFile == "" {
+		return &errors.ConfigError{
+			Field:   "SMTPServer.RequireTLS",
+			Message: "require-tls requires cert-file and key-file",
+		}
+	}
+	for i, user := range c.Users {
+		if user.Username == "" || user.Password == "" {
+			return &errors.ConfigError{
+				Field:   fmt.Sprintf("SMTPServer.Users[%d]", i),
+				Message: "username and password are required",
+			}
+		}
+	}
+	if c.RequireAuth && len(c.Users) == 0 {
+		return &errors.ConfigError{
+			Field:   "SMTPServer.RequireAuth",
+			Message: "require-auth requires at least one user",
+		}
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultSMTPServerMaxSize
+	}
+	if c.MaxRecipients <= 0 {
+		c.MaxRecipients = DefaultSMTPServerMaxRecipients
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultSMTPServerTimeout
+	}
+	if len(c.AllowedCIDRs) == 0 {
+		c.AllowedCIDRs = DefaultSMTPServerAllowedCIDRs
+	}
+	c.AllowedPrefixes = make([]netip.Prefix, 0, len(c.AllowedCIDRs))
+	for _, cidr := range c.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return &errors.ConfigError{
+				Field:   "SMTPServer.AllowedCIDRs",
+				Message: fmt.Sprintf("invalid cidr %s: %v", cidr, err),
+			}
+		}
+		c.AllowedPrefixes = append(c.AllowedPrefixes, prefix.Masked())
+	}
+	c.Mode = strings.ToLower(c.Mode)
+	if c.Mode == "" {
+		c.Mode = SMTPServerModeSpool
+	}
+	if !slices.Contains(SupportedSMTPServerModes, c.Mode) {
+		return &errors.ConfigError{
+			Field: "SMTPServer.Mode",
+			Message: fmt.Sprintf("unsupported smtp server mode %s, supported: %v",
+				c.Mode, SupportedSMTPServerModes),
+		}
+	}
+	if input.Type != InputTypeSendmail {
+		return &errors.ConfigError{
+			Field: "SMTPServer.Enabled",
+			Message: fmt.Sprintf("the smtp server requires the input type to be %s, not %s",
+				InputTypeSendmail, input.Type),
+		}
+	}
+	if !slices.ContainsFunc(readFile.FileMails, func(fileMail FileMailConfig) bool {
+		return fileMail.Type == "qf"
+	}) {
+		return &errors.ConfigError{
+			Field:   "ReadFileConfig.FileMails",
+			Message: "the smtp server requires the qf file mail, which reads the envelope of its qf files",
+		}
+	}
+	return nil
+}
+
+// AllowedAddr tells whether a client address is in the allowed cidrs
+func (c *SMTPServerConfig) AllowedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range c.AllowedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
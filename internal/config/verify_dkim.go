@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+)
+
+const (
+	VerifyDKIMOutputJSON = "json"
+	VerifyDKIMOutputText = "text"
+)
+
+var (
+	SupportedVerifyDKIMOutputs = []string{VerifyDKIMOutputJSON, VerifyDKIMOutputText}
+)
+
+// VerifyDKIMConfig configures the verify-dkim check of a message file.
+// Public keys are looked up in DNS, except for the selectors of Keys, given as
+// selector=path to a PEM file, and with ConfigKeys, the selectors of the dkim
+// processors in MailProcessors. Transform parses Keys into KeyFiles.
+type VerifyDKIMConfig struct {
+	ConfigKeys     bool                  `mapstructure:"config-keys,omitempty"`
+	DNS            DNSConfig             `mapstructure:"dns"`
+	File           string                `mapstructure:",omitempty"`
+	KeyFiles       map[string]string     `mapstructure:",omitempty"`
+	Keys           []string              `mapstructure:"verify-keys,omitempty"`
+	MailProcessors []MailProcessorConfig `mapstructure:"mail-processors"`
+	Output         string                `mapstructure:"output,omitempty"`
+}
+
+func NewVerifyDKIMConfig(ctx context.Context, file string) VerifyDKIMConfig {
+	logger := zerolog.Ctx(ctx)
+	var err error
+
+	result := VerifyDKIMConfig{
+		DNS:            DefaultDNSConfig(),
+		MailProcessors: DefaultMailProcessorConfigs(),
+		Output:         VerifyDKIMOutputText,
+	}
+	err = viper.Unmarshal(&result)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Unmarshal")
+	}
+	result.File = file
+
+	err = result.Transform(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("VerifyDKIMConfig.Transform")
+	}
+
+	logger.Info().
+		Interface("viper.AllSettings", viper.AllSettings()).
+		Interface("result", result).
+		Msg("VerifyDKIMConfig init")
+
+	return result
+}
+
+func (c *VerifyDKIMConfig) Transform(ctx context.Context) error {
+	if c.File == "" {
+		return &errors.ConfigError{
+			Field:   "File",
+			Message: "message file is required",
+		}
+	}
+	if c.Output == "" {
+		c.Output = VerifyDKIMOutputText
+	}
+	if !slices.Contains(SupportedVerifyDKIMOutputs, c.Output) {
+		return &errors.ConfigError{
+			Field: "Output",
+			Message: fmt.Sprintf("unsupported output %s, supported: %v",
+				c.Output, SupportedVerifyDKIMOutputs),
+		}
+	}
+
+	c.KeyFiles = make(map[string]string, len(c.Keys))
+	for _, key := range c.Keys {
+		selector, path, found := strings.Cut(key, "=")
+		selector = strings.ToLower(strings.TrimSpace(selector))
+		path = strings.TrimSpace(path)
+		if !found || selector == "" || path == "" {
+			return &errors.ConfigError{
+				Field:   "Keys",
+				Message: fmt.Sprintf("invalid key %s, expected selector=path", key),
+			}
+		}
+		c.KeyFiles[selector] = path
+	}
+
+	return c.DNS.Transform(ctx)
+}
@@ -81,12 +81,11 @@ func (_ *Ed25519KeyGenerator) LoadPrivateKey(
 		return nil, err
 	}
 
-	privateKeyBlock, _ := pem.Decode(rawFileData)
-	if privateKeyBlock == nil {
-		logger.Error().Msg("failed to decode private key")
+	// Raw as written by gendkim, or PKCS#8 as written by openssl
+	result, err := ParsePrivateKeyPEM(keyType, rawFileData)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse private key")
 		return nil, err
 	}
-
-	result := ed25519.PrivateKey(privateKeyBlock.Bytes)
 	return result, nil
 }
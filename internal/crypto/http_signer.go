@@ -0,0 +1,240 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SignerPublicKeyPath is the path of the remote signer returning the
+	// public key, relative to the signer url
+	SignerPublicKeyPath = "/public-key"
+	// SignerSignPath is the path of the remote signer signing a digest,
+	// relative to the signer url
+	SignerSignPath = "/sign"
+	// DefaultSignerTimeout is the timeout of a request to a remote signer
+	DefaultSignerTimeout = 10 * time.Second
+)
+
+// SignerPublicKeyResponse is the response of GET <url>/public-key
+type SignerPublicKeyResponse struct {
+	KeyType   string `json:"key_type"`
+	PublicKey string `json:"public_key"`
+}
+
+// SignerSignRequest is the request of POST <url>/sign. Hash is empty for
+// ed25519, where Digest is the whole message.
+type SignerSignRequest struct {
+	Digest string `json:"digest"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+// SignerSignResponse is the response of POST <url>/sign
+type SignerSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+// HTTPKeyLoader loads a signer whose private key stays in a remote signing
+// service, e.g. in front of a KMS or HSM. The service is reached over http
+// with the protocol of SignerHandler, which can stand in for it locally.
+type HTTPKeyLoader struct {
+	Client *http.Client
+	Token  string
+}
+
+// LoadPrivateKey fetches the public key of the remote signer
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - keyType: The type of key to load (KeyTypeEd25519 or KeyTypeRSA)
+//   - signerURL: The url of the remote signer
+//
+// Returns:
+//   - crypto.Signer: A signer sending each digest to the remote signer
+//   - error: Non-nil if the signer is unreachable, or its key is not of the key type
+func (l *HTTPKeyLoader) LoadPrivateKey(
+	ctx context.Context,
+	keyType string,
+	signerURL string,
+) (crypto.Signer, error) {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("key_type", keyType).
+		Str("signer_url", signerURL).
+		Logger()
+
+	result := &HTTPSigner{
+		Client: l.Client,
+		Token:  l.Token,
+		URL:    strings.TrimSuffix(signerURL, "/"),
+	}
+	if result.Client == nil {
+		result.Client = &http.Client{Timeout: DefaultSignerTimeout}
+	}
+
+	var response SignerPublicKeyResponse
+	err := result.do(ctx, http.MethodGet, SignerPublicKeyPath, nil, &response)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to fetch public key")
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(response.PublicKey)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to decode public key")
+		return nil, err
+	}
+	result.publicKey, err = x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse public key")
+		return nil, err
+	}
+	if got := signerKeyType(result.publicKey); got != keyType {
+		logger.Error().Str("got", got).Msg("key type mismatch")
+		return nil, fmt.Errorf("remote signer key is %s, expected %s", got, keyType)
+	}
+	logger.Info().Msg("loaded remote signer")
+	return result, nil
+}
+
+// HTTPSigner is a crypto.Signer sending digests to a remote signer
+type HTTPSigner struct {
+	Client *http.Client
+	Token  string
+	URL    string
+
+	publicKey crypto.PublicKey
+}
+
+func (s *HTTPSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign sends the digest to the remote signer. Only PKCS#1 v1.5 rsa
+// signatures and ed25519 signatures, as used by DKIM, are supported.
+func (s *HTTPSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("missing signer options")
+	}
+	request := SignerSignRequest{Digest: base64.StdEncoding.EncodeToString(digest)}
+	if opts.HashFunc() != 0 {
+		request.Hash = opts.HashFunc().String()
+	}
+	var response SignerSignResponse
+	err := s.do(context.Background(), http.MethodPost, SignerSignPath, request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(response.Signature)
+}
+
+func (s *HTTPSigner) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, s.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("remote signer %s %s: %s: %s",
+			method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// SignerHandler serves the remote signer protocol with a local key, to stand
+// in for the signing service in development and tests.
+type SignerHandler struct {
+	Signer crypto.Signer
+	Token  string
+}
+
+func (h *SignerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Token != "" && r.Header.Get("Authorization") != "Bearer "+h.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, SignerPublicKeyPath):
+		der, err := x509.MarshalPKIXPublicKey(h.Signer.Public())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSignerJSON(w, SignerPublicKeyResponse{
+			KeyType:   signerKeyType(h.Signer),
+			PublicKey: base64.StdEncoding.EncodeToString(der),
+		})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, SignerSignPath):
+		var request SignerSignRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		digest, err := base64.StdEncoding.DecodeString(request.Digest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hash, err := signerHash(request.Hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signature, err := h.Signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSignerJSON(w, SignerSignResponse{
+			Signature: base64.StdEncoding.EncodeToString(signature),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func signerHash(name string) (crypto.Hash, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512} {
+		if hash.String() == name {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported hash %s", name)
+}
+
+func writeSignerJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPKeyLoader(t *testing.T) {
+	rsaKey, ed25519Key := testPrivateKeys(t)
+	message := []byte("dkim-signature data")
+	digest := sha256.Sum256(message)
+
+	tests := []struct {
+		name        string
+		signer      crypto.Signer
+		keyType     string
+		serverToken string
+		token       string
+		wantLoadErr bool
+	}{
+		{
+			name:        "rsa",
+			signer:      rsaKey,
+			keyType:     KeyTypeRSA,
+			serverToken: "token",
+			token:       "token",
+		},
+		{
+			name:    "ed25519",
+			signer:  ed25519Key,
+			keyType: KeyTypeEd25519,
+		},
+		{
+			name:        "key type mismatch",
+			signer:      ed25519Key,
+			keyType:     KeyTypeRSA,
+			wantLoadErr: true,
+		},
+		{
+			name:        "unauthorized",
+			signer:      rsaKey,
+			keyType:     KeyTypeRSA,
+			serverToken: "token",
+			token:       "wrong",
+			wantLoadErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			server := httptest.NewServer(&SignerHandler{Signer: tt.signer, Token: tt.serverToken})
+			defer server.Close()
+
+			loader := &HTTPKeyLoader{Client: server.Client(), Token: tt.token}
+			got, err := loader.LoadPrivateKey(ctx, tt.keyType, server.URL+"/keys/key001/")
+			if tt.wantLoadErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.signer.Public(), got.Public())
+
+			switch publicKey := got.Public().(type) {
+			case *rsa.PublicKey:
+				signature, err := got.Sign(rand.Reader, digest[:], crypto.SHA256)
+				require.NoError(t, err)
+				assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+			case ed25519.PublicKey:
+				signature, err := got.Sign(rand.Reader, message, crypto.Hash(0))
+				require.NoError(t, err)
+				assert.True(t, ed25519.Verify(publicKey, message, signature))
+			}
+		})
+	}
+}
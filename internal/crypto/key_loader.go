@@ -0,0 +1,255 @@
+package crypto
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// ParsePrivateKeyPEM parses an unencrypted private key in PKCS#1, PKCS#8 or
+// the raw ed25519 form written by gendkim, and checks it is of the key type.
+//
+// Parameters:
+//   - keyType: The expected type of key (KeyTypeEd25519 or KeyTypeRSA)
+//   - data: The PEM encoded key
+//
+// Returns:
+//   - crypto.Signer: An *rsa.PrivateKey or ed25519.PrivateKey
+//   - error: Non-nil if the data holds no supported key of the key type
+func ParsePrivateKeyPEM(keyType string, data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM formatted")
+	}
+	//nolint:staticcheck // legacy RFC 1423 encryption, as openssl -traditional writes
+	if x509.IsEncryptedPEMBlock(block) || block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, fmt.Errorf("private key is encrypted, use the encrypted-file key source")
+	}
+	return parsePrivateKeyBlock(keyType, block.Type, block.Bytes)
+}
+
+// ParsePrivateKeyDER parses a DER encoded private key in PKCS#8 or PKCS#1
+// form, or a raw ed25519 seed or private key
+//
+// Parameters:
+//   - keyType: The expected type of key (KeyTypeEd25519 or KeyTypeRSA)
+//   - der: The DER encoded key
+//
+// Returns:
+//   - crypto.Signer: An *rsa.PrivateKey or ed25519.PrivateKey
+//   - error: Non-nil if the data holds no supported key of the key type
+func ParsePrivateKeyDER(keyType string, der []byte) (crypto.Signer, error) {
+	if result, err := parsePrivateKeyBlock(keyType, "PRIVATE KEY", der); err == nil {
+		return result, nil
+	}
+	if keyType == KeyTypeEd25519 {
+		return parsePrivateKeyBlock(keyType, "ED25519 PRIVATE KEY", der)
+	}
+	return parsePrivateKeyBlock(keyType, "RSA PRIVATE KEY", der)
+}
+
+func parsePrivateKeyBlock(keyType string, blockType string, der []byte) (crypto.Signer, error) {
+	var result crypto.Signer
+	switch blockType {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		result = privateKey
+	case "ED25519 PRIVATE KEY":
+		switch len(der) {
+		case ed25519.PrivateKeySize:
+			result = ed25519.PrivateKey(der)
+		case ed25519.SeedSize:
+			result = ed25519.NewKeyFromSeed(der)
+		default:
+			return nil, fmt.Errorf("invalid ed25519 private key size %d", len(der))
+		}
+	case "PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+		}
+		result = signer
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", blockType)
+	}
+	if got := signerKeyType(result); got != keyType {
+		return nil, fmt.Errorf("private key is %s, expected %s", got, keyType)
+	}
+	return result, nil
+}
+
+// signerKeyType returns the key type of a private or public key
+func signerKeyType(key any) string {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// EncryptedFileKeyLoader loads a passphrase encrypted PEM file: a PKCS#8
+// ENCRYPTED PRIVATE KEY, as written by openssl 3 with -aes256, or a legacy
+// Proc-Type and DEK-Info encrypted key, as written with -traditional or by
+// openssl 1. The passphrase is read from the environment variable
+// PassphraseEnv, or else from the file PassphraseFile.
+type EncryptedFileKeyLoader struct {
+	PassphraseEnv  string
+	PassphraseFile string
+}
+
+// LoadPrivateKey decrypts and parses the private key file
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - keyType: The type of key to load (KeyTypeEd25519 or KeyTypeRSA)
+//   - privateKeyPath: Path to the encrypted private key file
+//
+// Returns:
+//   - crypto.Signer: A signer interface for the loaded private key
+//   - error: Non-nil if the passphrase is missing or wrong, or key loading fails
+func (l *EncryptedFileKeyLoader) LoadPrivateKey(
+	ctx context.Context,
+	keyType string,
+	privateKeyPath string,
+) (crypto.Signer, error) {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("key_type", keyType).
+		Str("private_key_path", privateKeyPath).
+		Logger()
+
+	passphrase, err := l.passphrase()
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to read passphrase")
+		return nil, err
+	}
+
+	rawFileData, err := os.ReadFile(filepath.Clean(privateKeyPath))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to read private key")
+		return nil, err
+	}
+	block, _ := pem.Decode(rawFileData)
+	if block == nil {
+		logger.Error().Msg("failed to decode private key")
+		return nil, fmt.Errorf("private key is not PEM formatted")
+	}
+	var der []byte
+	blockType := block.Type
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		der, err = decryptPKCS8(block.Bytes, passphrase)
+		blockType = "PRIVATE KEY"
+	//nolint:staticcheck // legacy RFC 1423 encryption, as openssl -traditional writes
+	case x509.IsEncryptedPEMBlock(block):
+		//nolint:staticcheck // see above
+		der, err = x509.DecryptPEMBlock(block, passphrase)
+	default:
+		logger.Error().Str("type", block.Type).Msg("private key is not encrypted")
+		return nil, fmt.Errorf("private key %s is not encrypted, but a %s", privateKeyPath, block.Type)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to decrypt private key")
+		return nil, err
+	}
+
+	result, err := parsePrivateKeyBlock(keyType, blockType, der)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse private key")
+		return nil, err
+	}
+	return result, nil
+}
+
+func (l *EncryptedFileKeyLoader) passphrase() ([]byte, error) {
+	if l.PassphraseEnv != "" {
+		value, ok := os.LookupEnv(l.PassphraseEnv)
+		if ok && value != "" {
+			return []byte(value), nil
+		}
+	}
+	if l.PassphraseFile != "" {
+		data, err := os.ReadFile(filepath.Clean(l.PassphraseFile))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	return nil, fmt.Errorf("no passphrase in environment variable %q or file %q",
+		l.PassphraseEnv, l.PassphraseFile)
+}
+
+// EnvKeyLoader loads a private key from an environment variable, holding a
+// PEM, or the base64 of a PEM or DER encoded key, so that the key can be
+// injected as a secret without a file.
+type EnvKeyLoader struct{}
+
+// LoadPrivateKey parses the private key in the environment variable
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - keyType: The type of key to load (KeyTypeEd25519 or KeyTypeRSA)
+//   - envName: Name of the environment variable
+//
+// Returns:
+//   - crypto.Signer: A signer interface for the loaded private key
+//   - error: Non-nil if the variable is not set, or key loading fails
+func (_ *EnvKeyLoader) LoadPrivateKey(
+	ctx context.Context,
+	keyType string,
+	envName string,
+) (crypto.Signer, error) {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("key_type", keyType).
+		Str("env", envName).
+		Logger()
+
+	value := strings.TrimSpace(os.Getenv(envName))
+	if value == "" {
+		logger.Error().Msg("environment variable is not set")
+		return nil, fmt.Errorf("environment variable %q is not set", envName)
+	}
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return ParsePrivateKeyPEM(keyType, []byte(value))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to decode base64 private key")
+		return nil, fmt.Errorf("environment variable %q: %w", envName, err)
+	}
+	var result crypto.Signer
+	if strings.HasPrefix(string(data), "-----BEGIN") {
+		result, err = ParsePrivateKeyPEM(keyType, data)
+	} else {
+		result, err = ParsePrivateKeyDER(keyType, data)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse private key")
+		return nil, fmt.Errorf("environment variable %q: %w", envName, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,308 @@
+package crypto
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPrivateKeys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return rsaKey, ed25519Key
+}
+
+func pkcs8PEM(t *testing.T, key crypto.Signer) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, ed25519Key := testPrivateKeys(t)
+	//nolint:staticcheck // the legacy encryption is what is tested
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(rsaKey), []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		keyType string
+		data    []byte
+		want    crypto.Signer
+		wantErr bool
+	}{
+		{
+			name:    "rsa pkcs1",
+			keyType: KeyTypeRSA,
+			data:    pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			want:    rsaKey,
+		},
+		{
+			name:    "rsa pkcs8",
+			keyType: KeyTypeRSA,
+			data:    pkcs8PEM(t, rsaKey),
+			want:    rsaKey,
+		},
+		{
+			name:    "ed25519 raw",
+			keyType: KeyTypeEd25519,
+			data:    pem.EncodeToMemory(&pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: ed25519Key}),
+			want:    ed25519Key,
+		},
+		{
+			name:    "ed25519 pkcs8",
+			keyType: KeyTypeEd25519,
+			data:    pkcs8PEM(t, ed25519Key),
+			want:    ed25519Key,
+		},
+		{
+			name:    "key type mismatch",
+			keyType: KeyTypeRSA,
+			data:    pkcs8PEM(t, ed25519Key),
+			wantErr: true,
+		},
+		{
+			name:    "encrypted",
+			keyType: KeyTypeRSA,
+			data:    pem.EncodeToMemory(encrypted),
+			wantErr: true,
+		},
+		{
+			name:    "not pem",
+			keyType: KeyTypeRSA,
+			data:    []byte("not a key"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKeyPEM(tt.keyType, tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.Public(), got.Public())
+		})
+	}
+}
+
+func TestEncryptedFileKeyLoader(t *testing.T) {
+	rsaKey, _ := testPrivateKeys(t)
+	//nolint:staticcheck // the legacy encryption is what is tested
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(rsaKey), []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+
+	tmpDir := t.TempDir()
+	encryptedPath := filepath.Join(tmpDir, "key001.pem")
+	require.NoError(t, os.WriteFile(encryptedPath, pem.EncodeToMemory(encrypted), 0600))
+	plainPath := filepath.Join(tmpDir, "plain.pem")
+	require.NoError(t, os.WriteFile(plainPath, pkcs8PEM(t, rsaKey), 0600))
+	passphrasePath := filepath.Join(tmpDir, "passphrase")
+	require.NoError(t, os.WriteFile(passphrasePath, []byte("secret\n"), 0600))
+	t.Setenv("TEST_DKIM_PASSPHRASE", "secret")
+	t.Setenv("TEST_DKIM_WRONG_PASSPHRASE", "wrong")
+
+	tests := []struct {
+		name    string
+		loader  *EncryptedFileKeyLoader
+		path    string
+		wantErr bool
+	}{
+		{
+			name:   "passphrase env",
+			loader: &EncryptedFileKeyLoader{PassphraseEnv: "TEST_DKIM_PASSPHRASE"},
+			path:   encryptedPath,
+		},
+		{
+			name:   "passphrase file",
+			loader: &EncryptedFileKeyLoader{PassphraseFile: passphrasePath},
+			path:   encryptedPath,
+		},
+		{
+			name:   "unset env falls back to file",
+			loader: &EncryptedFileKeyLoader{PassphraseEnv: "TEST_DKIM_UNSET", PassphraseFile: passphrasePath},
+			path:   encryptedPath,
+		},
+		{
+			name:    "wrong passphrase",
+			loader:  &EncryptedFileKeyLoader{PassphraseEnv: "TEST_DKIM_WRONG_PASSPHRASE"},
+			path:    encryptedPath,
+			wantErr: true,
+		},
+		{
+			name:    "no passphrase",
+			loader:  &EncryptedFileKeyLoader{PassphraseEnv: "TEST_DKIM_UNSET"},
+			path:    encryptedPath,
+			wantErr: true,
+		},
+		{
+			name:    "not encrypted",
+			loader:  &EncryptedFileKeyLoader{PassphraseEnv: "TEST_DKIM_PASSPHRASE"},
+			path:    plainPath,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			got, err := tt.loader.LoadPrivateKey(ctx, KeyTypeRSA, tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, rsaKey.Public(), got.Public())
+		})
+	}
+}
+
+// The fixtures are written by openssl 3, in PKCS#8 with PBES2:
+//
+//	openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048 -aes-256-cbc -pass pass:secret
+//	openssl genpkey -algorithm ed25519 -aes-128-cbc -pass pass:secret
+func TestEncryptedFileKeyLoaderPKCS8(t *testing.T) {
+	t.Setenv("TEST_DKIM_PASSPHRASE", "secret")
+	t.Setenv("TEST_DKIM_WRONG_PASSPHRASE", "wrong")
+
+	tests := []struct {
+		name          string
+		keyType       string
+		path          string
+		passphraseEnv string
+		wantErr       bool
+	}{
+		{
+			name:          "rsa aes-256-cbc",
+			keyType:       KeyTypeRSA,
+			path:          "testdata/rsa_pkcs8_aes256.pem",
+			passphraseEnv: "TEST_DKIM_PASSPHRASE",
+		},
+		{
+			name:          "ed25519 aes-128-cbc",
+			keyType:       KeyTypeEd25519,
+			path:          "testdata/ed25519_pkcs8_aes128.pem",
+			passphraseEnv: "TEST_DKIM_PASSPHRASE",
+		},
+		{
+			name:          "wrong passphrase",
+			keyType:       KeyTypeRSA,
+			path:          "testdata/rsa_pkcs8_aes256.pem",
+			passphraseEnv: "TEST_DKIM_WRONG_PASSPHRASE",
+			wantErr:       true,
+		},
+		{
+			name:          "wrong key type",
+			keyType:       KeyTypeEd25519,
+			path:          "testdata/rsa_pkcs8_aes256.pem",
+			passphraseEnv: "TEST_DKIM_PASSPHRASE",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			loader := &EncryptedFileKeyLoader{PassphraseEnv: tt.passphraseEnv}
+			got, err := loader.LoadPrivateKey(ctx, tt.keyType, tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(strings.TrimSuffix(tt.path, ".pem") + ".pub.pem")
+			require.NoError(t, err)
+			block, _ := pem.Decode(data)
+			require.NotNil(t, block)
+			want, err := x509.ParsePKIXPublicKey(block.Bytes)
+			require.NoError(t, err)
+			assert.Equal(t, want, got.Public())
+		})
+	}
+}
+
+func TestEnvKeyLoader(t *testing.T) {
+	rsaKey, ed25519Key := testPrivateKeys(t)
+	rsaDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		keyType string
+		value   string
+		want    crypto.Signer
+		wantErr bool
+	}{
+		{
+			name:    "base64 pkcs8 der",
+			keyType: KeyTypeRSA,
+			value:   base64.StdEncoding.EncodeToString(rsaDER),
+			want:    rsaKey,
+		},
+		{
+			name:    "base64 pkcs1 der",
+			keyType: KeyTypeRSA,
+			value:   base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(rsaKey)),
+			want:    rsaKey,
+		},
+		{
+			name:    "base64 pem",
+			keyType: KeyTypeEd25519,
+			value:   base64.StdEncoding.EncodeToString(pkcs8PEM(t, ed25519Key)),
+			want:    ed25519Key,
+		},
+		{
+			name:    "base64 ed25519 seed",
+			keyType: KeyTypeEd25519,
+			value:   base64.StdEncoding.EncodeToString(ed25519Key.Seed()),
+			want:    ed25519Key,
+		},
+		{
+			name:    "pem",
+			keyType: KeyTypeRSA,
+			value:   string(pkcs8PEM(t, rsaKey)),
+			want:    rsaKey,
+		},
+		{
+			name:    "unset",
+			keyType: KeyTypeRSA,
+			wantErr: true,
+		},
+		{
+			name:    "not base64",
+			keyType: KeyTypeRSA,
+			value:   "not base64!",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			t.Setenv("TEST_DKIM_KEY", tt.value)
+			got, err := (&EnvKeyLoader{}).LoadPrivateKey(ctx, tt.keyType, "TEST_DKIM_KEY")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.Public(), got.Public())
+		})
+	}
+}
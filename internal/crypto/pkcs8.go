@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+
+	// pbkdf2PRFs are the PRFs of PBKDF2, hmacWithSHA1 when none is given
+	pbkdf2PRFs = map[string]func() hash.Hash{
+		oidHMACWithSHA1.String():                                 sha1.New,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}.String():  sha256.New,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}.String(): sha512.New384,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}.String(): sha512.New,
+	}
+
+	// pbes2Ciphers are the AES-CBC encryption schemes, by their key size
+	pbes2Ciphers = map[string]int{
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}.String():  16,
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}.String(): 24,
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}.String(): 32,
+	}
+)
+
+// encryptedPrivateKeyInfo is the EncryptedPrivateKeyInfo of RFC 5958
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the PBES2-params of RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PBKDF2-params of RFC 8018
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts the DER of an ENCRYPTED PRIVATE KEY PEM block, as
+// written by openssl 3, encrypted with PBES2, PBKDF2 and AES-CBC.
+//
+// Parameters:
+//   - der: The EncryptedPrivateKeyInfo
+//   - passphrase: The passphrase of the key
+//
+// Returns:
+//   - []byte: The DER of the PKCS#8 private key
+//   - error: Non-nil if the scheme is not supported, or the passphrase is wrong
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %s, only PBES2 is", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+
+	// The key derivation
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s, only PBKDF2 is",
+			params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams)
+	if err != nil || kdfParams.IterationCount < 1 {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+	prfOID := kdfParams.PRF.Algorithm
+	if len(prfOID) == 0 {
+		prfOID = oidHMACWithSHA1
+	}
+	prf, ok := pbkdf2PRFs[prfOID.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %s", prfOID)
+	}
+
+	// The encryption scheme
+	keySize, ok := pbes2Ciphers[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key cipher %s, only AES-CBC is",
+			params.EncryptionScheme.Algorithm)
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keySize {
+		return nil, fmt.Errorf("PBKDF2 key length %d does not match the cipher", kdfParams.KeyLength)
+	}
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid AES-CBC initialization vector")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted private key length")
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keySize, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(result, info.EncryptedData)
+
+	// A wrong passphrase is mostly detected by the padding
+	padding := int(result[len(result)-1])
+	if padding < 1 || padding > aes.BlockSize ||
+		!bytes.Equal(result[len(result)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("failed to decrypt private key, wrong passphrase")
+	}
+	return result[:len(result)-padding], nil
+}
@@ -83,13 +83,8 @@ func (_ *RsaKeyGenerator) LoadPrivateKey(
 		return nil, err
 	}
 
-	privateKeyBlock, _ := pem.Decode(rawFileData)
-	if privateKeyBlock == nil {
-		logger.Error().Msg("failed to decode private key")
-		return nil, err
-	}
-
-	result, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+	// PKCS#1 as written by gendkim, or PKCS#8 as written by openssl
+	result, err := ParsePrivateKeyPEM(keyType, rawFileData)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to parse private key")
 		return nil, err
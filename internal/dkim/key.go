@@ -0,0 +1,90 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+)
+
+// ParsePublicKeyPEM returns the public key of a PEM file holding a public or a
+// private key, in PKIX, PKCS#1 or PKCS#8 form, or a raw ed25519 key as
+// written by gendkim.
+//
+// Parameters:
+//   - data: The PEM encoded key
+//
+// Returns:
+//   - crypto.PublicKey: An *rsa.PublicKey or ed25519.PublicKey
+//   - error: Non-nil if the data holds no supported key
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("not PEM formatted")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey.Public(), nil
+	case "ED25519 PUBLIC KEY":
+		if len(block.Bytes) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 public key size %d", len(block.Bytes))
+		}
+		return ed25519.PublicKey(block.Bytes), nil
+	case "ED25519 PRIVATE KEY":
+		if len(block.Bytes) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid ed25519 private key size %d", len(block.Bytes))
+		}
+		return ed25519.PrivateKey(block.Bytes).Public(), nil
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+		}
+		return signer.Public(), nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+}
+
+// PublicKeyRecord returns the DKIM DNS record publishing the public key
+//
+// Parameters:
+//   - publicKey: An *rsa.PublicKey or ed25519.PublicKey
+//
+// Returns:
+//   - string: The TXT record, e.g. "v=DKIM1; k=rsa; p=..."
+//   - error: Non-nil if the key type is not supported
+func PublicKeyRecord(publicKey crypto.PublicKey) (string, error) {
+	record := &moxDkim.Record{Version: "DKIM1"}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		record.Key = "rsa"
+		record.Pubkey = der
+	case ed25519.PublicKey:
+		record.Key = "ed25519"
+		record.Pubkey = key
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return record.Record()
+}
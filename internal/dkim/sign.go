@@ -0,0 +1,290 @@
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // dkim allows the use of sha1
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"log/slog"
+	"strings"
+	"time"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Sign returns the DKIM-Signature header fields of the message, one per
+// selector, as moxDkim.Sign does. mox only signs with an *rsa.PrivateKey or
+// ed25519.PrivateKey, so selectors with another crypto.Signer, e.g. a remote
+// signer, are signed here with the same algorithm.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - slogger: Logger passed to mox
+//   - localpart: The localpart of the i tag
+//   - domain: The signing domain, the d tag
+//   - selectors: The selectors to sign with
+//   - smtputf8: Whether the message may hold utf-8 header fields
+//   - msg: The complete message
+//
+// Returns:
+//   - string: The DKIM-Signature header fields, each ending in CRLF
+//   - error: Non-nil if the message cannot be parsed, or signing fails
+func Sign(
+	ctx context.Context,
+	slogger *slog.Logger,
+	localpart smtp.Localpart,
+	domain moxDns.Domain,
+	selectors []moxDkim.Selector,
+	smtputf8 bool,
+	msg []byte,
+) (string, error) {
+	var result strings.Builder
+	for _, selector := range selectors {
+		var header string
+		var err error
+		switch selector.PrivateKey.(type) {
+		case *rsa.PrivateKey, ed25519.PrivateKey:
+			header, err = moxDkim.Sign(
+				ctx,
+				slogger,
+				localpart,
+				domain,
+				[]moxDkim.Selector{selector},
+				smtputf8,
+				bytes.NewReader(msg),
+			)
+		default:
+			header, err = signWithSigner(localpart, domain, selector, msg, time.Now())
+		}
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(header)
+	}
+	return result.String(), nil
+}
+
+// messageHeader is a header field of the message, with its raw lines
+type messageHeader struct {
+	lkey string
+	raw  string
+}
+
+func signWithSigner(
+	localpart smtp.Localpart,
+	domain moxDns.Domain,
+	selector moxDkim.Selector,
+	msg []byte,
+	now time.Time,
+) (string, error) {
+	if selector.PrivateKey == nil {
+		return "", fmt.Errorf("selector %s has no private key", selector.Domain.ASCII)
+	}
+	hdrs, body, err := splitMessage(msg)
+	if err != nil {
+		return "", err
+	}
+	nfrom := 0
+	counts := map[string]int{}
+	for _, h := range hdrs {
+		counts[h.lkey]++
+		if h.lkey == "from" {
+			nfrom++
+		}
+	}
+	if nfrom != 1 {
+		return "", fmt.Errorf("%w: message has %d from headers, need exactly 1", moxDkim.ErrFrom, nfrom)
+	}
+
+	sig := &moxDkim.Sig{
+		Version:       1,
+		AlgorithmHash: selector.Hash,
+		Domain:        domain,
+		Selector:      selector.Domain,
+		Identity:      &moxDkim.Identity{Localpart: &localpart, Domain: domain},
+		SignedHeaders: append([]string{}, selector.Headers...),
+		Length:        -1,
+		SignTime:      now.Unix(),
+		ExpireTime:    -1,
+	}
+	var opts crypto.SignerOpts
+	switch selector.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		sig.AlgorithmSign = "rsa"
+	case ed25519.PublicKey:
+		sig.AlgorithmSign = "ed25519"
+		// PureEdDSA over the hash, RFC 8463
+		opts = crypto.Hash(0)
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", selector.PrivateKey.Public())
+	}
+	if selector.SealHeaders {
+		// Sign each header field once more than it occurs, so that none can be added
+		for _, h := range selector.Headers {
+			for j := counts[strings.ToLower(h)]; j > 0; j-- {
+				sig.SignedHeaders = append(sig.SignedHeaders, h)
+			}
+		}
+	}
+	if selector.Expiration > 0 {
+		sig.ExpireTime = sig.SignTime + int64(selector.Expiration/time.Second)
+	}
+	sig.Canonicalization = canonName(selector.HeaderRelaxed) + "/" + canonName(selector.BodyRelaxed)
+
+	newHash, algHash, err := signHash(selector.Hash)
+	if err != nil {
+		return "", err
+	}
+	if opts == nil {
+		opts = algHash
+	}
+
+	h := newHash()
+	h.Write(canonicalBody(body, selector.BodyRelaxed))
+	sig.BodyHash = h.Sum(nil)
+
+	sigHeader, err := sig.Header()
+	if err != nil {
+		return "", err
+	}
+	h = newHash()
+	h.Write(signedHeaderData(hdrs, sig.SignedHeaders, strings.TrimSuffix(sigHeader, "\r\n"), selector.HeaderRelaxed))
+	sig.Signature, err = selector.PrivateKey.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return "", fmt.Errorf("signing data: %w", err)
+	}
+	return sig.Header()
+}
+
+func canonName(relaxed bool) string {
+	if relaxed {
+		return "relaxed"
+	}
+	return "simple"
+}
+
+func signHash(name string) (func() hash.Hash, crypto.Hash, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return sha1.New, crypto.SHA1, nil
+	case "sha256":
+		return sha256.New, crypto.SHA256, nil
+	}
+	return nil, 0, fmt.Errorf("unrecognized hash algorithm %q", name)
+}
+
+// splitMessage splits the message into its header fields and its body
+func splitMessage(msg []byte) ([]messageHeader, []byte, error) {
+	var hdrs []messageHeader
+	offset := 0
+	for offset < len(msg) {
+		end := bytes.Index(msg[offset:], []byte("\r\n"))
+		if end < 0 {
+			return nil, nil, fmt.Errorf("%w: header line without crlf", moxDkim.ErrHeaderMalformed)
+		}
+		line := string(msg[offset : offset+end+2])
+		offset += end + 2
+		if line == "\r\n" {
+			return hdrs, msg[offset:], nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(hdrs) == 0 {
+				return nil, nil, fmt.Errorf("%w: message starts with space/tab", moxDkim.ErrHeaderMalformed)
+			}
+			hdrs[len(hdrs)-1].raw += line
+			continue
+		}
+		key, _, found := strings.Cut(line, ":")
+		key = strings.TrimRight(key, " \t")
+		if !found || key == "" {
+			return nil, nil, fmt.Errorf("%w: header without colon", moxDkim.ErrHeaderMalformed)
+		}
+		hdrs = append(hdrs, messageHeader{lkey: strings.ToLower(key), raw: line})
+	}
+	return hdrs, nil, nil
+}
+
+// signedHeaderData returns the data of the header hash: the signed header
+// fields, selected from the bottom of the message, and the DKIM-Signature
+// without signature, RFC 6376 section 3.7
+func signedHeaderData(hdrs []messageHeader, signed []string, sigHeader string, relaxed bool) []byte {
+	remaining := map[string][]messageHeader{}
+	for _, h := range hdrs {
+		remaining[h.lkey] = append([]messageHeader{h}, remaining[h.lkey]...)
+	}
+	var result bytes.Buffer
+	for _, key := range signed {
+		lkey := strings.ToLower(key)
+		found := remaining[lkey]
+		if len(found) == 0 {
+			continue
+		}
+		remaining[lkey] = found[1:]
+		if relaxed {
+			result.WriteString(relaxedHeader(found[0].raw) + "\r\n")
+		} else {
+			result.WriteString(found[0].raw)
+		}
+	}
+	if relaxed {
+		sigHeader = relaxedHeader(sigHeader)
+	}
+	result.WriteString(sigHeader)
+	return result.Bytes()
+}
+
+// relaxedHeader returns the header field in relaxed canonicalization, without
+// the final crlf
+func relaxedHeader(raw string) string {
+	key, value, _ := strings.Cut(raw, ":")
+	value = collapseWSP(strings.ReplaceAll(value, "\r\n", ""))
+	return strings.ToLower(strings.TrimRight(key, " \t")) + ":" + strings.Trim(value, " \t")
+}
+
+// collapseWSP replaces runs of spaces and tabs with a single space
+func collapseWSP(s string) string {
+	var result strings.Builder
+	prevWSP := false
+	for _, c := range []byte(s) {
+		wsp := c == ' ' || c == '\t'
+		if wsp && prevWSP {
+			continue
+		}
+		if wsp {
+			c = ' '
+		}
+		prevWSP = wsp
+		result.WriteByte(c)
+	}
+	return result.String()
+}
+
+// canonicalBody returns the body in relaxed or simple body canonicalization,
+// RFC 6376 section 3.4, where trailing empty lines are removed. A non-empty
+// body ends in crlf, and an empty body is a single crlf for simple.
+func canonicalBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(collapseWSP(line), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
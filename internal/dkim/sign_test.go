@@ -0,0 +1,138 @@
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// opaqueSigner hides the type of the key, as a remote signer does
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	const msg = "From: sender@example.com\r\n" +
+		"To:   recipient@example.org\r\n" +
+		"Subject: test\r\n" +
+		"  subject\r\n" +
+		"\r\n" +
+		"test  body \r\n" +
+		"\r\n" +
+		"\r\n"
+
+	tests := []struct {
+		name     string
+		key      crypto.Signer
+		hash     string
+		relaxed  bool
+		seal     bool
+		multiple bool
+	}{
+		{name: "rsa relaxed", key: rsaKey, hash: "sha256", relaxed: true},
+		{name: "rsa simple sealed", key: rsaKey, hash: "sha256", seal: true},
+		{name: "rsa sha1", key: rsaKey, hash: "sha1", relaxed: true},
+		{name: "ed25519 relaxed", key: ed25519Key, hash: "sha256", relaxed: true},
+		{name: "ed25519 simple", key: ed25519Key, hash: "sha256"},
+		{name: "with local key", key: ed25519Key, hash: "sha256", relaxed: true, multiple: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			resolver := NewKeyResolver(nil)
+			require.NoError(t, resolver.AddSelectorKey("remote", tt.key.Public(), KeySourceConfig))
+			selectors := []moxDkim.Selector{{
+				Hash:          tt.hash,
+				HeaderRelaxed: tt.relaxed,
+				BodyRelaxed:   tt.relaxed,
+				Headers:       []string{"From", "To", "Subject"},
+				SealHeaders:   tt.seal,
+				Expiration:    time.Hour,
+				PrivateKey:    opaqueSigner{tt.key},
+				Domain:        moxDns.Domain{ASCII: "remote"},
+			}}
+			if tt.multiple {
+				require.NoError(t, resolver.AddSelectorKey("local", rsaKey.Public(), KeySourceConfig))
+				selectors = append(selectors, moxDkim.Selector{
+					Hash:          "sha256",
+					HeaderRelaxed: true,
+					BodyRelaxed:   true,
+					Headers:       []string{"From", "To", "Subject"},
+					PrivateKey:    rsaKey,
+					Domain:        moxDns.Domain{ASCII: "local"},
+				})
+			}
+
+			headers, err := Sign(
+				ctx,
+				telemetry.GetSLogger(ctx),
+				smtp.Localpart("sender"),
+				moxDns.Domain{ASCII: "example.com"},
+				selectors,
+				true,
+				[]byte(msg),
+			)
+			require.NoError(t, err)
+
+			verifier := &Verifier{Resolver: resolver, Slogger: telemetry.GetSLogger(ctx)}
+			results, err := verifier.Verify(ctx, bytes.NewReader([]byte(headers+msg)))
+			require.NoError(t, err)
+			require.Len(t, results, len(selectors))
+			for _, result := range results {
+				assert.Equal(t, CheckPass, result.Status, result.Error)
+			}
+		})
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		msg  string
+		hash string
+	}{
+		{name: "no from", msg: "To: recipient@example.org\r\n\r\nbody\r\n", hash: "sha256"},
+		{name: "malformed header", msg: "From sender@example.com\r\n\r\nbody\r\n", hash: "sha256"},
+		{name: "unknown hash", msg: "From: sender@example.com\r\n\r\nbody\r\n", hash: "md5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			_, err := Sign(
+				ctx,
+				telemetry.GetSLogger(ctx),
+				smtp.Localpart("sender"),
+				moxDns.Domain{ASCII: "example.com"},
+				[]moxDkim.Selector{{
+					Hash:       tt.hash,
+					Headers:    []string{"From"},
+					PrivateKey: opaqueSigner{ed25519Key},
+					Domain:     moxDns.Domain{ASCII: "remote"},
+				}},
+				true,
+				[]byte(tt.msg),
+			)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -5,17 +5,92 @@ package dkim
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/stlimtat/remiges-smtp/internal/crypto"
 )
 
+const (
+	// MaxTXTStringLength is the maximum length of a character string of a
+	// TXT record, RFC 1035 section 3.3. Longer values are split into
+	// several strings, which receivers concatenate.
+	MaxTXTStringLength = 255
+
+	// FlagTesting marks the key as being tested, t=y
+	FlagTesting = "y"
+	// FlagStrict requires the i tag domain to equal the d tag domain, t=s
+	FlagStrict = "s"
+	// ServiceEmail restricts the key to email, s=email
+	ServiceEmail = "email"
+	// ServiceAll allows the key for all services, s=*
+	ServiceAll = "*"
+)
+
+var (
+	ValidFlags    = []string{FlagStrict, FlagTesting}
+	ValidHashes   = []string{"sha1", "sha256"}
+	ValidServices = []string{ServiceAll, ServiceEmail}
+)
+
+// TxtRecordOptions are the optional tags of a DKIM TXT record
+type TxtRecordOptions struct {
+	// Flags sets the t tag, e.g. y while testing
+	Flags []string
+	// Hashes sets the h tag, the hash algorithms the key may sign with
+	Hashes []string
+	// Services sets the s tag, e.g. email
+	Services []string
+}
+
+// TxtRecord is a DKIM TXT record
+type TxtRecord struct {
+	// Name is the fully qualified name, selector._domainkey.domain.
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// KeyType is the type of the key, rsa or ed25519
+	KeyType string `json:"key_type"`
+	// Value is the complete record, e.g. "v=DKIM1; k=rsa; p=..."
+	Value string `json:"value"`
+	// Strings is the value split into strings of at most MaxTXTStringLength
+	Strings []string `json:"strings"`
+	// RData is the quoted strings, as zone files and some DNS APIs expect
+	RData string `json:"rdata"`
+}
+
+// BIND returns the record as a zone file line. A value of several strings is
+// written in parentheses, with a string per line.
+func (r TxtRecord) BIND() string {
+	name := strings.TrimSuffix(r.Name, ".")
+	if len(r.Strings) < 2 {
+		return fmt.Sprintf("%s IN TXT %s", name, r.RData)
+	}
+	quoted := make([]string, 0, len(r.Strings))
+	for _, value := range r.Strings {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	return fmt.Sprintf("%s IN TXT ( %s )", name, strings.Join(quoted, "\n\t"))
+}
+
+// SplitTXT splits a TXT record value into strings of at most
+// MaxTXTStringLength characters
+func SplitTXT(value string) []string {
+	result := make([]string, 0, len(value)/MaxTXTStringLength+1)
+	for len(value) > MaxTXTStringLength {
+		result = append(result, value[:MaxTXTStringLength])
+		value = value[MaxTXTStringLength:]
+	}
+	return append(result, value)
+}
+
 // TxtGen is a service for generating DKIM TXT records for DNS configuration.
 // It handles the conversion of public keys into the appropriate DNS record format
 // and ensures proper formatting according to DKIM specifications.
@@ -33,7 +108,7 @@ type TxtGen struct{}
 //   - pubKeyPEM: The public key in PEM format
 //
 // Returns:
-//   - []byte: The generated DKIM TXT record in DNS format
+//   - []byte: The generated DKIM TXT record as a zone file line
 //   - error: Non-nil if generation fails, with specific error messages for:
 //   - Empty public key
 //   - Invalid PEM format
@@ -43,12 +118,40 @@ type TxtGen struct{}
 // Example output format:
 //
 //	selector._domainkey.example.com IN TXT "v=DKIM1; k=rsa; p=base64encodedkey"
-func (_ *TxtGen) Generate(
+func (g *TxtGen) Generate(
 	ctx context.Context,
 	domain, keyType, selector string,
 	pubKeyPEM []byte,
 ) ([]byte, error) {
-	domain = strings.TrimSpace(domain)
+	record, err := g.Record(ctx, domain, keyType, selector, pubKeyPEM, TxtRecordOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(record.BIND()), nil
+}
+
+// Record creates the DKIM TXT record of the public key, with the optional
+// tags of the options. The p tag holds the SubjectPublicKeyInfo of an rsa
+// key, and the raw 32 bytes of an ed25519 key, RFC 8463.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - domain: The domain for which the DKIM record is being generated
+//   - keyType: The type of key being used (e.g., "rsa", "ed25519")
+//   - selector: The DKIM selector used to identify the key
+//   - pubKeyPEM: The public key in PEM format, PKCS#1, PKIX or raw ed25519
+//   - opts: The optional h, s and t tags
+//
+// Returns:
+//   - TxtRecord: The record
+//   - error: Non-nil if the key does not parse as the key type, or an option is invalid
+func (_ *TxtGen) Record(
+	ctx context.Context,
+	domain, keyType, selector string,
+	pubKeyPEM []byte,
+	opts TxtRecordOptions,
+) (TxtRecord, error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
 	keyType = strings.TrimSpace(keyType)
 	selector = strings.TrimSpace(selector)
 
@@ -68,35 +171,108 @@ func (_ *TxtGen) Generate(
 
 	if domain == "" {
 		logger.Error().Msg("domain is empty")
-		return nil, fmt.Errorf("domain is empty")
+		return TxtRecord{}, fmt.Errorf("domain is empty")
 	}
 
 	if len(pubKeyPEM) < 1 {
 		logger.Error().Msg("pubKeyPEM is empty")
-		return nil, fmt.Errorf("pubKeyPEM is empty")
+		return TxtRecord{}, fmt.Errorf("pubKeyPEM is empty")
 	}
 
 	block, _ := pem.Decode(pubKeyPEM)
 	if block == nil {
 		logger.Error().Msg("pubKeyPEM is not PEM formatted")
-		return nil, fmt.Errorf("pubKeyPEM is not PEM formatted")
+		return TxtRecord{}, fmt.Errorf("pubKeyPEM is not PEM formatted")
+	}
+
+	pubKey, err := recordPublicKey(keyType, block.Bytes)
+	if err != nil {
+		logger.Error().Err(err).Msg("recordPublicKey")
+		return TxtRecord{}, err
+	}
+
+	err = opts.validate()
+	if err != nil {
+		logger.Error().Err(err).Msg("TxtRecordOptions")
+		return TxtRecord{}, err
+	}
+
+	tags := []string{"v=DKIM1"}
+	if len(opts.Hashes) > 0 {
+		tags = append(tags, "h="+strings.Join(opts.Hashes, ":"))
+	}
+	tags = append(tags, "k="+keyType)
+	if len(opts.Services) > 0 {
+		tags = append(tags, "s="+strings.Join(opts.Services, ":"))
+	}
+	if len(opts.Flags) > 0 {
+		tags = append(tags, "t="+strings.Join(opts.Flags, ":"))
+	}
+	tags = append(tags, "p="+base64.StdEncoding.EncodeToString(pubKey))
+
+	result := TxtRecord{
+		Name:    fmt.Sprintf("%s._domainkey.%s.", selector, domain),
+		Type:    "TXT",
+		KeyType: keyType,
+		Value:   strings.Join(tags, "; "),
 	}
+	result.Strings = SplitTXT(result.Value)
+	quoted := make([]string, 0, len(result.Strings))
+	for _, value := range result.Strings {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	result.RData = strings.Join(quoted, " ")
+	return result, nil
+}
 
-	switch keyType {
-	case "ed25519":
-		break
-	default:
-		_, err := x509.ParsePKCS1PublicKey(block.Bytes)
+// recordPublicKey returns the public key bytes of the p tag
+func recordPublicKey(keyType string, der []byte) ([]byte, error) {
+	if keyType == crypto.KeyTypeEd25519 {
+		// Raw as written by gendkim
+		if len(der) == ed25519.PublicKeySize {
+			return der, nil
+		}
+		pubKey, err := x509.ParsePKIXPublicKey(der)
 		if err != nil {
-			logger.Error().Err(err).Msg("x509.ParsePKCS1PublicKey")
-			return nil, fmt.Errorf("x509.ParsePKCS1PublicKey: %w", err)
+			return nil, fmt.Errorf("x509.ParsePKIXPublicKey: %w", err)
 		}
+		ed25519Key, ok := pubKey.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is %T, expected ed25519", pubKey)
+		}
+		return ed25519Key, nil
 	}
 
-	resultKey := base64.StdEncoding.EncodeToString(block.Bytes)
-
-	result := fmt.Sprintf("\"v=DKIM1; k=%s; p=%s\"", keyType, resultKey)
-	result = fmt.Sprintf("%s._domainkey.%s IN TXT %s", selector, domain, result)
+	rsaKey, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		pubKey, pkixErr := x509.ParsePKIXPublicKey(der)
+		if pkixErr != nil {
+			return nil, fmt.Errorf("x509.ParsePKCS1PublicKey: %w", err)
+		}
+		var ok bool
+		rsaKey, ok = pubKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is %T, expected rsa", pubKey)
+		}
+	}
+	return x509.MarshalPKIXPublicKey(rsaKey)
+}
 
-	return []byte(result), nil
+func (o TxtRecordOptions) validate() error {
+	for _, check := range []struct {
+		tag    string
+		values []string
+		valid  []string
+	}{
+		{"t", o.Flags, ValidFlags},
+		{"h", o.Hashes, ValidHashes},
+		{"s", o.Services, ValidServices},
+	} {
+		for _, value := range check.values {
+			if !slices.Contains(check.valid, value) {
+				return fmt.Errorf("invalid %s tag value %q, supported: %v", check.tag, value, check.valid)
+			}
+		}
+	}
+	return nil
 }
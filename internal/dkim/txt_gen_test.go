@@ -12,6 +12,7 @@ import (
 	"strings"
 	"testing"
 
+	moxDkim "github.com/mjl-/mox/dkim"
 	mcrypto "github.com/stlimtat/remiges-smtp/internal/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -206,3 +207,110 @@ func TestTxtGen_Generate_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestTxtGen_Record(t *testing.T) {
+	ctx := context.Background()
+	gen := &TxtGen{}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPKIX, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	ed25519PubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519PKIX, err := x509.MarshalPKIXPublicKey(ed25519PubKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		keyType     string
+		pubKeyPEM   []byte
+		opts        TxtRecordOptions
+		wantKey     any
+		wantTags    []string
+		wantStrings int
+		wantErr     bool
+	}{
+		{
+			name:    "rsa pkcs1 split",
+			keyType: mcrypto.KeyTypeRSA,
+			pubKeyPEM: pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PUBLIC KEY",
+				Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+			}),
+			wantKey:     &rsaKey.PublicKey,
+			wantTags:    []string{"k=rsa"},
+			wantStrings: 2,
+		},
+		{
+			name:        "rsa pkix with tags",
+			keyType:     mcrypto.KeyTypeRSA,
+			pubKeyPEM:   pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPKIX}),
+			opts:        TxtRecordOptions{Flags: []string{FlagTesting, FlagStrict}, Hashes: []string{"sha256"}, Services: []string{ServiceEmail}},
+			wantKey:     &rsaKey.PublicKey,
+			wantTags:    []string{"h=sha256", "s=email", "t=y:s"},
+			wantStrings: 2,
+		},
+		{
+			name:        "ed25519 raw",
+			keyType:     mcrypto.KeyTypeEd25519,
+			pubKeyPEM:   pem.EncodeToMemory(&pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: ed25519PubKey}),
+			wantKey:     ed25519PubKey,
+			wantTags:    []string{"k=ed25519"},
+			wantStrings: 1,
+		},
+		{
+			name:        "ed25519 pkix",
+			keyType:     mcrypto.KeyTypeEd25519,
+			pubKeyPEM:   pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ed25519PKIX}),
+			wantKey:     ed25519PubKey,
+			wantTags:    []string{"k=ed25519"},
+			wantStrings: 1,
+		},
+		{
+			name:      "ed25519 key as rsa",
+			keyType:   mcrypto.KeyTypeRSA,
+			pubKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ed25519PKIX}),
+			wantErr:   true,
+		},
+		{
+			name:      "invalid flag",
+			keyType:   mcrypto.KeyTypeEd25519,
+			pubKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: ed25519PubKey}),
+			opts:      TxtRecordOptions{Flags: []string{"x"}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gen.Record(ctx, "example.com", tt.keyType, "key001", tt.pubKeyPEM, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "key001._domainkey.example.com.", got.Name)
+			assert.Equal(t, tt.keyType, got.KeyType)
+			require.Len(t, got.Strings, tt.wantStrings)
+			for _, value := range got.Strings {
+				assert.LessOrEqual(t, len(value), MaxTXTStringLength)
+			}
+			assert.Equal(t, got.Value, strings.Join(got.Strings, ""))
+			for _, tag := range tt.wantTags {
+				assert.Contains(t, got.Value, tag)
+			}
+
+			record, isDKIM, err := moxDkim.ParseRecord(got.Value)
+			require.NoError(t, err)
+			assert.True(t, isDKIM)
+			assert.Equal(t, tt.wantKey, record.PublicKey)
+
+			bind := got.BIND()
+			assert.True(t, strings.HasPrefix(bind, "key001._domainkey.example.com IN TXT "))
+			if tt.wantStrings > 1 {
+				assert.Contains(t, bind, "(")
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package dkim
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mjl-/adns"
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/rs/zerolog"
+)
+
+const (
+	KeySourceConfig = "config"
+	KeySourceDNS    = "dns"
+	KeySourceFile   = "file"
+
+	CheckPass      = "pass"
+	CheckFail      = "fail"
+	CheckUnchecked = "unchecked"
+
+	// RecommendedRSABits is the rsa key size below which a warning is given
+	RecommendedRSABits = 2048
+)
+
+// KeyResolver answers the DKIM record lookups of selectors with a local key,
+// and passes all other lookups to the embedded resolver. Keys added with
+// AddSelectorKey are used for the selector in any domain.
+type KeyResolver struct {
+	moxDns.Resolver
+
+	byName     map[string]localKey
+	bySelector map[string]localKey
+}
+
+type localKey struct {
+	record string
+	source string
+}
+
+// NewKeyResolver creates a KeyResolver without local keys
+//
+// Parameters:
+//   - resolver: The resolver of the lookups without a local key
+//
+// Returns:
+//   - *KeyResolver: The key resolver
+func NewKeyResolver(resolver moxDns.Resolver) *KeyResolver {
+	return &KeyResolver{
+		Resolver:   resolver,
+		byName:     make(map[string]localKey),
+		bySelector: make(map[string]localKey),
+	}
+}
+
+// AddSelectorKey sets the public key of the selector in any domain
+func (r *KeyResolver) AddSelectorKey(selector string, publicKey crypto.PublicKey, source string) error {
+	record, err := PublicKeyRecord(publicKey)
+	if err != nil {
+		return err
+	}
+	r.bySelector[strings.ToLower(selector)] = localKey{record: record, source: source}
+	return nil
+}
+
+// AddDomainKey sets the public key of the selector in the domain
+func (r *KeyResolver) AddDomainKey(selector, domain string, publicKey crypto.PublicKey, source string) error {
+	record, err := PublicKeyRecord(publicKey)
+	if err != nil {
+		return err
+	}
+	r.byName[recordName(selector, domain)] = localKey{record: record, source: source}
+	return nil
+}
+
+// Source returns where the key of the selector in the domain comes from
+func (r *KeyResolver) Source(selector, domain string) string {
+	if key, ok := r.lookup(recordName(selector, domain)); ok {
+		return key.source
+	}
+	return KeySourceDNS
+}
+
+func (r *KeyResolver) LookupTXT(ctx context.Context, name string) ([]string, adns.Result, error) {
+	if key, ok := r.lookup(strings.ToLower(name)); ok {
+		return []string{key.record}, adns.Result{}, nil
+	}
+	if r.Resolver == nil {
+		return nil, adns.Result{}, &adns.DNSError{Err: "no local key", Name: name, IsNotFound: true}
+	}
+	return r.Resolver.LookupTXT(ctx, name)
+}
+
+func (r *KeyResolver) lookup(name string) (localKey, bool) {
+	if key, ok := r.byName[name]; ok {
+		return key, true
+	}
+	selector, _, found := strings.Cut(name, "._domainkey.")
+	if !found {
+		return localKey{}, false
+	}
+	key, ok := r.bySelector[selector]
+	return key, ok
+}
+
+func recordName(selector, domain string) string {
+	return strings.ToLower(selector + "._domainkey." + strings.TrimSuffix(domain, ".") + ".")
+}
+
+// VerifyResult is the verification of a DKIM-Signature of a message
+type VerifyResult struct {
+	Domain           string   `json:"domain"`
+	Selector         string   `json:"selector"`
+	Algorithm        string   `json:"algorithm"`
+	Canonicalization string   `json:"canonicalization"`
+	SignedHeaders    []string `json:"signed_headers"`
+	Status           string   `json:"status"`
+	HeaderSignature  string   `json:"header_signature"`
+	BodyHash         string   `json:"body_hash"`
+	KeySource        string   `json:"key_source"`
+	KeyType          string   `json:"key_type,omitempty"`
+	KeyBits          int      `json:"key_bits,omitempty"`
+	Error            string   `json:"error,omitempty"`
+	Warnings         []string `json:"warnings,omitempty"`
+}
+
+// Verifier verifies all DKIM signatures of a message
+type Verifier struct {
+	Resolver *KeyResolver
+	Slogger  *slog.Logger
+	Now      func() time.Time
+}
+
+// Verify verifies every DKIM-Signature of the message. Signatures that a
+// receiver may reject by policy, e.g. without the Subject signed, are
+// verified, with a warning.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - msg: The complete message
+//
+// Returns:
+//   - []VerifyResult: A result per DKIM-Signature, in the order of the message
+//   - error: Non-nil if the message cannot be parsed
+func (v *Verifier) Verify(ctx context.Context, msg io.ReaderAt) ([]VerifyResult, error) {
+	logger := zerolog.Ctx(ctx)
+
+	results, err := moxDkim.Verify(
+		ctx,
+		v.Slogger,
+		v.Resolver,
+		true,
+		func(*moxDkim.Sig) error { return nil },
+		msg,
+		false,
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("dkim.Verify")
+		return nil, err
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	result := make([]VerifyResult, 0, len(results))
+	for _, moxResult := range results {
+		result = append(result, v.verifyResult(moxResult, now))
+	}
+	return result, nil
+}
+
+func (v *Verifier) verifyResult(moxResult moxDkim.Result, now time.Time) VerifyResult {
+	result := VerifyResult{
+		Status:          string(moxResult.Status),
+		HeaderSignature: CheckUnchecked,
+		BodyHash:        CheckUnchecked,
+		KeySource:       KeySourceDNS,
+	}
+	if moxResult.Err != nil {
+		result.Error = moxResult.Err.Error()
+	}
+	switch {
+	case moxResult.Status == moxDkim.StatusPass:
+		result.HeaderSignature = CheckPass
+		result.BodyHash = CheckPass
+	case stdErrors.Is(moxResult.Err, moxDkim.ErrBodyhashMismatch):
+		// The header signature is verified before the body hash
+		result.HeaderSignature = CheckPass
+		result.BodyHash = CheckFail
+	case stdErrors.Is(moxResult.Err, moxDkim.ErrSigVerify):
+		result.HeaderSignature = CheckFail
+	}
+
+	sig := moxResult.Sig
+	if sig == nil {
+		return result
+	}
+	result.Domain = sig.Domain.ASCII
+	result.Selector = sig.Selector.ASCII
+	result.Algorithm = sig.Algorithm()
+	result.Canonicalization = canonicalization(sig.Canonicalization)
+	result.SignedHeaders = sig.SignedHeaders
+	result.KeySource = v.Resolver.Source(result.Selector, result.Domain)
+
+	if moxResult.Record != nil {
+		switch key := moxResult.Record.PublicKey.(type) {
+		case *rsa.PublicKey:
+			result.KeyType = "rsa"
+			result.KeyBits = key.N.BitLen()
+		case ed25519.PublicKey:
+			result.KeyType = "ed25519"
+			result.KeyBits = 256
+		}
+	}
+	result.Warnings = resultWarnings(sig, moxResult.Record, result, now)
+	return result
+}
+
+// canonicalization returns the header and body canonicalization, where the
+// body defaults to simple and the c tag to simple/simple
+func canonicalization(value string) string {
+	value = strings.ToLower(value)
+	if value == "" {
+		return "simple/simple"
+	}
+	if !strings.Contains(value, "/") {
+		return value + "/simple"
+	}
+	return value
+}
+
+func resultWarnings(sig *moxDkim.Sig, record *moxDkim.Record, result VerifyResult, now time.Time) []string {
+	warnings := []string{}
+	add := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if result.KeyType == "rsa" && result.KeyBits < RecommendedRSABits {
+		add("rsa key of %d bits, %d bits recommended", result.KeyBits, RecommendedRSABits)
+	}
+	if strings.EqualFold(sig.AlgorithmHash, "sha1") {
+		add("sha1 is deprecated, use sha256")
+	}
+	headerCanon, _, _ := strings.Cut(result.Canonicalization, "/")
+	if headerCanon == "simple" {
+		add("simple header canonicalization breaks when a relay refolds header fields")
+	}
+	if sig.Length >= 0 {
+		add("body length l=%d allows content to be appended", sig.Length)
+	}
+	if err := moxDkim.DefaultPolicy(sig); err != nil {
+		add("receivers may reject the signature: %v", err)
+	}
+	if sig.ExpireTime >= 0 && now.Unix() > sig.ExpireTime {
+		add("signature expired at %s", time.Unix(sig.ExpireTime, 0).UTC().Format(time.RFC3339))
+	}
+	if sig.SignTime >= 0 && time.Unix(sig.SignTime, 0).After(now.Add(5*time.Minute)) {
+		add("signature time %s is in the future", time.Unix(sig.SignTime, 0).UTC().Format(time.RFC3339))
+	}
+	if record != nil {
+		for _, flag := range record.Flags {
+			if strings.EqualFold(flag, "y") {
+				add("key record is in test mode (t=y)")
+			}
+		}
+	}
+	return warnings
+}
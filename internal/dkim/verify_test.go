@@ -0,0 +1,268 @@
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const verifyTestMessage = "From: sender@example.com\r\n" +
+	"To: recipient@example.org\r\n" +
+	"Subject: test subject\r\n" +
+	"\r\n" +
+	"test body\r\n"
+
+func signTestMessage(
+	t *testing.T,
+	ctx context.Context,
+	selector moxDkim.Selector,
+	msg string,
+) string {
+	t.Helper()
+	headers, err := moxDkim.Sign(
+		ctx,
+		telemetry.GetSLogger(ctx),
+		smtp.Localpart("sender"),
+		moxDns.Domain{ASCII: "example.com"},
+		[]moxDkim.Selector{selector},
+		false,
+		bytes.NewReader([]byte(msg)),
+	)
+	require.NoError(t, err)
+	return headers + msg
+}
+
+func TestVerifier(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		selector       moxDkim.Selector
+		publicKey      crypto.PublicKey
+		tamper         func(string) string
+		wantStatus     string
+		wantHeader     string
+		wantBody       string
+		wantKeyBits    int
+		wantWarnings   []string
+		wantNoWarnings bool
+	}{
+		{
+			name: "ed25519 pass",
+			selector: moxDkim.Selector{
+				Hash:          "sha256",
+				PrivateKey:    edKey,
+				Headers:       []string{"From", "To", "Subject"},
+				Domain:        moxDns.Domain{ASCII: "key001"},
+				HeaderRelaxed: true,
+				BodyRelaxed:   true,
+			},
+			publicKey:      edKey.Public(),
+			wantStatus:     CheckPass,
+			wantHeader:     CheckPass,
+			wantBody:       CheckPass,
+			wantKeyBits:    256,
+			wantNoWarnings: true,
+		},
+		{
+			name: "body changed",
+			selector: moxDkim.Selector{
+				Hash:          "sha256",
+				PrivateKey:    edKey,
+				Headers:       []string{"From", "To", "Subject"},
+				Domain:        moxDns.Domain{ASCII: "key001"},
+				HeaderRelaxed: true,
+				BodyRelaxed:   true,
+			},
+			publicKey: edKey.Public(),
+			tamper: func(msg string) string {
+				return msg + "appended\r\n"
+			},
+			wantStatus:  string(moxDkim.StatusFail),
+			wantHeader:  CheckPass,
+			wantBody:    CheckFail,
+			wantKeyBits: 256,
+		},
+		{
+			name: "wrong key",
+			selector: moxDkim.Selector{
+				Hash:          "sha256",
+				PrivateKey:    edKey,
+				Headers:       []string{"From", "To", "Subject"},
+				Domain:        moxDns.Domain{ASCII: "key001"},
+				HeaderRelaxed: true,
+				BodyRelaxed:   true,
+			},
+			publicKey:   otherKey.Public(),
+			wantStatus:  string(moxDkim.StatusFail),
+			wantHeader:  CheckFail,
+			wantBody:    CheckUnchecked,
+			wantKeyBits: 256,
+		},
+		{
+			name: "weak rsa sha1 simple",
+			selector: moxDkim.Selector{
+				Hash:       "sha1",
+				PrivateKey: rsaKey,
+				Headers:    []string{"From", "To"},
+				Domain:     moxDns.Domain{ASCII: "key001"},
+			},
+			publicKey:   rsaKey.Public(),
+			wantStatus:  CheckPass,
+			wantHeader:  CheckPass,
+			wantBody:    CheckPass,
+			wantKeyBits: 1024,
+			wantWarnings: []string{
+				"rsa key of 1024 bits, 2048 bits recommended",
+				"sha1 is deprecated, use sha256",
+				"simple header canonicalization breaks when a relay refolds header fields",
+				"receivers may reject the signature: required header fields missing from signature: subject",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := signTestMessage(t, ctx, tt.selector, verifyTestMessage)
+			if tt.tamper != nil {
+				msg = tt.tamper(msg)
+			}
+			resolver := NewKeyResolver(nil)
+			require.NoError(t, resolver.AddSelectorKey("key001", tt.publicKey, KeySourceFile))
+			verifier := &Verifier{
+				Resolver: resolver,
+				Slogger:  telemetry.GetSLogger(ctx),
+				Now:      time.Now,
+			}
+
+			results, err := verifier.Verify(ctx, bytes.NewReader([]byte(msg)))
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			result := results[0]
+			assert.Equal(t, tt.wantStatus, result.Status)
+			assert.Equal(t, tt.wantHeader, result.HeaderSignature)
+			assert.Equal(t, tt.wantBody, result.BodyHash)
+			assert.Equal(t, "example.com", result.Domain)
+			assert.Equal(t, "key001", result.Selector)
+			assert.Equal(t, KeySourceFile, result.KeySource)
+			assert.Equal(t, tt.wantKeyBits, result.KeyBits)
+			for _, warning := range tt.wantWarnings {
+				assert.Contains(t, result.Warnings, warning)
+			}
+			if tt.wantNoWarnings {
+				assert.Empty(t, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestKeyResolver(t *testing.T) {
+	ctx := context.Background()
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	resolver := NewKeyResolver(nil)
+	require.NoError(t, resolver.AddSelectorKey("Key001", edPublic, KeySourceFile))
+	require.NoError(t, resolver.AddDomainKey("key002", "example.com", edPublic, KeySourceConfig))
+
+	txts, _, err := resolver.LookupTXT(ctx, "key001._domainkey.any.example.")
+	require.NoError(t, err)
+	assert.Len(t, txts, 1)
+	assert.Contains(t, txts[0], "k=ed25519")
+	_, _, err = resolver.LookupTXT(ctx, "key002._domainkey.example.com.")
+	require.NoError(t, err)
+	_, _, err = resolver.LookupTXT(ctx, "key002._domainkey.example.org.")
+	assert.Error(t, err)
+
+	assert.Equal(t, KeySourceFile, resolver.Source("key001", "example.org"))
+	assert.Equal(t, KeySourceConfig, resolver.Source("key002", "example.com"))
+	assert.Equal(t, KeySourceDNS, resolver.Source("key003", "example.com"))
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pkixDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{
+			name:  "rsa pkcs1 public",
+			block: &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)},
+			want:  &rsaKey.PublicKey,
+		},
+		{
+			name:  "rsa pkcs1 private",
+			block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			want:  &rsaKey.PublicKey,
+		},
+		{
+			name:  "rsa pkix public",
+			block: &pem.Block{Type: "PUBLIC KEY", Bytes: pkixDER},
+			want:  &rsaKey.PublicKey,
+		},
+		{
+			name:  "ed25519 pkcs8 private",
+			block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER},
+			want:  edPublic,
+		},
+		{
+			name:  "ed25519 raw private",
+			block: &pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: edPrivate},
+			want:  edPublic,
+		},
+		{
+			name:  "ed25519 raw public",
+			block: &pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: edPublic},
+			want:  edPublic,
+		},
+		{
+			name:    "unknown type",
+			block:   &pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePublicKeyPEM(pem.EncodeToMemory(tt.block))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = ParsePublicKeyPEM([]byte("not pem"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	rerrors "github.com/stlimtat/remiges-smtp/internal/errors"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+)
+
+const (
+	// MXCacheKeyPrefix is the prefix of the redis keys holding cached MX lookups
+	MXCacheKeyPrefix = "mx_cache_"
+)
+
+// MXCacheEntry is a cached MX lookup, with the error message for lookups
+// that did not produce any hosts.
+type MXCacheEntry struct {
+	Record dn.MXRecord `json:"record"`
+	ErrMsg string      `json:"err,omitempty"`
+}
+
+// Negative reports whether the entry caches a domain that cannot receive mail
+func (e *MXCacheEntry) Negative() bool {
+	return e.Record.Status.Permanent()
+}
+
+// Err returns the error for the status of the lookup, or nil if mail can be
+// delivered to the hosts of the record
+func (e *MXCacheEntry) Err() error {
+	var cause error
+	if e.ErrMsg != "" {
+		cause = errors.New(e.ErrMsg)
+	}
+	var result *rerrors.AppError
+	switch e.Record.Status {
+	case dn.MX_STATUS_FOUND, dn.MX_STATUS_IMPLICIT:
+		return nil
+	case dn.MX_STATUS_NULL:
+		result = rerrors.NewError(rerrors.ErrMXNull, "domain does not accept mail (null MX)", cause)
+	case dn.MX_STATUS_NOT_FOUND:
+		result = rerrors.NewError(rerrors.ErrDomainNotFound, "domain not found", cause)
+	case dn.MX_STATUS_INVALID:
+		result = rerrors.NewError(rerrors.ErrMXRecord, "invalid MX records", cause)
+	default:
+		result = rerrors.NewError(rerrors.ErrDNSLookup, "failed to lookup MX records", cause)
+	}
+	return result.WithContext("domain", e.Record.Domain)
+}
+
+// MemoryMXCache is an in-process IMXCache
+type MemoryMXCache struct {
+	entries map[string]memoryMXCacheItem
+	mutex   sync.RWMutex
+	now     func() time.Time
+}
+
+type memoryMXCacheItem struct {
+	entry     *MXCacheEntry
+	expiresAt time.Time
+}
+
+// NewMemoryMXCache creates a new empty in-process MX cache.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//
+// Returns:
+//   - *MemoryMXCache: A new cache instance
+func NewMemoryMXCache(
+	_ context.Context,
+) *MemoryMXCache {
+	return &MemoryMXCache{
+		entries: make(map[string]memoryMXCacheItem),
+		now:     time.Now,
+	}
+}
+
+func (c *MemoryMXCache) Get(
+	_ context.Context,
+	domain string,
+) (*MXCacheEntry, bool) {
+	c.mutex.RLock()
+	item, ok := c.entries[domain]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(item.expiresAt) {
+		c.mutex.Lock()
+		delete(c.entries, domain)
+		c.mutex.Unlock()
+		return nil, false
+	}
+	return item.entry, true
+}
+
+func (c *MemoryMXCache) Set(
+	_ context.Context,
+	domain string,
+	entry *MXCacheEntry,
+	ttl time.Duration,
+) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[domain] = memoryMXCacheItem{
+		entry:     entry,
+		expiresAt: c.now().Add(ttl),
+	}
+	return nil
+}
+
+// RedisMXCache is an IMXCache backed by redis, so that the cache is shared
+// between instances. Entries expire through the redis key TTL.
+type RedisMXCache struct {
+	redisClient *redis.Client
+}
+
+// NewRedisMXCache creates a new MX cache stored in redis.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//   - redisClient: The Redis client to store the entries with
+//
+// Returns:
+//   - *RedisMXCache: A new cache instance
+func NewRedisMXCache(
+	_ context.Context,
+	redisClient *redis.Client,
+) *RedisMXCache {
+	return &RedisMXCache{redisClient: redisClient}
+}
+
+func (c *RedisMXCache) Get(
+	ctx context.Context,
+	domain string,
+) (*MXCacheEntry, bool) {
+	logger := zerolog.Ctx(ctx)
+	getResult := c.redisClient.Get(ctx, MXCacheKeyPrefix+domain)
+	if getResult.Err() != nil {
+		if !errors.Is(getResult.Err(), redis.Nil) {
+			logger.Warn().Err(getResult.Err()).Str("domain", domain).Msg("RedisMXCache.Get")
+		}
+		return nil, false
+	}
+	var result MXCacheEntry
+	err := json.Unmarshal([]byte(getResult.Val()), &result)
+	if err != nil {
+		logger.Warn().Err(err).Str("domain", domain).Msg("RedisMXCache.Get.Unmarshal")
+		return nil, false
+	}
+	return &result, true
+}
+
+func (c *RedisMXCache) Set(
+	ctx context.Context,
+	domain string,
+	entry *MXCacheEntry,
+	ttl time.Duration,
+) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.redisClient.Set(ctx, MXCacheKeyPrefix+domain, value, ttl).Err()
+}
@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMXCache(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	now := time.Now()
+
+	cache := NewMemoryMXCache(ctx)
+	cache.now = func() time.Time { return now }
+
+	_, ok := cache.Get(ctx, "example.com")
+	assert.False(t, ok)
+
+	entry := &MXCacheEntry{
+		Record: dn.MXRecord{Domain: "example.com", Hosts: []string{"mail.example.com"}, Status: dn.MX_STATUS_FOUND},
+	}
+	err := cache.Set(ctx, "example.com", entry, time.Minute)
+	require.NoError(t, err)
+
+	got, ok := cache.Get(ctx, "example.com")
+	assert.True(t, ok)
+	assert.Equal(t, entry, got)
+	assert.False(t, got.Negative())
+	assert.NoError(t, got.Err())
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get(ctx, "example.com")
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
+
+func TestRedisMXCache(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	cache := NewRedisMXCache(ctx, client)
+
+	tests := []struct {
+		name  string
+		entry *MXCacheEntry
+		ttl   time.Duration
+	}{
+		{
+			name: "positive",
+			entry: &MXCacheEntry{
+				Record: dn.MXRecord{Domain: "example.com", Hosts: []string{"mail.example.com"}, Status: dn.MX_STATUS_FOUND},
+			},
+			ttl: time.Hour,
+		},
+		{
+			name: "negative",
+			entry: &MXCacheEntry{
+				Record: dn.MXRecord{Domain: "example.org", Status: dn.MX_STATUS_NULL},
+				ErrMsg: "domain does not accept email",
+			},
+			ttl: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := tt.entry.Record.Domain
+			_, ok := cache.Get(ctx, domain)
+			assert.False(t, ok)
+
+			err := cache.Set(ctx, domain, tt.entry, tt.ttl)
+			require.NoError(t, err)
+			assert.Equal(t, tt.ttl, mr.TTL(MXCacheKeyPrefix+domain))
+
+			got, ok := cache.Get(ctx, domain)
+			assert.True(t, ok)
+			assert.Equal(t, tt.entry.Record.Hosts, got.Record.Hosts)
+			assert.Equal(t, tt.entry.Negative(), got.Negative())
+
+			mr.FastForward(tt.ttl)
+			_, ok = cache.Get(ctx, domain)
+			assert.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+
+	"github.com/mjl-/adns"
+	"github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+)
+
+var (
+	// ErrNotAuthentic is returned for responses that are not DNSSEC-authenticated
+	// when the configuration requires DNSSEC
+	ErrNotAuthentic = errors.New("dns response is not dnssec authenticated")
+)
+
+// ConfiguredResolver is a mox dns.Resolver applying config.DNSConfig: custom
+// nameservers, per-attempt timeouts, the DNSSEC requirement and static overrides.
+// It is used for all lookups, so that every command resolves the same way.
+type ConfiguredResolver struct {
+	// Cfg holds the transformed dns configuration
+	Cfg config.DNSConfig
+
+	// Resolver performs the lookups that are not overridden
+	Resolver dns.Resolver
+
+	// TTLObserver records the TTLs of the responses, may be nil
+	TTLObserver *TTLObserver
+
+	// overrides maps normalized domains to their static override
+	overrides map[string]config.DNSOverrideConfig
+
+	// nextNameserver rotates over the configured nameservers
+	nextNameserver atomic.Uint32
+}
+
+var _ dns.Resolver = &ConfiguredResolver{}
+
+// NewConfiguredResolver creates a resolver from the dns configuration.
+// Lookups are done by a mox StrictResolver, dialing the configured nameservers.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//   - cfg: The transformed dns configuration
+//   - slogger: Structured logger for the mox resolver
+//   - ttlObserver: Records the TTLs of the responses, may be nil
+//
+// Returns:
+//   - *ConfiguredResolver: A new resolver instance
+func NewConfiguredResolver(
+	_ context.Context,
+	cfg config.DNSConfig,
+	slogger *slog.Logger,
+	ttlObserver *TTLObserver,
+) *ConfiguredResolver {
+	result := &ConfiguredResolver{
+		Cfg:         cfg,
+		TTLObserver: ttlObserver,
+		overrides:   make(map[string]config.DNSOverrideConfig, len(cfg.Overrides)),
+	}
+	for _, override := range cfg.Overrides {
+		result.overrides[override.Domain] = override
+	}
+	result.Resolver = dns.StrictResolver{
+		Log: slogger,
+		Resolver: &adns.Resolver{
+			Dial: result.dial,
+		},
+	}
+	return result
+}
+
+// dial connects to the next configured nameserver instead of the system one
+func (r *ConfiguredResolver) dial(
+	ctx context.Context,
+	network, address string,
+) (net.Conn, error) {
+	if len(r.Cfg.Nameservers) > 0 {
+		idx := r.nextNameserver.Add(1) - 1
+		address = r.Cfg.Nameservers[int(idx)%len(r.Cfg.Nameservers)]
+	}
+	if r.TTLObserver != nil {
+		return r.TTLObserver.Dial(ctx, network, address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// override returns the static override of the name, if any
+func (r *ConfiguredResolver) override(name string) (config.DNSOverrideConfig, bool) {
+	result, ok := r.overrides[config.NormalizeDomain(name)]
+	return result, ok
+}
+
+// OverrideIPs returns the statically configured addresses of the host, if any
+func (r *ConfiguredResolver) OverrideIPs(host string) []net.IP {
+	override, ok := r.override(host)
+	if !ok {
+		return nil
+	}
+	return override.IPAddrs
+}
+
+// lookup runs fn up to Cfg.Attempts times, retrying temporary failures,
+// each attempt limited to Cfg.Timeout, and enforces the DNSSEC requirement
+func lookup[T any](
+	ctx context.Context,
+	r *ConfiguredResolver,
+	name string,
+	fn func(ctx context.Context) (T, adns.Result, error),
+) (T, adns.Result, error) {
+	var result T
+	var adnsResult adns.Result
+	var err error
+
+	attempts := max(r.Cfg.Attempts, 1)
+	for range attempts {
+		attemptCtx := ctx
+		cancel := func() {}
+		if r.Cfg.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, r.Cfg.Timeout)
+		}
+		result, adnsResult, err = fn(attemptCtx)
+		cancel()
+		if err == nil || !isTemporary(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil && r.Cfg.RequireDNSSEC && !adnsResult.Authentic {
+		var zero T
+		return zero, adnsResult, fmt.Errorf("%w: %s", ErrNotAuthentic, name)
+	}
+	return result, adnsResult, err
+}
+
+func isTemporary(err error) bool {
+	var dnsErr *adns.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+func notFound(name, reason string) error {
+	return &adns.DNSError{Err: reason, Name: name, IsNotFound: true}
+}
+
+// overrideResult is the result of overridden lookups, which are trusted
+var overrideResult = adns.Result{Authentic: true}
+
+func (r *ConfiguredResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return r.Resolver.LookupPort(ctx, network, service)
+}
+
+func (r *ConfiguredResolver) LookupAddr(ctx context.Context, addr string) ([]string, adns.Result, error) {
+	return lookup(ctx, r, addr, func(ctx context.Context) ([]string, adns.Result, error) {
+		return r.Resolver.LookupAddr(ctx, addr)
+	})
+}
+
+func (r *ConfiguredResolver) LookupCNAME(ctx context.Context, host string) (string, adns.Result, error) {
+	if _, ok := r.override(host); ok {
+		return "", overrideResult, notFound(host, "no cname for static override")
+	}
+	return lookup(ctx, r, host, func(ctx context.Context) (string, adns.Result, error) {
+		return r.Resolver.LookupCNAME(ctx, host)
+	})
+}
+
+func (r *ConfiguredResolver) LookupHost(ctx context.Context, host string) ([]string, adns.Result, error) {
+	if override, ok := r.override(host); ok {
+		if len(override.IPAddrs) < 1 {
+			return nil, overrideResult, notFound(host, "no address for static override")
+		}
+		result := make([]string, 0, len(override.IPAddrs))
+		for _, ip := range override.IPAddrs {
+			result = append(result, ip.String())
+		}
+		return result, overrideResult, nil
+	}
+	return lookup(ctx, r, host, func(ctx context.Context) ([]string, adns.Result, error) {
+		return r.Resolver.LookupHost(ctx, host)
+	})
+}
+
+func (r *ConfiguredResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, adns.Result, error) {
+	if override, ok := r.override(host); ok {
+		result := make([]net.IP, 0, len(override.IPAddrs))
+		for _, ip := range override.IPAddrs {
+			isIPv4 := ip.To4() != nil
+			if (network == "ip4" && !isIPv4) || (network == "ip6" && isIPv4) {
+				continue
+			}
+			result = append(result, ip)
+		}
+		if len(result) < 1 {
+			return nil, overrideResult, notFound(host, "no address for static override")
+		}
+		return result, overrideResult, nil
+	}
+	return lookup(ctx, r, host, func(ctx context.Context) ([]net.IP, adns.Result, error) {
+		return r.Resolver.LookupIP(ctx, network, host)
+	})
+}
+
+func (r *ConfiguredResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, adns.Result, error) {
+	if _, ok := r.override(host); ok {
+		ips, adnsResult, err := r.LookupIP(ctx, "ip", host)
+		result := make([]net.IPAddr, 0, len(ips))
+		for _, ip := range ips {
+			result = append(result, net.IPAddr{IP: ip})
+		}
+		return result, adnsResult, err
+	}
+	return lookup(ctx, r, host, func(ctx context.Context) ([]net.IPAddr, adns.Result, error) {
+		return r.Resolver.LookupIPAddr(ctx, host)
+	})
+}
+
+func (r *ConfiguredResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, adns.Result, error) {
+	if override, ok := r.override(name); ok {
+		if len(override.MX) < 1 {
+			return nil, overrideResult, notFound(name, "no mx for static override")
+		}
+		result := make([]*net.MX, 0, len(override.MX))
+		for i, host := range override.MX {
+			result = append(result, &net.MX{Host: host + ".", Pref: uint16(10 * (i + 1))})
+		}
+		return result, overrideResult, nil
+	}
+	return lookup(ctx, r, name, func(ctx context.Context) ([]*net.MX, adns.Result, error) {
+		return r.Resolver.LookupMX(ctx, name)
+	})
+}
+
+func (r *ConfiguredResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, adns.Result, error) {
+	return lookup(ctx, r, name, func(ctx context.Context) ([]*net.NS, adns.Result, error) {
+		return r.Resolver.LookupNS(ctx, name)
+	})
+}
+
+func (r *ConfiguredResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, adns.Result, error) {
+	var cname string
+	srvs, adnsResult, err := lookup(ctx, r, name, func(ctx context.Context) ([]*net.SRV, adns.Result, error) {
+		var srvs []*net.SRV
+		var adnsResult adns.Result
+		var err error
+		cname, srvs, adnsResult, err = r.Resolver.LookupSRV(ctx, service, proto, name)
+		return srvs, adnsResult, err
+	})
+	return cname, srvs, adnsResult, err
+}
+
+func (r *ConfiguredResolver) LookupTXT(ctx context.Context, name string) ([]string, adns.Result, error) {
+	return lookup(ctx, r, name, func(ctx context.Context) ([]string, adns.Result, error) {
+		return r.Resolver.LookupTXT(ctx, name)
+	})
+}
+
+func (r *ConfiguredResolver) LookupTLSA(ctx context.Context, port int, protocol, host string) ([]adns.TLSA, adns.Result, error) {
+	return lookup(ctx, r, host, func(ctx context.Context) ([]adns.TLSA, adns.Result, error) {
+		return r.Resolver.LookupTLSA(ctx, port, protocol, host)
+	})
+}
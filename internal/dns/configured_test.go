@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+	"github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestConfiguredResolverOverrides(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	cfg := config.DNSConfig{
+		Overrides: []config.DNSOverrideConfig{
+			{Domain: "example.com", MX: []string{"mx1.lab.local", "mx2.lab.local"}},
+			{Domain: "mx1.lab.local", IPs: []string{"10.0.0.5", "fd00::5"}},
+			{Domain: "implicit.test", IPs: []string{"10.0.0.6"}},
+		},
+	}
+	require.NoError(t, cfg.Transform(ctx))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	r := NewConfiguredResolver(ctx, cfg, slogger, nil)
+	// Overridden lookups must never reach the underlying resolver
+	r.Resolver = NewMockResolver(ctrl)
+
+	mxs, result, err := r.LookupMX(ctx, "Example.com.")
+	require.NoError(t, err)
+	assert.True(t, result.Authentic)
+	assert.Equal(t, []*net.MX{
+		{Host: "mx1.lab.local.", Pref: 10},
+		{Host: "mx2.lab.local.", Pref: 20},
+	}, mxs)
+
+	_, _, err = r.LookupCNAME(ctx, "example.com.")
+	assert.True(t, dns.IsNotFound(err))
+
+	_, _, err = r.LookupMX(ctx, "implicit.test.")
+	assert.True(t, dns.IsNotFound(err))
+
+	ips, _, err := r.LookupIP(ctx, "ip4", "mx1.lab.local.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.5"}, ipStrings(ips))
+
+	ips, _, err = r.LookupIP(ctx, "ip", "mx1.lab.local.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.5", "fd00::5"}, ipStrings(ips))
+
+	hosts, _, err := r.LookupHost(ctx, "implicit.test.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.6"}, hosts)
+
+	_, _, err = r.LookupHost(ctx, "example.com.")
+	assert.True(t, dns.IsNotFound(err))
+}
+
+func ipStrings(ips []net.IP) []string {
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	return result
+}
+
+func TestConfiguredResolverLookup(t *testing.T) {
+	temporaryErr := &adns.DNSError{Err: "server misbehaving", Name: "example.com.", IsTemporary: true}
+	permanentErr := &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true}
+
+	tests := []struct {
+		name      string
+		cfg       config.DNSConfig
+		results   []adns.Result
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "authentic not required",
+			cfg:       config.DNSConfig{Attempts: 1},
+			results:   []adns.Result{{Authentic: false}},
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "authentic required and present",
+			cfg:       config.DNSConfig{Attempts: 1, RequireDNSSEC: true},
+			results:   []adns.Result{{Authentic: true}},
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "authentic required and missing",
+			cfg:       config.DNSConfig{Attempts: 1, RequireDNSSEC: true},
+			results:   []adns.Result{{Authentic: false}},
+			errs:      []error{nil},
+			wantCalls: 1,
+			wantErr:   ErrNotAuthentic,
+		},
+		{
+			name:      "temporary failure is retried",
+			cfg:       config.DNSConfig{Attempts: 3, Timeout: time.Second},
+			results:   []adns.Result{{}, {}},
+			errs:      []error{temporaryErr, nil},
+			wantCalls: 2,
+		},
+		{
+			name:      "permanent failure is not retried",
+			cfg:       config.DNSConfig{Attempts: 3},
+			results:   []adns.Result{{}},
+			errs:      []error{permanentErr},
+			wantCalls: 1,
+			wantErr:   permanentErr,
+		},
+		{
+			name:      "attempts exhausted",
+			cfg:       config.DNSConfig{Attempts: 2},
+			results:   []adns.Result{{}, {}},
+			errs:      []error{temporaryErr, temporaryErr},
+			wantCalls: 2,
+			wantErr:   temporaryErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockResolver := NewMockResolver(ctrl)
+			calls := 0
+			mockResolver.EXPECT().
+				LookupMX(gomock.Any(), "example.com.").
+				DoAndReturn(func(_ context.Context, _ string) ([]*net.MX, adns.Result, error) {
+					idx := calls
+					calls++
+					if tt.errs[idx] != nil {
+						return nil, tt.results[idx], tt.errs[idx]
+					}
+					return []*net.MX{{Host: "mail.example.com.", Pref: 10}}, tt.results[idx], nil
+				}).
+				Times(tt.wantCalls)
+
+			r := NewConfiguredResolver(ctx, tt.cfg, slogger, nil)
+			r.Resolver = mockResolver
+
+			mxs, _, err := r.LookupMX(ctx, "example.com.")
+			assert.Equal(t, tt.wantCalls, calls)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				assert.Nil(t, mxs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, mxs, 1)
+		})
+	}
+}
+
+func TestLookupMX_OverrideIPs(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	cfg := config.DNSConfig{
+		Overrides: []config.DNSOverrideConfig{
+			{Domain: "example.com", MX: []string{"mx1.lab.local", "mx2.lab.local"}},
+			{Domain: "mx1.lab.local", IPs: []string{"10.0.0.5"}},
+		},
+	}
+	require.NoError(t, cfg.Transform(ctx))
+
+	moxResolver := NewConfiguredResolver(ctx, cfg, slogger, nil)
+	r := NewResolver(ctx, moxResolver, slogger, nil, cfg.Cache, nil)
+
+	mxRecord, err := r.LookupMX(ctx, dns.Domain{ASCII: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, dn.MX_STATUS_FOUND, mxRecord.Status)
+	assert.Equal(t, []string{"10.0.0.5", "mx2.lab.local"}, mxRecord.Hosts)
+}
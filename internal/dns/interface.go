@@ -5,30 +5,58 @@ package dns
 
 import (
 	"context"
+	"time"
 
 	moxDns "github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
 )
 
 //go:generate mockgen -destination=mox_mock.go -package=dns github.com/mjl-/mox/dns Resolver
-//go:generate mockgen -destination=mock.go -package=dns . IResolver
+//go:generate mockgen -destination=mock.go -package=dns . IMXCache,IResolver
 
 // IResolver defines the interface for DNS resolution operations.
 // Implementations of this interface provide methods to look up various DNS records.
 type IResolver interface {
 	// LookupMX performs a DNS lookup for MX (Mail Exchange) records for the given domain.
-	// It returns a list of hostnames that are configured to receive email for the domain,
+	// It returns the hostnames that are configured to receive email for the domain,
 	// ordered by preference (lower numbers indicate higher preference).
+	// Domains without MX records fall back to their own A/AAAA records.
 	//
 	// Parameters:
 	//   - ctx: Context for cancellation and timeout control
 	//   - domain: The domain to look up MX records for
 	//
 	// Returns:
-	//   - []string: List of hostnames configured as mail servers for the domain
-	//   - error: Non-nil if the lookup fails, with specific error messages for:
-	//     - Network errors
-	//     - DNS resolution failures
-	//     - Invalid domain names
-	//     - Timeout errors
-	LookupMX(ctx context.Context, domain moxDns.Domain) ([]string, error)
+	//   - *dn.MXRecord: The lookup result, whose Status distinguishes MX records,
+	//     implicit MX, null MX, non-existent domains and temporary failures
+	//   - error: Non-nil if mail cannot be delivered to the domain, with the
+	//     error codes MX_NULL, DOMAIN_NOT_FOUND, MX_RECORD or DNS_LOOKUP
+	LookupMX(ctx context.Context, domain moxDns.Domain) (*dn.MXRecord, error)
+}
+
+// IMXCache stores the results of MX lookups so that repeated deliveries to
+// the same domain do not hit DNS every time.
+type IMXCache interface {
+	// Get returns the cached entry for the domain, if it has not expired.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - domain: The normalized domain name
+	//
+	// Returns:
+	//   - *MXCacheEntry: The cached entry
+	//   - bool: false if the domain is not cached
+	Get(ctx context.Context, domain string) (*MXCacheEntry, bool)
+
+	// Set stores the entry for the domain for the duration of the TTL.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - domain: The normalized domain name
+	//   - entry: The lookup result to cache
+	//   - ttl: How long the entry remains valid
+	//
+	// Returns:
+	//   - error: Non-nil if the entry could not be stored
+	Set(ctx context.Context, domain string, entry *MXCacheEntry, ttl time.Duration) error
 }
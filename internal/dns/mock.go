@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/stlimtat/remiges-smtp/internal/dns (interfaces: IResolver)
+// Source: github.com/stlimtat/remiges-smtp/internal/dns (interfaces: IMXCache,IResolver)
 //
 // Generated by this command:
 //
-//	mockgen -destination=mock.go -package=dns . IResolver
+//	mockgen -destination=mock.go -package=dns . IMXCache,IResolver
 //
 
 // Package dns is a generated GoMock package.
@@ -12,11 +12,66 @@ package dns
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	dns "github.com/mjl-/mox/dns"
+	dn "github.com/stlimtat/remiges-smtp/pkg/dn"
 	gomock "go.uber.org/mock/gomock"
 )
 
+// MockIMXCache is a mock of IMXCache interface.
+type MockIMXCache struct {
+	ctrl     *gomock.Controller
+	recorder *MockIMXCacheMockRecorder
+	isgomock struct{}
+}
+
+// MockIMXCacheMockRecorder is the mock recorder for MockIMXCache.
+type MockIMXCacheMockRecorder struct {
+	mock *MockIMXCache
+}
+
+// NewMockIMXCache creates a new mock instance.
+func NewMockIMXCache(ctrl *gomock.Controller) *MockIMXCache {
+	mock := &MockIMXCache{ctrl: ctrl}
+	mock.recorder = &MockIMXCacheMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIMXCache) EXPECT() *MockIMXCacheMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockIMXCache) Get(ctx context.Context, domain string) (*MXCacheEntry, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, domain)
+	ret0, _ := ret[0].(*MXCacheEntry)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockIMXCacheMockRecorder) Get(ctx, domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockIMXCache)(nil).Get), ctx, domain)
+}
+
+// Set mocks base method.
+func (m *MockIMXCache) Set(ctx context.Context, domain string, entry *MXCacheEntry, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Set", ctx, domain, entry, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Set indicates an expected call of Set.
+func (mr *MockIMXCacheMockRecorder) Set(ctx, domain, entry, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockIMXCache)(nil).Set), ctx, domain, entry, ttl)
+}
+
 // MockIResolver is a mock of IResolver interface.
 type MockIResolver struct {
 	ctrl     *gomock.Controller
@@ -42,10 +97,10 @@ func (m *MockIResolver) EXPECT() *MockIResolverMockRecorder {
 }
 
 // LookupMX mocks base method.
-func (m *MockIResolver) LookupMX(ctx context.Context, domain dns.Domain) ([]string, error) {
+func (m *MockIResolver) LookupMX(ctx context.Context, domain dns.Domain) (*dn.MXRecord, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "LookupMX", ctx, domain)
-	ret0, _ := ret[0].([]string)
+	ret0, _ := ret[0].(*dn.MXRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
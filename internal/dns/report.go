@@ -0,0 +1,258 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/tlsrpt"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+)
+
+const (
+	// smtpPort is the port the TLSA records of mail hosts are published for
+	smtpPort = 25
+)
+
+// MXReport describes how mail for a domain is routed and secured, for
+// troubleshooting deliveries.
+type MXReport struct {
+	Domain string         `json:"domain"`
+	Status string         `json:"status"`
+	DNSSEC bool           `json:"dnssec"`
+	Error  string         `json:"error,omitempty"`
+	Hosts  []MXHostReport `json:"hosts"`
+	MTASTS MTASTSReport   `json:"mta_sts"`
+	TLSRPT TLSRPTReport   `json:"tls_rpt"`
+}
+
+// MXHostReport describes a single mail host of a domain
+type MXHostReport struct {
+	Host       string   `json:"host"`
+	Preference uint16   `json:"preference"`
+	Addresses  []string `json:"addresses"`
+	DNSSEC     bool     `json:"dnssec"`
+	TLSA       []string `json:"tlsa,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// MTASTSReport describes the MTA-STS record and policy of a domain
+type MTASTSReport struct {
+	Record string   `json:"record,omitempty"`
+	Mode   string   `json:"mode,omitempty"`
+	MaxAge int      `json:"max_age,omitempty"`
+	MX     []string `json:"mx,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// TLSRPTReport describes the TLS reporting record of a domain
+type TLSRPTReport struct {
+	Record string   `json:"record,omitempty"`
+	RUAs   []string `json:"ruas,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// MXReporter builds MXReports from raw DNS lookups. Unlike Resolver, it keeps
+// the MX preferences and looks up everything that affects secure delivery.
+type MXReporter struct {
+	Resolver dns.Resolver
+	Slogger  *slog.Logger
+
+	// FetchPolicy retrieves the MTA-STS policy of a domain over HTTPS
+	FetchPolicy func(ctx context.Context, elog *slog.Logger, domain dns.Domain) (*mtasts.Policy, string, error)
+}
+
+// NewMXReporter creates a new MX reporter.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//   - resolver: The DNS resolver used for all lookups
+//   - slogger: Structured logger for the mox lookups
+//
+// Returns:
+//   - *MXReporter: A new reporter instance
+func NewMXReporter(
+	_ context.Context,
+	resolver dns.Resolver,
+	slogger *slog.Logger,
+) *MXReporter {
+	return &MXReporter{
+		Resolver:    resolver,
+		Slogger:     slogger,
+		FetchPolicy: mtasts.FetchPolicy,
+	}
+}
+
+// Report looks up the MX, address, TLSA, MTA-STS and TLS-RPT records of the
+// domain. Lookup failures are recorded in the report instead of being returned.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeout control
+//   - domain: The domain to report on
+//
+// Returns:
+//   - *MXReport: The report for the domain
+func (r *MXReporter) Report(
+	ctx context.Context,
+	domain dns.Domain,
+) *MXReport {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("domain", domain.ASCII).
+		Logger()
+	name := strings.TrimSuffix(domain.ASCII, ".")
+	domain.ASCII = name
+	result := &MXReport{
+		Domain: name,
+		Hosts:  []MXHostReport{},
+	}
+
+	status, err := r.reportMX(ctx, name, result)
+	result.Status = status.String()
+	if err != nil {
+		logger.Warn().Err(err).Stringer("status", status).Msg("MXReporter.reportMX")
+		result.Error = err.Error()
+	}
+	// A domain without MX records and addresses has no policies either
+	if status == dn.MX_STATUS_NOT_FOUND {
+		return result
+	}
+	result.MTASTS = r.reportMTASTS(ctx, domain)
+	result.TLSRPT = r.reportTLSRPT(ctx, domain)
+	return result
+}
+
+// reportMX adds the mail hosts of the domain to the report
+func (r *MXReporter) reportMX(
+	ctx context.Context,
+	name string,
+	report *MXReport,
+) (dn.MXStatus, error) {
+	mxs, adnsResult, err := r.Resolver.LookupMX(ctx, name+".")
+	report.DNSSEC = adnsResult.Authentic
+	if err != nil && !dns.IsNotFound(err) {
+		return dn.MX_STATUS_TEMP_FAILURE, err
+	}
+	// RFC 7505: a single MX record with the root as host
+	if len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "") {
+		return dn.MX_STATUS_NULL, nil
+	}
+	if len(mxs) > 0 {
+		slices.SortStableFunc(mxs, func(a, b *net.MX) int {
+			return int(a.Pref) - int(b.Pref)
+		})
+		for _, mx := range mxs {
+			report.Hosts = append(report.Hosts, r.reportHost(ctx, mx.Host, mx.Pref))
+		}
+		return dn.MX_STATUS_FOUND, nil
+	}
+
+	// Without MX records, mail is delivered to the domain itself
+	host := r.reportHost(ctx, name, 0)
+	if len(host.Addresses) < 1 {
+		if host.Error != "" {
+			return dn.MX_STATUS_TEMP_FAILURE, errors.New(host.Error)
+		}
+		return dn.MX_STATUS_NOT_FOUND, fmt.Errorf("no mx and no address records for %s", name)
+	}
+	report.Hosts = append(report.Hosts, host)
+	return dn.MX_STATUS_IMPLICIT, nil
+}
+
+// reportHost looks up the addresses and TLSA records of a mail host
+func (r *MXReporter) reportHost(
+	ctx context.Context,
+	host string,
+	pref uint16,
+) MXHostReport {
+	host = strings.TrimSuffix(host, ".")
+	result := MXHostReport{
+		Host:       host,
+		Preference: pref,
+		Addresses:  []string{},
+	}
+	ips, adnsResult, err := r.Resolver.LookupIP(ctx, "ip", host+".")
+	if err != nil {
+		if !dns.IsNotFound(err) {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.DNSSEC = adnsResult.Authentic
+	for _, ip := range ips {
+		result.Addresses = append(result.Addresses, ip.String())
+	}
+
+	records, _, err := r.Resolver.LookupTLSA(ctx, smtpPort, "tcp", host+".")
+	if err != nil {
+		if !dns.IsNotFound(err) {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	for _, record := range records {
+		result.TLSA = append(result.TLSA, record.Record())
+	}
+	return result
+}
+
+// reportMTASTS looks up the MTA-STS record and fetches the policy it announces
+func (r *MXReporter) reportMTASTS(
+	ctx context.Context,
+	domain dns.Domain,
+) MTASTSReport {
+	result := MTASTSReport{}
+	record, _, err := mtasts.LookupRecord(ctx, r.Slogger, r.Resolver, domain)
+	if err != nil {
+		if !errors.Is(err, mtasts.ErrNoRecord) {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.Record = record.String()
+
+	policy, _, err := r.FetchPolicy(ctx, r.Slogger, domain)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Mode = string(policy.Mode)
+	result.MaxAge = policy.MaxAgeSeconds
+	for _, mx := range policy.MX {
+		host := mx.Domain.Name()
+		if mx.Wildcard {
+			host = "*." + host
+		}
+		result.MX = append(result.MX, host)
+	}
+	return result
+}
+
+// reportTLSRPT looks up the TLS reporting record of the domain
+func (r *MXReporter) reportTLSRPT(
+	ctx context.Context,
+	domain dns.Domain,
+) TLSRPTReport {
+	result := TLSRPTReport{}
+	record, _, err := tlsrpt.Lookup(ctx, r.Slogger, r.Resolver, domain)
+	if err != nil {
+		if !errors.Is(err, tlsrpt.ErrNoRecord) {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.Record = record.String()
+	for _, ruas := range record.RUAs {
+		for _, rua := range ruas {
+			result.RUAs = append(result.RUAs, rua.String())
+		}
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/mjl-/adns"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestMXReporter(t *testing.T) {
+	notFound := &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true}
+	temporary := &adns.DNSError{Err: "server misbehaving", Name: "example.com.", IsTemporary: true}
+
+	tests := []struct {
+		name       string
+		mxList     []*net.MX
+		mxResult   adns.Result
+		mxErr      error
+		ips        map[string][]net.IP
+		tlsa       map[string][]adns.TLSA
+		txt        map[string][]string
+		policy     *mtasts.Policy
+		wantReport *MXReport
+	}{
+		{
+			name: "found with policies",
+			mxList: []*net.MX{
+				{Host: "mx2.example.com.", Pref: 20},
+				{Host: "mx1.example.com.", Pref: 10},
+			},
+			mxResult: adns.Result{Authentic: true},
+			ips: map[string][]net.IP{
+				"mx1.example.com.": {net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+				"mx2.example.com.": {net.ParseIP("192.0.2.2")},
+			},
+			tlsa: map[string][]adns.TLSA{
+				"mx1.example.com.": {{Usage: 3, Selector: 1, MatchType: 1, CertAssoc: []byte{0xab, 0xcd}}},
+			},
+			txt: map[string][]string{
+				"_mta-sts.example.com.":   {"v=STSv1; id=20240101"},
+				"_smtp._tls.example.com.": {"v=TLSRPTv1; rua=mailto:tlsrpt@example.com"},
+			},
+			policy: &mtasts.Policy{
+				Version:       "STSv1",
+				Mode:          mtasts.ModeEnforce,
+				MX:            []mtasts.MX{{Domain: dns.Domain{ASCII: "mx1.example.com"}}, {Wildcard: true, Domain: dns.Domain{ASCII: "example.com"}}},
+				MaxAgeSeconds: 604800,
+			},
+			wantReport: &MXReport{
+				Domain: "example.com",
+				Status: "found",
+				DNSSEC: true,
+				Hosts: []MXHostReport{
+					{
+						Host:       "mx1.example.com",
+						Preference: 10,
+						Addresses:  []string{"192.0.2.1", "2001:db8::1"},
+						DNSSEC:     true,
+						TLSA:       []string{"3 1 1 abcd"},
+					},
+					{
+						Host:       "mx2.example.com",
+						Preference: 20,
+						Addresses:  []string{"192.0.2.2"},
+						DNSSEC:     true,
+					},
+				},
+				MTASTS: MTASTSReport{
+					Record: "v=STSv1; id=20240101",
+					Mode:   "enforce",
+					MaxAge: 604800,
+					MX:     []string{"mx1.example.com", "*.example.com"},
+				},
+				TLSRPT: TLSRPTReport{
+					Record: "v=TLSRPTv1; rua=mailto:tlsrpt@example.com",
+					RUAs:   []string{"mailto:tlsrpt@example.com"},
+				},
+			},
+		},
+		{
+			name:   "null mx",
+			mxList: []*net.MX{{Host: ".", Pref: 0}},
+			wantReport: &MXReport{
+				Domain: "example.com",
+				Status: dn.MX_STATUS_NULL.String(),
+				Hosts:  []MXHostReport{},
+			},
+		},
+		{
+			name:  "implicit mx",
+			mxErr: notFound,
+			ips: map[string][]net.IP{
+				"example.com.": {net.ParseIP("192.0.2.3")},
+			},
+			wantReport: &MXReport{
+				Domain: "example.com",
+				Status: dn.MX_STATUS_IMPLICIT.String(),
+				Hosts: []MXHostReport{
+					{
+						Host:      "example.com",
+						Addresses: []string{"192.0.2.3"},
+					},
+				},
+			},
+		},
+		{
+			name:  "domain not found",
+			mxErr: notFound,
+			wantReport: &MXReport{
+				Domain: "example.com",
+				Status: dn.MX_STATUS_NOT_FOUND.String(),
+				Error:  "no mx and no address records for example.com",
+				Hosts:  []MXHostReport{},
+			},
+		},
+		{
+			name:  "temporary failure",
+			mxErr: temporary,
+			wantReport: &MXReport{
+				Domain: "example.com",
+				Status: dn.MX_STATUS_TEMP_FAILURE.String(),
+				Error:  temporary.Error(),
+				Hosts:  []MXHostReport{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			resolver := NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupMX(gomock.Any(), "example.com.").
+				Return(tt.mxList, tt.mxResult, tt.mxErr).
+				Times(1)
+			resolver.EXPECT().
+				LookupIP(gomock.Any(), "ip", gomock.Any()).
+				DoAndReturn(func(_ context.Context, _, host string) ([]net.IP, adns.Result, error) {
+					ips, ok := tt.ips[host]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return ips, tt.mxResult, nil
+				}).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupTLSA(gomock.Any(), 25, "tcp", gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ int, _, host string) ([]adns.TLSA, adns.Result, error) {
+					records, ok := tt.tlsa[host]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return records, adns.Result{}, nil
+				}).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, name string) ([]string, adns.Result, error) {
+					txts, ok := tt.txt[name]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return txts, adns.Result{}, nil
+				}).
+				AnyTimes()
+
+			reporter := NewMXReporter(ctx, resolver, slogger)
+			reporter.FetchPolicy = func(_ context.Context, _ *slog.Logger, domain dns.Domain) (*mtasts.Policy, string, error) {
+				assert.Equal(t, "example.com", domain.ASCII)
+				require.NotNil(t, tt.policy)
+				return tt.policy, tt.policy.String(), nil
+			}
+
+			got := reporter.Report(ctx, dns.Domain{ASCII: "example.com"})
+			assert.Equal(t, tt.wantReport, got)
+		})
+	}
+}
@@ -6,26 +6,85 @@ package dns
 import (
 	"context"
 	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/smtpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/pkg/dn"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
 )
 
-// Resolver provides DNS resolution capabilities with caching and retry mechanisms.
+var (
+	mxCacheLookups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "remiges_smtp_mx_cache_lookups_total",
+			Help: "MX lookups by cache result (hit, negative_hit, miss)",
+		},
+		[]string{"result"},
+	)
+)
+
+// Resolver provides DNS resolution capabilities with caching.
 // It implements the IResolver interface and wraps the mox/dns.Resolver with
-// additional functionality for logging and error handling.
+// additional functionality for logging, caching and error handling.
 type Resolver struct {
 	dns.Resolver
 	Slogger *slog.Logger
 
-	// Cache duration for DNS records
-	cacheDuration time.Duration
+	// Cache stores MX lookups, nil disables caching
+	Cache IMXCache
+
+	// CacheCfg holds the TTL bounds of cached entries
+	CacheCfg config.DNSCacheConfig
+
+	// TTLObserver provides the TTLs of the DNS responses, nil to always use CacheCfg.MinTTL
+	TTLObserver *TTLObserver
+
+	// Timeout limits a lookup, DefaultLookupMXTimeout by default
+	Timeout time.Duration
+
+	// group coalesces concurrent lookups for the same domain
+	group singleflight.Group
+
+	// hits and misses count cache lookups
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// DefaultLookupMXTimeout limits an MX lookup, which is shared by the
+// concurrent lookups of the domain and so does not end with their contexts
+const DefaultLookupMXTimeout = 30 * time.Second
+
+// nullMXErrMsg identifies the error returned by smtpclient.GatherDestinations
+// for a domain with a null MX record
+const nullMXErrMsg = "does not accept email"
+
+// ipOverrider is implemented by resolvers with statically configured addresses
+type ipOverrider interface {
+	OverrideIPs(host string) []net.IP
+}
+
+// CacheStats summarizes the MX cache usage of a Resolver
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
 
-	// Maximum number of retries for DNS lookups
-	maxRetries int
+// HitRate returns the fraction of lookups served from the cache
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
 }
 
 // NewResolver creates a new instance of the DNS resolver with the specified configuration.
@@ -34,6 +93,9 @@ type Resolver struct {
 //   - ctx: Context for initialization (currently unused but reserved for future use)
 //   - resolver: The underlying DNS resolver implementation
 //   - slogger: Structured logger for recording DNS operations
+//   - cache: Cache for MX lookups, nil to disable caching
+//   - cacheCfg: TTL bounds of cached entries
+//   - ttlObserver: Source of response TTLs, usually hooked into the resolver's Dial
 //
 // Returns:
 //   - *Resolver: A new resolver instance configured with the provided parameters
@@ -41,75 +103,191 @@ func NewResolver(
 	_ context.Context,
 	resolver dns.Resolver,
 	slogger *slog.Logger,
+	cache IMXCache,
+	cacheCfg config.DNSCacheConfig,
+	ttlObserver *TTLObserver,
 ) *Resolver {
 	result := &Resolver{
-		Resolver: resolver,
-		Slogger:  slogger,
+		Resolver:    resolver,
+		Slogger:     slogger,
+		Cache:       cache,
+		CacheCfg:    cacheCfg,
+		TTLObserver: ttlObserver,
+		Timeout:     DefaultLookupMXTimeout,
 	}
 	return result
 }
 
+// Stats returns the cache hits and misses of the resolver
+func (r *Resolver) Stats() CacheStats {
+	return CacheStats{
+		Hits:   r.hits.Load(),
+		Misses: r.misses.Load(),
+	}
+}
+
 // LookupMX performs a DNS lookup for MX (Mail Exchange) records for the given domain.
 // It uses the underlying resolver to gather destination information and returns
-// a list of hostnames that can receive email for the domain.
+// a structured result with the hostnames that can receive email for the domain.
+// Results are served from the cache when possible, and concurrent lookups for
+// the same domain are coalesced into a single DNS query. The query is not
+// canceled with the context of the caller that started it, as the others wait
+// for it, but is limited to Timeout. Each caller stops waiting at the end of
+// its own context.
 //
 // Parameters:
 //   - ctx: Context for cancellation and timeout control
 //   - domain: The domain to look up MX records for
 //
 // Returns:
-//   - []string: List of hostnames configured as mail servers for the domain
-//   - error: Non-nil if the lookup fails, with specific error messages for:
-//   - Network errors
-//   - DNS resolution failures
-//   - Invalid domain names
-//   - Timeout errors
+//   - *dn.MXRecord: The lookup result, its Status tells why there are no hosts
+//   - error: Non-nil if mail cannot be delivered to the domain, with codes:
+//   - MX_NULL: The domain publishes a null MX
+//   - DOMAIN_NOT_FOUND: The domain does not exist, or has no MX and no A/AAAA
+//   - MX_RECORD: The MX records are unusable
+//   - DNS_LOOKUP: Temporary failure, the lookup may be retried
 //
 // The function logs detailed information about the lookup process and results
 // using the configured structured logger.
 func (r *Resolver) LookupMX(
 	ctx context.Context,
 	domain dns.Domain,
-) ([]string, error) {
+) (*dn.MXRecord, error) {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("domain", domain.ASCII).
+		Logger()
+	key := strings.ToLower(strings.TrimSuffix(domain.ASCII, "."))
+
+	if r.Cache != nil {
+		entry, ok := r.Cache.Get(ctx, key)
+		if ok {
+			r.hits.Add(1)
+			if entry.Negative() {
+				mxCacheLookups.WithLabelValues("negative_hit").Inc()
+			} else {
+				mxCacheLookups.WithLabelValues("hit").Inc()
+			}
+			logger.Debug().
+				Stringer("status", entry.Record.Status).
+				Strs("hosts", entry.Record.Hosts).
+				Msg("lookupMX.cache hit")
+			return &entry.Record, entry.Err()
+		}
+		r.misses.Add(1)
+		mxCacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	results := r.group.DoChan(key, func() (any, error) {
+		timeout := r.Timeout
+		if timeout <= 0 {
+			timeout = DefaultLookupMXTimeout
+		}
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		entry, ttl := r.gatherMX(lookupCtx, domain)
+		// Temporary failures are not cached, so that the next attempt retries
+		if r.Cache != nil && entry.Record.Status != dn.MX_STATUS_TEMP_FAILURE {
+			err := r.Cache.Set(lookupCtx, key, entry, ttl)
+			if err != nil {
+				logger.Warn().Err(err).Msg("lookupMX.Cache.Set")
+			}
+		}
+		return entry, nil
+	})
+	select {
+	case result := <-results:
+		entry := result.Val.(*MXCacheEntry)
+		if result.Shared {
+			logger.Debug().Msg("lookupMX.coalesced")
+		}
+		return &entry.Record, entry.Err()
+	case <-ctx.Done():
+		logger.Warn().Err(ctx.Err()).Msg("lookupMX.canceled")
+		entry := &MXCacheEntry{
+			Record: dn.MXRecord{Domain: domain.ASCII, Status: dn.MX_STATUS_TEMP_FAILURE},
+			ErrMsg: ctx.Err().Error(),
+		}
+		return &entry.Record, entry.Err()
+	}
+}
+
+// gatherMX resolves the MX record of the domain and determines how long the
+// result may be cached. Negative results are cached for the negative TTL.
+func (r *Resolver) gatherMX(
+	ctx context.Context,
+	domain dns.Domain,
+) (*MXCacheEntry, time.Duration) {
 	logger := zerolog.Ctx(ctx).
 		With().
 		Str("domain", domain.ASCII).
 		Logger()
-	var result dn.MXRecord
+	result := dn.MXRecord{
+		Domain: domain.ASCII,
+		Status: dn.MX_STATUS_FOUND,
+	}
 
 	// Resolve the MX record for the domain
 	ipDomain := dns.IPDomain{
 		Domain: domain,
 	}
 
-	_, _, _, expandedNextHop, hosts, _, err := smtpclient.GatherDestinations( //nolint:dogsled // none of the identifiers are used
+	haveMX, _, authentic, expandedNextHop, hosts, permanent, err := smtpclient.GatherDestinations(
 		ctx, r.Slogger, r.Resolver, ipDomain,
 	)
+	result.ADNSResult.Authentic = authentic
 	if err != nil {
 		logger.Error().Err(err).Msg("smtpclient.GatherDestinations")
-		return nil, err
+		switch {
+		// The null MX error is not exported by smtpclient, it is the only
+		// permanent error returned without hosts for a single MX record
+		case permanent && strings.Contains(err.Error(), nullMXErrMsg):
+			result.Status = dn.MX_STATUS_NULL
+		case permanent:
+			result.Status = dn.MX_STATUS_INVALID
+		default:
+			result.Status = dn.MX_STATUS_TEMP_FAILURE
+		}
+		return &MXCacheEntry{Record: result, ErrMsg: err.Error()}, r.CacheCfg.NegativeTTL
+	}
+	if expandedNextHop.ASCII != domain.ASCII {
+		result.Domain = expandedNextHop.ASCII
 	}
 
-	// Convert from dns.IPDomain to string slice
-	hostStrSlice := []string{}
-	for _, host := range hosts {
-		hostStrSlice = append(hostStrSlice, host.String())
+	// Without MX records, mail is delivered to the A/AAAA records of the domain,
+	// if there are none the domain does not exist as far as mail is concerned
+	if !haveMX {
+		result.Status = dn.MX_STATUS_IMPLICIT
+		_, _, err = r.Resolver.LookupIP(ctx, "ip", expandedNextHop.ASCII+".")
+		if err != nil {
+			logger.Error().Err(err).Msg("lookupMX.implicit.LookupIP")
+			result.Status = dn.MX_STATUS_TEMP_FAILURE
+			if dns.IsNotFound(err) {
+				result.Status = dn.MX_STATUS_NOT_FOUND
+			}
+			return &MXCacheEntry{Record: result, ErrMsg: err.Error()}, r.CacheCfg.NegativeTTL
+		}
 	}
 
-	// Handle domain expansion if necessary
-	if expandedNextHop.ASCII != domain.ASCII {
-		result = dn.MXRecord{
-			Domain:  expandedNextHop.ASCII,
-			Entries: hosts,
-			Hosts:   hostStrSlice,
+	// Convert from dns.IPDomain to string slice, replacing hosts that have
+	// statically configured addresses so that delivery connects to them
+	overrider, _ := r.Resolver.(ipOverrider)
+	hostStrSlice := []string{}
+	for _, host := range hosts {
+		var ips []net.IP
+		if overrider != nil && !host.IsIP() {
+			ips = overrider.OverrideIPs(host.Domain.ASCII)
 		}
-	} else {
-		result = dn.MXRecord{
-			Domain:  domain.ASCII,
-			Entries: hosts,
-			Hosts:   hostStrSlice,
+		if len(ips) < 1 {
+			hostStrSlice = append(hostStrSlice, host.String())
+			continue
+		}
+		for _, ip := range ips {
+			hostStrSlice = append(hostStrSlice, ip.String())
 		}
 	}
+	result.Entries = hosts
+	result.Hosts = hostStrSlice
 
 	// Log the successful lookup results
 	logger.Info().
@@ -117,5 +295,31 @@ func (r *Resolver) LookupMX(
 		Strs("hosts", result.Hosts).
 		Msg("lookupMX")
 
-	return result.Hosts, nil
+	return &MXCacheEntry{Record: result}, r.cacheTTL(domain, expandedNextHop)
+}
+
+// cacheTTL returns the lowest observed TTL of the CNAME and MX responses used
+// to resolve the domain, clamped to the configured bounds
+func (r *Resolver) cacheTTL(
+	domain dns.Domain,
+	expandedNextHop dns.Domain,
+) time.Duration {
+	result := r.CacheCfg.MaxTTL
+	found := false
+	mxTTL, ok := r.TTLObserver.TakeTTL(expandedNextHop.ASCII, dnsmessage.TypeMX)
+	if ok {
+		result = min(result, mxTTL)
+		found = true
+	}
+	if expandedNextHop.ASCII != domain.ASCII {
+		cnameTTL, ok := r.TTLObserver.TakeTTL(domain.ASCII, dnsmessage.TypeCNAME)
+		if ok {
+			result = min(result, cnameTTL)
+			found = true
+		}
+	}
+	if !found || result < r.CacheCfg.MinTTL {
+		result = r.CacheCfg.MinTTL
+	}
+	return result
 }
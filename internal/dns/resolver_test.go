@@ -8,7 +8,9 @@ import (
 
 	"github.com/mjl-/adns"
 	"github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
@@ -161,8 +163,8 @@ func TestLookupMX(t *testing.T) {
 					return tt.mxResult.mxList, tt.mxResult.ADNSResult, tt.mxResult.err
 				})
 
-			r := NewResolver(ctx, resolver, slogger)
-			hosts, err := r.LookupMX(ctx, tt.domain)
+			r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
+			mxRecord, err := r.LookupMX(ctx, tt.domain)
 
 			if tt.wantErr {
 				assert.Error(t, err)
@@ -173,7 +175,8 @@ func TestLookupMX(t *testing.T) {
 			}
 
 			require.NoError(t, err)
-			assert.Equal(t, tt.expectedHosts, hosts)
+			assert.Equal(t, tt.expectedHosts, mxRecord.Hosts)
+			assert.Equal(t, dn.MX_STATUS_FOUND, mxRecord.Status)
 		})
 	}
 }
@@ -247,7 +250,7 @@ func TestLookupMX(t *testing.T) {
 // 			resolver := NewMockResolver(ctrl)
 // 			tt.setupResolver(resolver, ctrl)
 
-// 			r := NewResolver(ctx, resolver, slogger)
+// 			r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
 
 // 			if tt.name == "concurrent lookups" {
 // 				// Test concurrent lookups
@@ -293,7 +296,7 @@ func TestResolver_ContextCancellation(t *testing.T) {
 		LookupCNAME(gomock.Any(), gomock.Any()).
 		Return("", adns.Result{}, nil).
 		AnyTimes()
-	r := NewResolver(ctx, resolver, slogger)
+	r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
 
 	// Create a cancellable context
 	ctx, cancel := context.WithCancel(context.Background())
@@ -316,7 +319,7 @@ func TestResolver_Timeout(t *testing.T) {
 		LookupCNAME(gomock.Any(), gomock.Any()).
 		Return("", adns.Result{}, nil).
 		AnyTimes()
-	r := NewResolver(ctx, resolver, slogger)
+	r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
 
 	// Create a context with a very short timeout
 	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
@@ -326,3 +329,296 @@ func TestResolver_Timeout(t *testing.T) {
 	_, err := r.LookupMX(ctx, domain)
 	assert.Error(t, err)
 }
+
+func TestLookupMX_Cache(t *testing.T) {
+	tests := []struct {
+		name          string
+		mxList        []*net.MX
+		expectedHosts []string
+		wantErr       bool
+		wantTTL       time.Duration
+	}{
+		{
+			name:          "positive result is cached",
+			mxList:        []*net.MX{{Host: "mail.example.com", Pref: uint16(10)}},
+			expectedHosts: []string{"mail.example.com"},
+			wantTTL:       time.Minute,
+		},
+		{
+			name:    "null mx is cached as negative",
+			mxList:  []*net.MX{{Host: ".", Pref: uint16(0)}},
+			wantErr: true,
+			wantTTL: 10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			resolver := NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupCNAME(gomock.Any(), "example.com.").
+				Return("example.com.", adns.Result{}, nil).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupMX(gomock.Any(), "example.com.").
+				Return(tt.mxList, adns.Result{}, nil).
+				Times(1)
+
+			cache := NewMockIMXCache(ctrl)
+			var stored *MXCacheEntry
+			cache.EXPECT().
+				Get(gomock.Any(), "example.com").
+				DoAndReturn(func(_ context.Context, _ string) (*MXCacheEntry, bool) {
+					return stored, stored != nil
+				}).
+				Times(2)
+			cache.EXPECT().
+				Set(gomock.Any(), "example.com", gomock.Any(), tt.wantTTL).
+				DoAndReturn(func(_ context.Context, _ string, entry *MXCacheEntry, _ time.Duration) error {
+					stored = entry
+					return nil
+				}).
+				Times(1)
+
+			cacheCfg := config.DNSCacheConfig{
+				Enabled:     true,
+				MaxTTL:      time.Hour,
+				MinTTL:      time.Minute,
+				NegativeTTL: 10 * time.Second,
+			}
+			r := NewResolver(ctx, resolver, slogger, cache, cacheCfg, nil)
+			domain := dns.Domain{ASCII: "example.com"}
+
+			for range 2 {
+				mxRecord, err := r.LookupMX(ctx, domain)
+				if tt.wantErr {
+					assert.Error(t, err)
+				} else {
+					require.NoError(t, err)
+				}
+				assert.Equal(t, tt.expectedHosts, mxRecord.Hosts)
+			}
+			assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, r.Stats())
+			assert.InDelta(t, 0.5, r.Stats().HitRate(), 0.001)
+		})
+	}
+}
+
+func TestLookupMX_CacheTTL(t *testing.T) {
+	cacheCfg := config.DNSCacheConfig{
+		MaxTTL: time.Hour,
+		MinTTL: time.Minute,
+	}
+	tests := []struct {
+		name     string
+		observed []uint32
+		want     time.Duration
+	}{
+		{"not observed", nil, time.Minute},
+		{"within bounds", []uint32{300}, 5 * time.Minute},
+		{"below min", []uint32{5}, time.Minute},
+		{"above max", []uint32{86400}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := NewTTLObserver(context.Background())
+			if tt.observed != nil {
+				observer.Observe(buildMXResponse(t, "example.com.", tt.observed))
+			}
+			r := NewResolver(context.Background(), nil, nil, nil, cacheCfg, observer)
+			domain := dns.Domain{ASCII: "example.com"}
+			assert.Equal(t, tt.want, r.cacheTTL(domain, domain))
+		})
+	}
+}
+
+func TestLookupMX_Coalesce(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	const numGoroutines = 10
+	release := make(chan struct{})
+
+	resolver := NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupCNAME(gomock.Any(), "example.com.").
+		Return("example.com.", adns.Result{}, nil).
+		AnyTimes()
+	resolver.EXPECT().
+		LookupMX(gomock.Any(), "example.com.").
+		DoAndReturn(func(_ context.Context, _ string) ([]*net.MX, adns.Result, error) {
+			<-release
+			return []*net.MX{{Host: "mail.example.com", Pref: uint16(10)}}, adns.Result{}, nil
+		}).
+		Times(1)
+
+	r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
+	domain := dns.Domain{ASCII: "example.com"}
+
+	results := make(chan []string, numGoroutines)
+	for range numGoroutines {
+		go func() {
+			mxRecord, err := r.LookupMX(ctx, domain)
+			assert.NoError(t, err)
+			results <- mxRecord.Hosts
+		}()
+	}
+	// Give every goroutine the chance to join the in-flight lookup
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for range numGoroutines {
+		assert.Equal(t, []string{"mail.example.com"}, <-results)
+	}
+}
+
+func TestLookupMX_CoalesceCanceled(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	lookupErrs := make(chan error, 1)
+
+	resolver := NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupCNAME(gomock.Any(), "example.com.").
+		Return("example.com.", adns.Result{}, nil).
+		AnyTimes()
+	resolver.EXPECT().
+		LookupMX(gomock.Any(), "example.com.").
+		DoAndReturn(func(lookupCtx context.Context, _ string) ([]*net.MX, adns.Result, error) {
+			close(started)
+			<-release
+			// The lookup outlives the context of the caller that started it
+			lookupErrs <- lookupCtx.Err()
+			return []*net.MX{{Host: "mail.example.com", Pref: uint16(10)}}, adns.Result{}, nil
+		}).
+		Times(1)
+
+	r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
+	domain := dns.Domain{ASCII: "example.com"}
+
+	firstCtx, cancel := context.WithCancel(ctx)
+	firstErrs := make(chan error, 1)
+	go func() {
+		_, err := r.LookupMX(firstCtx, domain)
+		firstErrs <- err
+	}()
+	<-started
+	hosts := make(chan []string, 1)
+	go func() {
+		mxRecord, err := r.LookupMX(ctx, domain)
+		assert.NoError(t, err)
+		hosts <- mxRecord.Hosts
+	}()
+	// Give the second lookup the chance to join the in-flight lookup
+	time.Sleep(100 * time.Millisecond)
+
+	// The first caller stops waiting, with a temporary failure
+	cancel()
+	err := <-firstErrs
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DNS_LOOKUP")
+
+	close(release)
+	assert.NoError(t, <-lookupErrs)
+	assert.Equal(t, []string{"mail.example.com"}, <-hosts)
+}
+
+func TestLookupMX_Status(t *testing.T) {
+	tests := []struct {
+		name          string
+		mxList        []*net.MX
+		mxErr         error
+		ipErr         error
+		lookupIP      bool
+		expectedHosts []string
+		wantStatus    dn.MXStatus
+		wantErr       bool
+	}{
+		{
+			name:          "found",
+			mxList:        []*net.MX{{Host: "mail.example.com", Pref: uint16(10)}},
+			expectedHosts: []string{"mail.example.com"},
+			wantStatus:    dn.MX_STATUS_FOUND,
+		},
+		{
+			name:       "null mx",
+			mxList:     []*net.MX{{Host: ".", Pref: uint16(0)}},
+			wantStatus: dn.MX_STATUS_NULL,
+			wantErr:    true,
+		},
+		{
+			name:          "implicit mx",
+			mxErr:         &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true},
+			lookupIP:      true,
+			expectedHosts: []string{"example.com"},
+			wantStatus:    dn.MX_STATUS_IMPLICIT,
+		},
+		{
+			name:       "domain not found",
+			mxErr:      &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true},
+			ipErr:      &adns.DNSError{Err: "no such host", Name: "example.com.", IsNotFound: true},
+			lookupIP:   true,
+			wantStatus: dn.MX_STATUS_NOT_FOUND,
+			wantErr:    true,
+		},
+		{
+			name:       "temporary failure",
+			mxErr:      &adns.DNSError{Err: "server misbehaving", Name: "example.com.", IsTemporary: true},
+			wantStatus: dn.MX_STATUS_TEMP_FAILURE,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			resolver := NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupCNAME(gomock.Any(), "example.com.").
+				Return("example.com.", adns.Result{}, nil).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupMX(gomock.Any(), "example.com.").
+				Return(tt.mxList, adns.Result{}, tt.mxErr).
+				Times(1)
+			if tt.lookupIP {
+				resolver.EXPECT().
+					LookupIP(gomock.Any(), "ip", "example.com.").
+					Return([]net.IP{net.ParseIP("192.0.2.1")}, adns.Result{}, tt.ipErr).
+					Times(1)
+			}
+
+			r := NewResolver(ctx, resolver, slogger, nil, config.DNSCacheConfig{}, nil)
+			mxRecord, err := r.LookupMX(ctx, dns.Domain{ASCII: "example.com"})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NotNil(t, mxRecord)
+			assert.Equal(t, tt.wantStatus, mxRecord.Status)
+			assert.Equal(t, tt.expectedHosts, mxRecord.Hosts)
+		})
+	}
+}
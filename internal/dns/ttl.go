@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TTLObserverMaxAge is how long an observed TTL is kept when it is not taken,
+// e.g. when the lookup that queried it failed
+const TTLObserverMaxAge = time.Minute
+
+// observedTypes are the question types whose TTLs are taken by the resolver,
+// the responses to the other questions are not recorded
+var observedTypes = []dnsmessage.Type{dnsmessage.TypeMX, dnsmessage.TypeCNAME}
+
+// TTLObserver records the TTLs of DNS responses received by the resolver.
+// The mox and adns resolvers do not expose TTLs, so the observer is hooked
+// into adns.Resolver.Dial and parses every response passing through it. Only
+// the MX and CNAME responses are recorded, and forgotten once taken or after
+// TTLObserverMaxAge.
+type TTLObserver struct {
+	ttls sync.Map
+
+	// mutex protects lastSweep
+	mutex     sync.Mutex
+	lastSweep time.Time
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// observedTTL is a TTL observed at a time
+type observedTTL struct {
+	ttl      time.Duration
+	observed time.Time
+}
+
+// NewTTLObserver creates a new TTLObserver.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//
+// Returns:
+//   - *TTLObserver: A new observer with no recorded TTLs
+func NewTTLObserver(
+	_ context.Context,
+) *TTLObserver {
+	return &TTLObserver{now: time.Now}
+}
+
+// Dial connects to the nameserver and wraps the connection so that responses
+// are observed. It matches the signature of adns.Resolver.Dial.
+func (o *TTLObserver) Dial(
+	ctx context.Context,
+	network, address string,
+) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return o.Wrap(conn), nil
+}
+
+// Wrap wraps an existing connection to a nameserver. UDP connections remain
+// a net.PacketConn, as adns relies on this to pick the message framing.
+func (o *TTLObserver) Wrap(conn net.Conn) net.Conn {
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		return &ttlPacketConn{UDPConn: udpConn, observer: o}
+	}
+	return &ttlStreamConn{Conn: conn, observer: o}
+}
+
+// TakeTTL returns and forgets the lowest TTL observed for the question
+// name and type, including any CNAME records followed to answer it.
+//
+// Parameters:
+//   - name: The queried name, with or without trailing dot
+//   - qtype: The queried record type
+//
+// Returns:
+//   - time.Duration: The lowest TTL in the answer section
+//   - bool: false if no response was observed for the question
+func (o *TTLObserver) TakeTTL(name string, qtype dnsmessage.Type) (time.Duration, bool) {
+	if o == nil {
+		return 0, false
+	}
+	value, ok := o.ttls.LoadAndDelete(ttlKey(name, qtype))
+	if !ok {
+		return 0, false
+	}
+	entry := value.(observedTTL)
+	if o.now().Sub(entry.observed) >= TTLObserverMaxAge {
+		return 0, false
+	}
+	return entry.ttl, true
+}
+
+// Observe parses a single DNS message and records the lowest TTL of its answers
+func (o *TTLObserver) Observe(msg []byte) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(msg)
+	if err != nil || !header.Response || header.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+	question, err := parser.Question()
+	if err != nil || !slices.Contains(observedTypes, question.Type) {
+		return
+	}
+	err = parser.SkipAllQuestions()
+	if err != nil {
+		return
+	}
+
+	minTTL := uint32(math.MaxUint32)
+	found := false
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if answer.Type == question.Type || answer.Type == dnsmessage.TypeCNAME {
+			minTTL = min(minTTL, answer.TTL)
+			found = true
+		}
+		err = parser.SkipAnswer()
+		if err != nil {
+			return
+		}
+	}
+	if !found {
+		return
+	}
+	now := o.now()
+	o.ttls.Store(
+		ttlKey(question.Name.String(), question.Type),
+		observedTTL{ttl: time.Duration(minTTL) * time.Second, observed: now},
+	)
+	o.sweep(now)
+}
+
+// sweep forgets the TTLs older than TTLObserverMaxAge, at most once per
+// TTLObserverMaxAge
+func (o *TTLObserver) sweep(now time.Time) {
+	o.mutex.Lock()
+	if now.Sub(o.lastSweep) < TTLObserverMaxAge {
+		o.mutex.Unlock()
+		return
+	}
+	o.lastSweep = now
+	o.mutex.Unlock()
+
+	o.ttls.Range(func(key, value any) bool {
+		if now.Sub(value.(observedTTL).observed) >= TTLObserverMaxAge {
+			o.ttls.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+func ttlKey(name string, qtype dnsmessage.Type) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "/" + qtype.String()
+}
+
+// ttlPacketConn observes each datagram read from a UDP nameserver connection
+type ttlPacketConn struct {
+	*net.UDPConn
+	observer *TTLObserver
+}
+
+func (c *ttlPacketConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	if err == nil {
+		c.observer.Observe(b[:n])
+	}
+	return n, err
+}
+
+// ttlStreamConn reassembles the length-prefixed messages read from a TCP
+// nameserver connection, see RFC 7766 section 8
+type ttlStreamConn struct {
+	net.Conn
+	buf      []byte
+	observer *TTLObserver
+}
+
+func (c *ttlStreamConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.buf = append(c.buf, b[:n]...)
+		for len(c.buf) >= 2 {
+			length := int(binary.BigEndian.Uint16(c.buf))
+			if len(c.buf) < 2+length {
+				break
+			}
+			c.observer.Observe(c.buf[2 : 2+length])
+			c.buf = c.buf[2+length:]
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func buildMXResponse(t *testing.T, name string, ttls []uint32) []byte {
+	t.Helper()
+	qname := dnsmessage.MustNewName(name)
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	require.NoError(t, builder.StartQuestions())
+	require.NoError(t, builder.Question(dnsmessage.Question{
+		Name:  qname,
+		Type:  dnsmessage.TypeMX,
+		Class: dnsmessage.ClassINET,
+	}))
+	require.NoError(t, builder.StartAnswers())
+	for i, ttl := range ttls {
+		require.NoError(t, builder.MXResource(
+			dnsmessage.ResourceHeader{Name: qname, Class: dnsmessage.ClassINET, TTL: ttl},
+			dnsmessage.MXResource{Pref: uint16(10 * (i + 1)), MX: dnsmessage.MustNewName("mail." + name)},
+		))
+	}
+	msg, err := builder.Finish()
+	require.NoError(t, err)
+	return msg
+}
+
+func TestTTLObserverObserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     func(*testing.T) []byte
+		lookup  string
+		wantTTL time.Duration
+		wantOk  bool
+	}{
+		{
+			name:    "single_answer",
+			msg:     func(t *testing.T) []byte { return buildMXResponse(t, "example.com.", []uint32{300}) },
+			lookup:  "example.com",
+			wantTTL: 300 * time.Second,
+			wantOk:  true,
+		},
+		{
+			name:    "lowest_of_answers",
+			msg:     func(t *testing.T) []byte { return buildMXResponse(t, "Example.com.", []uint32{300, 60}) },
+			lookup:  "example.com.",
+			wantTTL: 60 * time.Second,
+			wantOk:  true,
+		},
+		{
+			name:   "no_answers",
+			msg:    func(t *testing.T) []byte { return buildMXResponse(t, "example.com.", nil) },
+			lookup: "example.com",
+			wantOk: false,
+		},
+		{
+			name:   "garbage",
+			msg:    func(*testing.T) []byte { return []byte{0x01, 0x02} },
+			lookup: "example.com",
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := NewTTLObserver(context.Background())
+			observer.Observe(tt.msg(t))
+
+			ttl, ok := observer.TakeTTL(tt.lookup, dnsmessage.TypeMX)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantTTL, ttl)
+
+			// The TTL is forgotten once taken
+			_, ok = observer.TakeTTL(tt.lookup, dnsmessage.TypeMX)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestTTLObserverStreamConn(t *testing.T) {
+	observer := NewTTLObserver(context.Background())
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := observer.Wrap(client)
+	_, isPacketConn := conn.(net.PacketConn)
+	assert.False(t, isPacketConn)
+
+	msg := buildMXResponse(t, "example.com.", []uint32{120})
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	framed = append(framed, msg...)
+	go func() {
+		// Write in two parts to exercise the reassembly
+		_, _ = server.Write(framed[:5])
+		_, _ = server.Write(framed[5:])
+	}()
+
+	buf := make([]byte, len(framed))
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	ttl, ok := observer.TakeTTL("example.com", dnsmessage.TypeMX)
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Second, ttl)
+}
+
+func buildTXTResponse(t *testing.T, name string, ttl uint32) []byte {
+	t.Helper()
+	qname := dnsmessage.MustNewName(name)
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	require.NoError(t, builder.StartQuestions())
+	require.NoError(t, builder.Question(dnsmessage.Question{
+		Name:  qname,
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	}))
+	require.NoError(t, builder.StartAnswers())
+	require.NoError(t, builder.TXTResource(
+		dnsmessage.ResourceHeader{Name: qname, Class: dnsmessage.ClassINET, TTL: ttl},
+		dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}},
+	))
+	msg, err := builder.Finish()
+	require.NoError(t, err)
+	return msg
+}
+
+func TestTTLObserverBounded(t *testing.T) {
+	now := time.Now()
+	observer := NewTTLObserver(context.Background())
+	observer.now = func() time.Time { return now }
+	count := func() int {
+		result := 0
+		observer.ttls.Range(func(_, _ any) bool {
+			result++
+			return true
+		})
+		return result
+	}
+
+	// The responses the resolver never takes are not recorded
+	observer.Observe(buildTXTResponse(t, "example.com.", 300))
+	assert.Equal(t, 0, count())
+	_, ok := observer.TakeTTL("example.com", dnsmessage.TypeTXT)
+	assert.False(t, ok)
+
+	// The TTLs not taken expire, and are swept by the next responses
+	observer.Observe(buildMXResponse(t, "a.example.com.", []uint32{300}))
+	observer.Observe(buildMXResponse(t, "b.example.com.", []uint32{300}))
+	assert.Equal(t, 2, count())
+	now = now.Add(TTLObserverMaxAge)
+	_, ok = observer.TakeTTL("a.example.com", dnsmessage.TypeMX)
+	assert.False(t, ok)
+	observer.Observe(buildMXResponse(t, "c.example.com.", []uint32{300}))
+	assert.Equal(t, 1, count())
+	ttl, ok := observer.TakeTTL("c.example.com", dnsmessage.TypeMX)
+	assert.True(t, ok)
+	assert.Equal(t, 300*time.Second, ttl)
+}
@@ -0,0 +1,397 @@
+// Package domaincheck audits the DNS setup of a sending domain: whether SPF
+// authorizes the source IPs, the DKIM records match the configured keys,
+// DMARC aligns and the EHLO hostname has forward-confirmed reverse DNS.
+package domaincheck
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	moxDkim "github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/spf"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+const (
+	CheckSPF    = "spf"
+	CheckDKIM   = "dkim"
+	CheckDMARC  = "dmarc"
+	CheckFCrDNS = "fcrdns"
+)
+
+// Result is the outcome of a check, Subject tells what was checked when a
+// check runs for several source IPs or selectors
+type Result struct {
+	Check   string `json:"check"`
+	Subject string `json:"subject,omitempty"`
+	Status  Status `json:"status"`
+	Detail  string `json:"detail"`
+}
+
+// Checker runs the checks against DNS for a single domain
+type Checker struct {
+	Cfg          config.CheckDomainConfig
+	Domain       dns.Domain
+	EHLOHostname dns.Domain
+	Resolver     dns.Resolver
+	Slogger      *slog.Logger
+
+	// Selectors are the configured DKIM selectors, with their private keys
+	Selectors map[string]moxDkim.Selector
+
+	// SelectorsErr is the error loading the DKIM selectors, reported as a failed check
+	SelectorsErr error
+}
+
+// NewChecker creates a checker for the configured domain.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//   - cfg: The transformed checkdomain configuration
+//   - resolver: The DNS resolver used for all lookups
+//   - selectors: The configured DKIM selectors, with their private keys
+//   - slogger: Structured logger for the mox lookups
+//
+// Returns:
+//   - *Checker: A new checker instance
+//   - error: Non-nil if the domain or EHLO hostname is invalid
+func NewChecker(
+	_ context.Context,
+	cfg config.CheckDomainConfig,
+	resolver dns.Resolver,
+	selectors map[string]moxDkim.Selector,
+	slogger *slog.Logger,
+) (*Checker, error) {
+	domain, err := dns.ParseDomain(cfg.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain %q: %w", cfg.Domain, err)
+	}
+	ehloHostname, err := dns.ParseDomain(cfg.EHLOHostname)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ehlo hostname %q: %w", cfg.EHLOHostname, err)
+	}
+	return &Checker{
+		Cfg:          cfg,
+		Domain:       domain,
+		EHLOHostname: ehloHostname,
+		Resolver:     resolver,
+		Selectors:    selectors,
+		Slogger:      slogger,
+	}, nil
+}
+
+// Check runs all checks and returns their results in order: SPF, DKIM, DMARC
+// and reverse DNS. DMARC is evaluated from the SPF and DKIM outcomes.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeout control
+//
+// Returns:
+//   - []Result: The results of all checks
+func (c *Checker) Check(ctx context.Context) []Result {
+	spfResults, spfStatus := c.checkSPF(ctx)
+	dkimResults, dkimPasses := c.checkDKIM(ctx)
+
+	result := make([]Result, 0, len(spfResults)+len(dkimResults)+2)
+	result = append(result, spfResults...)
+	result = append(result, dkimResults...)
+	result = append(result, c.checkDMARC(ctx, spfStatus, dkimPasses))
+	result = append(result, c.checkFCrDNS(ctx)...)
+	return result
+}
+
+// Failed reports whether any of the results failed
+func Failed(results []Result) bool {
+	return slices.ContainsFunc(results, func(r Result) bool {
+		return r.Status == StatusFail
+	})
+}
+
+// checkSPF verifies that SPF authorizes each source IP. Without source IPs,
+// it only checks that a valid record exists. The returned status is pass only
+// if all source IPs pass, for the DMARC evaluation.
+func (c *Checker) checkSPF(ctx context.Context) ([]Result, spf.Status) {
+	logger := zerolog.Ctx(ctx)
+
+	if len(c.Cfg.SourceIPAddrs) < 1 {
+		status, txt, _, _, err := spf.Lookup(ctx, c.Slogger, c.Resolver, c.Domain)
+		if err != nil {
+			logger.Warn().Err(err).Msg("checkSPF.Lookup")
+			return []Result{{
+				Check:  CheckSPF,
+				Status: StatusFail,
+				Detail: fmt.Sprintf("%s: %v", status, err),
+			}}, status
+		}
+		return []Result{{
+			Check:  CheckSPF,
+			Status: StatusWarn,
+			Detail: fmt.Sprintf("record %q found, no source-ips configured to verify", txt),
+		}}, spf.StatusNone
+	}
+
+	results := make([]Result, 0, len(c.Cfg.SourceIPAddrs))
+	overall := spf.StatusPass
+	for _, ip := range c.Cfg.SourceIPAddrs {
+		received, _, _, _, err := spf.Verify(ctx, c.Slogger, c.Resolver, spf.Args{
+			RemoteIP:          ip,
+			MailFromLocalpart: smtp.Localpart("postmaster"),
+			MailFromDomain:    c.Domain,
+			HelloDomain:       dns.IPDomain{Domain: c.EHLOHostname},
+			LocalHostname:     c.EHLOHostname,
+		})
+		result := Result{
+			Check:   CheckSPF,
+			Subject: ip.String(),
+			Detail:  fmt.Sprintf("spf %s for %s", received.Result, ip),
+		}
+		switch received.Result {
+		case spf.StatusPass:
+			result.Status = StatusPass
+		case spf.StatusNeutral, spf.StatusSoftfail:
+			result.Status = StatusWarn
+		default:
+			result.Status = StatusFail
+		}
+		if err != nil {
+			logger.Warn().Err(err).Stringer("ip", ip).Msg("checkSPF.Verify")
+			result.Detail += ": " + err.Error()
+		}
+		if received.Result != spf.StatusPass {
+			overall = received.Result
+		}
+		results = append(results, result)
+	}
+	return results, overall
+}
+
+// checkDKIM verifies that the DNS record of each configured selector holds the
+// public key of its private key. The selectors that pass are returned as DKIM
+// results signed by the domain, for the DMARC evaluation.
+func (c *Checker) checkDKIM(ctx context.Context) ([]Result, []moxDkim.Result) {
+	if c.SelectorsErr != nil {
+		return []Result{{
+			Check:  CheckDKIM,
+			Status: StatusFail,
+			Detail: fmt.Sprintf("cannot load configured keys: %v", c.SelectorsErr),
+		}}, nil
+	}
+	if len(c.Selectors) < 1 {
+		return []Result{{
+			Check:  CheckDKIM,
+			Status: StatusFail,
+			Detail: "no dkim selectors configured",
+		}}, nil
+	}
+
+	names := make([]string, 0, len(c.Selectors))
+	for name := range c.Selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]Result, 0, len(names))
+	passes := []moxDkim.Result{}
+	for _, name := range names {
+		result := c.checkSelector(ctx, c.Selectors[name])
+		result.Subject = name
+		if result.Status != StatusFail {
+			passes = append(passes, moxDkim.Result{
+				Status: moxDkim.StatusPass,
+				Sig:    &moxDkim.Sig{Domain: c.Domain, Selector: c.Selectors[name].Domain},
+			})
+		}
+		results = append(results, result)
+	}
+	return results, passes
+}
+
+// publicKey is implemented by the public keys of the standard library
+type publicKey interface {
+	Equal(x crypto.PublicKey) bool
+}
+
+func (c *Checker) checkSelector(ctx context.Context, selector moxDkim.Selector) Result {
+	logger := zerolog.Ctx(ctx)
+	name := selector.Domain.ASCII + "._domainkey." + c.Domain.ASCII
+	result := Result{Check: CheckDKIM, Status: StatusFail}
+
+	_, record, _, _, err := moxDkim.Lookup(ctx, c.Slogger, c.Resolver, selector.Domain, c.Domain)
+	if err != nil {
+		logger.Warn().Err(err).Str("name", name).Msg("checkDKIM.Lookup")
+		result.Detail = err.Error()
+		return result
+	}
+	if selector.PrivateKey == nil {
+		result.Detail = fmt.Sprintf("no private key loaded for %s", name)
+		return result
+	}
+	public, ok := selector.PrivateKey.Public().(publicKey)
+	if !ok || !public.Equal(record.PublicKey) {
+		result.Detail = fmt.Sprintf("public key in %s does not match the private key", name)
+		return result
+	}
+	if len(record.Hashes) > 0 && !slices.Contains(record.Hashes, selector.Hash) {
+		result.Detail = fmt.Sprintf("%s does not allow hash %s, only %s",
+			name, selector.Hash, strings.Join(record.Hashes, ","))
+		return result
+	}
+	if slices.Contains(record.Flags, "y") {
+		result.Status = StatusWarn
+		result.Detail = fmt.Sprintf("%s matches, but is in test mode (t=y)", name)
+		return result
+	}
+	keyType := record.Key
+	if keyType == "" {
+		keyType = config.AlgorithmRSA
+	}
+	result.Status = StatusPass
+	result.Detail = fmt.Sprintf("%s matches the configured %s key", name, keyType)
+	return result
+}
+
+// checkDMARC verifies that a DMARC policy exists and that SPF or DKIM passes
+// aligned with the domain. A policy of none is only a warning, as it does not
+// protect the domain.
+func (c *Checker) checkDMARC(
+	ctx context.Context,
+	spfStatus spf.Status,
+	dkimResults []moxDkim.Result,
+) Result {
+	logger := zerolog.Ctx(ctx)
+	result := Result{Check: CheckDMARC, Status: StatusFail}
+
+	// Mail is sent with the From domain as envelope domain
+	useResult, dmarcResult := dmarc.Verify(
+		ctx, c.Slogger, c.Resolver,
+		c.Domain, dkimResults, spfStatus, &c.Domain, false,
+	)
+	if dmarcResult.Record == nil {
+		if dmarcResult.Err != nil {
+			logger.Warn().Err(dmarcResult.Err).Msg("checkDMARC.Verify")
+			result.Detail = dmarcResult.Err.Error()
+		} else {
+			result.Detail = "no dmarc record"
+		}
+		return result
+	}
+
+	policy := dmarcResult.Record.Policy
+	if dmarcResult.Domain != c.Domain && dmarcResult.Record.SubdomainPolicy != dmarc.PolicyEmpty {
+		policy = dmarcResult.Record.SubdomainPolicy
+	}
+	aligned := []string{}
+	if dmarcResult.AlignedSPFPass {
+		aligned = append(aligned, "spf")
+	}
+	if dmarcResult.AlignedDKIMPass {
+		aligned = append(aligned, "dkim")
+	}
+
+	switch {
+	case !useResult || dmarcResult.Status != dmarc.StatusPass:
+		result.Detail = fmt.Sprintf("p=%s at %s, neither spf nor dkim pass aligned",
+			policy, dmarcResult.Domain.ASCII)
+	case policy == dmarc.PolicyNone:
+		result.Status = StatusWarn
+		result.Detail = fmt.Sprintf("aligned with %s, but p=none at %s only monitors",
+			strings.Join(aligned, ","), dmarcResult.Domain.ASCII)
+	default:
+		result.Status = StatusPass
+		result.Detail = fmt.Sprintf("p=%s at %s, aligned with %s",
+			policy, dmarcResult.Domain.ASCII, strings.Join(aligned, ","))
+	}
+	return result
+}
+
+// checkFCrDNS verifies that the reverse DNS of each source IP, or of the
+// addresses of the EHLO hostname, points back to the EHLO hostname and that
+// the EHLO hostname resolves to the IP.
+func (c *Checker) checkFCrDNS(ctx context.Context) []Result {
+	logger := zerolog.Ctx(ctx)
+	ehlo := c.EHLOHostname.ASCII
+
+	ehloIPs, _, err := c.Resolver.LookupIP(ctx, "ip", ehlo+".")
+	if err != nil {
+		logger.Warn().Err(err).Str("ehlo", ehlo).Msg("checkFCrDNS.LookupIP")
+		return []Result{{
+			Check:   CheckFCrDNS,
+			Subject: ehlo,
+			Status:  StatusFail,
+			Detail:  fmt.Sprintf("ehlo hostname %s does not resolve: %v", ehlo, err),
+		}}
+	}
+	ips := c.Cfg.SourceIPAddrs
+	if len(ips) < 1 {
+		ips = ehloIPs
+	}
+
+	results := make([]Result, 0, len(ips))
+	for _, ip := range ips {
+		results = append(results, c.checkIPFCrDNS(ctx, ip))
+	}
+	return results
+}
+
+func (c *Checker) checkIPFCrDNS(ctx context.Context, ip net.IP) Result {
+	ehlo := c.EHLOHostname.ASCII
+	result := Result{
+		Check:   CheckFCrDNS,
+		Subject: ip.String(),
+		Status:  StatusFail,
+	}
+
+	names, _, err := c.Resolver.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) < 1 {
+		result.Detail = fmt.Sprintf("no ptr record for %s", ip)
+		if err != nil {
+			result.Detail += ": " + err.Error()
+		}
+		return result
+	}
+
+	confirmed := []string{}
+	for _, name := range names {
+		name = config.NormalizeDomain(name)
+		forwardIPs, _, err := c.Resolver.LookupIP(ctx, "ip", name+".")
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(forwardIPs, ip.Equal) {
+			confirmed = append(confirmed, name)
+		}
+	}
+
+	switch {
+	case slices.Contains(confirmed, ehlo):
+		result.Status = StatusPass
+		result.Detail = fmt.Sprintf("%s <-> %s", ip, ehlo)
+	case len(confirmed) > 0:
+		result.Status = StatusWarn
+		result.Detail = fmt.Sprintf("%s <-> %s, does not match ehlo hostname %s",
+			ip, strings.Join(confirmed, ","), ehlo)
+	default:
+		result.Detail = fmt.Sprintf("ptr %s of %s does not resolve back to %s",
+			strings.Join(names, ","), ip, ip)
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package domaincheck
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/mjl-/adns"
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestChecker(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	dkimRecord := "v=DKIM1; k=ed25519; p=" +
+		base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+
+	tests := []struct {
+		name       string
+		sourceIPs  []string
+		privateKey ed25519.PrivateKey
+		dmarc      string
+		ptr        map[string][]string
+		want       []Status
+		wantFailed bool
+	}{
+		{
+			name:       "all pass",
+			sourceIPs:  []string{"192.0.2.1"},
+			privateKey: privateKey,
+			dmarc:      "v=DMARC1; p=reject",
+			ptr:        map[string][]string{"192.0.2.1": {"mail.example.com."}},
+			want:       []Status{StatusPass, StatusPass, StatusPass, StatusPass},
+		},
+		{
+			name:       "unauthorized ip and mismatched key",
+			sourceIPs:  []string{"198.51.100.1"},
+			privateKey: otherKey,
+			dmarc:      "v=DMARC1; p=reject",
+			want:       []Status{StatusFail, StatusFail, StatusFail, StatusFail},
+			wantFailed: true,
+		},
+		{
+			name:       "monitoring policy without source ips",
+			privateKey: privateKey,
+			dmarc:      "v=DMARC1; p=none",
+			ptr:        map[string][]string{"192.0.2.1": {"other.example.net."}},
+			want:       []Status{StatusWarn, StatusPass, StatusWarn, StatusWarn},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+			notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			txts := map[string][]string{
+				"example.com.":                   {"v=spf1 ip4:192.0.2.1 -all"},
+				"key001._domainkey.example.com.": {dkimRecord},
+				"_dmarc.example.com.":            {tt.dmarc},
+			}
+			ips := map[string][]net.IP{
+				"mail.example.com.":  {net.ParseIP("192.0.2.1")},
+				"other.example.net.": {net.ParseIP("192.0.2.1")},
+			}
+			resolver := dns.NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, name string) ([]string, adns.Result, error) {
+					result, ok := txts[name]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return result, adns.Result{}, nil
+				}).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupIP(gomock.Any(), "ip", gomock.Any()).
+				DoAndReturn(func(_ context.Context, _, host string) ([]net.IP, adns.Result, error) {
+					result, ok := ips[host]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return result, adns.Result{}, nil
+				}).
+				AnyTimes()
+			resolver.EXPECT().
+				LookupAddr(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, addr string) ([]string, adns.Result, error) {
+					result, ok := tt.ptr[addr]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return result, adns.Result{}, nil
+				}).
+				AnyTimes()
+
+			cfg := config.CheckDomainConfig{
+				Domain:       "example.com",
+				EHLOHostname: "mail.example.com",
+				SourceIPs:    tt.sourceIPs,
+			}
+			require.NoError(t, cfg.Transform(ctx))
+			selectors := map[string]moxDkim.Selector{
+				"key001": {
+					Hash:       config.HashSHA256,
+					PrivateKey: tt.privateKey,
+					Domain:     moxDns.Domain{ASCII: "key001"},
+				},
+			}
+
+			checker, err := NewChecker(ctx, cfg, resolver, selectors, slogger)
+			require.NoError(t, err)
+
+			results := checker.Check(ctx)
+			require.Len(t, results, len(tt.want))
+			for i, result := range results {
+				assert.Equal(t, tt.want[i], result.Status, "%s %s: %s", result.Check, result.Subject, result.Detail)
+			}
+			assert.Equal(t, []string{CheckSPF, CheckDKIM, CheckDMARC, CheckFCrDNS},
+				[]string{results[0].Check, results[1].Check, results[2].Check, results[3].Check})
+			assert.Equal(t, tt.wantFailed, Failed(results))
+		})
+	}
+}
@@ -13,6 +13,8 @@ const (
 	ErrMailValidation ErrorCode = "MAIL_VALIDATION"
 	ErrMailDelivery   ErrorCode = "MAIL_DELIVERY"
 	ErrMailProcessing ErrorCode = "MAIL_PROCESSING"
+	ErrAlignment      ErrorCode = "ALIGNMENT"
+	ErrDKIMVerify     ErrorCode = "DKIM_VERIFY"
 
 	// SMTP related errors
 	ErrSMTPConnection ErrorCode = "SMTP_CONNECTION"
@@ -24,8 +26,10 @@ const (
 	ErrDKIMConfig ErrorCode = "DKIM_CONFIG"
 
 	// DNS related errors
-	ErrDNSLookup ErrorCode = "DNS_LOOKUP"
-	ErrMXRecord  ErrorCode = "MX_RECORD"
+	ErrDNSLookup      ErrorCode = "DNS_LOOKUP"
+	ErrMXRecord       ErrorCode = "MX_RECORD"
+	ErrMXNull         ErrorCode = "MX_NULL"
+	ErrDomainNotFound ErrorCode = "DOMAIN_NOT_FOUND"
 
 	// File related errors
 	ErrFileStatFailed   ErrorCode = "FILE_STAT_FAILED"
@@ -52,6 +56,11 @@ func (e *AppError) Error() string {
 	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
 }
 
+// Unwrap returns the underlying error
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
 // NewError creates a new AppError
 func NewError(code ErrorCode, message string, err error) *AppError {
 	return &AppError{
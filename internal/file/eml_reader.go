@@ -0,0 +1,218 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/mailjob"
+)
+
+const (
+	// EMLFileExt is the extension of the files read by EMLFileReader
+	EMLFileExt = ".eml"
+)
+
+// EMLFileReader implements the IFileReader interface for a directory of
+// whole RFC 5322 messages, one .eml file per mail. The file holds both the
+// headers and the body, so FileInfo.QfFilePath is empty. NewJobFileReader
+// reads the mail job files of a directory the same way.
+type EMLFileReader struct {
+	// inputDir is the directory containing files to be processed
+	inputDir string
+
+	// exts are the extensions of the files to be processed
+	exts []string
+
+	// files is the list of files to be processed
+	files []*FileInfo
+
+	// fileIndex is the current position in the files list
+	fileIndex int
+
+	// mu protects concurrent access to files and fileIndex
+	mu sync.Mutex
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewEMLFileReader creates a new instance of EMLFileReader.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - inputDir: The directory containing the .eml files
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *EMLFileReader: A new reader instance
+//   - error: Non-nil if the input directory is invalid or inaccessible
+func NewEMLFileReader(
+	ctx context.Context,
+	inputDir string,
+	fileReadTracker IFileReadTracker,
+) (*EMLFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("inputDir", inputDir).
+		Msg("NewEMLFileReader")
+
+	err := validateDir(inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("NewEMLFileReader: validateDir")
+		return nil, err
+	}
+
+	return &EMLFileReader{
+		inputDir:        inputDir,
+		exts:            []string{EMLFileExt},
+		files:           make([]*FileInfo, 0),
+		fileReadTracker: fileReadTracker,
+	}, nil
+}
+
+// NewJobFileReader creates a new instance of EMLFileReader for the mail job
+// files of pkg/mailjob, .json, .yaml and .yml files, and the .eml files,
+// which the job transformer reads as the eml input does.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - inputDir: The directory containing the job files
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *EMLFileReader: A new reader instance
+//   - error: Non-nil if the input directory is invalid or inaccessible
+func NewJobFileReader(
+	ctx context.Context,
+	inputDir string,
+	fileReadTracker IFileReadTracker,
+) (*EMLFileReader, error) {
+	result, err := NewEMLFileReader(ctx, inputDir, fileReadTracker)
+	if err != nil {
+		return nil, err
+	}
+	result.exts = slices.Concat(mailjob.FileExts, []string{EMLFileExt})
+	return result, nil
+}
+
+// RefreshList scans the input directory for the files with the extensions
+// of the reader, in name order. The id of a file is its name without the
+// extension.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The list of files found in the input directory
+//   - error: Non-nil if directory scanning fails
+func (f *EMLFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("EMLFileReader.RefreshList")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: os.ReadDir")
+		return nil, err
+	}
+
+	f.files = make([]*FileInfo, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") ||
+			!slices.Contains(f.exts, strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		f.files = append(f.files, &FileInfo{
+			DfFilePath: filepath.Join(f.inputDir, name),
+			ID:         strings.TrimSuffix(name, filepath.Ext(name)),
+			Status:     input.FILE_STATUS_INIT,
+		})
+	}
+
+	f.fileIndex = 0
+	return f.files, nil
+}
+
+// ReadNextFile retrieves the next unprocessed file from the list.
+// Files that are done or being processed are skipped.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is left
+//   - error: Non-nil if file tracking operations fail
+func (f *EMLFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("EMLFileReader.ReadNextFile")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := nextTrackedFile(ctx, f.fileReadTracker, f.files, &f.fileIndex)
+	if err != nil || file == nil {
+		return nil, err
+	}
+	err = f.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+	if err != nil {
+		logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+		return nil, err
+	}
+	return file, nil
+}
+
+// nextTrackedFile advances the index past the files that are done or being
+// processed, and returns the next file, nil if none is left
+func nextTrackedFile(
+	ctx context.Context,
+	fileReadTracker IFileReadTracker,
+	files []*FileInfo,
+	fileIndex *int,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	for *fileIndex < len(files) {
+		file := files[*fileIndex]
+		*fileIndex++
+		status, err := fileReadTracker.FileRead(ctx, file.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("nextTrackedFile: FileRead")
+			return nil, err
+		}
+		if status == input.FILE_STATUS_PROCESSING || status == input.FILE_STATUS_DONE {
+			logger.Debug().
+				Str("fileName", file.DfFilePath).
+				Int("status", int(status)).
+				Msg("nextTrackedFile: skipping file")
+			continue
+		}
+		return file, nil
+	}
+	logger.Debug().Msg("nextTrackedFile: no more files")
+	return nil, nil
+}
+
+// validateDir checks that the path is an existing directory
+func validateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestEMLFileReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		statuses map[string]input.FileStatus
+		wantIDs  []string
+	}{
+		{
+			name:    "eml files only",
+			files:   []string{"b.eml", "a.EML", "c.txt", ".hidden.eml", "dfd001"},
+			wantIDs: []string{"a", "b"},
+		},
+		{
+			name:     "skips done and processing",
+			files:    []string{"a.eml", "b.eml", "c.eml"},
+			statuses: map[string]input.FileStatus{"a": input.FILE_STATUS_DONE, "b": input.FILE_STATUS_PROCESSING},
+			wantIDs:  []string{"c"},
+		},
+		{
+			name: "empty directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			tmpDir := t.TempDir()
+			for _, name := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte("Subject: test\r\n\r\nbody\r\n"), 0600))
+			}
+			require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "dir.eml"), 0700))
+
+			ctrl := gomock.NewController(t)
+			tracker := NewMockIFileReadTracker(ctrl)
+			tracker.EXPECT().
+				FileRead(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, id string) (input.FileStatus, error) {
+					status, ok := tt.statuses[id]
+					if !ok {
+						return input.FILE_STATUS_NOT_FOUND, nil
+					}
+					return status, nil
+				}).
+				AnyTimes()
+			for _, id := range tt.wantIDs {
+				tracker.EXPECT().UpsertFile(gomock.Any(), id, input.FILE_STATUS_PROCESSING).Return(nil)
+			}
+
+			reader, err := NewEMLFileReader(ctx, tmpDir, tracker)
+			require.NoError(t, err)
+			_, err = reader.RefreshList(ctx)
+			require.NoError(t, err)
+
+			gotIDs := make([]string, 0)
+			for {
+				fileInfo, err := reader.ReadNextFile(ctx)
+				require.NoError(t, err)
+				if fileInfo == nil {
+					break
+				}
+				assert.Empty(t, fileInfo.QfFilePath)
+				assert.FileExists(t, fileInfo.DfFilePath)
+				gotIDs = append(gotIDs, fileInfo.ID)
+			}
+			if tt.wantIDs == nil {
+				tt.wantIDs = []string{}
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+		})
+	}
+}
+
+func TestJobFileReader(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	tmpDir := t.TempDir()
+	for _, name := range []string{"c.yml", "a.json", "b.YAML", "d.eml", ".e.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte("{}"), 0600))
+	}
+	ctrl := gomock.NewController(t)
+	tracker := NewMockIFileReadTracker(ctrl)
+
+	reader, err := NewJobFileReader(ctx, tmpDir, tracker)
+	require.NoError(t, err)
+	files, err := reader.RefreshList(ctx)
+	require.NoError(t, err)
+	gotIDs := make([]string, 0)
+	for _, fileInfo := range files {
+		gotIDs = append(gotIDs, fileInfo.ID)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, gotIDs)
+}
+
+func TestNewEMLFileReader(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	tmpDir := t.TempDir()
+	notDir := filepath.Join(tmpDir, "file.eml")
+	require.NoError(t, os.WriteFile(notDir, []byte{}, 0600))
+
+	_, err := NewEMLFileReader(ctx, filepath.Join(tmpDir, "missing"), nil)
+	assert.Error(t, err)
+	_, err = NewEMLFileReader(ctx, notDir, nil)
+	assert.Error(t, err)
+	_, err = NewEMLFileReader(ctx, tmpDir, nil)
+	assert.NoError(t, err)
+}
@@ -5,6 +5,7 @@ package file
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -127,3 +128,85 @@ func (f *FileReadTracker) UpsertFile(
 	}
 	return nil
 }
+
+// RecipientsTTL is how long the recipients of a file that are done are kept,
+// longer than the status of the file so that every retry of the file finds
+// them
+const RecipientsTTL = 7 * 24 * time.Hour
+
+// RecipientsDone returns the recipients of a file that are done, from a
+// redis hash of their last response by recipient.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeout control
+//   - id: The unique identifier of the file
+//
+// Returns:
+//   - map[string]RecipientDone: The last response of each recipient done
+//   - error: Non-nil if the Redis operation fails or a response cannot be parsed
+func (f *FileReadTracker) RecipientsDone(
+	ctx context.Context,
+	id string,
+) (map[string]RecipientDone, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", id).Msg("RecipientsDone")
+
+	values, err := f.redisClient.HGetAll(ctx, "recipients_"+id).Result()
+	if err != nil {
+		logger.Error().Err(err).Msg("RecipientsDone: HGetAll")
+		return nil, err
+	}
+	result := make(map[string]RecipientDone, len(values))
+	for to, value := range values {
+		var done RecipientDone
+		err = json.Unmarshal([]byte(value), &done)
+		if err != nil {
+			logger.Error().Err(err).Str("to", to).Msg("RecipientsDone: Unmarshal")
+			return nil, err
+		}
+		result[to] = done
+	}
+	return result, nil
+}
+
+// UpsertRecipients adds the recipients of a file that are done to its redis
+// hash, which expires after RecipientsTTL.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeout control
+//   - id: The unique identifier of the file
+//   - recipients: The last response of each recipient done
+//
+// Returns:
+//   - error: Non-nil if the Redis operation fails
+func (f *FileReadTracker) UpsertRecipients(
+	ctx context.Context,
+	id string,
+	recipients map[string]RecipientDone,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", id).Int("recipients", len(recipients)).Msg("UpsertRecipients")
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	values := make(map[string]any, len(recipients))
+	for to, done := range recipients {
+		data, err := json.Marshal(done)
+		if err != nil {
+			return err
+		}
+		values[to] = string(data)
+	}
+	key := "recipients_" + id
+	_, err := f.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, values)
+		pipe.Expire(ctx, key, RecipientsTTL)
+		return nil
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("UpsertRecipients: TxPipelined")
+		return err
+	}
+	return nil
+}
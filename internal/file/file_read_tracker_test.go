@@ -0,0 +1,37 @@
+package file
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileReadTrackerRecipients(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	tracker := NewFileReadTracker(ctx, redisClient)
+
+	got, err := tracker.RecipientsDone(ctx, "a")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	delivered := RecipientDone{Code: 250, Line: "250 OK"}
+	failed := RecipientDone{Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown", Permanent: true}
+	require.NoError(t, tracker.UpsertRecipients(ctx, "a", map[string]RecipientDone{"john@example.org": delivered}))
+	require.NoError(t, tracker.UpsertRecipients(ctx, "a", map[string]RecipientDone{"jane@example.org": failed}))
+	require.NoError(t, tracker.UpsertRecipients(ctx, "a", nil))
+
+	got, err = tracker.RecipientsDone(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RecipientDone{
+		"john@example.org": delivered,
+		"jane@example.org": failed,
+	}, got)
+	assert.Equal(t, RecipientsTTL, mr.TTL("recipients_a"))
+}
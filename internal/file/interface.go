@@ -37,6 +37,17 @@ type FileInfo struct {
 
 	// Status represents the current processing state of the file
 	Status input.FileStatus
+
+	// Lane is the lane of the file, when the reader sorts its files into lanes
+	Lane string
+
+	// Priority is the priority of the file, the lower the more urgent
+	Priority int64
+
+	// Abandoned is why the reader gave up on the file, e.g. an entry of a
+	// stream read too many times. The file is not sent, its output is the
+	// reason.
+	Abandoned string
 }
 
 // IFileReader defines the interface for file reading operations in the mail processing system.
@@ -67,6 +78,175 @@ type IFileReader interface {
 	ReadNextFile(ctx context.Context) (*FileInfo, error)
 }
 
+// IFileWatcher defines an IFileReader that watches the input directory for
+// new files, instead of waiting for RefreshList to be called on a ticker.
+type IFileWatcher interface {
+	IFileReader
+
+	// Notify returns a channel that receives when files are ready to be read.
+	Notify() <-chan struct{}
+
+	// Run watches the input directory until the context is done.
+	//
+	// Returns:
+	//   - error: Non-nil if the directory cannot be watched
+	Run(ctx context.Context) error
+}
+
+// IFileQueue defines an IFileWatcher that also reads the files enqueued in
+// memory, before the files of the input directory.
+type IFileQueue interface {
+	IFileWatcher
+
+	// Enqueue adds a file to the queue, which is read by the next call to
+	// ReadNextFile. The file is lost if the process stops before it is read.
+	//
+	// Parameters:
+	//   - ctx: Context for logging
+	//   - fileInfo: The file to read, usually with its content in DfReader
+	//
+	// Returns:
+	//   - error: Non-nil if a file with the same id is already queued
+	Enqueue(ctx context.Context, fileInfo *FileInfo) error
+
+	// Queued tells whether a file is in the queue, and not read yet.
+	Queued(id string) bool
+}
+
+// IFileAcker defines an IFileReader whose files stay in their source until
+// they are acknowledged, e.g. the pending entries of a redis stream, which
+// are read again when they are not acknowledged in time.
+type IFileAcker interface {
+	IFileReader
+
+	// Ack removes a file from its source, once its output is written.
+	//
+	// Parameters:
+	//   - ctx: Context for logging and cancellation
+	//   - fileInfo: The file returned by ReadNextFile
+	//
+	// Returns:
+	//   - error: Non-nil if the file cannot be acknowledged
+	Ack(ctx context.Context, fileInfo *FileInfo) error
+}
+
+// IFileFailer defines an IFileReader that keeps track of the files which
+// failed, e.g. the messages of an mbox, which are not read again by the
+// reader, so that it does not wait for them to be done.
+type IFileFailer interface {
+	IFileReader
+
+	// Fail records a file that was given up on, with its error. It is not
+	// called for a file that failed temporarily, which is left to be read
+	// again.
+	//
+	// Parameters:
+	//   - ctx: Context for logging
+	//   - fileInfo: The file returned by ReadNextFile
+	//   - fileError: The error of the file
+	//
+	// Returns:
+	//   - error: Non-nil if the failure cannot be recorded
+	Fail(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error
+}
+
+// IFileLaneReader defines an IFileReader that sorts its files into lanes by
+// their priority, so that each lane can be read by its own workers.
+type IFileLaneReader interface {
+	IFileReader
+
+	// ReadNextLaneFile retrieves the next unprocessed file of a lane.
+	//
+	// Parameters:
+	//   - ctx: Context for logging and cancellation
+	//   - lane: The name of the lane
+	//
+	// Returns:
+	//   - *FileInfo: Information about the next file to process, nil if none is left
+	//   - error: Non-nil if the lane is unknown, or file tracking operations fail
+	ReadNextLaneFile(ctx context.Context, lane string) (*FileInfo, error)
+}
+
+// IFileLifecycle defines where the files go once they are processed, so that
+// the input directory only holds the files left to read.
+type IFileLifecycle interface {
+	// Archive moves the files of a mail that was sent.
+	//
+	// Parameters:
+	//   - ctx: Context for logging
+	//   - fileInfo: The processed file
+	//
+	// Returns:
+	//   - error: Non-nil if the files cannot be moved
+	Archive(ctx context.Context, fileInfo *FileInfo) error
+
+	// Fail moves the files of a mail that failed permanently, with its error.
+	//
+	// Parameters:
+	//   - ctx: Context for logging
+	//   - fileInfo: The processed file
+	//   - fileError: The error of the mail, written beside the files
+	//
+	// Returns:
+	//   - error: Non-nil if the files cannot be moved
+	Fail(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error
+
+	// Quarantine moves the files of a mail that could not be parsed, with its
+	// error.
+	//
+	// Parameters:
+	//   - ctx: Context for logging
+	//   - fileInfo: The file that could not be parsed
+	//   - fileError: The parse error, written beside the files
+	//
+	// Returns:
+	//   - error: Non-nil if the files cannot be moved
+	Quarantine(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error
+
+	// Run removes the files older than the retention, until the context is
+	// done.
+	//
+	// Returns:
+	//   - error: Non-nil if the cleanup cannot run
+	Run(ctx context.Context) error
+}
+
+// RecipientDone is the last response of a recipient of a file that is done,
+// delivered or failed permanently
+type RecipientDone struct {
+	Code      int    `json:"code"`
+	Secode    string `json:"secode,omitempty"`
+	Line      string `json:"line"`
+	Permanent bool   `json:"permanent,omitempty"`
+}
+
+// IRecipientTracker defines the tracking of the recipients of a file that
+// are done, so that a file sent again because some of its recipients failed
+// temporarily is only sent to the recipients left.
+type IRecipientTracker interface {
+	// RecipientsDone returns the recipients of a file that are done.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - id: The unique identifier of the file
+	//
+	// Returns:
+	//   - map[string]RecipientDone: The last response of each recipient done
+	//   - error: Non-nil if the recipients cannot be read
+	RecipientsDone(ctx context.Context, id string) (map[string]RecipientDone, error)
+
+	// UpsertRecipients records recipients of a file that are done.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - id: The unique identifier of the file
+	//   - recipients: The last response of each recipient done
+	//
+	// Returns:
+	//   - error: Non-nil if the recipients cannot be recorded
+	UpsertRecipients(ctx context.Context, id string, recipients map[string]RecipientDone) error
+}
+
 // IFileReadTracker defines the interface for tracking file processing states.
 // Implementations of this interface provide functionality to:
 // - Track which files have been read
@@ -101,4 +281,4 @@ type IFileReadTracker interface {
 	UpsertFile(ctx context.Context, id string, status input.FileStatus) error
 }
 
-//go:generate mockgen -destination=mock.go -package=file . IFileReader,IFileReadTracker
+//go:generate mockgen -destination=mock.go -package=file . IFileAcker,IFileFailer,IFileLaneReader,IFileLifecycle,IFileQueue,IFileReader,IFileReadTracker,IFileWatcher,IRecipientTracker
@@ -0,0 +1,377 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	// PrioritySourceQf reads the priority from the P record of the qf file
+	PrioritySourceQf = "qf"
+	// PrioritySourceHeader reads the priority from a header of the qf file
+	PrioritySourceHeader = "header"
+	// PrioritySourceSubdir sorts the files of a subdirectory named after a
+	// lane into that lane
+	PrioritySourceSubdir = "subdir"
+
+	// DefaultPriorityHeader is the header holding the priority, from 1 the
+	// highest to 5 the lowest
+	DefaultPriorityHeader = "X-Priority"
+)
+
+// LaneRule sorts the files into a lane
+type LaneRule struct {
+	// Name is the name of the lane, and of its subdirectory for the subdir source
+	Name string
+
+	// MaxPriority is the least urgent priority of the lane, the lower the
+	// more urgent. The last lane takes every file left.
+	MaxPriority int64
+}
+
+// laneFile is the lane of a file listed by the reader
+type laneFile struct {
+	lane     int
+	priority int64
+	done     bool
+}
+
+// laneQueue holds the files of a lane, in the order of the reader
+type laneQueue struct {
+	files     []*FileInfo
+	fileIndex int
+}
+
+// LaneFileReader implements the IFileLaneReader interface, sorting the files
+// listed by another reader into lanes by their priority, so that each lane is
+// read on its own. The lanes are in order of urgency, the first lane is the
+// most urgent.
+//
+// The priority of each file is read once, from the P record or a header of
+// its qf file, or from its subdirectory. The files of a lane are read in the
+// order of the listing.
+type LaneFileReader struct {
+	// fileReader lists the files, its ReadNextFile is never called
+	fileReader IFileReader
+
+	// inputDir is the directory containing files to be processed
+	inputDir string
+
+	// source is the PrioritySource of the priority
+	source string
+
+	// header is the header of the priority, for the header source
+	header string
+
+	// defaultPriority is the priority of the files without one
+	defaultPriority int64
+
+	// lanes are the rules of the lanes, in order of urgency
+	lanes []LaneRule
+
+	// mu protects files and queues
+	mu sync.Mutex
+
+	// files holds the lane of each listed file, by id
+	files map[string]*laneFile
+
+	// queues holds the files of each lane
+	queues []*laneQueue
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewLaneFileReader creates a new instance of LaneFileReader.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - fileReader: The reader listing the files
+//   - inputDir: The directory containing files to be processed
+//   - fileReadTracker: The tracker for file processing states
+//   - source: PrioritySourceQf, PrioritySourceHeader or PrioritySourceSubdir, qf if empty
+//   - header: The header of the priority, DefaultPriorityHeader if empty
+//   - defaultPriority: The priority of the files without one
+//   - lanes: The rules of the lanes, in order of urgency
+//
+// Returns:
+//   - *LaneFileReader: A new reader instance
+//   - error: Non-nil if there is no lane, a lane name is repeated, or the source unknown
+func NewLaneFileReader(
+	ctx context.Context,
+	fileReader IFileReader,
+	inputDir string,
+	fileReadTracker IFileReadTracker,
+	source string,
+	header string,
+	defaultPriority int64,
+	lanes []LaneRule,
+) (*LaneFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("inputDir", inputDir).
+		Str("source", source).
+		Int("lanes", len(lanes)).
+		Msg("NewLaneFileReader")
+
+	if source == "" {
+		source = PrioritySourceQf
+	}
+	if !slices.Contains([]string{PrioritySourceQf, PrioritySourceHeader, PrioritySourceSubdir}, source) {
+		logger.Error().Str("source", source).Msg("NewLaneFileReader: unknown source")
+		return nil, fmt.Errorf("unknown priority source: %s", source)
+	}
+	if header == "" {
+		header = DefaultPriorityHeader
+	}
+	if len(lanes) == 0 {
+		logger.Error().Msg("NewLaneFileReader: no lane")
+		return nil, errors.New("no lane")
+	}
+	queues := make([]*laneQueue, 0, len(lanes))
+	for i, lane := range lanes {
+		if slices.ContainsFunc(lanes[:i], func(other LaneRule) bool { return other.Name == lane.Name }) {
+			logger.Error().Str("lane", lane.Name).Msg("NewLaneFileReader: repeated lane")
+			return nil, fmt.Errorf("repeated lane: %s", lane.Name)
+		}
+		queues = append(queues, &laneQueue{})
+	}
+
+	return &LaneFileReader{
+		fileReader:      fileReader,
+		inputDir:        inputDir,
+		source:          source,
+		header:          header,
+		defaultPriority: defaultPriority,
+		lanes:           lanes,
+		files:           make(map[string]*laneFile),
+		queues:          queues,
+		fileReadTracker: fileReadTracker,
+	}, nil
+}
+
+// RefreshList lists the files of the reader, and sorts them into their
+// lanes. The priority of a file is only read the first time it is listed.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The list of files of the reader
+//   - error: Non-nil if the reader cannot list its files
+func (f *LaneFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("LaneFileReader.RefreshList")
+
+	listed, err := f.fileReader.RefreshList(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: fileReader.RefreshList")
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := make(map[string]*laneFile, len(listed))
+	for _, queue := range f.queues {
+		queue.files = make([]*FileInfo, 0)
+		queue.fileIndex = 0
+	}
+	for _, fileInfo := range listed {
+		file, ok := f.files[fileInfo.ID]
+		if !ok {
+			file = f.classify(fileInfo)
+		}
+		files[fileInfo.ID] = file
+		// A reader may list the same files again, which may be processed
+		if fileInfo.Lane == "" {
+			fileInfo.Lane = f.lanes[file.lane].Name
+			fileInfo.Priority = file.priority
+		}
+		if file.done {
+			continue
+		}
+		queue := f.queues[file.lane]
+		queue.files = append(queue.files, fileInfo)
+	}
+	// The files which are gone are forgotten
+	f.files = files
+	return listed, nil
+}
+
+// classify reads the priority of a file, and finds its lane
+func (f *LaneFileReader) classify(fileInfo *FileInfo) *laneFile {
+	priority := f.defaultPriority
+	switch f.source {
+	case PrioritySourceQf:
+		value, ok := readQfPriority(fileInfo.QfFilePath)
+		if ok {
+			priority = value
+		}
+	case PrioritySourceHeader:
+		value, ok := readQfHeader(fileInfo.QfFilePath, f.header)
+		if ok {
+			priority = parsePriority(value, f.defaultPriority)
+		}
+	case PrioritySourceSubdir:
+		// The first subdirectory of the input directory names the lane
+		rel, err := filepath.Rel(f.inputDir, filepath.Dir(fileInfo.DfFilePath))
+		if err == nil {
+			subdir, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+			lane := slices.IndexFunc(f.lanes, func(lane LaneRule) bool { return lane.Name == subdir })
+			if lane >= 0 {
+				return &laneFile{lane: lane, priority: priority}
+			}
+		}
+	}
+	lane := slices.IndexFunc(f.lanes, func(lane LaneRule) bool { return priority <= lane.MaxPriority })
+	if lane < 0 {
+		lane = len(f.lanes) - 1
+	}
+	return &laneFile{lane: lane, priority: priority}
+}
+
+// ReadNextFile retrieves the next unprocessed file of the most urgent lane
+// with one.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is left
+//   - error: Non-nil if file tracking operations fail
+func (f *LaneFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	for _, lane := range f.lanes {
+		file, err := f.ReadNextLaneFile(ctx, lane.Name)
+		if err != nil || file != nil {
+			return file, err
+		}
+	}
+	return nil, nil
+}
+
+// ReadNextLaneFile retrieves the next unprocessed file of a lane. It skips
+// the files that are done or being processed.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - lane: The name of the lane
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is left
+//   - error: Non-nil if the lane is unknown, or file tracking operations fail
+func (f *LaneFileReader) ReadNextLaneFile(
+	ctx context.Context,
+	lane string,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx).With().Str("lane", lane).Logger()
+	logger.Debug().Msg("ReadNextLaneFile")
+
+	index := slices.IndexFunc(f.lanes, func(rule LaneRule) bool { return rule.Name == lane })
+	if index < 0 {
+		logger.Error().Msg("ReadNextLaneFile: unknown lane")
+		return nil, fmt.Errorf("unknown lane: %s", lane)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queue := f.queues[index]
+	for queue.fileIndex < len(queue.files) {
+		file := queue.files[queue.fileIndex]
+		queue.fileIndex++
+
+		status, err := f.fileReadTracker.FileRead(ctx, file.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextLaneFile: FileRead")
+			return nil, err
+		}
+		if status == input.FILE_STATUS_DONE {
+			// Not queued again while it is listed
+			if listed, ok := f.files[file.ID]; ok {
+				listed.done = true
+			}
+			continue
+		}
+		if status == input.FILE_STATUS_PROCESSING {
+			logger.Debug().
+				Str("fileName", file.DfFilePath).
+				Msg("ReadNextLaneFile: file is being processed")
+			continue
+		}
+
+		err = f.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextLaneFile: UpsertFile")
+			return nil, err
+		}
+		return file, nil
+	}
+
+	logger.Debug().Msg("ReadNextLaneFile: no more files")
+	return nil, nil
+}
+
+// readQfHeader returns the value of the first header of a qf file with the
+// name
+func readQfHeader(qfFilePath string, name string) (string, bool) {
+	qfFile, err := os.Open(qfFilePath)
+	if err != nil {
+		return "", false
+	}
+	defer func() { _ = qfFile.Close() }()
+	scanner := bufio.NewScanner(qfFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "." {
+			break
+		}
+		if !strings.HasPrefix(line, "H") {
+			continue
+		}
+		header := line[1:]
+		// Skip the ?condition? of the header
+		if strings.HasPrefix(header, "?") {
+			end := strings.Index(header[1:], "?")
+			if end < 0 {
+				continue
+			}
+			header = header[end+2:]
+		}
+		key, value, ok := strings.Cut(header, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// parsePriority parses the leading number of a priority, e.g. "1 (Highest)"
+func parsePriority(value string, defaultPriority int64) int64 {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '-' && r != '+'
+	})
+	if end >= 0 {
+		value = value[:end]
+	}
+	priority, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return defaultPriority
+	}
+	return priority
+}
@@ -0,0 +1,156 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func laneIDs(t *testing.T, ctx context.Context, reader *LaneFileReader, lane string) []string {
+	result := make([]string, 0)
+	for {
+		got, err := reader.ReadNextLaneFile(ctx, lane)
+		require.NoError(t, err)
+		if got == nil {
+			return result
+		}
+		assert.Equal(t, lane, got.Lane)
+		result = append(result, got.ID)
+	}
+}
+
+func TestLaneFileReader(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	lanes := []LaneRule{
+		{Name: "transactional", MaxPriority: 2},
+		{Name: "bulk"},
+	}
+	tests := []struct {
+		name              string
+		source            string
+		defaultPriority   int64
+		wantTransactional []string
+		wantBulk          []string
+	}{
+		{
+			name:              "qf",
+			source:            PrioritySourceQf,
+			defaultPriority:   1,
+			wantTransactional: []string{"d", "e"},
+			wantBulk:          []string{"a", "b", "c"},
+		},
+		{
+			name:              "header",
+			source:            PrioritySourceHeader,
+			defaultPriority:   3,
+			wantTransactional: []string{"a", "c"},
+			wantBulk:          []string{"b", "d", "e"},
+		},
+		{
+			name:              "subdir",
+			source:            PrioritySourceSubdir,
+			defaultPriority:   3,
+			wantTransactional: []string{"e"},
+			wantBulk:          []string{"a", "b", "c", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			tracker := NewMockIFileReadTracker(gomock.NewController(t))
+			tracker.EXPECT().FileRead(gomock.Any(), gomock.Any()).Return(input.FILE_STATUS_NOT_FOUND, nil).AnyTimes()
+			tracker.EXPECT().UpsertFile(gomock.Any(), gomock.Any(), input.FILE_STATUS_PROCESSING).Return(nil).AnyTimes()
+			inPath := t.TempDir()
+			writeScanPair(t, inPath, "a", "V8\nP30000\nH??X-Priority: 1 (Highest)\n.\n", old)
+			writeScanPair(t, inPath, "b", "V8\nP90000\nH??X-Priority: 5\n.\n", old)
+			writeScanPair(t, filepath.Join(inPath, "bulk"), "c", "V8\nP120000\nHX-Priority: 2\n.\n", old)
+			writeScanPair(t, filepath.Join(inPath, "bulk"), "d", "V8\nP-1\nHX-Priority: urgent\n.\n", old)
+			writeScanPair(t, filepath.Join(inPath, "transactional"), "e", "V8\n.\n", old)
+
+			scanReader, err := NewScanFileReader(ctx, inPath, tracker, 1, ScanOrderFilename, time.Hour)
+			require.NoError(t, err)
+			reader, err := NewLaneFileReader(ctx, scanReader, inPath, tracker, tt.source, "", tt.defaultPriority, lanes)
+			require.NoError(t, err)
+			files, err := reader.RefreshList(ctx)
+			require.NoError(t, err)
+			assert.Len(t, files, 5)
+
+			assert.Equal(t, tt.wantTransactional, laneIDs(t, ctx, reader, "transactional"))
+			assert.Equal(t, tt.wantBulk, laneIDs(t, ctx, reader, "bulk"))
+		})
+	}
+}
+
+func TestLaneFileReaderRead(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	fileReader := NewMockIFileReader(ctrl)
+	tracker := NewMockIFileReadTracker(ctrl)
+	inPath := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	writeScanPair(t, inPath, "a", "V8\nP9\n.\n", old)
+	writeScanPair(t, inPath, "b", "V8\nP1\n.\n", old)
+	writeScanPair(t, inPath, "c", "V8\nP1\n.\n", old)
+	listed := func() []*FileInfo {
+		result := make([]*FileInfo, 0)
+		for _, id := range []string{"a", "b", "c"} {
+			result = append(result, &FileInfo{
+				DfFilePath: filepath.Join(inPath, "df"+id),
+				ID:         id,
+				QfFilePath: filepath.Join(inPath, "qf"+id),
+			})
+		}
+		return result
+	}
+	fileReader.EXPECT().RefreshList(gomock.Any()).DoAndReturn(func(context.Context) ([]*FileInfo, error) {
+		return listed(), nil
+	}).Times(2)
+
+	reader, err := NewLaneFileReader(ctx, fileReader, inPath, tracker, "", "", 0, []LaneRule{
+		{Name: "high", MaxPriority: 1},
+		{Name: "low"},
+	})
+	require.NoError(t, err)
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+
+	// The most urgent lane is read first, skipping the files being processed
+	// or done
+	tracker.EXPECT().FileRead(gomock.Any(), "b").Return(input.FILE_STATUS_DONE, nil)
+	tracker.EXPECT().FileRead(gomock.Any(), "c").Return(input.FILE_STATUS_PROCESSING, nil)
+	tracker.EXPECT().FileRead(gomock.Any(), "a").Return(input.FILE_STATUS_NOT_FOUND, nil)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "a", input.FILE_STATUS_PROCESSING).Return(nil)
+	got, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got.ID)
+	assert.Equal(t, "low", got.Lane)
+	assert.Equal(t, int64(9), got.Priority)
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// The done file is not read again, the others are
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	tracker.EXPECT().FileRead(gomock.Any(), "c").Return(input.FILE_STATUS_NOT_FOUND, nil)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "c", input.FILE_STATUS_PROCESSING).Return(nil)
+	got, err = reader.ReadNextLaneFile(ctx, "high")
+	require.NoError(t, err)
+	assert.Equal(t, "c", got.ID)
+
+	_, err = reader.ReadNextLaneFile(ctx, "missing")
+	assert.Error(t, err)
+	_, err = NewLaneFileReader(ctx, fileReader, inPath, tracker, "size", "", 0, []LaneRule{{Name: "a"}})
+	assert.Error(t, err)
+	_, err = NewLaneFileReader(ctx, fileReader, inPath, tracker, "", "", 0, nil)
+	assert.Error(t, err)
+	_, err = NewLaneFileReader(ctx, fileReader, inPath, tracker, "", "", 0, []LaneRule{{Name: "a"}, {Name: "a"}})
+	assert.Error(t, err)
+}
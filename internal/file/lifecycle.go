@@ -0,0 +1,359 @@
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// LifecycleArchiveDir holds the files of the mails that were sent
+	LifecycleArchiveDir = "archive"
+	// LifecycleFailedDir holds the files of the mails that failed permanently
+	LifecycleFailedDir = "failed"
+	// LifecycleQuarantineDir holds the files that could not be parsed
+	LifecycleQuarantineDir = "quarantine"
+	// ErrFileExt is the extension of the sidecar describing the error of a
+	// failed or quarantined file
+	ErrFileExt = ".err"
+	// GzipFileExt is the extension of the archived files, when compressed
+	GzipFileExt = ".gz"
+)
+
+// FileError is the JSON sidecar of a failed or quarantined file
+type FileError struct {
+	ID string `json:"id"`
+	// Error describes why the mail was not sent
+	Error string `json:"error"`
+	// Recipients holds the last response of each recipient that failed
+	Recipients map[string]RecipientError `json:"recipients,omitempty"`
+	Time       time.Time                 `json:"time"`
+}
+
+// RecipientError is the response of a recipient that failed
+type RecipientError struct {
+	Code   int    `json:"code"`
+	Secode string `json:"secode,omitempty"`
+	Line   string `json:"line"`
+}
+
+// FileLifecycle implements the IFileLifecycle interface, moving the processed
+// files out of the input directory, into the archive, failed and quarantine
+// directories of its path, so that they are not read again once the tracker
+// forgets them. The entries of these directories are removed after the
+// retention.
+type FileLifecycle struct {
+	// path holds the archive, failed and quarantine directories
+	path string
+
+	// dated archives into year/month/day subdirectories
+	dated bool
+
+	// gzip compresses the archived files
+	gzip bool
+
+	// retention is how long the entries are kept, forever when 0
+	retention time.Duration
+
+	// cleanupInterval is how often Run removes the old entries
+	cleanupInterval time.Duration
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// NewFileLifecycle creates a new instance of FileLifecycle, and its
+// directories.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - path: The directory of the archive, failed and quarantine directories
+//   - dated: Whether the archive has year/month/day subdirectories
+//   - gzip: Whether the archived files are compressed
+//   - retention: How long the entries are kept, forever when 0
+//   - cleanupInterval: How often the old entries are removed
+//
+// Returns:
+//   - *FileLifecycle: A new lifecycle instance
+//   - error: Non-nil if the directories cannot be created
+func NewFileLifecycle(
+	ctx context.Context,
+	path string,
+	dated bool,
+	gzip bool,
+	retention time.Duration,
+	cleanupInterval time.Duration,
+) (*FileLifecycle, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("path", path).
+		Bool("dated", dated).
+		Bool("gzip", gzip).
+		Dur("retention", retention).
+		Msg("NewFileLifecycle")
+
+	for _, dir := range []string{LifecycleArchiveDir, LifecycleFailedDir, LifecycleQuarantineDir} {
+		err := os.MkdirAll(filepath.Join(path, dir), 0o750)
+		if err != nil {
+			logger.Error().Err(err).Str("dir", dir).Msg("NewFileLifecycle: os.MkdirAll")
+			return nil, err
+		}
+	}
+	return &FileLifecycle{
+		path:            path,
+		dated:           dated,
+		gzip:            gzip,
+		retention:       retention,
+		cleanupInterval: cleanupInterval,
+		now:             time.Now,
+	}, nil
+}
+
+// Archive moves the files of a mail that was sent into the archive
+// directory, under the day of the move when dated, and compressed with gzip
+// when configured.
+func (l *FileLifecycle) Archive(ctx context.Context, fileInfo *FileInfo) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("FileLifecycle.Archive")
+
+	now := l.now()
+	dir := filepath.Join(l.path, LifecycleArchiveDir)
+	if l.dated {
+		dir = filepath.Join(dir, now.Format("2006"), now.Format("01"), now.Format("02"))
+	}
+	return l.moveFiles(ctx, fileInfo, dir, l.gzip, now)
+}
+
+// Fail moves the files of a mail that failed permanently into the failed
+// directory, with the sidecar of its error.
+func (l *FileLifecycle) Fail(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("FileLifecycle.Fail")
+	return l.moveWithError(ctx, fileInfo, filepath.Join(l.path, LifecycleFailedDir), fileError)
+}
+
+// Quarantine moves the files of a mail that could not be parsed into the
+// quarantine directory, with the sidecar of its error.
+func (l *FileLifecycle) Quarantine(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("FileLifecycle.Quarantine")
+	return l.moveWithError(ctx, fileInfo, filepath.Join(l.path, LifecycleQuarantineDir), fileError)
+}
+
+// moveWithError writes the sidecar of the error, then moves the files, so
+// that a moved file always has its sidecar
+func (l *FileLifecycle) moveWithError(
+	ctx context.Context,
+	fileInfo *FileInfo,
+	dir string,
+	fileError *FileError,
+) error {
+	logger := zerolog.Ctx(ctx)
+	now := l.now()
+	fileError.ID = fileInfo.ID
+	fileError.Time = now
+	data, err := json.MarshalIndent(fileError, "", "  ")
+	if err != nil {
+		return err
+	}
+	errPath := filepath.Join(dir, fileInfo.ID+ErrFileExt)
+	err = os.WriteFile(errPath, append(data, '\n'), 0o640)
+	if err == nil {
+		err = os.Chtimes(errPath, now, now)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("moveWithError: os.WriteFile")
+		return err
+	}
+	return l.moveFiles(ctx, fileInfo, dir, false, now)
+}
+
+// moveFiles moves the df and the qf file into the directory. The files which
+// do not exist, e.g. the in memory files of the queue, are skipped. The moved
+// files get the time of the move, from which their retention starts.
+func (l *FileLifecycle) moveFiles(
+	ctx context.Context,
+	fileInfo *FileInfo,
+	dir string,
+	compress bool,
+	now time.Time,
+) error {
+	logger := zerolog.Ctx(ctx)
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		logger.Error().Err(err).Msg("moveFiles: os.MkdirAll")
+		return err
+	}
+	for _, src := range []string{fileInfo.DfFilePath, fileInfo.QfFilePath} {
+		if src == "" {
+			continue
+		}
+		_, err = os.Stat(src)
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Debug().Str("src", src).Msg("moveFiles: skipping missing file")
+			continue
+		}
+		dst := filepath.Join(dir, filepath.Base(src))
+		if compress {
+			dst += GzipFileExt
+			err = gzipFile(src, dst)
+		} else {
+			err = moveFile(src, dst)
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("src", src).Str("dst", dst).Msg("moveFiles")
+			return err
+		}
+		err = os.Chtimes(dst, now, now)
+		if err != nil {
+			logger.Error().Err(err).Str("dst", dst).Msg("moveFiles: os.Chtimes")
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames the file, or copies it across file systems
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return writeFile(src, dst, func(writer io.Writer, reader io.Reader) error {
+		_, err := io.Copy(writer, reader)
+		return err
+	})
+}
+
+// gzipFile compresses the file into dst
+func gzipFile(src string, dst string) error {
+	return writeFile(src, dst, func(writer io.Writer, reader io.Reader) error {
+		gzipWriter := gzip.NewWriter(writer)
+		_, err := io.Copy(gzipWriter, reader)
+		if err != nil {
+			return err
+		}
+		return gzipWriter.Close()
+	})
+}
+
+// writeFile writes src through the copy function into a temporary file,
+// renames it to dst once synced, and removes src
+func writeFile(src string, dst string, copyFunc func(io.Writer, io.Reader) error) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = srcFile.Close() }()
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	err = copyFunc(tmpFile, srcFile)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile.Name(), dst)
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Cleanup removes the entries of the archive, failed and quarantine
+// directories older than the retention, and the subdirectories left empty.
+//
+// Returns:
+//   - int: The number of files removed
+//   - error: Non-nil if a directory cannot be walked
+func (l *FileLifecycle) Cleanup(ctx context.Context) (int, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("FileLifecycle.Cleanup")
+	if l.retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := l.now().Add(-l.retention)
+	removed := 0
+	for _, top := range []string{LifecycleArchiveDir, LifecycleFailedDir, LifecycleQuarantineDir} {
+		root := filepath.Join(l.path, top)
+		dirs := make([]string, 0)
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != root {
+					dirs = append(dirs, path)
+				}
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(cutoff) {
+				return nil
+			}
+			err = os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			removed++
+			return nil
+		})
+		if err != nil {
+			logger.Error().Err(err).Str("root", root).Msg("Cleanup: filepath.WalkDir")
+			return removed, err
+		}
+		// The deepest directories first, a directory which is not empty stays
+		slices.Reverse(dirs)
+		for _, dir := range dirs {
+			_ = os.Remove(dir)
+		}
+	}
+	if removed > 0 {
+		logger.Info().Int("removed", removed).Msg("Cleanup: removed old entries")
+	}
+	return removed, nil
+}
+
+// Run removes the old entries every cleanup interval, until the context is
+// done.
+//
+// Returns:
+//   - error: Always nil, the errors of a cleanup are logged
+func (l *FileLifecycle) Run(ctx context.Context) error {
+	if l.retention <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(l.cleanupInterval)
+	defer ticker.Stop()
+	for {
+		_, _ = l.Cleanup(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestPair writes a df/qf pair into the input directory
+func writeTestPair(t *testing.T, inPath string, id string) *FileInfo {
+	fileInfo := &FileInfo{
+		DfFilePath: filepath.Join(inPath, "df"+id),
+		ID:         id,
+		QfFilePath: filepath.Join(inPath, "qf"+id),
+	}
+	require.NoError(t, os.WriteFile(fileInfo.DfFilePath, []byte("body "+id), 0o600))
+	require.NoError(t, os.WriteFile(fileInfo.QfFilePath, []byte("V8\n"), 0o600))
+	return fileInfo
+}
+
+func TestFileLifecycle(t *testing.T) {
+	now := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		dated    bool
+		gzip     bool
+		move     func(context.Context, *FileLifecycle, *FileInfo) error
+		wantDir  string
+		wantExt  string
+		wantErr  string
+		wantJSON bool
+	}{
+		{
+			name: "archive",
+			move: func(ctx context.Context, l *FileLifecycle, fileInfo *FileInfo) error {
+				return l.Archive(ctx, fileInfo)
+			},
+			wantDir: LifecycleArchiveDir,
+		},
+		{
+			name:  "archive dated gzip",
+			dated: true,
+			gzip:  true,
+			move: func(ctx context.Context, l *FileLifecycle, fileInfo *FileInfo) error {
+				return l.Archive(ctx, fileInfo)
+			},
+			wantDir: filepath.Join(LifecycleArchiveDir, "2026", "03", "04"),
+			wantExt: GzipFileExt,
+		},
+		{
+			name: "failed",
+			move: func(ctx context.Context, l *FileLifecycle, fileInfo *FileInfo) error {
+				return l.Fail(ctx, fileInfo, &FileError{
+					Error: "permanent failure",
+					Recipients: map[string]RecipientError{
+						"john@example.org": {Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown"},
+					},
+				})
+			},
+			wantDir:  LifecycleFailedDir,
+			wantErr:  "permanent failure",
+			wantJSON: true,
+		},
+		{
+			name:  "quarantine is never dated",
+			dated: true,
+			move: func(ctx context.Context, l *FileLifecycle, fileInfo *FileInfo) error {
+				return l.Quarantine(ctx, fileInfo, &FileError{Error: "invalid qf"})
+			},
+			wantDir:  LifecycleQuarantineDir,
+			wantErr:  "invalid qf",
+			wantJSON: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			inPath := t.TempDir()
+			lifecycle, err := NewFileLifecycle(ctx, inPath, tt.dated, tt.gzip, 0, time.Hour)
+			require.NoError(t, err)
+			lifecycle.now = func() time.Time { return now }
+			fileInfo := writeTestPair(t, inPath, "abc")
+
+			require.NoError(t, tt.move(ctx, lifecycle, fileInfo))
+
+			assert.NoFileExists(t, fileInfo.DfFilePath)
+			assert.NoFileExists(t, fileInfo.QfFilePath)
+			dfPath := filepath.Join(inPath, tt.wantDir, "dfabc"+tt.wantExt)
+			assert.FileExists(t, filepath.Join(inPath, tt.wantDir, "qfabc"+tt.wantExt))
+			dfFile, err := os.Open(dfPath)
+			require.NoError(t, err)
+			defer func() { _ = dfFile.Close() }()
+			var reader io.Reader = dfFile
+			if tt.gzip {
+				reader, err = gzip.NewReader(dfFile)
+				require.NoError(t, err)
+			}
+			content, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "body abc", string(content))
+			// The retention starts with the move
+			info, err := os.Stat(dfPath)
+			require.NoError(t, err)
+			assert.True(t, info.ModTime().Equal(now))
+
+			errPath := filepath.Join(inPath, tt.wantDir, "abc"+ErrFileExt)
+			if !tt.wantJSON {
+				assert.NoFileExists(t, errPath)
+				return
+			}
+			data, err := os.ReadFile(errPath)
+			require.NoError(t, err)
+			var got FileError
+			require.NoError(t, json.Unmarshal(data, &got))
+			assert.Equal(t, "abc", got.ID)
+			assert.Equal(t, tt.wantErr, got.Error)
+			assert.True(t, got.Time.Equal(now))
+		})
+	}
+}
+
+func TestFileLifecycleMissingFiles(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	inPath := t.TempDir()
+	lifecycle, err := NewFileLifecycle(ctx, inPath, false, false, 0, time.Hour)
+	require.NoError(t, err)
+
+	// The in memory files of the queue have no files to move
+	require.NoError(t, lifecycle.Archive(ctx, &FileInfo{
+		DfFilePath: filepath.Join(inPath, "dfmissing"),
+		ID:         "missing",
+	}))
+	entries, err := os.ReadDir(filepath.Join(inPath, LifecycleArchiveDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestFileLifecycleCleanup(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	inPath := t.TempDir()
+	now := time.Now()
+	lifecycle, err := NewFileLifecycle(ctx, inPath, true, false, 24*time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	lifecycle.now = func() time.Time { return now.Add(-48 * time.Hour) }
+	require.NoError(t, lifecycle.Archive(ctx, writeTestPair(t, inPath, "old")))
+	require.NoError(t, lifecycle.Quarantine(ctx, writeTestPair(t, inPath, "bad"), &FileError{Error: "invalid"}))
+	lifecycle.now = func() time.Time { return now }
+	require.NoError(t, lifecycle.Archive(ctx, writeTestPair(t, inPath, "new")))
+
+	removed, err := lifecycle.Cleanup(ctx)
+	require.NoError(t, err)
+	// The old pair, and the bad pair with its sidecar
+	assert.Equal(t, 5, removed)
+
+	oldDay := now.Add(-48 * time.Hour)
+	assert.NoDirExists(t, filepath.Join(inPath, LifecycleArchiveDir, oldDay.Format("2006"), oldDay.Format("01"), oldDay.Format("02")))
+	assert.FileExists(t, filepath.Join(inPath, LifecycleArchiveDir, now.Format("2006"), now.Format("01"), now.Format("02"), "dfnew"))
+	assert.DirExists(t, filepath.Join(inPath, LifecycleQuarantineDir))
+	entries, err := os.ReadDir(filepath.Join(inPath, LifecycleQuarantineDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,180 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	MaildirCur = "cur"
+	MaildirNew = "new"
+	MaildirTmp = "tmp"
+
+	// maildirInfoSeparator separates the unique name of a message from its
+	// info, e.g. 1700000000.M1P2.host:2,S
+	maildirInfoSeparator = ":"
+	// maildirInfoEmpty is the info of a message moved to cur without flags
+	maildirInfoEmpty = ":2,"
+)
+
+// MaildirFileReader implements the IFileReader interface for a Maildir.
+// Messages are read from new/, and moved to cur/ when they are read, as a
+// mail client does, so that each message is read once. Writers deliver into
+// tmp/ and rename into new/, so files in new/ are always complete.
+type MaildirFileReader struct {
+	// maildir is the directory holding cur, new and tmp
+	maildir string
+
+	// files is the list of files to be processed
+	files []*FileInfo
+
+	// fileIndex is the current position in the files list
+	fileIndex int
+
+	// mu protects concurrent access to files and fileIndex
+	mu sync.Mutex
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewMaildirFileReader creates a new instance of MaildirFileReader.
+// The cur, new and tmp directories are created if missing.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - maildir: The Maildir directory
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *MaildirFileReader: A new reader instance
+//   - error: Non-nil if the Maildir is invalid or inaccessible
+func NewMaildirFileReader(
+	ctx context.Context,
+	maildir string,
+	fileReadTracker IFileReadTracker,
+) (*MaildirFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("maildir", maildir).
+		Msg("NewMaildirFileReader")
+
+	err := validateDir(maildir)
+	if err != nil {
+		logger.Error().Err(err).Msg("NewMaildirFileReader: validateDir")
+		return nil, err
+	}
+	for _, dir := range []string{MaildirCur, MaildirNew, MaildirTmp} {
+		err = os.MkdirAll(filepath.Join(maildir, dir), 0700)
+		if err != nil {
+			logger.Error().Err(err).Str("dir", dir).Msg("NewMaildirFileReader: os.MkdirAll")
+			return nil, err
+		}
+	}
+
+	return &MaildirFileReader{
+		maildir:         maildir,
+		files:           make([]*FileInfo, 0),
+		fileReadTracker: fileReadTracker,
+	}, nil
+}
+
+// RefreshList scans new/ for messages. The id of a message is its unique
+// name, without the info.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The list of messages found in new/
+//   - error: Non-nil if directory scanning fails
+func (f *MaildirFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("MaildirFileReader.RefreshList")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	newDir := filepath.Join(f.maildir, MaildirNew)
+	entries, err := os.ReadDir(newDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: os.ReadDir")
+		return nil, err
+	}
+
+	f.files = make([]*FileInfo, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, _, _ := strings.Cut(name, maildirInfoSeparator)
+		f.files = append(f.files, &FileInfo{
+			DfFilePath: filepath.Join(newDir, name),
+			ID:         id,
+			Status:     input.FILE_STATUS_INIT,
+		})
+	}
+
+	f.fileIndex = 0
+	return f.files, nil
+}
+
+// ReadNextFile retrieves the next unprocessed message and moves it to cur/.
+// Messages that are done, being processed, or already moved by another
+// reader are skipped.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: The message, with DfFilePath in cur/, nil if none is left
+//   - error: Non-nil if file tracking operations or the move fail
+func (f *MaildirFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("MaildirFileReader.ReadNextFile")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		file, err := nextTrackedFile(ctx, f.fileReadTracker, f.files, &f.fileIndex)
+		if err != nil || file == nil {
+			return nil, err
+		}
+
+		name := filepath.Base(file.DfFilePath)
+		if !strings.Contains(name, maildirInfoSeparator) {
+			name += maildirInfoEmpty
+		}
+		curPath := filepath.Join(f.maildir, MaildirCur, name)
+		err = os.Rename(file.DfFilePath, curPath)
+		if os.IsNotExist(err) {
+			// Moved by another reader since the refresh
+			logger.Debug().Str("fileName", file.DfFilePath).Msg("ReadNextFile: already moved")
+			continue
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: os.Rename")
+			return nil, err
+		}
+		file.DfFilePath = curPath
+
+		err = f.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+			return nil, err
+		}
+		return file, nil
+	}
+}
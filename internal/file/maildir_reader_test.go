@@ -0,0 +1,109 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestMaildirFileReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		statuses  map[string]input.FileStatus
+		wantIDs   []string
+		wantNames []string
+	}{
+		{
+			name:      "new messages",
+			files:     []string{"1700000001.M1P1.host", "1700000000.M2P1.host:2,F", ".tmpfile"},
+			wantIDs:   []string{"1700000000.M2P1.host", "1700000001.M1P1.host"},
+			wantNames: []string{"1700000000.M2P1.host:2,F", "1700000001.M1P1.host:2,"},
+		},
+		{
+			name:      "skips done",
+			files:     []string{"1700000000.M1P1.host", "1700000001.M1P1.host"},
+			statuses:  map[string]input.FileStatus{"1700000000.M1P1.host": input.FILE_STATUS_DONE},
+			wantIDs:   []string{"1700000001.M1P1.host"},
+			wantNames: []string{"1700000001.M1P1.host:2,"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			maildir := t.TempDir()
+			require.NoError(t, os.Mkdir(filepath.Join(maildir, MaildirNew), 0700))
+			for _, name := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(maildir, MaildirNew, name), []byte("Subject: test\r\n\r\nbody\r\n"), 0600))
+			}
+
+			ctrl := gomock.NewController(t)
+			tracker := NewMockIFileReadTracker(ctrl)
+			tracker.EXPECT().
+				FileRead(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, id string) (input.FileStatus, error) {
+					status, ok := tt.statuses[id]
+					if !ok {
+						return input.FILE_STATUS_NOT_FOUND, nil
+					}
+					return status, nil
+				}).
+				AnyTimes()
+			for _, id := range tt.wantIDs {
+				tracker.EXPECT().UpsertFile(gomock.Any(), id, input.FILE_STATUS_PROCESSING).Return(nil)
+			}
+
+			reader, err := NewMaildirFileReader(ctx, maildir, tracker)
+			require.NoError(t, err)
+			assert.DirExists(t, filepath.Join(maildir, MaildirCur))
+			assert.DirExists(t, filepath.Join(maildir, MaildirTmp))
+			_, err = reader.RefreshList(ctx)
+			require.NoError(t, err)
+
+			gotIDs := make([]string, 0)
+			gotNames := make([]string, 0)
+			for {
+				fileInfo, err := reader.ReadNextFile(ctx)
+				require.NoError(t, err)
+				if fileInfo == nil {
+					break
+				}
+				// The message is moved to cur
+				assert.Equal(t, filepath.Join(maildir, MaildirCur), filepath.Dir(fileInfo.DfFilePath))
+				assert.FileExists(t, fileInfo.DfFilePath)
+				gotIDs = append(gotIDs, fileInfo.ID)
+				gotNames = append(gotNames, filepath.Base(fileInfo.DfFilePath))
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+			assert.Equal(t, tt.wantNames, gotNames)
+		})
+	}
+}
+
+func TestMaildirFileReaderMovedByOther(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	maildir := t.TempDir()
+	ctrl := gomock.NewController(t)
+	tracker := NewMockIFileReadTracker(ctrl)
+	tracker.EXPECT().FileRead(gomock.Any(), gomock.Any()).Return(input.FILE_STATUS_NOT_FOUND, nil).AnyTimes()
+
+	reader, err := NewMaildirFileReader(ctx, maildir, tracker)
+	require.NoError(t, err)
+	newPath := filepath.Join(maildir, MaildirNew, "1700000000.M1P1.host")
+	require.NoError(t, os.WriteFile(newPath, []byte("Subject: test\r\n\r\nbody\r\n"), 0600))
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+
+	// Another instance moves the message after the refresh
+	require.NoError(t, os.Rename(newPath, filepath.Join(maildir, MaildirCur, "1700000000.M1P1.host:2,")))
+	fileInfo, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, fileInfo)
+}
@@ -0,0 +1,612 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	// MboxFormatMboxrd quotes lines of the body matching ^>*From with one
+	// more >, which is removed when read
+	MboxFormatMboxrd = "mboxrd"
+	// MboxFormatMboxcl2 does not quote the body, the Content-Length header
+	// gives its length
+	MboxFormatMboxcl2 = "mboxcl2"
+
+	// MboxIDOffset identifies a message by the mbox path and its offset
+	MboxIDOffset = "offset"
+	// MboxIDMessageID identifies a message by the hash of its Message-ID,
+	// and by its offset if it has none
+	MboxIDMessageID = "message-id"
+
+	// MboxProgressExt is appended to the mbox path for the default progress file
+	MboxProgressExt = ".progress"
+	// MboxFailedExt is appended to the progress file for the failed file
+	MboxFailedExt = ".failed"
+
+	mboxSeparator = "From "
+)
+
+var (
+	mboxrdQuoted = regexp.MustCompile(`^>+From `)
+)
+
+// MboxProgress is the progress of an mbox import, saved in the progress
+// file. Every message before Offset is done.
+type MboxProgress struct {
+	Path    string    `json:"path"`
+	Offset  int64     `json:"offset"`
+	Updated time.Time `json:"updated"`
+}
+
+// MboxFailure is a message that failed, appended to the failed file as a
+// line of JSON, so that the messages can be sent again on their own
+type MboxFailure struct {
+	FileError
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	End    int64  `json:"end"`
+}
+
+// mboxMessage is a message of the mbox, from its From line to the next
+type mboxMessage struct {
+	fileInfo *FileInfo
+	offset   int64
+	end      int64
+}
+
+// MboxFileReader implements the IFileReader interface for an mbox archive.
+// The archive is scanned once for the offsets of its messages, and each
+// message is read when it is returned, so that large archives are not held
+// in memory. FileInfo.DfReader holds the unquoted message.
+//
+// The progress file records the offset before which every message is done,
+// so that an interrupted import resumes there. Messages after it that were
+// already delivered are skipped by the tracker. The messages that can never
+// be sent are recorded in the failed file, and count as done for the
+// progress, while those that failed temporarily stop the progress.
+type MboxFileReader struct {
+	// path is the mbox file
+	path string
+
+	// format is MboxFormatMboxrd or MboxFormatMboxcl2
+	format string
+
+	// idType is MboxIDOffset or MboxIDMessageID
+	idType string
+
+	// progressPath is the file recording the progress
+	progressPath string
+
+	// failedPath is the file recording the messages that failed
+	failedPath string
+
+	// pathHash identifies the mbox in the ids of its messages
+	pathHash string
+
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// messages are the messages after the progress, in file order
+	messages []*mboxMessage
+
+	// messageIndex is the next message to return
+	messageIndex int
+
+	// inflight are the messages returned but not yet done, in file order
+	inflight []*mboxMessage
+
+	// failed are the ids of the messages that failed, never read again
+	failed map[string]bool
+
+	// progress is the offset before which every message is done
+	progress int64
+
+	// scannedSize is the size of the mbox when it was scanned
+	scannedSize int64
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewMboxFileReader creates a new instance of MboxFileReader, and loads the
+// progress of a previous import.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - path: The mbox file
+//   - format: MboxFormatMboxrd or MboxFormatMboxcl2, mboxrd if empty
+//   - idType: MboxIDOffset or MboxIDMessageID, offset if empty
+//   - progressPath: The progress file, the mbox path with MboxProgressExt if empty
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *MboxFileReader: A new reader instance
+//   - error: Non-nil if the mbox or the progress file cannot be read
+func NewMboxFileReader(
+	ctx context.Context,
+	path string,
+	format string,
+	idType string,
+	progressPath string,
+	fileReadTracker IFileReadTracker,
+) (*MboxFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("path", path).
+		Str("format", format).
+		Str("idType", idType).
+		Str("progressPath", progressPath).
+		Msg("NewMboxFileReader")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.Error().Err(err).Msg("NewMboxFileReader: os.Stat")
+		return nil, err
+	}
+	if info.IsDir() {
+		logger.Error().Msg("NewMboxFileReader: is a directory")
+		return nil, errors.New("is a directory")
+	}
+	if format == "" {
+		format = MboxFormatMboxrd
+	}
+	if format != MboxFormatMboxrd && format != MboxFormatMboxcl2 {
+		return nil, fmt.Errorf("unsupported mbox format %s", format)
+	}
+	if idType == "" {
+		idType = MboxIDOffset
+	}
+	if idType != MboxIDOffset && idType != MboxIDMessageID {
+		return nil, fmt.Errorf("unsupported mbox id %s", idType)
+	}
+	if progressPath == "" {
+		progressPath = path + MboxProgressExt
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	pathSum := sha256.Sum256([]byte(absPath))
+
+	result := &MboxFileReader{
+		path:            path,
+		format:          format,
+		idType:          idType,
+		progressPath:    progressPath,
+		failedPath:      progressPath + MboxFailedExt,
+		pathHash:        hex.EncodeToString(pathSum[:6]),
+		messages:        make([]*mboxMessage, 0),
+		inflight:        make([]*mboxMessage, 0),
+		failed:          make(map[string]bool),
+		scannedSize:     -1,
+		fileReadTracker: fileReadTracker,
+	}
+	result.progress, err = result.loadProgress()
+	if err != nil {
+		logger.Error().Err(err).Msg("NewMboxFileReader: loadProgress")
+		return nil, err
+	}
+	if result.progress > info.Size() {
+		return nil, fmt.Errorf("progress %d is after the end of %s, remove %s to import it again",
+			result.progress, path, progressPath)
+	}
+	return result, nil
+}
+
+// Progress returns the offset before which every message is done
+func (f *MboxFileReader) Progress() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.progress
+}
+
+// RefreshList scans the mbox from the progress, if it was not scanned yet or
+// its size changed. Messages returned before are skipped by the tracker.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The messages left to return
+//   - error: Non-nil if the mbox cannot be read or parsed
+func (f *MboxFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("MboxFileReader.RefreshList")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: os.Stat")
+		return nil, err
+	}
+	if info.Size() != f.scannedSize {
+		messages, err := f.scan(ctx, f.progress)
+		if err != nil {
+			logger.Error().Err(err).Msg("RefreshList: scan")
+			return nil, err
+		}
+		f.messages = messages
+		f.messageIndex = 0
+		f.scannedSize = info.Size()
+		logger.Info().
+			Int64("progress", f.progress).
+			Int("messages", len(messages)).
+			Msg("RefreshList: scanned mbox")
+	}
+
+	result := make([]*FileInfo, 0, len(f.messages)-f.messageIndex)
+	for _, message := range f.messages[f.messageIndex:] {
+		result = append(result, message.fileInfo)
+	}
+	return result, nil
+}
+
+// ReadNextFile returns the next message that is not processed yet, with its
+// content in DfReader, and saves the progress of the messages done since.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: The next message, nil if none is left
+//   - error: Non-nil if file tracking, reading the message or saving the progress fail
+func (f *MboxFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("MboxFileReader.ReadNextFile")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.saveDoneProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for f.messageIndex < len(f.messages) {
+		message := f.messages[f.messageIndex]
+		f.messageIndex++
+		if f.failed[message.fileInfo.ID] {
+			f.inflight = append(f.inflight, message)
+			continue
+		}
+		status, err := f.fileReadTracker.FileRead(ctx, message.fileInfo.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: FileRead")
+			return nil, err
+		}
+		if status == input.FILE_STATUS_PROCESSING || status == input.FILE_STATUS_DONE {
+			// Wait for it to be done before moving the progress past it
+			f.inflight = append(f.inflight, message)
+			continue
+		}
+
+		content, err := f.readMessage(message)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: readMessage")
+			return nil, err
+		}
+		err = f.fileReadTracker.UpsertFile(ctx, message.fileInfo.ID, input.FILE_STATUS_PROCESSING)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+			return nil, err
+		}
+		f.inflight = append(f.inflight, message)
+		fileInfo := *message.fileInfo
+		fileInfo.DfReader = bytes.NewReader(content)
+		return &fileInfo, nil
+	}
+	logger.Debug().Msg("ReadNextFile: no more messages")
+	return nil, nil
+}
+
+// Fail records a message that failed in the failed file, and moves the
+// progress past it once the messages before it are done, so that it is not
+// sent again when the import resumes.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - fileInfo: The message returned by ReadNextFile
+//   - fileError: The error of the message
+//
+// Returns:
+//   - error: Non-nil if the message was not returned, or the failed file or
+//     the progress cannot be written
+func (f *MboxFileReader) Fail(
+	ctx context.Context,
+	fileInfo *FileInfo,
+	fileError *FileError,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("MboxFileReader.Fail")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := slices.IndexFunc(f.inflight, func(message *mboxMessage) bool {
+		return message.fileInfo.ID == fileInfo.ID
+	})
+	if index < 0 {
+		logger.Error().Str("id", fileInfo.ID).Msg("Fail: message not returned")
+		return fmt.Errorf("message %s was not returned", fileInfo.ID)
+	}
+	message := f.inflight[index]
+	fileError.ID = fileInfo.ID
+	fileError.Time = time.Now().UTC()
+	err := f.appendFailure(MboxFailure{
+		FileError: *fileError,
+		Path:      f.path,
+		Offset:    message.offset,
+		End:       message.end,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Fail: appendFailure")
+		return err
+	}
+	f.failed[fileInfo.ID] = true
+	return f.saveDoneProgress(ctx)
+}
+
+// appendFailure appends a line of the failure to the failed file
+func (f *MboxFileReader) appendFailure(failure MboxFailure) error {
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	failedFile, err := os.OpenFile(f.failedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = failedFile.Write(append(data, '\n'))
+	if err != nil {
+		_ = failedFile.Close()
+		return err
+	}
+	return failedFile.Close()
+}
+
+// saveDoneProgress moves the progress past the returned messages that are
+// done or failed, in file order, and saves it when it moved
+func (f *MboxFileReader) saveDoneProgress(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	progress := f.progress
+	for len(f.inflight) > 0 {
+		id := f.inflight[0].fileInfo.ID
+		if !f.failed[id] {
+			status, err := f.fileReadTracker.FileRead(ctx, id)
+			if err != nil {
+				logger.Error().Err(err).Msg("saveDoneProgress: FileRead")
+				return err
+			}
+			if status != input.FILE_STATUS_DONE {
+				break
+			}
+		}
+		progress = f.inflight[0].end
+		f.inflight = f.inflight[1:]
+	}
+	if progress == f.progress {
+		return nil
+	}
+	err := f.saveProgress(progress)
+	if err != nil {
+		logger.Error().Err(err).Msg("saveDoneProgress: saveProgress")
+		return err
+	}
+	f.progress = progress
+	return nil
+}
+
+func (f *MboxFileReader) loadProgress() (int64, error) {
+	data, err := os.ReadFile(f.progressPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var progress MboxProgress
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", f.progressPath, err)
+	}
+	return progress.Offset, nil
+}
+
+// saveProgress replaces the progress file at once, so that an interruption
+// never leaves half of it
+func (f *MboxFileReader) saveProgress(offset int64) error {
+	data, err := json.Marshal(MboxProgress{
+		Path:    f.path,
+		Offset:  offset,
+		Updated: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	tmpPath := f.progressPath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, f.progressPath)
+}
+
+// scan returns the messages of the mbox from the offset, which is the start
+// of a message or the end of the file
+func (f *MboxFileReader) scan(ctx context.Context, offset int64) ([]*mboxMessage, error) {
+	mbox, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer mbox.Close()
+	_, err = mbox.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*mboxMessage, 0)
+	reader := bufio.NewReaderSize(mbox, 64*1024)
+	var current *mboxMessage
+	var messageID string
+	// inHeaders is true from the From line to the first empty line
+	inHeaders := false
+	// skip is the body left to skip of a mboxcl2 message
+	var skip int64 = -1
+	finish := func(end int64) {
+		if current == nil {
+			return
+		}
+		current.end = end
+		current.fileInfo.ID = f.messageID(current.offset, messageID)
+		result = append(result, current)
+		current = nil
+	}
+
+	pos := offset
+	for {
+		if skip > 0 {
+			skipped, err := reader.Discard(int(min(skip, int64(reader.Size()))))
+			pos += int64(skipped)
+			skip -= int64(skipped)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineStart := pos
+		pos += int64(len(line))
+		trimmed := bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case bytes.HasPrefix(line, []byte(mboxSeparator)) && !inHeaders:
+			finish(lineStart)
+			current = &mboxMessage{
+				fileInfo: &FileInfo{
+					DfFilePath: f.path,
+					Status:     input.FILE_STATUS_INIT,
+				},
+				offset: lineStart,
+			}
+			messageID = ""
+			inHeaders = true
+			skip = -1
+		case current == nil:
+			if len(trimmed) > 0 {
+				return nil, fmt.Errorf("%s: no From line at offset %d", f.path, lineStart)
+			}
+		case inHeaders && len(trimmed) == 0:
+			inHeaders = false
+			if f.format == MboxFormatMboxcl2 && skip >= 0 {
+				// Skip the body, which may hold unquoted From lines
+				if skip == 0 {
+					skip = -1
+				}
+			}
+		case inHeaders:
+			name, value, found := bytes.Cut(trimmed, []byte(":"))
+			if !found {
+				continue
+			}
+			switch strings.ToLower(string(bytes.TrimSpace(name))) {
+			case "message-id":
+				messageID = string(bytes.TrimSpace(value))
+			case "content-length":
+				if f.format == MboxFormatMboxcl2 {
+					length, err := strconv.ParseInt(string(bytes.TrimSpace(value)), 10, 64)
+					if err != nil || length < 0 {
+						return nil, fmt.Errorf("%s: invalid Content-Length at offset %d", f.path, lineStart)
+					}
+					skip = length
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	finish(pos)
+	return result, nil
+}
+
+// messageID returns the stable id of the message at the offset
+func (f *MboxFileReader) messageID(offset int64, messageID string) string {
+	if f.idType == MboxIDMessageID && messageID != "" {
+		sum := sha256.Sum256([]byte(messageID))
+		return "mbox-" + hex.EncodeToString(sum[:12])
+	}
+	return "mbox-" + f.pathHash + "-" + strconv.FormatInt(offset, 10)
+}
+
+// readMessage returns the message without its From line and the empty line
+// separating it from the next, unquoted for mboxrd
+func (f *MboxFileReader) readMessage(message *mboxMessage) ([]byte, error) {
+	mbox, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer mbox.Close()
+	content := make([]byte, message.end-message.offset)
+	_, err = mbox.ReadAt(content, message.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// The From line
+	_, content, _ = bytes.Cut(content, []byte("\n"))
+	// The empty line before the next From line
+	switch {
+	case bytes.HasSuffix(content, []byte("\r\n\r\n")):
+		content = content[:len(content)-2]
+	case bytes.HasSuffix(content, []byte("\n\n")):
+		content = content[:len(content)-1]
+	}
+	if f.format != MboxFormatMboxrd {
+		return content, nil
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for i, line := range lines {
+		if mboxrdQuoted.Match(line) {
+			lines[i] = line[1:]
+		}
+	}
+	return bytes.Join(lines, nil), nil
+}
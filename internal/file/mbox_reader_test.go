@@ -0,0 +1,301 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// memoryTracker is an IFileReadTracker in memory
+type memoryTracker struct {
+	statuses map[string]input.FileStatus
+}
+
+func (m *memoryTracker) FileRead(_ context.Context, id string) (input.FileStatus, error) {
+	status, ok := m.statuses[id]
+	if !ok {
+		return input.FILE_STATUS_NOT_FOUND, nil
+	}
+	return status, nil
+}
+
+func (m *memoryTracker) UpsertFile(_ context.Context, id string, status input.FileStatus) error {
+	m.statuses[id] = status
+	return nil
+}
+
+func readAllMessages(ctx context.Context, t *testing.T, reader *MboxFileReader) ([]string, []string) {
+	t.Helper()
+	ids := make([]string, 0)
+	contents := make([]string, 0)
+	for {
+		fileInfo, err := reader.ReadNextFile(ctx)
+		require.NoError(t, err)
+		if fileInfo == nil {
+			return ids, contents
+		}
+		require.NotNil(t, fileInfo.DfReader)
+		content, err := io.ReadAll(fileInfo.DfReader)
+		require.NoError(t, err)
+		ids = append(ids, fileInfo.ID)
+		contents = append(contents, string(content))
+	}
+}
+
+func TestMboxFileReader(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		mbox         string
+		wantContents []string
+		wantErr      bool
+	}{
+		{
+			name:   "mboxrd",
+			format: MboxFormatMboxrd,
+			mbox: "From sender@example.com Mon Nov 13 10:00:00 2023\n" +
+				"From: sender@example.com\n" +
+				"Subject: first\n" +
+				"\n" +
+				">From the start\n" +
+				">>From quoted twice\n" +
+				"\n" +
+				"From sender@example.com Mon Nov 13 11:00:00 2023\n" +
+				"From: sender@example.com\n" +
+				"Subject: second\n" +
+				"\n" +
+				"body\n" +
+				"\n",
+			wantContents: []string{
+				"From: sender@example.com\nSubject: first\n\nFrom the start\n>From quoted twice\n",
+				"From: sender@example.com\nSubject: second\n\nbody\n",
+			},
+		},
+		{
+			name:   "mboxcl2",
+			format: MboxFormatMboxcl2,
+			mbox: "From sender@example.com Mon Nov 13 10:00:00 2023\r\n" +
+				"Subject: first\r\n" +
+				"Content-Length: 28\r\n" +
+				"\r\n" +
+				"From the start\r\n" +
+				">From kept\r\n" +
+				"\r\n" +
+				"From sender@example.com Mon Nov 13 11:00:00 2023\r\n" +
+				"Subject: second\r\n" +
+				"\r\n" +
+				"body\r\n",
+			wantContents: []string{
+				"Subject: first\r\nContent-Length: 28\r\n\r\nFrom the start\r\n>From kept\r\n",
+				"Subject: second\r\n\r\nbody\r\n",
+			},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:    "not an mbox",
+			mbox:    "Subject: test\n\nbody\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			path := filepath.Join(t.TempDir(), "archive.mbox")
+			require.NoError(t, os.WriteFile(path, []byte(tt.mbox), 0600))
+			tracker := &memoryTracker{statuses: map[string]input.FileStatus{}}
+
+			reader, err := NewMboxFileReader(ctx, path, tt.format, "", "", tracker)
+			require.NoError(t, err)
+			files, err := reader.RefreshList(ctx)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, files, len(tt.wantContents))
+
+			ids, contents := readAllMessages(ctx, t, reader)
+			if tt.wantContents == nil {
+				tt.wantContents = []string{}
+			}
+			assert.Equal(t, tt.wantContents, contents)
+			for _, id := range ids {
+				assert.True(t, strings.HasPrefix(id, "mbox-"), id)
+			}
+		})
+	}
+}
+
+func TestMboxFileReaderIDs(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	const mbox = "From a Mon Nov 13 10:00:00 2023\n" +
+		"Message-ID: <one@example.com>\n" +
+		"\n" +
+		"body\n" +
+		"\n" +
+		"From a Mon Nov 13 10:00:00 2023\n" +
+		"Subject: no message-id\n" +
+		"\n" +
+		"body\n"
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(mbox), 0600))
+
+	idsOf := func(idType string) []string {
+		reader, err := NewMboxFileReader(ctx, path, "", idType, filepath.Join(t.TempDir(), "progress"),
+			&memoryTracker{statuses: map[string]input.FileStatus{}})
+		require.NoError(t, err)
+		files, err := reader.RefreshList(ctx)
+		require.NoError(t, err)
+		ids := make([]string, 0)
+		for _, fileInfo := range files {
+			ids = append(ids, fileInfo.ID)
+		}
+		return ids
+	}
+
+	offsetIDs := idsOf(MboxIDOffset)
+	require.Len(t, offsetIDs, 2)
+	assert.NotEqual(t, offsetIDs[0], offsetIDs[1])
+	assert.True(t, strings.HasSuffix(offsetIDs[0], "-0"))
+	// The ids are stable
+	assert.Equal(t, offsetIDs, idsOf(MboxIDOffset))
+
+	messageIDs := idsOf(MboxIDMessageID)
+	require.Len(t, messageIDs, 2)
+	assert.NotEqual(t, offsetIDs[0], messageIDs[0])
+	// Without a Message-ID, the offset is used
+	assert.Equal(t, offsetIDs[1], messageIDs[1])
+
+	_, err := NewMboxFileReader(ctx, path, "mboxo", "", "", nil)
+	assert.Error(t, err)
+	_, err = NewMboxFileReader(ctx, path, "", "uuid", "", nil)
+	assert.Error(t, err)
+}
+
+func TestMboxFileReaderResume(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	var mbox strings.Builder
+	for _, subject := range []string{"one", "two", "three"} {
+		mbox.WriteString("From a Mon Nov 13 10:00:00 2023\nSubject: " + subject + "\n\nbody\n\n")
+	}
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(mbox.String()), 0600))
+	tracker := &memoryTracker{statuses: map[string]input.FileStatus{}}
+
+	reader, err := NewMboxFileReader(ctx, path, "", "", "", tracker)
+	require.NoError(t, err)
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	first, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	second, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+
+	// Only the second message is delivered before the interruption
+	tracker.statuses[second.ID] = input.FILE_STATUS_DONE
+	_, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reader.Progress())
+	tracker.statuses[first.ID] = input.FILE_STATUS_DONE
+	_, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	progressAfterTwo := reader.Progress()
+	assert.Positive(t, progressAfterTwo)
+
+	data, err := os.ReadFile(path + MboxProgressExt)
+	require.NoError(t, err)
+	var progress MboxProgress
+	require.NoError(t, json.Unmarshal(data, &progress))
+	assert.Equal(t, progressAfterTwo, progress.Offset)
+
+	// The third message was returned but not delivered, a new reader
+	// resumes with it, while the tracker forgot it
+	resumed, err := NewMboxFileReader(ctx, path, "", "", "", &memoryTracker{statuses: map[string]input.FileStatus{}})
+	require.NoError(t, err)
+	_, err = resumed.RefreshList(ctx)
+	require.NoError(t, err)
+	_, contents := readAllMessages(ctx, t, resumed)
+	assert.Equal(t, []string{"Subject: three\n\nbody\n"}, contents)
+}
+
+func TestMboxFileReaderTrackerError(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	require.NoError(t, os.WriteFile(path, []byte("From a\nSubject: one\n\nbody\n"), 0600))
+	ctrl := gomock.NewController(t)
+	tracker := NewMockIFileReadTracker(ctrl)
+	tracker.EXPECT().FileRead(gomock.Any(), gomock.Any()).Return(input.FILE_STATUS_ERROR, assert.AnError)
+
+	reader, err := NewMboxFileReader(ctx, path, "", "", "", tracker)
+	require.NoError(t, err)
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	_, err = reader.ReadNextFile(ctx)
+	assert.Error(t, err)
+}
+
+func TestMboxFileReaderFail(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	var mbox strings.Builder
+	for _, subject := range []string{"one", "two", "three"} {
+		mbox.WriteString("From a Mon Nov 13 10:00:00 2023\nSubject: " + subject + "\n\nbody\n\n")
+	}
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(mbox.String()), 0600))
+	tracker := &memoryTracker{statuses: map[string]input.FileStatus{}}
+
+	reader, err := NewMboxFileReader(ctx, path, "", "", "", tracker)
+	require.NoError(t, err)
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	first, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	second, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	_, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+
+	// The failed first message counts as done, the progress moves past the
+	// delivered second one
+	tracker.statuses[second.ID] = input.FILE_STATUS_DONE
+	require.NoError(t, reader.Fail(ctx, first, &FileError{Error: "connection refused"}))
+	progressAfterTwo := reader.Progress()
+	assert.Positive(t, progressAfterTwo)
+	assert.Error(t, reader.Fail(ctx, &FileInfo{ID: "missing"}, &FileError{}))
+
+	data, err := os.ReadFile(path + MboxProgressExt + MboxFailedExt)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var failure MboxFailure
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &failure))
+	assert.Equal(t, first.ID, failure.ID)
+	assert.Equal(t, "connection refused", failure.Error)
+	assert.Equal(t, path, failure.Path)
+	assert.Equal(t, int64(0), failure.Offset)
+	assert.Positive(t, failure.End)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content[failure.Offset:failure.End]), "Subject: one")
+
+	// A new reader resumes with the third message, once the tracker forgot
+	// the others
+	resumed, err := NewMboxFileReader(ctx, path, "", "", "", &memoryTracker{statuses: map[string]input.FileStatus{}})
+	require.NoError(t, err)
+	_, err = resumed.RefreshList(ctx)
+	require.NoError(t, err)
+	_, contents := readAllMessages(ctx, t, resumed)
+	assert.Equal(t, []string{"Subject: three\n\nbody\n"}, contents)
+}
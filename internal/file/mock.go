@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/stlimtat/remiges-smtp/internal/file (interfaces: IFileReader,IFileReadTracker)
+// Source: github.com/stlimtat/remiges-smtp/internal/file (interfaces: IFileAcker,IFileFailer,IFileLaneReader,IFileLifecycle,IFileQueue,IFileReader,IFileReadTracker,IFileWatcher,IRecipientTracker)
 //
 // Generated by this command:
 //
-//	mockgen -destination=mock.go -package=file . IFileReader,IFileReadTracker
+//	mockgen -destination=mock.go -package=file . IFileAcker,IFileFailer,IFileLaneReader,IFileLifecycle,IFileQueue,IFileReader,IFileReadTracker,IFileWatcher,IRecipientTracker
 //
 
 // Package file is a generated GoMock package.
@@ -17,6 +17,401 @@ import (
 	gomock "go.uber.org/mock/gomock"
 )
 
+// MockIFileAcker is a mock of IFileAcker interface.
+type MockIFileAcker struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileAckerMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileAckerMockRecorder is the mock recorder for MockIFileAcker.
+type MockIFileAckerMockRecorder struct {
+	mock *MockIFileAcker
+}
+
+// NewMockIFileAcker creates a new mock instance.
+func NewMockIFileAcker(ctrl *gomock.Controller) *MockIFileAcker {
+	mock := &MockIFileAcker{ctrl: ctrl}
+	mock.recorder = &MockIFileAckerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileAcker) EXPECT() *MockIFileAckerMockRecorder {
+	return m.recorder
+}
+
+// Ack mocks base method.
+func (m *MockIFileAcker) Ack(ctx context.Context, fileInfo *FileInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ack", ctx, fileInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ack indicates an expected call of Ack.
+func (mr *MockIFileAckerMockRecorder) Ack(ctx, fileInfo any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ack", reflect.TypeOf((*MockIFileAcker)(nil).Ack), ctx, fileInfo)
+}
+
+// ReadNextFile mocks base method.
+func (m *MockIFileAcker) ReadNextFile(ctx context.Context) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextFile", ctx)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextFile indicates an expected call of ReadNextFile.
+func (mr *MockIFileAckerMockRecorder) ReadNextFile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextFile", reflect.TypeOf((*MockIFileAcker)(nil).ReadNextFile), ctx)
+}
+
+// RefreshList mocks base method.
+func (m *MockIFileAcker) RefreshList(ctx context.Context) ([]*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshList", ctx)
+	ret0, _ := ret[0].([]*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshList indicates an expected call of RefreshList.
+func (mr *MockIFileAckerMockRecorder) RefreshList(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshList", reflect.TypeOf((*MockIFileAcker)(nil).RefreshList), ctx)
+}
+
+// MockIFileFailer is a mock of IFileFailer interface.
+type MockIFileFailer struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileFailerMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileFailerMockRecorder is the mock recorder for MockIFileFailer.
+type MockIFileFailerMockRecorder struct {
+	mock *MockIFileFailer
+}
+
+// NewMockIFileFailer creates a new mock instance.
+func NewMockIFileFailer(ctrl *gomock.Controller) *MockIFileFailer {
+	mock := &MockIFileFailer{ctrl: ctrl}
+	mock.recorder = &MockIFileFailerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileFailer) EXPECT() *MockIFileFailerMockRecorder {
+	return m.recorder
+}
+
+// Fail mocks base method.
+func (m *MockIFileFailer) Fail(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fail", ctx, fileInfo, fileError)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Fail indicates an expected call of Fail.
+func (mr *MockIFileFailerMockRecorder) Fail(ctx, fileInfo, fileError any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fail", reflect.TypeOf((*MockIFileFailer)(nil).Fail), ctx, fileInfo, fileError)
+}
+
+// ReadNextFile mocks base method.
+func (m *MockIFileFailer) ReadNextFile(ctx context.Context) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextFile", ctx)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextFile indicates an expected call of ReadNextFile.
+func (mr *MockIFileFailerMockRecorder) ReadNextFile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextFile", reflect.TypeOf((*MockIFileFailer)(nil).ReadNextFile), ctx)
+}
+
+// RefreshList mocks base method.
+func (m *MockIFileFailer) RefreshList(ctx context.Context) ([]*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshList", ctx)
+	ret0, _ := ret[0].([]*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshList indicates an expected call of RefreshList.
+func (mr *MockIFileFailerMockRecorder) RefreshList(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshList", reflect.TypeOf((*MockIFileFailer)(nil).RefreshList), ctx)
+}
+
+// MockIFileLaneReader is a mock of IFileLaneReader interface.
+type MockIFileLaneReader struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileLaneReaderMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileLaneReaderMockRecorder is the mock recorder for MockIFileLaneReader.
+type MockIFileLaneReaderMockRecorder struct {
+	mock *MockIFileLaneReader
+}
+
+// NewMockIFileLaneReader creates a new mock instance.
+func NewMockIFileLaneReader(ctrl *gomock.Controller) *MockIFileLaneReader {
+	mock := &MockIFileLaneReader{ctrl: ctrl}
+	mock.recorder = &MockIFileLaneReaderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileLaneReader) EXPECT() *MockIFileLaneReaderMockRecorder {
+	return m.recorder
+}
+
+// ReadNextFile mocks base method.
+func (m *MockIFileLaneReader) ReadNextFile(ctx context.Context) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextFile", ctx)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextFile indicates an expected call of ReadNextFile.
+func (mr *MockIFileLaneReaderMockRecorder) ReadNextFile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextFile", reflect.TypeOf((*MockIFileLaneReader)(nil).ReadNextFile), ctx)
+}
+
+// ReadNextLaneFile mocks base method.
+func (m *MockIFileLaneReader) ReadNextLaneFile(ctx context.Context, lane string) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextLaneFile", ctx, lane)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextLaneFile indicates an expected call of ReadNextLaneFile.
+func (mr *MockIFileLaneReaderMockRecorder) ReadNextLaneFile(ctx, lane any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextLaneFile", reflect.TypeOf((*MockIFileLaneReader)(nil).ReadNextLaneFile), ctx, lane)
+}
+
+// RefreshList mocks base method.
+func (m *MockIFileLaneReader) RefreshList(ctx context.Context) ([]*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshList", ctx)
+	ret0, _ := ret[0].([]*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshList indicates an expected call of RefreshList.
+func (mr *MockIFileLaneReaderMockRecorder) RefreshList(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshList", reflect.TypeOf((*MockIFileLaneReader)(nil).RefreshList), ctx)
+}
+
+// MockIFileLifecycle is a mock of IFileLifecycle interface.
+type MockIFileLifecycle struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileLifecycleMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileLifecycleMockRecorder is the mock recorder for MockIFileLifecycle.
+type MockIFileLifecycleMockRecorder struct {
+	mock *MockIFileLifecycle
+}
+
+// NewMockIFileLifecycle creates a new mock instance.
+func NewMockIFileLifecycle(ctrl *gomock.Controller) *MockIFileLifecycle {
+	mock := &MockIFileLifecycle{ctrl: ctrl}
+	mock.recorder = &MockIFileLifecycleMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileLifecycle) EXPECT() *MockIFileLifecycleMockRecorder {
+	return m.recorder
+}
+
+// Archive mocks base method.
+func (m *MockIFileLifecycle) Archive(ctx context.Context, fileInfo *FileInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Archive", ctx, fileInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Archive indicates an expected call of Archive.
+func (mr *MockIFileLifecycleMockRecorder) Archive(ctx, fileInfo any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Archive", reflect.TypeOf((*MockIFileLifecycle)(nil).Archive), ctx, fileInfo)
+}
+
+// Fail mocks base method.
+func (m *MockIFileLifecycle) Fail(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fail", ctx, fileInfo, fileError)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Fail indicates an expected call of Fail.
+func (mr *MockIFileLifecycleMockRecorder) Fail(ctx, fileInfo, fileError any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fail", reflect.TypeOf((*MockIFileLifecycle)(nil).Fail), ctx, fileInfo, fileError)
+}
+
+// Quarantine mocks base method.
+func (m *MockIFileLifecycle) Quarantine(ctx context.Context, fileInfo *FileInfo, fileError *FileError) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Quarantine", ctx, fileInfo, fileError)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Quarantine indicates an expected call of Quarantine.
+func (mr *MockIFileLifecycleMockRecorder) Quarantine(ctx, fileInfo, fileError any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Quarantine", reflect.TypeOf((*MockIFileLifecycle)(nil).Quarantine), ctx, fileInfo, fileError)
+}
+
+// Run mocks base method.
+func (m *MockIFileLifecycle) Run(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Run", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Run indicates an expected call of Run.
+func (mr *MockIFileLifecycleMockRecorder) Run(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockIFileLifecycle)(nil).Run), ctx)
+}
+
+// MockIFileQueue is a mock of IFileQueue interface.
+type MockIFileQueue struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileQueueMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileQueueMockRecorder is the mock recorder for MockIFileQueue.
+type MockIFileQueueMockRecorder struct {
+	mock *MockIFileQueue
+}
+
+// NewMockIFileQueue creates a new mock instance.
+func NewMockIFileQueue(ctrl *gomock.Controller) *MockIFileQueue {
+	mock := &MockIFileQueue{ctrl: ctrl}
+	mock.recorder = &MockIFileQueueMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileQueue) EXPECT() *MockIFileQueueMockRecorder {
+	return m.recorder
+}
+
+// Enqueue mocks base method.
+func (m *MockIFileQueue) Enqueue(ctx context.Context, fileInfo *FileInfo) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", ctx, fileInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockIFileQueueMockRecorder) Enqueue(ctx, fileInfo any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockIFileQueue)(nil).Enqueue), ctx, fileInfo)
+}
+
+// Notify mocks base method.
+func (m *MockIFileQueue) Notify() <-chan struct{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Notify")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// Notify indicates an expected call of Notify.
+func (mr *MockIFileQueueMockRecorder) Notify() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockIFileQueue)(nil).Notify))
+}
+
+// Queued mocks base method.
+func (m *MockIFileQueue) Queued(id string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Queued", id)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Queued indicates an expected call of Queued.
+func (mr *MockIFileQueueMockRecorder) Queued(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Queued", reflect.TypeOf((*MockIFileQueue)(nil).Queued), id)
+}
+
+// ReadNextFile mocks base method.
+func (m *MockIFileQueue) ReadNextFile(ctx context.Context) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextFile", ctx)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextFile indicates an expected call of ReadNextFile.
+func (mr *MockIFileQueueMockRecorder) ReadNextFile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextFile", reflect.TypeOf((*MockIFileQueue)(nil).ReadNextFile), ctx)
+}
+
+// RefreshList mocks base method.
+func (m *MockIFileQueue) RefreshList(ctx context.Context) ([]*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshList", ctx)
+	ret0, _ := ret[0].([]*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshList indicates an expected call of RefreshList.
+func (mr *MockIFileQueueMockRecorder) RefreshList(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshList", reflect.TypeOf((*MockIFileQueue)(nil).RefreshList), ctx)
+}
+
+// Run mocks base method.
+func (m *MockIFileQueue) Run(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Run", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Run indicates an expected call of Run.
+func (mr *MockIFileQueueMockRecorder) Run(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockIFileQueue)(nil).Run), ctx)
+}
+
 // MockIFileReader is a mock of IFileReader interface.
 type MockIFileReader struct {
 	ctrl     *gomock.Controller
@@ -123,3 +518,138 @@ func (mr *MockIFileReadTrackerMockRecorder) UpsertFile(ctx, id, status any) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertFile", reflect.TypeOf((*MockIFileReadTracker)(nil).UpsertFile), ctx, id, status)
 }
+
+// MockIFileWatcher is a mock of IFileWatcher interface.
+type MockIFileWatcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockIFileWatcherMockRecorder
+	isgomock struct{}
+}
+
+// MockIFileWatcherMockRecorder is the mock recorder for MockIFileWatcher.
+type MockIFileWatcherMockRecorder struct {
+	mock *MockIFileWatcher
+}
+
+// NewMockIFileWatcher creates a new mock instance.
+func NewMockIFileWatcher(ctrl *gomock.Controller) *MockIFileWatcher {
+	mock := &MockIFileWatcher{ctrl: ctrl}
+	mock.recorder = &MockIFileWatcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIFileWatcher) EXPECT() *MockIFileWatcherMockRecorder {
+	return m.recorder
+}
+
+// Notify mocks base method.
+func (m *MockIFileWatcher) Notify() <-chan struct{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Notify")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// Notify indicates an expected call of Notify.
+func (mr *MockIFileWatcherMockRecorder) Notify() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockIFileWatcher)(nil).Notify))
+}
+
+// ReadNextFile mocks base method.
+func (m *MockIFileWatcher) ReadNextFile(ctx context.Context) (*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadNextFile", ctx)
+	ret0, _ := ret[0].(*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadNextFile indicates an expected call of ReadNextFile.
+func (mr *MockIFileWatcherMockRecorder) ReadNextFile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadNextFile", reflect.TypeOf((*MockIFileWatcher)(nil).ReadNextFile), ctx)
+}
+
+// RefreshList mocks base method.
+func (m *MockIFileWatcher) RefreshList(ctx context.Context) ([]*FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshList", ctx)
+	ret0, _ := ret[0].([]*FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshList indicates an expected call of RefreshList.
+func (mr *MockIFileWatcherMockRecorder) RefreshList(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshList", reflect.TypeOf((*MockIFileWatcher)(nil).RefreshList), ctx)
+}
+
+// Run mocks base method.
+func (m *MockIFileWatcher) Run(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Run", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Run indicates an expected call of Run.
+func (mr *MockIFileWatcherMockRecorder) Run(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockIFileWatcher)(nil).Run), ctx)
+}
+
+// MockIRecipientTracker is a mock of IRecipientTracker interface.
+type MockIRecipientTracker struct {
+	ctrl     *gomock.Controller
+	recorder *MockIRecipientTrackerMockRecorder
+	isgomock struct{}
+}
+
+// MockIRecipientTrackerMockRecorder is the mock recorder for MockIRecipientTracker.
+type MockIRecipientTrackerMockRecorder struct {
+	mock *MockIRecipientTracker
+}
+
+// NewMockIRecipientTracker creates a new mock instance.
+func NewMockIRecipientTracker(ctrl *gomock.Controller) *MockIRecipientTracker {
+	mock := &MockIRecipientTracker{ctrl: ctrl}
+	mock.recorder = &MockIRecipientTrackerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIRecipientTracker) EXPECT() *MockIRecipientTrackerMockRecorder {
+	return m.recorder
+}
+
+// RecipientsDone mocks base method.
+func (m *MockIRecipientTracker) RecipientsDone(ctx context.Context, id string) (map[string]RecipientDone, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecipientsDone", ctx, id)
+	ret0, _ := ret[0].(map[string]RecipientDone)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecipientsDone indicates an expected call of RecipientsDone.
+func (mr *MockIRecipientTrackerMockRecorder) RecipientsDone(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecipientsDone", reflect.TypeOf((*MockIRecipientTracker)(nil).RecipientsDone), ctx, id)
+}
+
+// UpsertRecipients mocks base method.
+func (m *MockIRecipientTracker) UpsertRecipients(ctx context.Context, id string, recipients map[string]RecipientDone) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertRecipients", ctx, id, recipients)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertRecipients indicates an expected call of UpsertRecipients.
+func (mr *MockIRecipientTrackerMockRecorder) UpsertRecipients(ctx, id, recipients any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertRecipients", reflect.TypeOf((*MockIRecipientTracker)(nil).UpsertRecipients), ctx, id, recipients)
+}
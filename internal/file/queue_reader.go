@@ -0,0 +1,167 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+// QueueFileReader implements the IFileQueue interface, where the files
+// enqueued in memory, e.g. by the messages api, are read before the files of
+// the wrapped reader. The workers are notified of each enqueued file, and of
+// the files of the wrapped reader when it is an IFileWatcher.
+type QueueFileReader struct {
+	// reader reads the files of the input directory
+	reader IFileReader
+
+	// queue holds the enqueued files, in order
+	queue []*FileInfo
+
+	// queued holds the ids of the files in queue
+	queued map[string]bool
+
+	// mu protects concurrent access to queue and queued
+	mu sync.Mutex
+
+	// notify signals the workers that files are ready to be read
+	notify chan struct{}
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewQueueFileReader creates a new instance of QueueFileReader.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - reader: The reader of the input directory
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *QueueFileReader: A new reader instance
+func NewQueueFileReader(
+	ctx context.Context,
+	reader IFileReader,
+	fileReadTracker IFileReadTracker,
+) *QueueFileReader {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("NewQueueFileReader")
+	return &QueueFileReader{
+		reader:          reader,
+		queue:           make([]*FileInfo, 0),
+		queued:          make(map[string]bool),
+		notify:          make(chan struct{}, 1),
+		fileReadTracker: fileReadTracker,
+	}
+}
+
+// RefreshList refreshes the list of the wrapped reader, the queue needs no
+// refresh.
+func (q *QueueFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	return q.reader.RefreshList(ctx)
+}
+
+// ReadNextFile returns the next enqueued file, or the next file of the
+// wrapped reader when the queue is empty.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is left
+//   - error: Non-nil if file tracking operations fail
+func (q *QueueFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("QueueFileReader.ReadNextFile")
+
+	q.mu.Lock()
+	if len(q.queue) == 0 {
+		q.mu.Unlock()
+		return q.reader.ReadNextFile(ctx)
+	}
+	file := q.queue[0]
+	q.queue = q.queue[1:]
+	delete(q.queued, file.ID)
+	if len(q.queue) > 0 {
+		q.signal()
+	}
+	q.mu.Unlock()
+
+	err := q.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+	if err != nil {
+		logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+		return nil, err
+	}
+	return file, nil
+}
+
+func (q *QueueFileReader) Enqueue(
+	ctx context.Context,
+	fileInfo *FileInfo,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("id", fileInfo.ID).
+		Msg("QueueFileReader.Enqueue")
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[fileInfo.ID] {
+		return fmt.Errorf("file %s is already queued", fileInfo.ID)
+	}
+	fileInfo.Status = input.FILE_STATUS_INIT
+	q.queue = append(q.queue, fileInfo)
+	q.queued[fileInfo.ID] = true
+	q.signal()
+	return nil
+}
+
+func (q *QueueFileReader) Queued(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued[id]
+}
+
+func (q *QueueFileReader) Notify() <-chan struct{} {
+	return q.notify
+}
+
+// Run runs the wrapped reader when it is an IFileWatcher, forwarding its
+// notifications, until the context is done.
+//
+// Returns:
+//   - error: The error of the wrapped watcher
+func (q *QueueFileReader) Run(ctx context.Context) error {
+	watcher, ok := q.reader.(IFileWatcher)
+	if !ok {
+		<-ctx.Done()
+		return nil
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-watcher.Notify():
+				q.signal()
+			}
+		}
+	}()
+	return watcher.Run(ctx)
+}
+
+// signal notifies the workers without blocking, a pending notification
+// already wakes one
+func (q *QueueFileReader) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
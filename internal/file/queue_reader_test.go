@@ -0,0 +1,79 @@
+package file
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestQueueFileReader(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	reader := NewMockIFileReader(ctrl)
+	tracker := NewMockIFileReadTracker(ctrl)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "a", input.FILE_STATUS_PROCESSING).Return(nil)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "b", input.FILE_STATUS_PROCESSING).Return(nil)
+	fromDir := &FileInfo{ID: "dir"}
+	reader.EXPECT().ReadNextFile(gomock.Any()).Return(fromDir, nil)
+
+	queue := NewQueueFileReader(ctx, reader, tracker)
+	require.NoError(t, queue.Enqueue(ctx, &FileInfo{ID: "a", DfReader: strings.NewReader("a")}))
+	require.NoError(t, queue.Enqueue(ctx, &FileInfo{ID: "b", DfReader: strings.NewReader("b")}))
+	assert.Error(t, queue.Enqueue(ctx, &FileInfo{ID: "b"}))
+	assert.True(t, queue.Queued("a"))
+
+	select {
+	case <-queue.Notify():
+	default:
+		t.Fatal("no notification for the enqueued files")
+	}
+
+	// The queue is read first, then the wrapped reader
+	got, err := queue.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", got.ID)
+	assert.False(t, queue.Queued("a"))
+	got, err = queue.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", got.ID)
+	got, err = queue.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fromDir, got)
+}
+
+func TestQueueFileReaderRun(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctrl := gomock.NewController(t)
+	watcher := NewMockIFileWatcher(ctrl)
+	watcherNotify := make(chan struct{}, 1)
+	watcher.EXPECT().Notify().Return(watcherNotify).AnyTimes()
+	watcher.EXPECT().Run(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	queue := NewQueueFileReader(ctx, watcher, NewMockIFileReadTracker(ctrl))
+	done := make(chan error)
+	go func() {
+		done <- queue.Run(ctx)
+	}()
+
+	// The notifications of the wrapped watcher are forwarded
+	watcherNotify <- struct{}{}
+	select {
+	case <-queue.Notify():
+	case <-time.After(time.Second):
+		t.Fatal("the notification of the watcher is not forwarded")
+	}
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,455 @@
+package file
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	// ScanOrderFilename reads the files in the order of their names
+	ScanOrderFilename = "filename"
+	// ScanOrderMtime reads the oldest files first
+	ScanOrderMtime = "mtime"
+	// ScanOrderPriority reads the files with the lowest qf priority first,
+	// as sendmail does, then the oldest
+	ScanOrderPriority = "priority"
+
+	// DefaultFullScanInterval is how often every directory is listed again,
+	// and the files left unread are queued again
+	DefaultFullScanInterval = time.Hour
+
+	// ScanRacyWindow is how recent the mtime of a directory may be, for the
+	// directory to be listed again at the next scan. A file added in the same
+	// tick of the mtime as the last listing does not change the mtime.
+	ScanRacyWindow = 2 * time.Second
+)
+
+// scanDir is the last listing of a directory
+type scanDir struct {
+	mtime   time.Time
+	ids     []string
+	subdirs []string
+}
+
+// scanEntry is a df/qf pair found by a scan, with its sort keys
+type scanEntry struct {
+	fileInfo *FileInfo
+	name     string
+	mtime    time.Time
+	priority int64
+}
+
+// ScanFileReader implements the IFileReader interface for large sendmail
+// queue directories, where the pairs may be spread over hashed
+// subdirectories, e.g. in-path/ab/cd/dfXYZ with a shard depth of 2. The pairs
+// of the upper levels are read too.
+//
+// RefreshList only lists the directories whose mtime changed since the last
+// scan, and queues the new pairs in order. The pairs read are dropped from the
+// queue; every full scan interval, every directory is listed again, and the
+// pairs left in it are queued again.
+type ScanFileReader struct {
+	// inputDir is the directory containing files to be processed
+	inputDir string
+
+	// shardDepth is the number of levels of subdirectories
+	shardDepth int
+
+	// order is the ScanOrder of the queue
+	order string
+
+	// fullScanInterval is the interval between full scans
+	fullScanInterval time.Duration
+
+	// lastFullScan is the time of the last full scan
+	lastFullScan time.Time
+
+	// mu protects dirs, entries and queue
+	mu sync.Mutex
+
+	// dirs holds the last listing of each directory, by path
+	dirs map[string]*scanDir
+
+	// entries holds the pairs found, by id
+	entries map[string]*scanEntry
+
+	// queue holds the pairs to read, in order
+	queue []*scanEntry
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// NewScanFileReader creates a new instance of ScanFileReader.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - inputDir: The directory containing files to be processed
+//   - fileReadTracker: The tracker for file processing states
+//   - shardDepth: The number of levels of hashed subdirectories, 0 when flat
+//   - order: ScanOrderFilename, ScanOrderMtime or ScanOrderPriority, filename if empty
+//   - fullScanInterval: The interval between full scans, DefaultFullScanInterval if zero
+//
+// Returns:
+//   - *ScanFileReader: A new reader instance
+//   - error: Non-nil if the input directory is invalid, or the order unknown
+func NewScanFileReader(
+	ctx context.Context,
+	inputDir string,
+	fileReadTracker IFileReadTracker,
+	shardDepth int,
+	order string,
+	fullScanInterval time.Duration,
+) (*ScanFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("inputDir", inputDir).
+		Int("shardDepth", shardDepth).
+		Str("order", order).
+		Msg("NewScanFileReader")
+
+	err := validateDir(inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("NewScanFileReader: validateDir")
+		return nil, err
+	}
+	if order == "" {
+		order = ScanOrderFilename
+	}
+	if order != ScanOrderFilename && order != ScanOrderMtime && order != ScanOrderPriority {
+		return nil, fmt.Errorf("unknown scan order %s", order)
+	}
+	if shardDepth < 0 {
+		return nil, errors.New("the shard depth cannot be negative")
+	}
+	if fullScanInterval <= 0 {
+		fullScanInterval = DefaultFullScanInterval
+	}
+
+	return &ScanFileReader{
+		inputDir:         inputDir,
+		shardDepth:       shardDepth,
+		order:            order,
+		fullScanInterval: fullScanInterval,
+		dirs:             make(map[string]*scanDir),
+		entries:          make(map[string]*scanEntry),
+		queue:            make([]*scanEntry, 0),
+		fileReadTracker:  fileReadTracker,
+		now:              time.Now,
+	}, nil
+}
+
+// RefreshList lists the directories that changed since the last scan, or
+// every directory at a full scan, and queues the new pairs in order. The
+// pairs which are gone are forgotten.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The files queued for reading
+//   - error: Non-nil if directory scanning fails
+func (f *ScanFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("ScanFileReader.RefreshList")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	full := now.Sub(f.lastFullScan) >= f.fullScanInterval
+	dirs := make(map[string]*scanDir, len(f.dirs))
+	found := make(map[string]bool, len(f.entries))
+	added := make([]*scanEntry, 0)
+	err := f.scanDir(ctx, f.inputDir, 0, full, now, dirs, found, &added)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: scanDir")
+		return nil, err
+	}
+	f.dirs = dirs
+	for id := range f.entries {
+		if !found[id] {
+			delete(f.entries, id)
+		}
+	}
+	for _, entry := range added {
+		f.entries[entry.fileInfo.ID] = entry
+	}
+
+	if full {
+		// Every pair is queued again, the tracker skips those which are done
+		f.lastFullScan = now
+		f.queue = slices.Collect(maps.Values(f.entries))
+		slices.SortFunc(f.queue, f.compare)
+	} else {
+		// The new pairs are merged into the queue, without the pairs gone
+		slices.SortFunc(added, f.compare)
+		queue := make([]*scanEntry, 0, len(f.queue)+len(added))
+		for _, entry := range f.queue {
+			if f.entries[entry.fileInfo.ID] == entry {
+				queue = append(queue, entry)
+			}
+		}
+		f.queue = mergeSorted(queue, added, f.compare)
+	}
+	logger.Debug().
+		Bool("full", full).
+		Int("added", len(added)).
+		Int("queued", len(f.queue)).
+		Msg("RefreshList: scanned")
+
+	result := make([]*FileInfo, 0, len(f.queue))
+	for _, entry := range f.queue {
+		result = append(result, entry.fileInfo)
+	}
+	return result, nil
+}
+
+// scanDir lists a directory when it changed, or reuses its last listing,
+// and scans its subdirectories up to the shard depth. The ids of the
+// directory are marked found, and its new pairs added.
+func (f *ScanFileReader) scanDir(
+	ctx context.Context,
+	dir string,
+	level int,
+	full bool,
+	now time.Time,
+	dirs map[string]*scanDir,
+	found map[string]bool,
+	added *[]*scanEntry,
+) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		// A subdirectory may be removed during the scan
+		if level > 0 && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	last := f.dirs[dir]
+	current := last
+	if full || last == nil || !info.ModTime().Equal(last.mtime) || now.Sub(info.ModTime()) < ScanRacyWindow {
+		current, err = f.listDir(ctx, dir, level, info.ModTime(), added)
+		if err != nil {
+			return err
+		}
+	}
+	dirs[dir] = current
+	for _, id := range current.ids {
+		found[id] = true
+	}
+	for _, subdir := range current.subdirs {
+		err = f.scanDir(ctx, subdir, level+1, full, now, dirs, found, added)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDir reads a directory, for its df files and, above the shard depth,
+// its subdirectories. The hidden entries, e.g. temporary files, are skipped.
+func (f *ScanFileReader) listDir(
+	ctx context.Context,
+	dir string,
+	level int,
+	mtime time.Time,
+	added *[]*scanEntry,
+) (*scanDir, error) {
+	logger := zerolog.Ctx(ctx)
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := &scanDir{mtime: mtime}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if dirEntry.IsDir() {
+			// The directories of the lifecycle are not shards
+			if level < f.shardDepth && !(level == 0 && isLifecycleDir(name)) {
+				result.subdirs = append(result.subdirs, filepath.Join(dir, name))
+			}
+			continue
+		}
+		if len(name) <= 2 || !strings.HasPrefix(name, "df") {
+			continue
+		}
+		id := name[2:]
+		result.ids = append(result.ids, id)
+		if _, ok := f.entries[id]; ok {
+			continue
+		}
+		entry, err := f.newEntry(dir, name, dirEntry)
+		if err != nil {
+			// The pair may be read and moved during the scan
+			logger.Debug().Err(err).Str("name", name).Msg("listDir: skipping file")
+			continue
+		}
+		*added = append(*added, entry)
+	}
+	return result, nil
+}
+
+// newEntry returns the pair of a df file, with the sort keys of the order
+func (f *ScanFileReader) newEntry(dir string, name string, dirEntry os.DirEntry) (*scanEntry, error) {
+	result := &scanEntry{
+		fileInfo: &FileInfo{
+			DfFilePath: filepath.Join(dir, name),
+			ID:         name[2:],
+			QfFilePath: filepath.Join(dir, "qf"+name[2:]),
+			Status:     input.FILE_STATUS_INIT,
+		},
+		name: name,
+	}
+	if f.order == ScanOrderFilename {
+		return result, nil
+	}
+	info, err := dirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	result.mtime = info.ModTime()
+	if f.order == ScanOrderPriority {
+		// Without a P record, the priority is 0
+		result.priority, _ = readQfPriority(result.fileInfo.QfFilePath)
+	}
+	return result, nil
+}
+
+// compare orders the entries by the order of the reader, then by name
+func (f *ScanFileReader) compare(a *scanEntry, b *scanEntry) int {
+	switch f.order {
+	case ScanOrderPriority:
+		return cmp.Or(
+			cmp.Compare(a.priority, b.priority),
+			a.mtime.Compare(b.mtime),
+			strings.Compare(a.name, b.name),
+		)
+	case ScanOrderMtime:
+		return cmp.Or(
+			a.mtime.Compare(b.mtime),
+			strings.Compare(a.name, b.name),
+		)
+	default:
+		return strings.Compare(a.name, b.name)
+	}
+}
+
+// ReadNextFile retrieves the next queued file that is not processed yet.
+// Files that are done or being processed are dropped from the queue, until
+// the next full scan.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is queued
+//   - error: Non-nil if file tracking operations fail
+func (f *ScanFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("ScanFileReader.ReadNextFile")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.queue) > 0 {
+		entry := f.queue[0]
+		f.queue[0] = nil
+		f.queue = f.queue[1:]
+		file := entry.fileInfo
+
+		status, err := f.fileReadTracker.FileRead(ctx, file.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: FileRead")
+			return nil, err
+		}
+		if status == input.FILE_STATUS_PROCESSING || status == input.FILE_STATUS_DONE {
+			logger.Debug().
+				Str("fileName", file.DfFilePath).
+				Int("status", int(status)).
+				Msg("ReadNextFile: skipping file")
+			continue
+		}
+
+		err = f.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+			return nil, err
+		}
+		return file, nil
+	}
+
+	logger.Debug().Msg("ReadNextFile: no more files")
+	return nil, nil
+}
+
+// readQfPriority returns the P record of a qf file, and whether it has one
+func readQfPriority(qfFilePath string) (int64, bool) {
+	qfFile, err := os.Open(qfFilePath)
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = qfFile.Close() }()
+	scanner := bufio.NewScanner(qfFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// The headers follow the envelope records
+		if strings.HasPrefix(line, "H") || line == "." {
+			break
+		}
+		if strings.HasPrefix(line, "P") {
+			priority, err := strconv.ParseInt(line[1:], 10, 64)
+			if err == nil {
+				return priority, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// isLifecycleDir tells whether a directory of the input directory is one of
+// the lifecycle
+func isLifecycleDir(name string) bool {
+	return name == LifecycleArchiveDir || name == LifecycleFailedDir || name == LifecycleQuarantineDir
+}
+
+// mergeSorted merges two sorted slices
+func mergeSorted[T any](a []T, b []T, compare func(T, T) int) []T {
+	result := make([]T, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if compare(b[0], a[0]) < 0 {
+			result = append(result, b[0])
+			b = b[1:]
+		} else {
+			result = append(result, a[0])
+			a = a[1:]
+		}
+	}
+	result = append(result, a...)
+	return append(result, b...)
+}
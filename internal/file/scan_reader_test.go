@@ -0,0 +1,163 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// writeScanPair writes a df/qf pair, with the qf records and the mtime
+func writeScanPair(t *testing.T, dir string, id string, qf string, mtime time.Time) {
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	for _, name := range []string{"df" + id, "qf" + id} {
+		path := filepath.Join(dir, name)
+		content := "body"
+		if name[:2] == "qf" {
+			content = qf
+		}
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+}
+
+func scanIDs(files []*FileInfo) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		result = append(result, file.ID)
+	}
+	return result
+}
+
+func TestScanFileReaderOrder(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name  string
+		order string
+		want  []string
+	}{
+		{
+			name:  "filename",
+			order: ScanOrderFilename,
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:  "default is filename",
+			order: "",
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:  "mtime",
+			order: ScanOrderMtime,
+			want:  []string{"d", "c", "b", "a"},
+		},
+		{
+			name:  "priority",
+			order: ScanOrderPriority,
+			want:  []string{"c", "d", "a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			inPath := t.TempDir()
+			writeScanPair(t, inPath, "a", "V8\nP100\nH??Subject: P5\n.\n", base.Add(3*time.Second))
+			writeScanPair(t, filepath.Join(inPath, "01"), "b", "V8\nP900\n.\n", base.Add(2*time.Second))
+			writeScanPair(t, filepath.Join(inPath, "02"), "c", "V8\nP-5\n.\n", base.Add(time.Second))
+			// Without a priority, d has the priority 0
+			writeScanPair(t, filepath.Join(inPath, "02"), "d", "V8\n.\n", base)
+
+			reader, err := NewScanFileReader(ctx, inPath, NewMockIFileReadTracker(gomock.NewController(t)), 1, tt.order, 0)
+			require.NoError(t, err)
+			files, err := reader.RefreshList(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, scanIDs(files))
+		})
+	}
+
+	ctx, _ := telemetry.InitLogger(context.Background())
+	_, err := NewScanFileReader(ctx, t.TempDir(), nil, 0, "size", 0)
+	assert.Error(t, err)
+	_, err = NewScanFileReader(ctx, filepath.Join(t.TempDir(), "missing"), nil, 0, "", 0)
+	assert.Error(t, err)
+}
+
+func TestScanFileReaderShards(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	inPath := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	writeScanPair(t, inPath, "0", "V8\n", old)
+	writeScanPair(t, filepath.Join(inPath, "ab", "cd"), "1", "V8\n", old)
+	writeScanPair(t, filepath.Join(inPath, "ab", "ce"), "2", "V8\n", old)
+	// Beyond the shard depth, in the lifecycle, or hidden
+	writeScanPair(t, filepath.Join(inPath, "ab", "cd", "ef"), "3", "V8\n", old)
+	writeScanPair(t, filepath.Join(inPath, LifecycleArchiveDir), "4", "V8\n", old)
+	writeScanPair(t, filepath.Join(inPath, ".tmp"), "5", "V8\n", old)
+	require.NoError(t, os.WriteFile(filepath.Join(inPath, "ab", "cd", ".df6-1.tmp"), nil, 0o600))
+
+	reader, err := NewScanFileReader(ctx, inPath, nil, 2, ScanOrderFilename, time.Hour)
+	require.NoError(t, err)
+	files, err := reader.RefreshList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2"}, scanIDs(files))
+	assert.Equal(t, filepath.Join(inPath, "ab", "cd", "df1"), files[1].DfFilePath)
+	assert.Equal(t, filepath.Join(inPath, "ab", "cd", "qf1"), files[1].QfFilePath)
+}
+
+func TestScanFileReaderIncremental(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	tracker := NewMockIFileReadTracker(ctrl)
+	inPath := t.TempDir()
+	shard := filepath.Join(inPath, "ab")
+	old := time.Now().Add(-time.Hour)
+	writeScanPair(t, shard, "1", "V8\n", old)
+	writeScanPair(t, shard, "2", "V8\n", old)
+	require.NoError(t, os.Chtimes(shard, old, old))
+
+	reader, err := NewScanFileReader(ctx, inPath, tracker, 1, ScanOrderFilename, time.Hour)
+	require.NoError(t, err)
+	now := time.Now()
+	reader.now = func() time.Time { return now }
+	files, err := reader.RefreshList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, scanIDs(files))
+
+	// The read file and the done file are dropped from the queue
+	tracker.EXPECT().FileRead(gomock.Any(), "1").Return(input.FILE_STATUS_DONE, nil)
+	tracker.EXPECT().FileRead(gomock.Any(), "2").Return(input.FILE_STATUS_NOT_FOUND, nil)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "2", input.FILE_STATUS_PROCESSING).Return(nil)
+	got, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "2", got.ID)
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// A directory which did not change is not listed again
+	writeScanPair(t, shard, "3", "V8\n", old)
+	require.NoError(t, os.Chtimes(shard, old, old))
+	files, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// The new pairs of a changed directory are queued, without the known ones
+	writeScanPair(t, shard, "0", "V8\n", old)
+	require.NoError(t, os.Remove(filepath.Join(shard, "df2")))
+	files, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "3"}, scanIDs(files))
+
+	// A full scan queues every pair left
+	now = now.Add(time.Hour)
+	files, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "3"}, scanIDs(files))
+}
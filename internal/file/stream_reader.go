@@ -0,0 +1,435 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	// StreamFieldJob is the field of a stream entry holding the mail job
+	StreamFieldJob = "job"
+	// StreamFieldFormat is the field of a stream entry holding the format of
+	// the job, json by default, yaml, or eml for a whole RFC 5322 message
+	StreamFieldFormat = "format"
+	// StreamFieldID is the field of a dead-letter entry holding the id of
+	// the entry in the stream
+	StreamFieldID = "id"
+	// StreamFieldError is the field of a dead-letter entry holding why it was
+	// given up on
+	StreamFieldError = "error"
+	// StreamNotifyBlock is how long Run waits for new entries at a time, and
+	// so how long it takes to stop
+	StreamNotifyBlock = time.Second
+)
+
+// StreamFileReader implements the IFileAcker, IFileFailer and IFileWatcher
+// interfaces for the mail jobs of a redis stream, read with a consumer group.
+// An entry stays pending until it is acknowledged, once its output is
+// written. The entries left pending longer than the claim timeout, by a
+// consumer that stopped or a mail that failed temporarily, are claimed with
+// XAUTOCLAIM and read again. An entry read more than the maximum deliveries
+// is abandoned, and moved to the dead-letter stream once its output is
+// written, as is an entry that failed.
+//
+// The id of a file is the id of its entry, and its DfFilePath is the id with
+// the extension of its format in the input directory, so that the job
+// transformer reads it as a job file.
+type StreamFileReader struct {
+	// redisClient reads the stream
+	redisClient *redis.Client
+
+	// inputDir is the directory of the relative attachment paths
+	inputDir string
+
+	// stream, group and consumer identify the entries of this reader
+	stream   string
+	group    string
+	consumer string
+
+	// claimTimeout is how long an entry stays pending before it is claimed
+	claimTimeout time.Duration
+
+	// maxDeliveries is how many times an entry is read before it is abandoned
+	maxDeliveries int64
+
+	// deadLetterStream holds the entries abandoned or failed
+	deadLetterStream string
+
+	// claimStart is the cursor of XAUTOCLAIM, empty once the pending entries
+	// are all scanned, until RefreshList restarts the scan
+	claimStart string
+
+	// mu protects concurrent access to claimStart
+	mu sync.Mutex
+
+	// notify signals the workers that entries are ready to be read
+	notify chan struct{}
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+}
+
+// NewStreamFileReader creates a new instance of StreamFileReader, and the
+// consumer group of the stream, which starts with the first entry of the
+// stream when it does not exist yet.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - redisClient: The Redis client of the stream
+//   - inputDir: The directory of the relative attachment paths
+//   - stream: The key of the stream
+//   - group: The consumer group
+//   - consumer: The name of this consumer in the group
+//   - claimTimeout: How long an entry stays pending before it is claimed
+//   - maxDeliveries: How many times an entry is read before it is abandoned
+//   - deadLetterStream: The stream of the entries abandoned or failed
+//   - fileReadTracker: The tracker for file processing states
+//
+// Returns:
+//   - *StreamFileReader: A new reader instance
+//   - error: Non-nil if the consumer group cannot be created
+func NewStreamFileReader(
+	ctx context.Context,
+	redisClient *redis.Client,
+	inputDir string,
+	stream string,
+	group string,
+	consumer string,
+	claimTimeout time.Duration,
+	maxDeliveries int64,
+	deadLetterStream string,
+	fileReadTracker IFileReadTracker,
+) (*StreamFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("stream", stream).
+		Str("group", group).
+		Str("consumer", consumer).
+		Int64("maxDeliveries", maxDeliveries).
+		Str("deadLetterStream", deadLetterStream).
+		Msg("NewStreamFileReader")
+
+	err := redisClient.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		logger.Error().Err(err).Msg("NewStreamFileReader: XGroupCreateMkStream")
+		return nil, err
+	}
+
+	return &StreamFileReader{
+		redisClient:      redisClient,
+		inputDir:         inputDir,
+		stream:           stream,
+		group:            group,
+		consumer:         consumer,
+		claimTimeout:     claimTimeout,
+		maxDeliveries:    maxDeliveries,
+		deadLetterStream: deadLetterStream,
+		claimStart:       "0-0",
+		notify:           make(chan struct{}, 1),
+		fileReadTracker:  fileReadTracker,
+	}, nil
+}
+
+// RefreshList restarts the scan of the pending entries, so that the next
+// calls to ReadNextFile claim the stale ones. The entries are not listed.
+func (f *StreamFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("StreamFileReader.RefreshList")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.claimStart = "0-0"
+	return nil, nil
+}
+
+// ReadNextFile claims the next stale pending entry, or reads the next new
+// entry of the group. A claimed entry which is done already, because its
+// consumer stopped before acknowledging it, is acknowledged and skipped. A
+// claimed entry read more than the maximum deliveries is returned abandoned.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next entry to process, nil if none is left
+//   - error: Non-nil if the redis or file tracking operations fail
+func (f *StreamFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("StreamFileReader.ReadNextFile")
+
+	for {
+		message, deliveries, err := f.claimNext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if message == nil {
+			break
+		}
+		status, err := f.fileReadTracker.FileRead(ctx, message.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: FileRead")
+			return nil, err
+		}
+		fileInfo := f.newFileInfo(message)
+		if status == input.FILE_STATUS_DONE {
+			logger.Debug().Str("id", message.ID).Msg("ReadNextFile: acknowledging done entry")
+			err = f.Ack(ctx, fileInfo)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if deliveries > f.maxDeliveries {
+			logger.Warn().
+				Str("id", message.ID).
+				Int64("deliveries", deliveries).
+				Msg("ReadNextFile: abandoning entry")
+			fileInfo.Abandoned = fmt.Sprintf("not done after %d deliveries", deliveries-1)
+		}
+		// The consumer of the entry stopped while processing it
+		if status != input.FILE_STATUS_PROCESSING {
+			err = f.fileReadTracker.UpsertFile(ctx, fileInfo.ID, input.FILE_STATUS_PROCESSING)
+			if err != nil {
+				logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+				return nil, err
+			}
+		}
+		return fileInfo, nil
+	}
+
+	streams, err := f.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    f.group,
+		Consumer: f.consumer,
+		Streams:  []string{f.stream, ">"},
+		Count:    1,
+		Block:    -1,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("ReadNextFile: XReadGroup")
+		return nil, err
+	}
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		return nil, nil
+	}
+	fileInfo := f.newFileInfo(&streams[0].Messages[0])
+	err = f.fileReadTracker.UpsertFile(ctx, fileInfo.ID, input.FILE_STATUS_PROCESSING)
+	if err != nil {
+		logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+// claimNext claims the next entry pending longer than the claim timeout,
+// with how many times it was read, this time included, nil once the pending
+// entries are all scanned
+func (f *StreamFileReader) claimNext(ctx context.Context) (*redis.XMessage, int64, error) {
+	logger := zerolog.Ctx(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.claimStart != "" {
+		messages, start, err := f.redisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   f.stream,
+			Group:    f.group,
+			MinIdle:  f.claimTimeout,
+			Start:    f.claimStart,
+			Count:    1,
+			Consumer: f.consumer,
+		}).Result()
+		if err != nil {
+			logger.Error().Err(err).Msg("claimNext: XAutoClaim")
+			return nil, 0, err
+		}
+		// The scan is over when the cursor wraps around
+		if start == "0-0" {
+			start = ""
+		}
+		f.claimStart = start
+		if len(messages) > 0 {
+			pending, err := f.redisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: f.stream,
+				Group:  f.group,
+				Start:  messages[0].ID,
+				End:    messages[0].ID,
+				Count:  1,
+			}).Result()
+			if err != nil {
+				logger.Error().Err(err).Msg("claimNext: XPendingExt")
+				return nil, 0, err
+			}
+			var deliveries int64
+			if len(pending) > 0 {
+				deliveries = pending[0].RetryCount
+			}
+			logger.Info().
+				Str("id", messages[0].ID).
+				Int64("deliveries", deliveries).
+				Msg("claimNext: claimed stale entry")
+			return &messages[0], deliveries, nil
+		}
+	}
+	return nil, 0, nil
+}
+
+// newFileInfo returns the file of an entry, with its job in DfReader
+func (f *StreamFileReader) newFileInfo(message *redis.XMessage) *FileInfo {
+	job, _ := message.Values[StreamFieldJob].(string)
+	format, _ := message.Values[StreamFieldFormat].(string)
+	ext := ".json"
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		ext = ".yaml"
+	case "eml":
+		ext = EMLFileExt
+	}
+	return &FileInfo{
+		DfFilePath: filepath.Join(f.inputDir, message.ID+ext),
+		DfReader:   bytes.NewReader([]byte(job)),
+		ID:         message.ID,
+		Status:     input.FILE_STATUS_INIT,
+	}
+}
+
+// Ack acknowledges the entry of the file, and deletes it from the stream. An
+// abandoned entry is moved to the dead-letter stream.
+func (f *StreamFileReader) Ack(
+	ctx context.Context,
+	fileInfo *FileInfo,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("StreamFileReader.Ack")
+
+	if fileInfo.Abandoned != "" {
+		return f.deadLetter(ctx, fileInfo.ID, fileInfo.Abandoned)
+	}
+	_, err := f.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, f.stream, f.group, fileInfo.ID)
+		pipe.XDel(ctx, f.stream, fileInfo.ID)
+		return nil
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Ack: TxPipelined")
+		return err
+	}
+	return nil
+}
+
+// Fail moves the entry of a file that can never be sent to the dead-letter
+// stream, with its error.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - fileInfo: The file returned by ReadNextFile
+//   - fileError: The error of the file
+//
+// Returns:
+//   - error: Non-nil if the entry cannot be moved
+func (f *StreamFileReader) Fail(
+	ctx context.Context,
+	fileInfo *FileInfo,
+	fileError *FileError,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", fileInfo.ID).Msg("StreamFileReader.Fail")
+	return f.deadLetter(ctx, fileInfo.ID, fileError.Error)
+}
+
+// deadLetter adds the entry to the dead-letter stream, with its id and
+// error, then acknowledges and deletes it. An entry that is gone already,
+// moved by another consumer, is only acknowledged.
+func (f *StreamFileReader) deadLetter(ctx context.Context, id string, reason string) error {
+	logger := zerolog.Ctx(ctx)
+
+	messages, err := f.redisClient.XRange(ctx, f.stream, id, id).Result()
+	if err != nil {
+		logger.Error().Err(err).Msg("deadLetter: XRange")
+		return err
+	}
+	_, err = f.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(messages) > 0 {
+			values := make(map[string]any, len(messages[0].Values)+2)
+			maps.Copy(values, messages[0].Values)
+			values[StreamFieldID] = id
+			values[StreamFieldError] = reason
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: f.deadLetterStream,
+				Values: values,
+			})
+		}
+		pipe.XAck(ctx, f.stream, f.group, id)
+		pipe.XDel(ctx, f.stream, id)
+		return nil
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("deadLetter: TxPipelined")
+		return err
+	}
+	logger.Warn().
+		Str("id", id).
+		Str("error", reason).
+		Str("deadLetterStream", f.deadLetterStream).
+		Msg("deadLetter: entry moved")
+	return nil
+}
+
+func (f *StreamFileReader) Notify() <-chan struct{} {
+	return f.notify
+}
+
+// Run waits for the new entries of the stream, and notifies the workers of
+// them, until the context is done. The entries are only read by
+// ReadNextFile, with the consumer group.
+//
+// Returns:
+//   - error: Non-nil if the stream cannot be read
+func (f *StreamFileReader) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("StreamFileReader.Run")
+
+	// The entries added before Run are read by the first ticks
+	lastID := "$"
+	for ctx.Err() == nil {
+		streams, err := f.redisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{f.stream, lastID},
+			Block:   StreamNotifyBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Error().Err(err).Msg("Run: XRead")
+			return err
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			continue
+		}
+		lastID = streams[0].Messages[len(streams[0].Messages)-1].ID
+		select {
+		case f.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
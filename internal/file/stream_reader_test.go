@@ -0,0 +1,202 @@
+package file
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStreamReader(
+	t *testing.T,
+	ctx context.Context,
+	redisClient *redis.Client,
+	consumer string,
+) *StreamFileReader {
+	reader, err := NewStreamFileReader(
+		ctx, redisClient, "/in", "jobs", "senders", consumer, time.Minute,
+		2, "jobs:dead", NewFileReadTracker(ctx, redisClient),
+	)
+	require.NoError(t, err)
+	return reader
+}
+
+func TestStreamFileReader(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	tracker := NewFileReadTracker(ctx, redisClient)
+
+	reader := newTestStreamReader(t, ctx, redisClient, "a")
+	// The group exists already
+	other := newTestStreamReader(t, ctx, redisClient, "b")
+
+	jsonID, err := redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: "jobs",
+		Values: map[string]any{StreamFieldJob: `{"from": "a@example.com"}`},
+	}).Result()
+	require.NoError(t, err)
+	yamlID, err := redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: "jobs",
+		Values: map[string]any{StreamFieldJob: "from: a@example.com", StreamFieldFormat: "yaml"},
+	}).Result()
+	require.NoError(t, err)
+
+	got, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, jsonID, got.ID)
+	assert.Equal(t, "/in/"+jsonID+".json", got.DfFilePath)
+	content, err := io.ReadAll(got.DfReader)
+	require.NoError(t, err)
+	assert.Equal(t, `{"from": "a@example.com"}`, string(content))
+	status, err := tracker.FileRead(ctx, jsonID)
+	require.NoError(t, err)
+	assert.Equal(t, input.FILE_STATUS_PROCESSING, status)
+
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, yamlID, got.ID)
+	assert.Equal(t, "/in/"+yamlID+".yaml", got.DfFilePath)
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// The acknowledged entry is removed from the stream
+	require.NoError(t, reader.Ack(ctx, &FileInfo{ID: jsonID}))
+	assert.Equal(t, int64(1), redisClient.XLen(ctx, "jobs").Val())
+	assert.Equal(t, int64(1), redisClient.XPending(ctx, "jobs", "senders").Val().Count)
+
+	// The pending entry is only claimed after the claim timeout
+	got, err = other.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	mr.SetTime(now.Add(2 * time.Minute))
+	_, err = other.RefreshList(ctx)
+	require.NoError(t, err)
+	got, err = other.ReadNextFile(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, yamlID, got.ID)
+
+	// A claimed entry which is done is acknowledged without being read
+	require.NoError(t, tracker.UpsertFile(ctx, yamlID, input.FILE_STATUS_DONE))
+	mr.SetTime(now.Add(4 * time.Minute))
+	_, err = reader.RefreshList(ctx)
+	require.NoError(t, err)
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	assert.Equal(t, int64(0), redisClient.XLen(ctx, "jobs").Val())
+	assert.Equal(t, int64(0), redisClient.XPending(ctx, "jobs", "senders").Val().Count)
+}
+
+func TestStreamFileReaderRun(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	reader := newTestStreamReader(t, ctx, redisClient, "a")
+
+	done := make(chan error)
+	go func() {
+		done <- reader.Run(ctx)
+	}()
+
+	// Run notifies of the new entries, until one is seen
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+notified:
+	for {
+		select {
+		case <-reader.Notify():
+			break notified
+		case <-ticker.C:
+			require.NoError(t, redisClient.XAdd(ctx, &redis.XAddArgs{
+				Stream: "jobs",
+				Values: map[string]any{StreamFieldJob: "{}"},
+			}).Err())
+		case <-timeout:
+			t.Fatal("no notification for the new entries")
+		}
+	}
+	cancel()
+	assert.NoError(t, <-done)
+
+	// The entries are left to ReadNextFile
+	got, err := reader.ReadNextFile(context.Background())
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func TestStreamFileReaderDeadLetter(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	reader := newTestStreamReader(t, ctx, redisClient, "a")
+
+	abandonedID, err := redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: "jobs",
+		Values: map[string]any{StreamFieldJob: `{"from": "a@example.com"}`},
+	}).Result()
+	require.NoError(t, err)
+	failedID, err := redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: "jobs",
+		Values: map[string]any{StreamFieldJob: "from: a@example.com", StreamFieldFormat: "yaml"},
+	}).Result()
+	require.NoError(t, err)
+
+	// Read, then claimed once more within the maximum deliveries
+	got, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, abandonedID, got.ID)
+	for i, wantAbandoned := range []string{"", "not done after 2 deliveries"} {
+		mr.SetTime(now.Add(time.Duration(i+1) * 2 * time.Minute))
+		_, err = reader.RefreshList(ctx)
+		require.NoError(t, err)
+		got, err = reader.ReadNextFile(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, abandonedID, got.ID)
+		assert.Equal(t, wantAbandoned, got.Abandoned)
+	}
+
+	// The abandoned entry is moved once its output is written
+	require.NoError(t, reader.Ack(ctx, got))
+	dead, err := redisClient.XRange(ctx, "jobs:dead", "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, map[string]any{
+		StreamFieldJob:   `{"from": "a@example.com"}`,
+		StreamFieldID:    abandonedID,
+		StreamFieldError: "not done after 2 deliveries",
+	}, dead[0].Values)
+
+	// The failed entry is moved with its error
+	got, err = reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, failedID, got.ID)
+	require.NoError(t, reader.Fail(ctx, got, &FileError{Error: "invalid yaml"}))
+	dead, err = redisClient.XRange(ctx, "jobs:dead", "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, dead, 2)
+	assert.Equal(t, failedID, dead[1].Values[StreamFieldID])
+	assert.Equal(t, "yaml", dead[1].Values[StreamFieldFormat])
+	assert.Equal(t, "invalid yaml", dead[1].Values[StreamFieldError])
+
+	assert.Equal(t, int64(0), redisClient.XLen(ctx, "jobs").Val())
+	assert.Equal(t, int64(0), redisClient.XPending(ctx, "jobs", "senders").Val().Count)
+}
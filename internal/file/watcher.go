@@ -0,0 +1,404 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+)
+
+const (
+	// DefaultRescanInterval is how often the watcher rescans the directory,
+	// in case an event was missed
+	DefaultRescanInterval = 5 * time.Minute
+
+	// DefaultSettleDelay is how long a df/qf pair must be left unchanged
+	// before it is considered completely written
+	DefaultSettleDelay = time.Second
+)
+
+// WatchFileReader implements the IFileReader interface with fsnotify events
+// instead of polling. A df/qf pair is queued once both files exist and
+// neither has been written to for the settle delay, and the workers are
+// woken through Notify.
+//
+// The directory is rescanned every rescan interval as a safety net, and
+// fully scanned when the kernel event queue overflows.
+type WatchFileReader struct {
+	// inputDir is the directory containing files to be processed
+	inputDir string
+
+	// fileReadTracker tracks which files have been read
+	fileReadTracker IFileReadTracker
+
+	// rescanInterval is the interval between full scans of the directory
+	rescanInterval time.Duration
+
+	// settleDelay is how long a pair must be unchanged before it is queued
+	settleDelay time.Duration
+
+	// mu protects pending, queue and queued
+	mu sync.Mutex
+
+	// pending holds the ids that changed recently, with their last change
+	pending map[string]time.Time
+
+	// queue holds the pairs ready to be read, in the order they were ready
+	queue []*FileInfo
+
+	// queued holds the ids in queue
+	queued map[string]bool
+
+	// notify signals that the queue holds files
+	notify chan struct{}
+}
+
+// NewWatchFileReader creates a new instance of WatchFileReader.
+// It validates the input directory; events are only watched once Run is
+// called.
+//
+// Parameters:
+//   - ctx: Context for initialization and logging
+//   - inputDir: The directory containing files to be processed
+//   - fileReadTracker: The tracker for file processing states
+//   - rescanInterval: The interval between full scans, DefaultRescanInterval if zero
+//   - settleDelay: How long a pair must be unchanged, DefaultSettleDelay if zero
+//
+// Returns:
+//   - *WatchFileReader: A new reader instance
+//   - error: Non-nil if the input directory is invalid or inaccessible
+func NewWatchFileReader(
+	ctx context.Context,
+	inputDir string,
+	fileReadTracker IFileReadTracker,
+	rescanInterval time.Duration,
+	settleDelay time.Duration,
+) (*WatchFileReader, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("inputDir", inputDir).
+		Dur("rescanInterval", rescanInterval).
+		Dur("settleDelay", settleDelay).
+		Msg("NewWatchFileReader")
+
+	info, err := os.Stat(inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("NewWatchFileReader: os.Stat")
+		return nil, err
+	}
+	if !info.IsDir() {
+		logger.Error().Msg("NewWatchFileReader: not a directory")
+		return nil, errors.New("not a directory")
+	}
+	if rescanInterval <= 0 {
+		rescanInterval = DefaultRescanInterval
+	}
+	if settleDelay <= 0 {
+		settleDelay = DefaultSettleDelay
+	}
+
+	return &WatchFileReader{
+		inputDir:        inputDir,
+		fileReadTracker: fileReadTracker,
+		rescanInterval:  rescanInterval,
+		settleDelay:     settleDelay,
+		pending:         make(map[string]time.Time),
+		queue:           make([]*FileInfo, 0),
+		queued:          make(map[string]bool),
+		notify:          make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify returns a channel that receives when files are ready to be read.
+// Readers should call ReadNextFile until it returns no file.
+func (f *WatchFileReader) Notify() <-chan struct{} {
+	return f.notify
+}
+
+// Run watches the input directory until the context is done.
+// It scans the directory once at start, queues pairs as their events settle,
+// rescans every rescan interval and after an event queue overflow.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - error: Non-nil if the directory cannot be watched
+func (f *WatchFileReader) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("WatchFileReader.Run")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error().Err(err).Msg("Run: fsnotify.NewWatcher")
+		return err
+	}
+	defer watcher.Close()
+	err = watcher.Add(f.inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("Run: watcher.Add")
+		return err
+	}
+
+	// Files written before the watch started have no events
+	_, err = f.RefreshList(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Run: RefreshList")
+	}
+
+	rescanTicker := time.NewTicker(f.rescanInterval)
+	defer rescanTicker.Stop()
+	settleTicker := time.NewTicker(f.settleDelay / 2)
+	defer settleTicker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			f.handleEvent(ctx, event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				logger.Warn().Err(err).Msg("Run: events lost, scanning the directory")
+			} else {
+				logger.Error().Err(err).Msg("Run: watcher.Errors")
+			}
+			_, err = f.RefreshList(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("Run: RefreshList")
+			}
+		case <-settleTicker.C:
+			f.queueSettled(ctx, time.Now())
+		case <-rescanTicker.C:
+			_, err = f.RefreshList(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("Run: RefreshList")
+			}
+		case <-ctx.Done():
+			logger.Debug().Msg("Run: ctx.Done")
+			return nil
+		}
+	}
+}
+
+// handleEvent marks the id of a df or qf file as changed
+func (f *WatchFileReader) handleEvent(ctx context.Context, event fsnotify.Event) {
+	logger := zerolog.Ctx(ctx)
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	id, ok := spoolFileID(filepath.Base(event.Name))
+	if !ok {
+		return
+	}
+	logger.Debug().
+		Str("name", event.Name).
+		Str("op", event.Op.String()).
+		Msg("handleEvent")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending[id] = time.Now()
+}
+
+// queueSettled queues the pending pairs that are complete and left unchanged
+// for the settle delay
+func (f *WatchFileReader) queueSettled(ctx context.Context, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	added := false
+	for id, changed := range f.pending {
+		if now.Sub(changed) < f.settleDelay {
+			continue
+		}
+		fileInfo, modTime, ok := f.statPair(ctx, id)
+		if !ok {
+			// Wait for the other file of the pair
+			delete(f.pending, id)
+			continue
+		}
+		if now.Sub(modTime) < f.settleDelay {
+			f.pending[id] = modTime
+			continue
+		}
+		delete(f.pending, id)
+		added = f.enqueue(fileInfo) || added
+	}
+	if added {
+		f.signal()
+	}
+}
+
+// statPair returns the pair of the id, and the last modification of its
+// files. ok is false unless both files exist.
+func (f *WatchFileReader) statPair(ctx context.Context, id string) (*FileInfo, time.Time, bool) {
+	logger := zerolog.Ctx(ctx)
+	fileInfo := &FileInfo{
+		DfFilePath: filepath.Join(f.inputDir, "df"+id),
+		ID:         id,
+		QfFilePath: filepath.Join(f.inputDir, "qf"+id),
+		Status:     input.FILE_STATUS_INIT,
+	}
+	var modTime time.Time
+	for _, path := range []string{fileInfo.DfFilePath, fileInfo.QfFilePath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Error().Err(err).Str("path", path).Msg("statPair: os.Stat")
+			}
+			return nil, time.Time{}, false
+		}
+		if info.IsDir() {
+			return nil, time.Time{}, false
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return fileInfo, modTime, true
+}
+
+// enqueue adds the pair to the queue, unless it is already queued.
+// The caller must hold mu.
+func (f *WatchFileReader) enqueue(fileInfo *FileInfo) bool {
+	if f.queued[fileInfo.ID] {
+		return false
+	}
+	f.queued[fileInfo.ID] = true
+	f.queue = append(f.queue, fileInfo)
+	return true
+}
+
+// signal wakes a reader, without blocking if one is already signalled
+func (f *WatchFileReader) signal() {
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshList scans the input directory, as after an event queue overflow.
+// Complete pairs left unchanged for the settle delay are queued, and the
+// others are checked again once they settle.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - []*FileInfo: The files queued for reading
+//   - error: Non-nil if directory scanning fails
+func (f *WatchFileReader) RefreshList(
+	ctx context.Context,
+) ([]*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("WatchFileReader.RefreshList")
+
+	entries, err := os.ReadDir(f.inputDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("RefreshList: os.ReadDir")
+		return nil, err
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	added := false
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "df") {
+			continue
+		}
+		id, ok := spoolFileID(entry.Name())
+		if !ok || f.queued[id] {
+			continue
+		}
+		fileInfo, modTime, ok := f.statPair(ctx, id)
+		if !ok {
+			continue
+		}
+		if now.Sub(modTime) < f.settleDelay {
+			f.pending[id] = modTime
+			continue
+		}
+		delete(f.pending, id)
+		added = f.enqueue(fileInfo) || added
+	}
+	result := make([]*FileInfo, len(f.queue))
+	copy(result, f.queue)
+	f.mu.Unlock()
+
+	if added {
+		f.signal()
+	}
+	return result, nil
+}
+
+// ReadNextFile retrieves the next queued file that is not processed yet.
+// Files that are done or being processed by another reader are dropped.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//
+// Returns:
+//   - *FileInfo: Information about the next file to process, nil if none is queued
+//   - error: Non-nil if file tracking operations fail
+func (f *WatchFileReader) ReadNextFile(
+	ctx context.Context,
+) (*FileInfo, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("WatchFileReader.ReadNextFile")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.queue) > 0 {
+		file := f.queue[0]
+		f.queue = f.queue[1:]
+		delete(f.queued, file.ID)
+
+		status, err := f.fileReadTracker.FileRead(ctx, file.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: FileRead")
+			return nil, err
+		}
+		if status == input.FILE_STATUS_PROCESSING || status == input.FILE_STATUS_DONE {
+			logger.Debug().
+				Str("fileName", file.DfFilePath).
+				Int("status", int(status)).
+				Msg("ReadNextFile: skipping file")
+			continue
+		}
+
+		err = f.fileReadTracker.UpsertFile(ctx, file.ID, input.FILE_STATUS_PROCESSING)
+		if err != nil {
+			logger.Error().Err(err).Msg("ReadNextFile: UpsertFile")
+			return nil, err
+		}
+		// Wake another reader for the rest of the queue
+		if len(f.queue) > 0 {
+			f.signal()
+		}
+		return file, nil
+	}
+
+	logger.Debug().Msg("ReadNextFile: no more files")
+	return nil, nil
+}
+
+// spoolFileID returns the id of a df or qf file name
+func spoolFileID(name string) (string, bool) {
+	if len(name) <= 2 || (!strings.HasPrefix(name, "df") && !strings.HasPrefix(name, "qf")) {
+		return "", false
+	}
+	return name[2:], true
+}
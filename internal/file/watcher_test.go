@@ -0,0 +1,183 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// writeSpoolFile writes the file, with a modification time age ago
+func writeSpoolFile(t *testing.T, dir, name string, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0600))
+	modTime := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestWatchFileReader_RefreshList(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]time.Duration
+		wantIDs []string
+	}{
+		{
+			name:    "complete pair",
+			files:   map[string]time.Duration{"df001": time.Minute, "qf001": time.Minute},
+			wantIDs: []string{"001"},
+		},
+		{
+			name:  "missing qf",
+			files: map[string]time.Duration{"df001": time.Minute},
+		},
+		{
+			name:  "qf still being written",
+			files: map[string]time.Duration{"df001": time.Minute, "qf001": 0},
+		},
+		{
+			name: "several pairs",
+			files: map[string]time.Duration{
+				"df001": time.Minute, "qf001": time.Minute,
+				"df002": time.Minute, "qf002": 0,
+				"df003": time.Minute, "qf003": time.Minute,
+				"xf004": time.Minute,
+			},
+			wantIDs: []string{"001", "003"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			tmpDir := t.TempDir()
+			for name, age := range tt.files {
+				writeSpoolFile(t, tmpDir, name, age)
+			}
+			ctrl := gomock.NewController(t)
+			tracker := NewMockIFileReadTracker(ctrl)
+
+			reader, err := NewWatchFileReader(ctx, tmpDir, tracker, time.Minute, 10*time.Second)
+			require.NoError(t, err)
+			got, err := reader.RefreshList(ctx)
+			require.NoError(t, err)
+			gotIDs := make([]string, 0, len(got))
+			for _, fileInfo := range got {
+				gotIDs = append(gotIDs, fileInfo.ID)
+				assert.Equal(t, filepath.Join(tmpDir, "df"+fileInfo.ID), fileInfo.DfFilePath)
+				assert.Equal(t, filepath.Join(tmpDir, "qf"+fileInfo.ID), fileInfo.QfFilePath)
+			}
+			assert.ElementsMatch(t, tt.wantIDs, gotIDs)
+
+			// A second scan does not queue the pairs again
+			got, err = reader.RefreshList(ctx)
+			require.NoError(t, err)
+			assert.Len(t, got, len(tt.wantIDs))
+
+			select {
+			case <-reader.Notify():
+				assert.NotEmpty(t, tt.wantIDs)
+			default:
+				assert.Empty(t, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestWatchFileReader_ReadNextFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses map[string]input.FileStatus
+		wantIDs  []string
+	}{
+		{
+			name:     "new files",
+			statuses: map[string]input.FileStatus{"001": input.FILE_STATUS_NOT_FOUND, "002": input.FILE_STATUS_NOT_FOUND},
+			wantIDs:  []string{"001", "002"},
+		},
+		{
+			name:     "skips done and processing",
+			statuses: map[string]input.FileStatus{"001": input.FILE_STATUS_DONE, "002": input.FILE_STATUS_PROCESSING, "003": input.FILE_STATUS_INIT},
+			wantIDs:  []string{"003"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			tmpDir := t.TempDir()
+			ctrl := gomock.NewController(t)
+			tracker := NewMockIFileReadTracker(ctrl)
+			for id, status := range tt.statuses {
+				writeSpoolFile(t, tmpDir, "df"+id, time.Minute)
+				writeSpoolFile(t, tmpDir, "qf"+id, time.Minute)
+				tracker.EXPECT().FileRead(gomock.Any(), id).Return(status, nil)
+			}
+			for _, id := range tt.wantIDs {
+				tracker.EXPECT().UpsertFile(gomock.Any(), id, input.FILE_STATUS_PROCESSING).Return(nil)
+			}
+
+			reader, err := NewWatchFileReader(ctx, tmpDir, tracker, time.Minute, time.Second)
+			require.NoError(t, err)
+			_, err = reader.RefreshList(ctx)
+			require.NoError(t, err)
+
+			gotIDs := make([]string, 0)
+			for {
+				fileInfo, err := reader.ReadNextFile(ctx)
+				require.NoError(t, err)
+				if fileInfo == nil {
+					break
+				}
+				gotIDs = append(gotIDs, fileInfo.ID)
+			}
+			assert.Equal(t, tt.wantIDs, gotIDs)
+		})
+	}
+}
+
+func TestWatchFileReader_Run(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	tmpDir := t.TempDir()
+	ctrl := gomock.NewController(t)
+	tracker := NewMockIFileReadTracker(ctrl)
+	tracker.EXPECT().FileRead(gomock.Any(), "001").Return(input.FILE_STATUS_NOT_FOUND, nil)
+	tracker.EXPECT().UpsertFile(gomock.Any(), "001", input.FILE_STATUS_PROCESSING).Return(nil)
+
+	reader, err := NewWatchFileReader(ctx, tmpDir, tracker, time.Minute, 50*time.Millisecond)
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- reader.Run(ctx)
+	}()
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "df001"), []byte("body"), 0600))
+	// Only the df file is written, nothing is queued
+	select {
+	case <-reader.Notify():
+		t.Fatal("notified without the qf file")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "qf001"), []byte("H??From: a@example.com"), 0600))
+	select {
+	case <-reader.Notify():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified of the pair")
+	}
+	fileInfo, err := reader.ReadNextFile(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, fileInfo)
+	assert.Equal(t, "001", fileInfo.ID)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -3,8 +3,6 @@ package file_mail
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"os"
 	"regexp"
 
 	"github.com/rs/zerolog"
@@ -50,19 +48,13 @@ func (_ *BodyTransformer) Transform(
 		Msg("BodyTransformer")
 	var err error
 
-	// 1. validate the df file exists and is readable
-	if fileInfo.DfFilePath == "" {
-		return nil, fmt.Errorf("ToSkip: DfFilePath is empty")
-	}
-
-	// 2. read all the bytes from the df file
-	inMail.Body, err = os.ReadFile(fileInfo.DfFilePath)
+	// 1. read all the bytes from the df reader of a queued mail, or the df file
+	inMail.Body, err = readDf(ctx, fileInfo)
 	if err != nil {
-		logger.Error().Err(err).Msg("os.ReadFile")
 		return nil, err
 	}
 
-	// 3. Handling of unix new line to dos new line is done in mail Processor
+	// 2. Handling of unix new line to dos new line is done in mail Processor
 	re := regexp.MustCompile(`\r?\n`)
 	inMail.Body = re.ReplaceAll(inMail.Body, []byte("\r\n"))
 	inMail.Body = bytes.TrimSpace(inMail.Body)
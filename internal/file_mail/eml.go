@@ -0,0 +1,160 @@
+package file_mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/utils"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	EMLTransformerType = "eml"
+)
+
+// EMLTransformer reads a mail that is a single RFC 5322 file, as read by the
+// eml and maildir inputs, or the DfReader of a message of the mbox input. The headers are set in the metadata for the header
+// transformers, with the value of their first occurrence, the header section
+// as read is kept in RawHeaders, and the rest of the file is the body.
+type EMLTransformer struct {
+	Cfg config.FileMailConfig
+}
+
+func (t *EMLTransformer) Init(
+	ctx context.Context,
+	cfg config.FileMailConfig,
+) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("type", EMLTransformerType).
+		Int("index", cfg.Index).
+		Interface("args", cfg.Args).
+		Logger()
+	logger.Debug().Msg("EMLTransformer Init")
+	t.Cfg = cfg
+	return nil
+}
+
+func (t *EMLTransformer) Index() int {
+	return t.Cfg.Index
+}
+
+func (_ *EMLTransformer) Transform(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	inMail *pmail.Mail,
+) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx).With().Str("df_file_path", fileInfo.DfFilePath).Logger()
+	logger.Debug().Msg("EMLTransformer")
+	if inMail == nil {
+		inMail = &pmail.Mail{}
+	}
+
+	// 1. read the reader of the message, e.g. of an mbox, or the file
+	byteSlice, err := readDf(ctx, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo.Status = input.FILE_STATUS_HEADERS_READ
+
+	// 2. replace all \n with \r\n, and split the headers from the body
+	re := regexp.MustCompile(`\r?\n`)
+	byteSlice = re.ReplaceAll(byteSlice, []byte("\r\n"))
+	headerBytes, body, found := bytes.Cut(byteSlice, []byte("\r\n\r\n"))
+	if !found {
+		// Only headers, or a file ending right after them
+		headerBytes = bytes.TrimSuffix(byteSlice, []byte("\r\n"))
+		body = nil
+	}
+
+	// 3. the headers, unfolded, where the first occurrence wins
+	headers, err := parseEMLHeaders(headerBytes)
+	if err != nil {
+		logger.Error().Err(err).Msg("parseEMLHeaders")
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no headers in %s", fileInfo.DfFilePath)
+	}
+	if inMail.Metadata == nil {
+		inMail.Metadata = make(map[string][]byte)
+	}
+	for _, header := range headers {
+		if _, ok := inMail.Metadata[header.Name]; ok {
+			continue
+		}
+		inMail.Metadata[header.Name] = header.Value
+	}
+	inMail.RawHeaders = append(bytes.Clone(headerBytes), []byte("\r\n")...)
+	fileInfo.Status = input.FILE_STATUS_HEADERS_PARSE
+
+	// 4. the body, as the body transformer reads it from a df file
+	inMail.Body = bytes.TrimSpace(body)
+	fileInfo.Status = input.FILE_STATUS_BODY_READ
+
+	return inMail, nil
+}
+
+// readDf returns the content of DfReader when set, otherwise of the file
+func readDf(ctx context.Context, fileInfo *file.FileInfo) ([]byte, error) {
+	logger := zerolog.Ctx(ctx)
+	if fileInfo.DfReader != nil {
+		result, err := io.ReadAll(fileInfo.DfReader)
+		if err != nil {
+			logger.Error().Err(err).Msg("io.ReadAll")
+			return nil, err
+		}
+		return result, nil
+	}
+	if fileInfo.DfFilePath == "" {
+		logger.Error().Msg("ToSkip: fileInfo.DfFilePath is empty")
+		return nil, fmt.Errorf("ToSkip: fileInfo.DfFilePath is empty")
+	}
+	_, err := utils.ValidateIO(ctx, fileInfo.DfFilePath, true, false)
+	if err != nil {
+		logger.Error().Err(err).Msg("utils.ValidateIO")
+		return nil, err
+	}
+	result, err := os.ReadFile(fileInfo.DfFilePath)
+	if err != nil {
+		logger.Error().Err(err).Msg("os.ReadFile")
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseEMLHeaders parses the header lines, where only the first colon
+// separates the name, and continuation lines are unfolded
+func parseEMLHeaders(headerBytes []byte) ([]QueueHeader, error) {
+	result := make([]QueueHeader, 0)
+	for _, line := range bytes.Split(headerBytes, []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(result) == 0 {
+				return nil, fmt.Errorf("message starts with a continuation line")
+			}
+			header := &result[len(result)-1]
+			header.Value = append(header.Value, line...)
+			continue
+		}
+		name, value, found := bytes.Cut(line, []byte(":"))
+		name = bytes.TrimSpace(name)
+		if !found || len(name) == 0 {
+			return nil, fmt.Errorf("invalid header: %s", line)
+		}
+		result = append(result, QueueHeader{
+			Name:  string(name),
+			Value: bytes.Clone(bytes.TrimLeft(value, " \t")),
+		})
+	}
+	return result, nil
+}
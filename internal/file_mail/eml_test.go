@@ -0,0 +1,155 @@
+package file_mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEMLTransformer(t *testing.T) {
+	tests := []struct {
+		name           string
+		eml            string
+		wantMetadata   map[string][]byte
+		wantRawHeaders []byte
+		wantBody       []byte
+		wantErr        bool
+	}{
+		{
+			name: "happy",
+			eml: "Received: from a by b\r\n" +
+				"Received: from c by d\r\n" +
+				"From: Sender <sender@example.com>\r\n" +
+				"To: to@example.org\r\n" +
+				"Subject: Meeting at 10:30,\r\n" +
+				"\tsee https://example.com\r\n" +
+				"\r\n" +
+				"Hello\r\n" +
+				"\r\n" +
+				"Second paragraph\r\n",
+			wantMetadata: map[string][]byte{
+				"Received": []byte("from a by b"),
+				"From":     []byte("Sender <sender@example.com>"),
+				"To":       []byte("to@example.org"),
+				"Subject":  []byte("Meeting at 10:30,\tsee https://example.com"),
+			},
+			wantRawHeaders: []byte("Received: from a by b\r\n" +
+				"Received: from c by d\r\n" +
+				"From: Sender <sender@example.com>\r\n" +
+				"To: to@example.org\r\n" +
+				"Subject: Meeting at 10:30,\r\n" +
+				"\tsee https://example.com\r\n"),
+			wantBody: []byte("Hello\r\n\r\nSecond paragraph"),
+		},
+		{
+			name: "unix new lines",
+			eml:  "From: sender@example.com\nSubject: test\n\nbody\n",
+			wantMetadata: map[string][]byte{
+				"From":    []byte("sender@example.com"),
+				"Subject": []byte("test"),
+			},
+			wantRawHeaders: []byte("From: sender@example.com\r\nSubject: test\r\n"),
+			wantBody:       []byte("body"),
+		},
+		{
+			name: "headers only",
+			eml:  "Subject: test\r\n",
+			wantMetadata: map[string][]byte{
+				"Subject": []byte("test"),
+			},
+			wantRawHeaders: []byte("Subject: test\r\n"),
+		},
+		{
+			name:    "starts with continuation",
+			eml:     " folded\r\nSubject: test\r\n\r\nbody\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid header",
+			eml:     "Subject test\r\n\r\nbody\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "no headers",
+			eml:     "\r\n\r\nbody\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+
+			transformer := &EMLTransformer{}
+			err := transformer.Init(ctx, config.FileMailConfig{Type: EMLTransformerType})
+			require.NoError(t, err)
+
+			tmpFile := filepath.Join(t.TempDir(), "001.eml")
+			err = os.WriteFile(tmpFile, []byte(tt.eml), 0644)
+			require.NoError(t, err)
+			fileInfo := &file.FileInfo{
+				DfFilePath: tmpFile,
+				ID:         "001",
+			}
+
+			got, err := transformer.Transform(ctx, fileInfo, &pmail.Mail{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMetadata, got.Metadata)
+			assert.Equal(t, tt.wantRawHeaders, got.RawHeaders)
+			assert.Equal(t, tt.wantBody, got.Body)
+		})
+	}
+}
+
+func TestEMLTransformerPipeline(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	tmpFile := filepath.Join(t.TempDir(), "001.eml")
+	err := os.WriteFile(tmpFile, []byte("From: Sender <sender@example.com>\r\n"+
+		"To: to@example.org\r\n"+
+		"Subject: test\r\n"+
+		"\r\n"+
+		"body\r\n"), 0644)
+	require.NoError(t, err)
+
+	// The default file mails of the eml input fill the mail from the file
+	factory := NewMailTransformerFactory(ctx, config.DefaultEMLFileMailConfigs())
+	require.NoError(t, factory.Init(ctx, config.FileMailConfig{}))
+	got, err := factory.Transform(ctx, &file.FileInfo{DfFilePath: tmpFile, ID: "001"}, &pmail.Mail{})
+	require.NoError(t, err)
+	assert.Equal(t, "sender@example.com", got.From.String())
+	require.Len(t, got.To, 1)
+	assert.Equal(t, "to@example.org", got.To[0].String())
+	assert.Equal(t, []byte("test"), got.Subject)
+	assert.Equal(t, []byte("body"), got.Body)
+	assert.NotEmpty(t, got.MsgID)
+}
+
+func TestEMLTransformerDfReader(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	transformer := &EMLTransformer{}
+	require.NoError(t, transformer.Init(ctx, config.FileMailConfig{Type: EMLTransformerType}))
+
+	// A message of an mbox is read from its reader, not from the mbox file
+	fileInfo := &file.FileInfo{
+		DfFilePath: filepath.Join(t.TempDir(), "archive.mbox"),
+		DfReader:   strings.NewReader("Subject: from mbox\n\nbody\n"),
+		ID:         "mbox-0",
+	}
+	got, err := transformer.Transform(ctx, fileInfo, &pmail.Mail{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from mbox"), got.Metadata["Subject"])
+	assert.Equal(t, []byte("body"), got.Body)
+}
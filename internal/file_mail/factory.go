@@ -28,12 +28,15 @@ func NewMailTransformerFactory(
 	}
 	result.registry = make(map[string]reflect.Type)
 	result.registry[BodyTransformerType] = reflect.TypeOf(BodyTransformer{})
+	result.registry[EMLTransformerType] = reflect.TypeOf(EMLTransformer{})
 	result.registry[HeadersTransformerType] = reflect.TypeOf(HeadersTransformer{})
 	result.registry[HeaderContentTypeTransformerType] = reflect.TypeOf(HeaderContentTypeTransformer{})
 	result.registry[HeaderFromTransformerType] = reflect.TypeOf(HeaderFromTransformer{})
 	result.registry[HeaderMsgIDTransformerType] = reflect.TypeOf(HeaderMsgIDTransformer{})
 	result.registry[HeaderSubjectTransformerType] = reflect.TypeOf(HeaderSubjectTransformer{})
 	result.registry[HeaderToTransformerType] = reflect.TypeOf(HeaderToTransformer{})
+	result.registry[JobTransformerType] = reflect.TypeOf(JobTransformer{})
+	result.registry[QfTransformerType] = reflect.TypeOf(QfTransformer{})
 	return result
 }
 
@@ -3,14 +3,11 @@ package file_mail
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"os"
 	"regexp"
 
 	"github.com/rs/zerolog"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/internal/file"
-	"github.com/stlimtat/remiges-smtp/internal/utils"
 	"github.com/stlimtat/remiges-smtp/pkg/input"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 )
@@ -53,34 +50,20 @@ func (h *HeadersTransformer) Transform(
 	logger := zerolog.Ctx(ctx).With().Str("qf_file_path", fileInfo.QfFilePath).Logger()
 	logger.Debug().Msg("HeadersTransformer")
 
-	// 1. check fileInfo.QfReader is not nil
-	if fileInfo.QfFilePath == "" {
-		logger.Error().Msg("ToSkip: fileInfo.QfFilePath is empty")
-		return nil, fmt.Errorf("ToSkip: fileInfo.QfFilePath is empty")
-	}
-
-	// 2. validate the qf file exists and is readable
-	_, err = utils.ValidateIO(ctx, fileInfo.QfFilePath, true, false)
-	if err != nil {
-		logger.Error().Err(err).Msg("utils.ValidateIO")
-		return nil, err
-	}
-
-	// 3. read all the bytes from the qf file
-	byteSlice, err := os.ReadFile(fileInfo.QfFilePath)
+	// 1. read all the bytes from the qf reader of a queued mail, or the qf file
+	byteSlice, err := readQf(ctx, fileInfo)
 	if err != nil {
-		logger.Error().Err(err).Msg("os.ReadFile")
 		return nil, err
 	}
 	fileInfo.Status = input.FILE_STATUS_HEADERS_READ
 
-	// 4. initialize the headers map in the mail
+	// 2. initialize the headers map in the mail
 	inMail.Metadata = make(map[string][]byte)
-	// 5. replace all \n with \r\n
+	// 3. replace all \n with \r\n
 	re := regexp.MustCompile(`\r?\n`)
 	byteSlice = re.ReplaceAll(byteSlice, []byte("\r\n"))
 
-	// 6. split the bytes into lines
+	// 4. split the bytes into lines
 	lines := bytes.Split(byteSlice, []byte("\r\n"))
 
 	// 4. iterate over the lines and add them to the result map
@@ -88,6 +71,8 @@ func (h *HeadersTransformer) Transform(
 	var keyStr string
 	var keyPrefixStr string
 	var value []byte
+	// the header fields of the mail, the keys with the prefix
+	headers := make([]QueueHeader, 0)
 	for _, line := range lines {
 		// 4. split the line into key and value with the colon as the delimiter
 		if len(line) < 1 {
@@ -100,10 +85,14 @@ func (h *HeadersTransformer) Transform(
 			inMail.Metadata[keyStr] = value
 			if bytes.HasPrefix(key, h.PrefixBytes) {
 				inMail.Metadata[keyPrefixStr] = value
+				if len(h.PrefixBytes) > 0 {
+					headers[len(headers)-1].Value = value
+				}
 			}
 			continue
 		}
-		kvPair := bytes.Split(line, []byte(":"))
+		// only the first colon separates the key, values may hold times and urls
+		kvPair := bytes.SplitN(line, []byte(":"), 2)
 		key = bytes.TrimSpace(kvPair[0])
 		value = bytes.TrimSpace(kvPair[1])
 		keyStr = string(key)
@@ -114,8 +103,15 @@ func (h *HeadersTransformer) Transform(
 			keyPrefix := bytes.TrimPrefix(key, h.PrefixBytes)
 			keyPrefixStr = string(keyPrefix)
 			inMail.Metadata[keyPrefixStr] = value
+			if len(h.PrefixBytes) > 0 {
+				headers = append(headers, QueueHeader{Name: keyPrefixStr, Value: value})
+			}
 		}
 	}
+	// 7. without a prefix, the keys cannot be told apart from the headers
+	if len(headers) > 0 {
+		inMail.RawHeaders = formatHeaders(headers)
+	}
 	fileInfo.Status = input.FILE_STATUS_HEADERS_PARSE
 
 	return inMail, nil
@@ -52,6 +52,20 @@ func TestHeadersTransformer(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "happy - colon in value",
+			cfg: config.FileMailConfig{
+				Type: HeadersTransformerType,
+				Args: map[string]any{},
+			},
+			headers: []byte("Subject: Meeting at 10:30, see https://example.com\n"),
+			wantMail: &pmail.Mail{
+				Metadata: map[string][]byte{
+					"Subject": []byte("Meeting at 10:30, see https://example.com"),
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "happy - with prefix",
 			cfg: config.FileMailConfig{
@@ -70,6 +84,7 @@ func TestHeadersTransformer(t *testing.T) {
 					"To":         []byte("test1@example.com"),
 					"Subject":    []byte("test1"),
 				},
+				RawHeaders: []byte("From: test1@example.com\r\nTo: test1@example.com\r\nSubject: test1\r\n"),
 			},
 			wantErr: false,
 		},
@@ -99,6 +114,7 @@ func TestHeadersTransformer(t *testing.T) {
 			}
 			require.NoError(t, err)
 			assert.Equal(t, tt.wantMail.Metadata, got.Metadata)
+			assert.Equal(t, tt.wantMail.RawHeaders, got.RawHeaders)
 		})
 	}
 }
@@ -0,0 +1,312 @@
+package file_mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mjl-/mox/smtp"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/mailjob"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	JobTransformerType = "job"
+	// JobMetadataPriority is the priority of the job, normal by default
+	JobMetadataPriority = "job-priority"
+	// MIMEVersionKey is the header of a MIME message
+	MIMEVersionKey = "MIME-Version"
+	// base64LineLength is the length of the lines of a base64 attachment
+	base64LineLength = 76
+)
+
+// JobTransformer reads a mail job, a JSON or YAML file described by the
+// schema of pkg/mailjob, and sets every field of the mail from it, so that
+// it needs no other transformer. The body is always a MIME multipart body.
+// An invalid job returns a *pmail.RejectedError with the errors of its fields.
+// A .eml file is read by the default file mails of the eml input instead.
+type JobTransformer struct {
+	Cfg config.FileMailConfig
+	eml *MailTransformerFactory
+}
+
+func (t *JobTransformer) Init(
+	ctx context.Context,
+	cfg config.FileMailConfig,
+) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("type", JobTransformerType).
+		Int("index", cfg.Index).
+		Interface("args", cfg.Args).
+		Logger()
+	logger.Debug().Msg("JobTransformer Init")
+	t.Cfg = cfg
+	t.eml = NewMailTransformerFactory(ctx, config.DefaultEMLFileMailConfigs())
+	return t.eml.Init(ctx, config.FileMailConfig{})
+}
+
+func (t *JobTransformer) Index() int {
+	return t.Cfg.Index
+}
+
+func (t *JobTransformer) Transform(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	inMail *pmail.Mail,
+) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx).With().Str("df_file_path", fileInfo.DfFilePath).Logger()
+	logger.Debug().Msg("JobTransformer")
+	if strings.EqualFold(filepath.Ext(fileInfo.DfFilePath), file.EMLFileExt) {
+		return t.eml.Transform(ctx, fileInfo, inMail)
+	}
+	if inMail == nil {
+		inMail = &pmail.Mail{}
+	}
+
+	// 1. read and validate the job
+	byteSlice, err := readDf(ctx, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo.Status = input.FILE_STATUS_HEADERS_READ
+	job, err := mailjob.Parse(fileInfo.DfFilePath, byteSlice)
+	if err != nil {
+		logger.Error().Err(err).Msg("mailjob.Parse")
+		return nil, err
+	}
+	fileInfo.Status = input.FILE_STATUS_HEADERS_PARSE
+
+	// 2. the attachments are relative to the directory of the job. A job
+	// without a file, e.g. of a stream, has no directory.
+	attachments := make([][]byte, 0, len(job.Attachments))
+	fieldErrors := make([]pmail.FieldError, 0)
+	for i, attachment := range job.Attachments {
+		if attachment.Path == "" {
+			attachments = append(attachments, attachment.Content)
+			continue
+		}
+		field := fmt.Sprintf("attachments[%d].path", i)
+		if fileInfo.DfReader != nil {
+			fieldErrors = append(fieldErrors, pmail.FieldError{
+				Field:   field,
+				Message: "not allowed for a job without a file, set the content instead",
+			})
+			continue
+		}
+		content, err := readAttachment(filepath.Dir(fileInfo.DfFilePath), attachment.Path)
+		if err != nil {
+			fieldErrors = append(fieldErrors, pmail.FieldError{
+				Field:   field,
+				Message: fmt.Sprintf("cannot read %s: %v", attachment.Path, err),
+			})
+			continue
+		}
+		attachments = append(attachments, content)
+	}
+	if len(fieldErrors) > 0 {
+		err = &pmail.RejectedError{Fields: fieldErrors}
+		logger.Error().Err(err).Msg("attachments")
+		return nil, err
+	}
+
+	// 3. the envelope has every recipient, the headers only to and cc
+	inMail.From = job.FromAddress
+	inMail.To = make([]smtp.Address, 0)
+	seen := make(map[string]bool)
+	for _, addrs := range [][]smtp.Address{job.ToAddresses, job.CcAddresses, job.BccAddresses} {
+		for _, addr := range addrs {
+			if seen[addr.String()] {
+				continue
+			}
+			seen[addr.String()] = true
+			inMail.To = append(inMail.To, addr)
+		}
+	}
+	inMail.Cc = job.CcAddresses
+	inMail.Bcc = job.BccAddresses
+	inMail.Subject = []byte(job.Subject)
+	inMail.MsgID = []byte(job.Options.MessageID)
+	if len(inMail.MsgID) == 0 {
+		inMail.MsgID = []byte(fmt.Sprintf("<%s@%s>", uuid.NewString(), job.FromAddress.Domain.ASCII))
+	}
+
+	if inMail.HeadersMap == nil {
+		inMail.HeadersMap = make(map[string][]byte)
+	}
+	for name, value := range job.Headers {
+		inMail.HeadersMap[name] = []byte(value)
+	}
+	inMail.HeadersMap[MIMEVersionKey] = []byte("1.0")
+
+	if inMail.Metadata == nil {
+		inMail.Metadata = make(map[string][]byte)
+	}
+	for key, value := range job.Metadata {
+		inMail.Metadata[key] = []byte(value)
+	}
+	priority := job.Options.Priority
+	if priority == "" {
+		priority = mailjob.PriorityNormal
+	}
+	inMail.Metadata[JobMetadataPriority] = []byte(priority)
+
+	// 4. the body
+	contentType, body, err := buildJobBody(job, attachments)
+	if err != nil {
+		logger.Error().Err(err).Msg("buildJobBody")
+		return nil, err
+	}
+	inMail.ContentType = []byte(contentType)
+	inMail.Body = body
+	fileInfo.Status = input.FILE_STATUS_BODY_READ
+
+	return inMail, nil
+}
+
+// readAttachment reads the attachment of a job, which cannot leave the
+// directory of the job, through .. or a symlink
+func readAttachment(dir string, path string) ([]byte, error) {
+	if !filepath.IsLocal(path) {
+		return nil, fmt.Errorf("%s is outside of the directory of the job", path)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(dir, path))
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(realDir, realPath)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%s is outside of the directory of the job", path)
+	}
+	return os.ReadFile(realPath)
+}
+
+// buildJobBody returns the multipart body of the job and its content type,
+// multipart/alternative for its text and html, within multipart/mixed when
+// it has attachments
+func buildJobBody(job *mailjob.Job, attachments [][]byte) (string, []byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	mediaType := "multipart/alternative"
+	if len(attachments) > 0 {
+		mediaType = "multipart/mixed"
+	}
+
+	// With attachments, both text and html are a nested alternative part
+	textWriter := writer
+	var textBuf bytes.Buffer
+	nested := len(attachments) > 0 && job.Text != "" && job.HTML != ""
+	if nested {
+		textWriter = multipart.NewWriter(&textBuf)
+	}
+	if job.Text != "" {
+		err := writeTextPart(textWriter, "text/plain; charset=utf-8", job.Text)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if job.HTML != "" {
+		err := writeTextPart(textWriter, "text/html; charset=utf-8", job.HTML)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if nested {
+		err := textWriter.Close()
+		if err != nil {
+			return "", nil, err
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative",
+			map[string]string{"boundary": textWriter.Boundary()}))
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return "", nil, err
+		}
+		_, err = part.Write(textBuf.Bytes())
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	for i, attachment := range job.Attachments {
+		err := writeAttachmentPart(writer, attachment, attachments[i])
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return mime.FormatMediaType(mediaType, map[string]string{"boundary": writer.Boundary()}),
+		buf.Bytes(), nil
+}
+
+// writeTextPart writes a quoted-printable part
+func writeTextPart(writer *multipart.Writer, contentType string, content string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	qpWriter := quotedprintable.NewWriter(part)
+	_, err = qpWriter.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+	return qpWriter.Close()
+}
+
+// writeAttachmentPart writes a base64 attachment part
+func writeAttachmentPart(writer *multipart.Writer, attachment mailjob.Attachment, content []byte) error {
+	filename := attachment.Filename
+	if filename == "" {
+		filename = filepath.Base(attachment.Path)
+	}
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": filename}))
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 0 {
+		n := min(base64LineLength, len(encoded))
+		_, err = fmt.Fprintf(part, "%s\r\n", encoded[:n])
+		if err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package file_mail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readParts returns the content types of the parts of a multipart body,
+// with the parts of a nested multipart part
+func readParts(t *testing.T, contentType string, body []byte) []string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	result := []string{mediaType}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(part)
+		require.NoError(t, err)
+		partType := part.Header.Get("Content-Type")
+		if bytes.HasPrefix([]byte(partType), []byte("multipart/")) {
+			result = append(result, readParts(t, partType, content)...)
+			continue
+		}
+		result = append(result, partType)
+	}
+}
+
+func TestJobTransformer(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      string
+		fileName string
+		// reader gives the job in DfReader, as a stream does
+		reader bool
+		// symlink is linked from link.pdf in the directory of the job
+		symlink   string
+		wantTo    []string
+		wantParts []string
+		wantErr   bool
+	}{
+		{
+			name:     "text",
+			fileName: "001.json",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "Hello\n\nSecond paragraph"}`,
+			wantTo:    []string{"john@example.org"},
+			wantParts: []string{"multipart/alternative", "text/plain; charset=utf-8"},
+		},
+		{
+			name:     "text, html and attachment",
+			fileName: "001.yaml",
+			job: "from: sender@example.com\n" +
+				"to: [john@example.org]\n" +
+				"cc: [jane@example.org]\n" +
+				"bcc: [audit@example.com, john@example.org]\n" +
+				"subject: invoice\n" +
+				"text: Please find your invoice attached\n" +
+				"html: <p>Please find your invoice attached</p>\n" +
+				"attachments:\n" +
+				"  - path: invoice.pdf\n",
+			wantTo: []string{"john@example.org", "jane@example.org", "audit@example.com"},
+			wantParts: []string{
+				"multipart/mixed",
+				"multipart/alternative",
+				"text/plain; charset=utf-8",
+				"text/html; charset=utf-8",
+				"application/pdf",
+			},
+		},
+		{
+			name:     "missing attachment",
+			fileName: "001.json",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "missing.pdf"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "absolute attachment",
+			fileName: "001.json",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "/etc/passwd"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "attachment outside of the job directory",
+			fileName: "001.json",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "../secret.pem"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "attachment of a job without a file",
+			fileName: "001.json",
+			reader:   true,
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "invoice.pdf"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "attachment linked outside of the job directory",
+			fileName: "001.json",
+			symlink:  "secret.pem",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "link.pdf"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "attachment linked inside of the job directory",
+			fileName: "001.json",
+			symlink:  "invoice.pdf",
+			job: `{"from": "sender@example.com", "to": ["john@example.org"], ` +
+				`"subject": "test", "text": "body", "attachments": [{"path": "link.pdf"}]}`,
+			wantTo: []string{"john@example.org"},
+			wantParts: []string{
+				"multipart/mixed",
+				"text/plain; charset=utf-8",
+				"application/pdf",
+			},
+		},
+		{
+			name:     "invalid job",
+			fileName: "001.json",
+			job:      `{"from": "sender@example.com"}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			transformer := &JobTransformer{}
+			require.NoError(t, transformer.Init(ctx, config.FileMailConfig{Type: JobTransformerType}))
+
+			parentDir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(parentDir, "secret.pem"), []byte("secret"), 0600))
+			tmpDir := filepath.Join(parentDir, "jobs")
+			require.NoError(t, os.Mkdir(tmpDir, 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "invoice.pdf"), []byte("%PDF-1.4"), 0600))
+			switch tt.symlink {
+			case "secret.pem":
+				require.NoError(t, os.Symlink(filepath.Join(parentDir, tt.symlink), filepath.Join(tmpDir, "link.pdf")))
+			case "invoice.pdf":
+				require.NoError(t, os.Symlink(tt.symlink, filepath.Join(tmpDir, "link.pdf")))
+			}
+			tmpFile := filepath.Join(tmpDir, tt.fileName)
+			fileInfo := &file.FileInfo{DfFilePath: tmpFile, ID: "001"}
+			if tt.reader {
+				fileInfo.DfReader = strings.NewReader(tt.job)
+			} else {
+				require.NoError(t, os.WriteFile(tmpFile, []byte(tt.job), 0600))
+			}
+
+			got, err := transformer.Transform(ctx, fileInfo, &pmail.Mail{})
+			if tt.wantErr {
+				var rejected *pmail.RejectedError
+				assert.ErrorAs(t, err, &rejected)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "sender@example.com", got.From.String())
+			gotTo := make([]string, 0)
+			for _, addr := range got.To {
+				gotTo = append(gotTo, addr.String())
+			}
+			assert.Equal(t, tt.wantTo, gotTo)
+			assert.NotEmpty(t, got.MsgID)
+			assert.Equal(t, []byte("normal"), got.Metadata[JobMetadataPriority])
+			assert.Equal(t, []byte("1.0"), got.HeadersMap[MIMEVersionKey])
+			// The body processor keeps a body starting with -- as is
+			assert.True(t, bytes.HasPrefix(got.Body, []byte("--")))
+			assert.Equal(t, tt.wantParts, readParts(t, string(got.ContentType), got.Body))
+		})
+	}
+}
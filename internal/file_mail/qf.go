@@ -0,0 +1,198 @@
+package file_mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/utils"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	QfTransformerType = "qf"
+	// QfConfigArgEnvelope sets the from and to of the mail from the envelope,
+	// true by default
+	QfConfigArgEnvelope = "envelope"
+
+	// Metadata keys of the envelope
+	QfMetadataAttempts  = "qf-attempts"
+	QfMetadataPriority  = "qf-priority"
+	QfMetadataQueueTime = "qf-queue-time"
+)
+
+// QfTransformer parses the sendmail qf file of the mail. Each header is set in
+// the metadata for the header transformers, with the value of its first
+// occurrence, all of them are kept in RawHeaders, and the envelope sets the
+// from and to of the mail.
+type QfTransformer struct {
+	Cfg      config.FileMailConfig
+	Envelope bool
+}
+
+func (t *QfTransformer) Init(
+	ctx context.Context,
+	cfg config.FileMailConfig,
+) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("type", QfTransformerType).
+		Int("index", cfg.Index).
+		Interface("args", cfg.Args).
+		Logger()
+	logger.Debug().Msg("QfTransformer Init")
+	t.Cfg = cfg
+	t.Envelope = true
+	envelopeAny, ok := cfg.Args[QfConfigArgEnvelope]
+	if ok {
+		t.Envelope, ok = envelopeAny.(bool)
+		if !ok {
+			return fmt.Errorf("%s must be a boolean", QfConfigArgEnvelope)
+		}
+	}
+	return nil
+}
+
+func (t *QfTransformer) Index() int {
+	return t.Cfg.Index
+}
+
+func (t *QfTransformer) Transform(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	inMail *pmail.Mail,
+) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx).With().Str("qf_file_path", fileInfo.QfFilePath).Logger()
+	logger.Debug().Msg("QfTransformer")
+	if inMail == nil {
+		inMail = &pmail.Mail{}
+	}
+
+	// 1. read the qf file, or the qf of a queued mail
+	byteSlice, err := readQf(ctx, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo.Status = input.FILE_STATUS_HEADERS_READ
+
+	// 2. parse the records
+	queueFile, err := ParseQueueFile(bytes.NewReader(byteSlice))
+	if err != nil {
+		logger.Error().Err(err).Msg("ParseQueueFile")
+		return nil, err
+	}
+
+	// 3. the headers, where the first occurrence wins, as for Received
+	if inMail.Metadata == nil {
+		inMail.Metadata = make(map[string][]byte)
+	}
+	seen := make(map[string]bool)
+	for _, header := range queueFile.Headers {
+		if seen[header.Name] {
+			continue
+		}
+		seen[header.Name] = true
+		inMail.Metadata[header.Name] = header.Value
+	}
+	inMail.RawHeaders = formatHeaders(queueFile.Headers)
+
+	// 4. the envelope
+	envelope := queueFile.Envelope
+	if !envelope.QueueTime.IsZero() {
+		inMail.Metadata[QfMetadataQueueTime] = []byte(envelope.QueueTime.UTC().Format(time.RFC3339))
+	}
+	inMail.Metadata[QfMetadataAttempts] = []byte(strconv.Itoa(envelope.Attempts))
+	inMail.Metadata[QfMetadataPriority] = []byte(strconv.FormatInt(envelope.Priority, 10))
+	if t.Envelope {
+		if !envelope.Sender.IsZero() {
+			inMail.From = envelope.Sender
+		}
+		if len(envelope.Recipients) > 0 {
+			inMail.To = make([]smtp.Address, 0, len(envelope.Recipients))
+			for _, recipient := range envelope.Recipients {
+				inMail.To = append(inMail.To, recipient.Address)
+			}
+			// The recipients missing from the To and Cc headers are blind
+			// copies, which the headers of the mail must not list
+			inMail.Cc, inMail.Bcc = splitRecipients(inMail.To, queueFile)
+		}
+	}
+	fileInfo.Status = input.FILE_STATUS_HEADERS_PARSE
+
+	logger.Debug().
+		Int("headers", len(queueFile.Headers)).
+		Int("recipients", len(envelope.Recipients)).
+		Int("attempts", envelope.Attempts).
+		Msg("QfTransformer")
+	return inMail, nil
+}
+
+// readQf reads the qf reader of a queued mail, or the qf file
+func readQf(ctx context.Context, fileInfo *file.FileInfo) ([]byte, error) {
+	logger := zerolog.Ctx(ctx)
+	if fileInfo.QfReader != nil {
+		result, err := io.ReadAll(fileInfo.QfReader)
+		if err != nil {
+			logger.Error().Err(err).Msg("io.ReadAll")
+			return nil, err
+		}
+		return result, nil
+	}
+	if fileInfo.QfFilePath == "" {
+		logger.Error().Msg("ToSkip: fileInfo.QfFilePath is empty")
+		return nil, fmt.Errorf("ToSkip: fileInfo.QfFilePath is empty")
+	}
+	_, err := utils.ValidateIO(ctx, fileInfo.QfFilePath, true, false)
+	if err != nil {
+		logger.Error().Err(err).Msg("utils.ValidateIO")
+		return nil, err
+	}
+	result, err := os.ReadFile(fileInfo.QfFilePath)
+	if err != nil {
+		logger.Error().Err(err).Msg("os.ReadFile")
+		return nil, err
+	}
+	return result, nil
+}
+
+// splitRecipients returns the recipients listed in the Cc header, and the
+// recipients listed in neither the To nor the Cc header
+func splitRecipients(recipients []smtp.Address, queueFile *QueueFile) (cc []smtp.Address, bcc []smtp.Address) {
+	listed := func(name string) map[string]bool {
+		result := make(map[string]bool)
+		value, ok := queueFile.Header(name)
+		if !ok {
+			return result
+		}
+		addrs, err := mail.ParseAddressList(string(value))
+		if err != nil {
+			return result
+		}
+		for _, addr := range addrs {
+			result[strings.ToLower(addr.Address)] = true
+		}
+		return result
+	}
+	toListed := listed(input.HeaderToKey)
+	ccListed := listed(input.HeaderCcKey)
+	for _, recipient := range recipients {
+		key := strings.ToLower(recipient.String())
+		switch {
+		case ccListed[key]:
+			cc = append(cc, recipient)
+		case !toListed[key]:
+			bcc = append(bcc, recipient)
+		}
+	}
+	return cc, bcc
+}
@@ -0,0 +1,231 @@
+package file_mail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// Record types of a sendmail qf file, see the sendmail operations guide
+const (
+	QfRecordAttempts   = 'N'
+	QfRecordEnd        = '.'
+	QfRecordHeader     = 'H'
+	QfRecordMessage    = 'M'
+	QfRecordPriority   = 'P'
+	QfRecordQueueTime  = 'T'
+	QfRecordRecipient  = 'R'
+	QfRecordSender     = 'S'
+	QfRecordVersion    = 'V'
+	qfHeaderConditions = '?'
+)
+
+// QueueEnvelope is the envelope of a sendmail qf file
+type QueueEnvelope struct {
+	// Version is the qf file format version, from the V record
+	Version int
+
+	// Sender is the envelope sender, from the S record. It is empty for the
+	// null sender <>.
+	Sender smtp.Address
+
+	// Recipients are the envelope recipients, from the R records
+	Recipients []QueueRecipient
+
+	// QueueTime is when the message was queued, from the T record
+	QueueTime time.Time
+
+	// Attempts is the number of delivery attempts, from the N record
+	Attempts int
+
+	// Priority is the queue priority, from the P record. A lower value is
+	// delivered first.
+	Priority int64
+
+	// Message is the status message of the last attempt, from the M record
+	Message string
+}
+
+// QueueRecipient is an envelope recipient with its flags, e.g. "PFD" for a
+// primary address with failure and delay notifications
+type QueueRecipient struct {
+	Address smtp.Address
+	Flags   string
+}
+
+// QueueHeader is a header of a sendmail qf file. Condition holds the macro
+// condition between the question marks of H?condition?Name: value, and
+// Value is unfolded, without the leading space.
+type QueueHeader struct {
+	Condition string
+	Name      string
+	Value     []byte
+}
+
+// QueueFile is a parsed sendmail qf file
+type QueueFile struct {
+	Envelope QueueEnvelope
+
+	// Headers are in the order of the file, including repeated headers
+	Headers []QueueHeader
+}
+
+// Header returns the value of the first header with the name, ignoring case
+func (q *QueueFile) Header(name string) ([]byte, bool) {
+	for _, header := range q.Headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ParseQueueFile parses a sendmail qf file. Only the first colon of a header
+// separates its name, so values may hold colons, such as times and urls.
+// Record types not needed for delivery are ignored.
+//
+// Parameters:
+//   - r: The qf file content
+//
+// Returns:
+//   - *QueueFile: The envelope and the headers in order
+//   - error: Non-nil if a record is malformed
+func ParseQueueFile(r io.Reader) (*QueueFile, error) {
+	result := &QueueFile{}
+	scanner := bufio.NewScanner(r)
+	// Headers may be long, e.g. Received and DKIM-Signature
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lineNum := 0
+	// inHeader tells whether a continuation line belongs to a header
+	inHeader := false
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			inHeader = false
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if !inHeader {
+				// Continuation of a record that is not kept
+				continue
+			}
+			header := &result.Headers[len(result.Headers)-1]
+			header.Value = append(header.Value, line...)
+			continue
+		}
+		inHeader = false
+
+		recordType, value := line[0], line[1:]
+		var err error
+		switch recordType {
+		case QfRecordVersion:
+			result.Envelope.Version, err = strconv.Atoi(value)
+		case QfRecordQueueTime:
+			var seconds int64
+			seconds, err = strconv.ParseInt(value, 10, 64)
+			result.Envelope.QueueTime = time.Unix(seconds, 0)
+		case QfRecordAttempts:
+			result.Envelope.Attempts, err = strconv.Atoi(value)
+		case QfRecordPriority:
+			result.Envelope.Priority, err = strconv.ParseInt(value, 10, 64)
+		case QfRecordMessage:
+			result.Envelope.Message = value
+		case QfRecordSender:
+			result.Envelope.Sender, err = parseQfAddress(value)
+		case QfRecordRecipient:
+			var recipient QueueRecipient
+			recipient, err = parseQfRecipient(value, result.Envelope.Version)
+			result.Envelope.Recipients = append(result.Envelope.Recipients, recipient)
+		case QfRecordHeader:
+			var header QueueHeader
+			header, err = parseQfHeader(value)
+			result.Headers = append(result.Headers, header)
+			inHeader = true
+		case QfRecordEnd:
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("qf line %d: %c record: %w", lineNum, recordType, err)
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseQfAddress parses an address with or without angle brackets
+func parseQfAddress(value string) (smtp.Address, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+	if value == "" {
+		return smtp.Address{}, nil
+	}
+	return smtp.ParseAddress(value)
+}
+
+// parseQfRecipient parses the flags and the address of an R record. Since
+// version 4 the flags are separated from the address by a colon.
+func parseQfRecipient(value string, version int) (QueueRecipient, error) {
+	var result QueueRecipient
+	if version >= 4 || strings.Contains(value, ":") {
+		flags, address, found := strings.Cut(value, ":")
+		if !found {
+			return result, fmt.Errorf("no flags separator in %q", value)
+		}
+		result.Flags = flags
+		value = address
+	}
+	var err error
+	result.Address, err = parseQfAddress(value)
+	if err != nil {
+		return result, err
+	}
+	if result.Address.IsZero() {
+		return result, fmt.Errorf("empty recipient")
+	}
+	return result, nil
+}
+
+// formatHeaders returns the header section of the headers, one unfolded
+// header field per line
+func formatHeaders(headers []QueueHeader) []byte {
+	result := make([]byte, 0)
+	for _, header := range headers {
+		result = append(result, header.Name...)
+		result = append(result, ": "...)
+		result = append(result, header.Value...)
+		result = append(result, "\r\n"...)
+	}
+	return result
+}
+
+// parseQfHeader parses ?condition?Name: value
+func parseQfHeader(value string) (QueueHeader, error) {
+	var result QueueHeader
+	if len(value) > 0 && value[0] == qfHeaderConditions {
+		end := strings.IndexByte(value[1:], qfHeaderConditions)
+		if end < 0 {
+			return result, fmt.Errorf("unterminated condition in %q", value)
+		}
+		result.Condition = value[1 : end+1]
+		value = value[end+2:]
+	}
+	name, headerValue, found := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return result, fmt.Errorf("no header name in %q", value)
+	}
+	result.Name = name
+	result.Value = bytes.TrimLeft([]byte(headerValue), " \t")
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package file_mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueueFile(t *testing.T) {
+	const qf = "V8\n" +
+		"T1700000000\n" +
+		"K1700000100\n" +
+		"N2\n" +
+		"P30522\n" +
+		"I253/1/7340\n" +
+		"MDeferred: Connection timed out\n" +
+		"Fs\n" +
+		"$_localhost [127.0.0.1]\n" +
+		"S<sender@example.com>\n" +
+		"RPFD:first@example.org\n" +
+		"RPF:<second@example.org>\n" +
+		"H?P?Return-Path: <sender@example.com>\n" +
+		"H??Received: from localhost by mail.example.com;\n" +
+		"\tMon, 14 Nov 2023 22:13:20 +0000\n" +
+		"H??Received: from other by localhost\n" +
+		"H??Date: Mon, 14 Nov 2023 22:13:20 +0000\n" +
+		"H??From: Sender <sender@example.com>\n" +
+		"H??Subject: Meeting at 10:30, see https://example.com/agenda\n" +
+		"H?${MTAHost}?X-Host: mail\n" +
+		".\n"
+
+	got, err := ParseQueueFile(strings.NewReader(qf))
+	require.NoError(t, err)
+
+	envelope := got.Envelope
+	assert.Equal(t, 8, envelope.Version)
+	assert.Equal(t, time.Unix(1700000000, 0), envelope.QueueTime)
+	assert.Equal(t, 2, envelope.Attempts)
+	assert.Equal(t, int64(30522), envelope.Priority)
+	assert.Equal(t, "Deferred: Connection timed out", envelope.Message)
+	assert.Equal(t, "sender@example.com", envelope.Sender.String())
+	require.Len(t, envelope.Recipients, 2)
+	assert.Equal(t, "first@example.org", envelope.Recipients[0].Address.String())
+	assert.Equal(t, "PFD", envelope.Recipients[0].Flags)
+	assert.Equal(t, "second@example.org", envelope.Recipients[1].Address.String())
+	assert.Equal(t, "PF", envelope.Recipients[1].Flags)
+
+	wantHeaders := []QueueHeader{
+		{Condition: "P", Name: "Return-Path", Value: []byte("<sender@example.com>")},
+		{Name: "Received", Value: []byte("from localhost by mail.example.com;\tMon, 14 Nov 2023 22:13:20 +0000")},
+		{Name: "Received", Value: []byte("from other by localhost")},
+		{Name: "Date", Value: []byte("Mon, 14 Nov 2023 22:13:20 +0000")},
+		{Name: "From", Value: []byte("Sender <sender@example.com>")},
+		{Name: "Subject", Value: []byte("Meeting at 10:30, see https://example.com/agenda")},
+		{Condition: "${MTAHost}", Name: "X-Host", Value: []byte("mail")},
+	}
+	assert.Equal(t, wantHeaders, got.Headers)
+
+	value, ok := got.Header("received")
+	assert.True(t, ok)
+	assert.Equal(t, wantHeaders[1].Value, value)
+	_, ok = got.Header("To")
+	assert.False(t, ok)
+}
+
+func TestParseQueueFileRecords(t *testing.T) {
+	tests := []struct {
+		name           string
+		qf             string
+		wantErr        bool
+		wantSender     string
+		wantRecipients []string
+	}{
+		{
+			name:       "null sender",
+			qf:         "V8\nS<>\nRPFD:to@example.org\n",
+			wantSender: "",
+			wantRecipients: []string{
+				"to@example.org",
+			},
+		},
+		{
+			name:           "old version without flags",
+			qf:             "V2\nSsender@example.com\nRto@example.org\n",
+			wantSender:     "sender@example.com",
+			wantRecipients: []string{"to@example.org"},
+		},
+		{
+			name:    "recipient without flags separator",
+			qf:      "V8\nRto@example.org\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			qf:      "V8\nTnow\n",
+			wantErr: true,
+		},
+		{
+			name:    "header without colon",
+			qf:      "V8\nH??From sender@example.com\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated condition",
+			qf:      "V8\nH?P Return-Path: <>\n",
+			wantErr: true,
+		},
+		{
+			name:       "records after end are ignored",
+			qf:         "V8\nSsender@example.com\n.\nSother@example.com\n",
+			wantSender: "sender@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQueueFile(strings.NewReader(tt.qf))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantSender == "" {
+				assert.True(t, got.Envelope.Sender.IsZero())
+			} else {
+				assert.Equal(t, tt.wantSender, got.Envelope.Sender.String())
+			}
+			gotRecipients := make([]string, 0)
+			for _, recipient := range got.Envelope.Recipients {
+				gotRecipients = append(gotRecipients, recipient.Address.String())
+			}
+			if tt.wantRecipients == nil {
+				tt.wantRecipients = []string{}
+			}
+			assert.Equal(t, tt.wantRecipients, gotRecipients)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package file_mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQfTransformer(t *testing.T) {
+	const qf = "V8\n" +
+		"T1700000000\n" +
+		"N1\n" +
+		"P120\n" +
+		"S<sender@example.com>\n" +
+		"RPFD:to@example.org\n" +
+		"H??Received: from a by b\n" +
+		"H??Received: from c by d\n" +
+		"H??From: Sender <header@example.com>\n" +
+		"H??Subject: Lunch at 12:30\n" +
+		".\n"
+
+	tests := []struct {
+		name           string
+		cfg            config.FileMailConfig
+		qf             string
+		wantMetadata   map[string][]byte
+		wantRawHeaders []byte
+		wantFrom       string
+		wantTo         []string
+		wantErr        bool
+	}{
+		{
+			name: "happy",
+			cfg:  config.FileMailConfig{Type: QfTransformerType},
+			qf:   qf,
+			wantMetadata: map[string][]byte{
+				"Received":          []byte("from a by b"),
+				"From":              []byte("Sender <header@example.com>"),
+				"Subject":           []byte("Lunch at 12:30"),
+				QfMetadataAttempts:  []byte("1"),
+				QfMetadataPriority:  []byte("120"),
+				QfMetadataQueueTime: []byte("2023-11-14T22:13:20Z"),
+			},
+			wantRawHeaders: []byte("Received: from a by b\r\n" +
+				"Received: from c by d\r\n" +
+				"From: Sender <header@example.com>\r\n" +
+				"Subject: Lunch at 12:30\r\n"),
+			wantFrom: "sender@example.com",
+			wantTo:   []string{"to@example.org"},
+		},
+		{
+			name: "without envelope",
+			cfg: config.FileMailConfig{
+				Type: QfTransformerType,
+				Args: map[string]any{QfConfigArgEnvelope: false},
+			},
+			qf: "V8\nS<sender@example.com>\nRPFD:to@example.org\nH??Subject: test\n",
+			wantMetadata: map[string][]byte{
+				"Subject":          []byte("test"),
+				QfMetadataAttempts: []byte("0"),
+				QfMetadataPriority: []byte("0"),
+			},
+			wantRawHeaders: []byte("Subject: test\r\n"),
+		},
+		{
+			name:    "malformed",
+			cfg:     config.FileMailConfig{Type: QfTransformerType},
+			qf:      "V8\nRto@example.org\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+
+			transformer := &QfTransformer{}
+			err := transformer.Init(ctx, tt.cfg)
+			require.NoError(t, err)
+
+			tmpFile := filepath.Join(t.TempDir(), "qf001")
+			err = os.WriteFile(tmpFile, []byte(tt.qf), 0644)
+			require.NoError(t, err)
+			fileInfo := &file.FileInfo{
+				QfFilePath: tmpFile,
+			}
+
+			got, err := transformer.Transform(ctx, fileInfo, &pmail.Mail{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMetadata, got.Metadata)
+			assert.Equal(t, tt.wantRawHeaders, got.RawHeaders)
+			if tt.wantFrom == "" {
+				assert.True(t, got.From.IsZero())
+			} else {
+				assert.Equal(t, tt.wantFrom, got.From.String())
+			}
+			var gotTo []string
+			for _, to := range got.To {
+				gotTo = append(gotTo, to.String())
+			}
+			assert.Equal(t, tt.wantTo, gotTo)
+		})
+	}
+}
+
+func TestQfTransformerInit(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	transformer := &QfTransformer{}
+	err := transformer.Init(ctx, config.FileMailConfig{
+		Type: QfTransformerType,
+		Args: map[string]any{QfConfigArgEnvelope: "yes"},
+	})
+	assert.Error(t, err)
+
+	// The qf transformer is registered in the factory
+	factory := NewMailTransformerFactory(ctx, nil)
+	got, err := factory.NewMailTransformer(ctx, config.FileMailConfig{Type: QfTransformerType, Index: 1})
+	require.NoError(t, err)
+	assert.IsType(t, &QfTransformer{}, got)
+	assert.Equal(t, 1, got.Index())
+}
+
+func TestQfTransformerRecipients(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	transformer := &QfTransformer{}
+	require.NoError(t, transformer.Init(ctx, config.FileMailConfig{Type: QfTransformerType}))
+
+	// The qf of a queued mail is read from its reader
+	got, err := transformer.Transform(ctx, &file.FileInfo{
+		ID: "abc",
+		QfReader: strings.NewReader("V8\n" +
+			"S<sender@example.com>\n" +
+			"RPFD:<to@example.org>\n" +
+			"RPFD:<cc@example.org>\n" +
+			"RPFD:<bcc@example.org>\n" +
+			"H??To: To <TO@example.org>\n" +
+			"H??Cc: cc@example.org\n" +
+			".\n"),
+	}, &pmail.Mail{})
+	require.NoError(t, err)
+	addresses := func(addrs []smtp.Address) []string {
+		result := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			result = append(result, addr.String())
+		}
+		return result
+	}
+	assert.Equal(t, []string{"to@example.org", "cc@example.org", "bcc@example.org"}, addresses(got.To))
+	assert.Equal(t, []string{"cc@example.org"}, addresses(got.Cc))
+	assert.Equal(t, []string{"bcc@example.org"}, addresses(got.Bcc))
+}
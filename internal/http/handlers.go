@@ -1,11 +1,18 @@
 package http
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// BearerPrefix prefixes the token of the Authorization header
+	BearerPrefix = "Bearer "
+)
+
 func HandleAuth(
 	c *gin.Context,
 ) {
@@ -15,3 +22,34 @@ func HandleAuth(
 	}
 	c.Next()
 }
+
+// NewBearerAuth returns a handler that accepts the requests with one of the
+// tokens as the bearer token of their Authorization header
+func NewBearerAuth(tokens []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.Request.Header.Get("Authorization"), BearerPrefix)
+		if ok {
+			for _, want := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="remiges-smtp"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+	}
+}
+
+// NewLimitRequestSize returns a handler that limits the size of the request
+// bodies, reading more than limit bytes fails with *http.MaxBytesError
+func NewLimitRequestSize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: "request too large"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,493 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/output"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/mailjob"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	// ContentTypeRFC822 is the content type of a raw RFC 5322 message
+	ContentTypeRFC822 = "message/rfc822"
+	// JobFileExt is the extension of the jobs written by the api
+	JobFileExt = ".json"
+	// StatusQueued is the status of a message that is not read yet
+	StatusQueued = "QUEUED"
+)
+
+// ErrBatchTooLarge is returned for a batch of more than max-batch messages
+var ErrBatchTooLarge = errors.New("batch too large")
+
+// ErrorResponse is the body of the failed requests, with the errors of the
+// fields of an invalid message
+type ErrorResponse struct {
+	Error  string             `json:"error"`
+	Fields []pmail.FieldError `json:"fields,omitempty"`
+}
+
+// MessageResponse is the id of an accepted message, and its Message-ID
+type MessageResponse struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+}
+
+// BatchResponse lists the accepted messages of a batch, in order. When
+// only the first messages are accepted, Error tells why the others are not.
+type BatchResponse struct {
+	Messages []MessageResponse `json:"messages"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// StatusResponse is the status of a message, and the results of each
+// recipient once it is sent
+type StatusResponse struct {
+	ID         string                              `json:"id"`
+	Status     string                              `json:"status"`
+	MessageID  string                              `json:"message_id,omitempty"`
+	Recipients map[string][]output.RecipientResult `json:"recipients,omitempty"`
+	Updated    *time.Time                          `json:"updated,omitempty"`
+}
+
+// spoolEntry is a message accepted by the api, to write in the spool
+type spoolEntry struct {
+	id        string
+	messageID string
+	ext       string
+	data      []byte
+}
+
+// MessagesHandler serves the messages api, which writes each accepted
+// message atomically into the input directory, or enqueues it for the
+// workers, and reports its status from the tracker and the results output.
+type MessagesHandler struct {
+	Cfg         config.APIConfig
+	InPath      string
+	InputType   string
+	FileQueue   file.IFileQueue
+	Tracker     file.IFileReadTracker
+	ResultStore output.IResultStore
+}
+
+// NewMessagesHandler creates a new instance of MessagesHandler.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - cfg: The api configuration
+//   - inPath: The input directory of the spool mode
+//   - inputType: The input type, which reads the messages of the api
+//   - fileQueue: The queue of the queue mode, nil in the spool mode
+//   - tracker: The tracker of the status of the messages
+//   - resultStore: The store of the results of the messages
+//
+// Returns:
+//   - *MessagesHandler: A new handler instance
+//   - error: Non-nil if the queue mode has no queue
+func NewMessagesHandler(
+	ctx context.Context,
+	cfg config.APIConfig,
+	inPath string,
+	inputType string,
+	fileQueue file.IFileQueue,
+	tracker file.IFileReadTracker,
+	resultStore output.IResultStore,
+) (*MessagesHandler, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("inPath", inPath).
+		Str("mode", cfg.Mode).
+		Msg("NewMessagesHandler")
+	if cfg.Mode == config.APIModeQueue && fileQueue == nil {
+		return nil, errors.New("the queue mode of the api requires a queue")
+	}
+	return &MessagesHandler{
+		Cfg:         cfg,
+		InPath:      inPath,
+		InputType:   inputType,
+		FileQueue:   fileQueue,
+		Tracker:     tracker,
+		ResultStore: resultStore,
+	}, nil
+}
+
+// RegisterMessageRoutes registers the messages api, behind the bearer
+// tokens and the request size limit of the configuration
+//
+//	POST /v1/messages       a mail job, an array of mail jobs, or a message/rfc822 message
+//	GET  /v1/messages/:id   the status and the results of a message
+func RegisterMessageRoutes(
+	_ context.Context,
+	engine *gin.Engine,
+	handler *MessagesHandler,
+) error {
+	group := engine.Group("/v1/messages",
+		NewBearerAuth(handler.Cfg.Tokens),
+		NewLimitRequestSize(handler.Cfg.MaxRequestSize),
+	)
+	group.POST("", handler.PostMessages)
+	group.GET("/:id", handler.GetMessage)
+	return nil
+}
+
+// PostMessages accepts a mail job, a batch of mail jobs as a JSON array,
+// or a raw RFC 5322 message with the message/rfc822 content type. A batch
+// is only accepted when every job is valid. When only the first messages of
+// a batch could be written, they are listed with a 207, so that they are not
+// sent again.
+func (h *MessagesHandler) PostMessages(c *gin.Context) {
+	logger := zerolog.Ctx(c.Request.Context())
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: "request too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	mediaType := "application/json"
+	if contentType := c.ContentType(); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	var entries []spoolEntry
+	var fieldErrors []pmail.FieldError
+	batch := false
+	switch mediaType {
+	case ContentTypeRFC822:
+		var entry spoolEntry
+		entry, fieldErrors = h.rawEntry(data)
+		entries = []spoolEntry{entry}
+	case "application/json":
+		if h.InputType != config.InputTypeJob {
+			c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{
+				Error: fmt.Sprintf("mail jobs require the %s input, send %s messages",
+					config.InputTypeJob, ContentTypeRFC822),
+			})
+			return
+		}
+		batch = bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+		entries, fieldErrors, err = h.jobEntries(data, batch)
+		if errors.Is(err, ErrBatchTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("PostMessages: jobEntries")
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "cannot read the message"})
+			return
+		}
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{
+			Error: fmt.Sprintf("unsupported content type %s", mediaType),
+		})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Error:  "invalid message",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	accepted, err := h.write(c.Request.Context(), entries)
+	responses := make([]MessageResponse, 0, accepted)
+	for _, entry := range entries[:accepted] {
+		responses = append(responses, MessageResponse{ID: entry.id, MessageID: entry.messageID})
+	}
+	if err != nil {
+		logger.Error().Err(err).Int("accepted", accepted).Msg("PostMessages: write")
+		if accepted == 0 {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "cannot write the message"})
+			return
+		}
+		c.JSON(http.StatusMultiStatus, BatchResponse{
+			Messages: responses,
+			Error:    fmt.Sprintf("cannot write the messages from [%d], send them again", accepted),
+		})
+		return
+	}
+	if batch {
+		c.JSON(http.StatusAccepted, BatchResponse{Messages: responses})
+		return
+	}
+	c.JSON(http.StatusAccepted, responses[0])
+}
+
+// GetMessage returns the status of a message from the tracker, and the
+// results of its recipients from the results output.
+func (h *MessagesHandler) GetMessage(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger := zerolog.Ctx(ctx)
+	id := c.Param("id")
+	// The api only assigns uuids, which are also safe file names
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "message not found"})
+		return
+	}
+
+	status, err := h.Tracker.FileRead(ctx, id)
+	if err != nil {
+		logger.Error().Err(err).Str("id", id).Msg("GetMessage: FileRead")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "cannot read the status"})
+		return
+	}
+	result, err := h.ResultStore.Get(ctx, id)
+	if err != nil {
+		logger.Error().Err(err).Str("id", id).Msg("GetMessage: ResultStore.Get")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "cannot read the results"})
+		return
+	}
+
+	response := StatusResponse{ID: id, Status: status.String()}
+	if status == input.FILE_STATUS_NOT_FOUND && result == nil {
+		if !h.pending(id) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "message not found"})
+			return
+		}
+		response.Status = StatusQueued
+	}
+	if result != nil {
+		response.MessageID = result.MessageID
+		response.Recipients = result.Recipients
+		response.Updated = &result.Updated
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// rawEntry checks that a raw message has the headers read by the eml input,
+// and adds a Message-ID when it has none
+func (h *MessagesHandler) rawEntry(data []byte) (spoolEntry, []pmail.FieldError) {
+	entry := spoolEntry{id: uuid.NewString(), ext: file.EMLFileExt}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return entry, []pmail.FieldError{{Message: fmt.Sprintf("invalid message: %v", err)}}
+	}
+	fieldErrors := make([]pmail.FieldError, 0)
+	var from *mail.Address
+	for _, name := range []string{input.HeaderFromKey, input.HeaderToKey} {
+		addrs, err := msg.Header.AddressList(name)
+		if err != nil || len(addrs) == 0 {
+			fieldErrors = append(fieldErrors, pmail.FieldError{Field: name, Message: "a valid address is required"})
+			continue
+		}
+		if name == input.HeaderFromKey {
+			from = addrs[0]
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return entry, fieldErrors
+	}
+
+	entry.messageID = msg.Header.Get(input.HeaderMsgIDKey)
+	entry.data = data
+	if entry.messageID == "" {
+		entry.messageID = newMessageID(entry.id, from.Address)
+		entry.data = append([]byte(input.HeaderMsgIDKey+": "+entry.messageID+"\r\n"), data...)
+	}
+	return entry, nil
+}
+
+// jobEntries validates a job, or each job of a batch, where the errors of
+// the fields of a batch are prefixed with the index of the job. The
+// attachments of the api are given as their content, never a path on the
+// server.
+func (h *MessagesHandler) jobEntries(data []byte, batch bool) ([]spoolEntry, []pmail.FieldError, error) {
+	rawJobs := []json.RawMessage{data}
+	if batch {
+		err := json.Unmarshal(data, &rawJobs)
+		if err != nil {
+			return nil, []pmail.FieldError{{Message: fmt.Sprintf("invalid batch: %v", err)}}, nil
+		}
+		if len(rawJobs) == 0 {
+			return nil, []pmail.FieldError{{Message: "the batch is empty"}}, nil
+		}
+		if len(rawJobs) > h.Cfg.MaxBatch {
+			return nil, nil, fmt.Errorf("%w: %d messages, the limit is %d",
+				ErrBatchTooLarge, len(rawJobs), h.Cfg.MaxBatch)
+		}
+	}
+
+	entries := make([]spoolEntry, 0, len(rawJobs))
+	fieldErrors := make([]pmail.FieldError, 0)
+	for i, rawJob := range rawJobs {
+		prefix := ""
+		if batch {
+			prefix = fmt.Sprintf("[%d]", i)
+		}
+		addErrors := func(errs []pmail.FieldError) {
+			for _, fieldError := range errs {
+				if prefix != "" {
+					fieldError.Field = prefixField(prefix, fieldError.Field)
+				}
+				fieldErrors = append(fieldErrors, fieldError)
+			}
+		}
+
+		job, err := mailjob.Parse(JobFileExt, rawJob)
+		if err != nil {
+			var rejected *pmail.RejectedError
+			if !errors.As(err, &rejected) {
+				return nil, nil, err
+			}
+			addErrors(rejected.Fields)
+			continue
+		}
+		pathErrors := make([]pmail.FieldError, 0)
+		for j, attachment := range job.Attachments {
+			if attachment.Path != "" {
+				pathErrors = append(pathErrors, pmail.FieldError{
+					Field:   fmt.Sprintf("attachments[%d].path", j),
+					Message: "not allowed in the api, set the content instead",
+				})
+			}
+		}
+		if len(pathErrors) > 0 {
+			addErrors(pathErrors)
+			continue
+		}
+
+		entry := spoolEntry{id: uuid.NewString(), ext: JobFileExt}
+		if job.Options.MessageID == "" {
+			job.Options.MessageID = newMessageID(entry.id, job.FromAddress.String())
+		}
+		entry.messageID = job.Options.MessageID
+		entry.data, err = json.Marshal(job)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, fieldErrors, nil
+}
+
+// write writes the messages atomically into the input directory, or
+// enqueues them in the queue mode. Every message is first written to a
+// hidden file that the readers skip, and they are only renamed once all are
+// written, so that a failure leaves none of them behind.
+//
+// Parameters:
+//   - ctx: The context of the request
+//   - entries: The messages to write, in order
+//
+// Returns:
+//   - int: The number of messages accepted, the first ones of entries
+//   - error: Non-nil if not every message is accepted
+func (h *MessagesHandler) write(ctx context.Context, entries []spoolEntry) (int, error) {
+	if h.Cfg.Mode == config.APIModeQueue {
+		for i, entry := range entries {
+			err := h.FileQueue.Enqueue(ctx, &file.FileInfo{
+				DfFilePath: filepath.Join(h.InPath, entry.id+entry.ext),
+				DfReader:   bytes.NewReader(entry.data),
+				ID:         entry.id,
+			})
+			if err != nil {
+				return i, err
+			}
+		}
+		return len(entries), nil
+	}
+
+	tmpPaths := make([]string, 0, len(entries))
+	defer func() {
+		// Nothing is left behind on failure, after the rename it is gone
+		for _, tmpPath := range tmpPaths {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	for _, entry := range entries {
+		tmpPath, err := h.writeTemp(entry)
+		if err != nil {
+			return 0, err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+	for i, entry := range entries {
+		err := os.Rename(tmpPaths[i], filepath.Join(h.InPath, entry.id+entry.ext))
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// writeTemp writes and syncs a message into a hidden file of the input
+// directory, and returns its path
+func (h *MessagesHandler) writeTemp(entry spoolEntry) (string, error) {
+	tmpFile, err := os.CreateTemp(h.InPath, "."+entry.id+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmpFile.Write(entry.data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+// pending tells whether a message was accepted, but not read yet
+func (h *MessagesHandler) pending(id string) bool {
+	if h.Cfg.Mode == config.APIModeQueue {
+		return h.FileQueue.Queued(id)
+	}
+	for _, ext := range []string{JobFileExt, file.EMLFileExt} {
+		_, err := os.Stat(filepath.Join(h.InPath, id+ext))
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newMessageID returns the Message-ID of a message without one, at the
+// domain of its sender
+func newMessageID(id string, from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", id, domain)
+}
+
+// prefixField prefixes the field of a job with its index in the batch
+func prefixField(prefix string, field string) string {
+	if field == "" {
+		return prefix
+	}
+	return prefix + "." + field
+}
@@ -0,0 +1,456 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/output"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/input"
+	"github.com/stlimtat/remiges-smtp/pkg/mailjob"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+const testToken = "secret"
+
+func newTestEngine(t *testing.T, handler *MessagesHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	require.NoError(t, RegisterMessageRoutes(context.Background(), engine, handler))
+	return engine
+}
+
+func doRequest(engine *gin.Engine, method string, path string, token string, contentType string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", BearerPrefix+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestPostMessages(t *testing.T) {
+	validJob := `{"from": "sender@example.com", "to": ["john@example.org"], "subject": "test", "text": "body"}`
+	tests := []struct {
+		name        string
+		inputType   string
+		token       string
+		contentType string
+		body        string
+		wantStatus  int
+		wantFiles   []string
+		wantFields  []pmail.FieldError
+	}{
+		{
+			name:        "no token",
+			inputType:   config.InputTypeJob,
+			contentType: "application/json",
+			body:        validJob,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "wrong token",
+			inputType:   config.InputTypeJob,
+			token:       "other",
+			contentType: "application/json",
+			body:        validJob,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "job",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json; charset=utf-8",
+			body:        validJob,
+			wantStatus:  http.StatusAccepted,
+			wantFiles:   []string{JobFileExt},
+		},
+		{
+			name:        "batch",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json",
+			body:        "[" + validJob + "," + validJob + "]",
+			wantStatus:  http.StatusAccepted,
+			wantFiles:   []string{JobFileExt, JobFileExt},
+		},
+		{
+			name:        "batch too large",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json",
+			body:        "[" + validJob + "," + validJob + "," + validJob + "]",
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "invalid batch",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json",
+			body: "[" + validJob + "," +
+				`{"from": "sender@example.com", "to": ["jane"], "subject": "test", "text": "body",` +
+				`"attachments": [{"path": "/etc/passwd"}]}]`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []pmail.FieldError{
+				{Field: "[1].to[0]", Message: `invalid address "jane"`},
+			},
+		},
+		{
+			name:        "attachment path",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json",
+			body: `{"from": "sender@example.com", "to": ["john@example.org"], "subject": "test", "text": "body",` +
+				`"attachments": [{"path": "/etc/passwd"}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []pmail.FieldError{
+				{Field: "attachments[0].path", Message: "not allowed in the api, set the content instead"},
+			},
+		},
+		{
+			name:        "job with the eml input",
+			inputType:   config.InputTypeEML,
+			token:       testToken,
+			contentType: "application/json",
+			body:        validJob,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "raw message",
+			inputType:   config.InputTypeEML,
+			token:       testToken,
+			contentType: ContentTypeRFC822,
+			body:        "From: sender@example.com\r\nTo: john@example.org\r\nSubject: test\r\n\r\nbody\r\n",
+			wantStatus:  http.StatusAccepted,
+			wantFiles:   []string{file.EMLFileExt},
+		},
+		{
+			name:        "raw message without recipient",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: ContentTypeRFC822,
+			body:        "From: sender@example.com\r\nSubject: test\r\n\r\nbody\r\n",
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantFields: []pmail.FieldError{
+				{Field: input.HeaderToKey, Message: "a valid address is required"},
+			},
+		},
+		{
+			name:        "unsupported content type",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "text/plain",
+			body:        "hello",
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "too large",
+			inputType:   config.InputTypeJob,
+			token:       testToken,
+			contentType: "application/json",
+			body:        `{"text": "` + strings.Repeat("a", 1024) + `"}`,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			inPath := t.TempDir()
+			handler, err := NewMessagesHandler(
+				ctx,
+				config.APIConfig{
+					Tokens:         []string{testToken},
+					Mode:           config.APIModeSpool,
+					MaxRequestSize: 1024,
+					MaxBatch:       2,
+				},
+				inPath, tt.inputType, nil, nil, nil,
+			)
+			require.NoError(t, err)
+			engine := newTestEngine(t, handler)
+
+			recorder := doRequest(engine, http.MethodPost, "/v1/messages", tt.token, tt.contentType, tt.body)
+			assert.Equal(t, tt.wantStatus, recorder.Code, recorder.Body.String())
+			if tt.wantFields != nil {
+				var got ErrorResponse
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+				assert.Equal(t, tt.wantFields, got.Fields)
+			}
+
+			entries, err := os.ReadDir(inPath)
+			require.NoError(t, err)
+			gotFiles := make([]string, 0)
+			for _, entry := range entries {
+				gotFiles = append(gotFiles, filepath.Ext(entry.Name()))
+			}
+			if tt.wantFiles == nil {
+				assert.Empty(t, gotFiles)
+				return
+			}
+			assert.Equal(t, tt.wantFiles, gotFiles)
+
+			var got []MessageResponse
+			if strings.HasPrefix(tt.body, "[") {
+				var batch BatchResponse
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &batch))
+				got = batch.Messages
+			} else {
+				var single MessageResponse
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &single))
+				got = []MessageResponse{single}
+			}
+			for i, message := range got {
+				data, err := os.ReadFile(filepath.Join(inPath, message.ID+tt.wantFiles[i]))
+				require.NoError(t, err)
+				assert.Equal(t, "<"+message.ID+"@example.com>", message.MessageID)
+				if tt.wantFiles[i] == JobFileExt {
+					job, err := mailjob.Parse(JobFileExt, data)
+					require.NoError(t, err)
+					assert.Equal(t, message.MessageID, job.Options.MessageID)
+					continue
+				}
+				assert.True(t, strings.HasPrefix(string(data), input.HeaderMsgIDKey+": "+message.MessageID+"\r\n"))
+			}
+		})
+	}
+}
+
+func TestPostMessagesQueue(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	queue := file.NewMockIFileQueue(ctrl)
+	var queued *file.FileInfo
+	queue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileInfo *file.FileInfo) error {
+			queued = fileInfo
+			return nil
+		})
+
+	cfg := config.APIConfig{Tokens: []string{testToken}, Mode: config.APIModeQueue, MaxRequestSize: 1024, MaxBatch: 1}
+	_, err := NewMessagesHandler(ctx, cfg, "/in", config.InputTypeJob, nil, nil, nil)
+	assert.Error(t, err)
+	handler, err := NewMessagesHandler(ctx, cfg, "/in", config.InputTypeJob, queue, nil, nil)
+	require.NoError(t, err)
+	engine := newTestEngine(t, handler)
+
+	recorder := doRequest(engine, http.MethodPost, "/v1/messages", testToken, "",
+		`{"from": "sender@example.com", "to": ["john@example.org"], "subject": "test", "text": "body"}`)
+	require.Equal(t, http.StatusAccepted, recorder.Code, recorder.Body.String())
+	var got MessageResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+	require.NotNil(t, queued)
+	assert.Equal(t, got.ID, queued.ID)
+	assert.Equal(t, filepath.Join("/in", got.ID+JobFileExt), queued.DfFilePath)
+	data, err := io.ReadAll(queued.DfReader)
+	require.NoError(t, err)
+	_, err = mailjob.Parse(JobFileExt, data)
+	assert.NoError(t, err)
+}
+
+func TestMessagesHandlerWrite(t *testing.T) {
+	tests := []struct {
+		name         string
+		ids          []string
+		busy         string
+		wantAccepted int
+		wantErr      bool
+		wantFiles    []string
+	}{
+		{
+			name:         "all written",
+			ids:          []string{"first", "second"},
+			wantAccepted: 2,
+			wantFiles:    []string{"first" + JobFileExt, "second" + JobFileExt},
+		},
+		{
+			name:    "none written when one cannot be staged",
+			ids:     []string{"first", "invalid/id"},
+			wantErr: true,
+		},
+		{
+			name:         "first written when the second cannot be renamed",
+			ids:          []string{"first", "second"},
+			busy:         "second" + JobFileExt,
+			wantAccepted: 1,
+			wantErr:      true,
+			wantFiles:    []string{"first" + JobFileExt, "second" + JobFileExt},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			inPath := t.TempDir()
+			if tt.busy != "" {
+				// A directory which is not empty cannot be replaced
+				require.NoError(t, os.MkdirAll(filepath.Join(inPath, tt.busy, "busy"), 0o700))
+			}
+			handler := &MessagesHandler{
+				Cfg:    config.APIConfig{Mode: config.APIModeSpool},
+				InPath: inPath,
+			}
+			entries := make([]spoolEntry, 0, len(tt.ids))
+			for _, id := range tt.ids {
+				entries = append(entries, spoolEntry{id: id, ext: JobFileExt, data: []byte("{}")})
+			}
+
+			accepted, err := handler.write(ctx, entries)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAccepted, accepted)
+
+			// No hidden file is left behind
+			dirEntries, err := os.ReadDir(inPath)
+			require.NoError(t, err)
+			gotFiles := make([]string, 0)
+			for _, dirEntry := range dirEntries {
+				gotFiles = append(gotFiles, dirEntry.Name())
+			}
+			if tt.wantFiles == nil {
+				assert.Empty(t, gotFiles)
+				return
+			}
+			assert.Equal(t, tt.wantFiles, gotFiles)
+		})
+	}
+}
+
+func TestPostMessagesQueuePartial(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	queue := file.NewMockIFileQueue(ctrl)
+	var queued *file.FileInfo
+	first := queue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileInfo *file.FileInfo) error {
+			queued = fileInfo
+			return nil
+		})
+	queue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(assert.AnError).After(first)
+
+	cfg := config.APIConfig{Tokens: []string{testToken}, Mode: config.APIModeQueue, MaxRequestSize: 1024, MaxBatch: 2}
+	handler, err := NewMessagesHandler(ctx, cfg, "/in", config.InputTypeJob, queue, nil, nil)
+	require.NoError(t, err)
+	engine := newTestEngine(t, handler)
+
+	validJob := `{"from": "sender@example.com", "to": ["john@example.org"], "subject": "test", "text": "body"}`
+	recorder := doRequest(engine, http.MethodPost, "/v1/messages", testToken, "", "["+validJob+","+validJob+"]")
+	require.Equal(t, http.StatusMultiStatus, recorder.Code, recorder.Body.String())
+	var got BatchResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+	require.Len(t, got.Messages, 1)
+	require.NotNil(t, queued)
+	assert.Equal(t, queued.ID, got.Messages[0].ID)
+	assert.Contains(t, got.Error, "[1]")
+}
+
+func TestGetMessage(t *testing.T) {
+	doneID := uuid.NewString()
+	spooledID := uuid.NewString()
+	missingID := uuid.NewString()
+	result := &output.MessageResult{
+		ID:        doneID,
+		MessageID: "<" + doneID + "@example.com>",
+		Recipients: map[string][]output.RecipientResult{
+			"john@example.org": {{Code: 250, Line: "250 2.0.0 OK"}},
+		},
+	}
+	tests := []struct {
+		name       string
+		id         string
+		status     input.FileStatus
+		result     *output.MessageResult
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "done",
+			id:         doneID,
+			status:     input.FILE_STATUS_DONE,
+			result:     result,
+			wantCode:   http.StatusOK,
+			wantStatus: "DONE",
+		},
+		{
+			name:       "processing",
+			id:         doneID,
+			status:     input.FILE_STATUS_PROCESSING,
+			wantCode:   http.StatusOK,
+			wantStatus: "PROCESSING",
+		},
+		{
+			name:       "queued",
+			id:         spooledID,
+			status:     input.FILE_STATUS_NOT_FOUND,
+			wantCode:   http.StatusOK,
+			wantStatus: StatusQueued,
+		},
+		{
+			name:     "not found",
+			id:       missingID,
+			status:   input.FILE_STATUS_NOT_FOUND,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "not an id",
+			id:       "..",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			tracker := file.NewMockIFileReadTracker(ctrl)
+			resultStore := output.NewMockIResultStore(ctrl)
+			// Only the ids assigned by the api are looked up
+			if _, err := uuid.Parse(tt.id); err == nil {
+				tracker.EXPECT().FileRead(gomock.Any(), tt.id).Return(tt.status, nil)
+				resultStore.EXPECT().Get(gomock.Any(), tt.id).Return(tt.result, nil)
+			}
+			inPath := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(inPath, spooledID+JobFileExt), []byte("{}"), 0o600))
+
+			handler, err := NewMessagesHandler(
+				ctx,
+				config.APIConfig{Tokens: []string{testToken}, Mode: config.APIModeSpool, MaxRequestSize: 1024},
+				inPath, config.InputTypeJob, nil, tracker, resultStore,
+			)
+			require.NoError(t, err)
+			engine := newTestEngine(t, handler)
+
+			recorder := doRequest(engine, http.MethodGet, "/v1/messages/"+tt.id, testToken, "", "")
+			assert.Equal(t, tt.wantCode, recorder.Code, recorder.Body.String())
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var got StatusResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &got))
+			assert.Equal(t, tt.id, got.ID)
+			assert.Equal(t, tt.wantStatus, got.Status)
+			if tt.result != nil {
+				assert.Equal(t, tt.result.MessageID, got.MessageID)
+				assert.Equal(t, tt.result.Recipients, got.Recipients)
+			}
+		})
+	}
+}
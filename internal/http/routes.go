@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func RegisterAdminRoutes(
@@ -15,5 +16,7 @@ func RegisterAdminRoutes(
 		"foo": "bar",
 	}))
 	pprof.RouteRegister(debugGroup, "pprof")
+	// Exposes the mail delivery and dns cache metrics
+	debugGroup.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	return nil
 }
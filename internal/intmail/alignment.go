@@ -0,0 +1,311 @@
+package intmail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	moxDkim "github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/spf"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	AlignmentProcessorType = "alignment"
+
+	// Metadata keys set in tag mode
+	AlignmentResultKey = "Alignment-Result"
+	AlignmentDetailKey = "Alignment-Detail"
+
+	AlignmentResultPass = "pass"
+	AlignmentResultFail = "fail"
+)
+
+// AlignmentResult is the outcome of the SPF and DMARC evaluation of a domain
+type AlignmentResult struct {
+	Pass      bool
+	SPF       spf.Status
+	DMARC     dmarc.Status
+	Detail    string
+	Temporary bool
+}
+
+type alignmentCacheEntry struct {
+	result  AlignmentResult
+	expires time.Time
+}
+
+// AlignmentProcessor checks, before sending, that SPF of the From domain
+// authorizes the source IPs and that DMARC passes aligned, given the DKIM
+// domains the mail will be signed with: dkim-domains, else the signing domain
+// of the dkim processor, else none. Depending on the mode, a failing mail
+// is rejected, tagged in its metadata or only logged.
+// Results are cached per domain for the configured cache-ttl.
+type AlignmentProcessor struct {
+	AlignmentCfg config.AlignmentConfig
+	Cfg          config.MailProcessorConfig
+	Resolver     moxDns.Resolver
+	Signer       IDKIMSigner
+	SLogger      *slog.Logger
+
+	cache map[string]alignmentCacheEntry
+	// mutex only protects the cache, the lookups are done without it
+	mutex sync.Mutex
+	// group coalesces concurrent evaluations of the same domain
+	group singleflight.Group
+	now   func() time.Time
+}
+
+func (p *AlignmentProcessor) Init(
+	ctx context.Context,
+	cfg config.MailProcessorConfig,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("AlignmentProcessor Init")
+	p.Cfg = cfg
+
+	decoder, err := mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			Metadata:   nil,
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			Result:     &p.AlignmentCfg,
+		},
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("AlignmentProcessor: NewDecoder")
+		return err
+	}
+	err = decoder.Decode(p.Cfg.Args)
+	if err != nil {
+		logger.Error().Err(err).Msg("AlignmentProcessor: decode")
+		return err
+	}
+	err = p.AlignmentCfg.Transform(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("AlignmentProcessor: transform")
+		return err
+	}
+
+	p.Resolver = moxDns.StrictResolver{Log: p.SLogger}
+	p.cache = make(map[string]alignmentCacheEntry)
+	p.now = time.Now
+	return nil
+}
+
+// InitResolver replaces the resolver, so that the processor uses the
+// configured dns settings of the service
+func (p *AlignmentProcessor) InitResolver(
+	ctx context.Context,
+	resolver moxDns.Resolver,
+	slogger *slog.Logger,
+) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("AlignmentProcessor InitResolver")
+	p.Resolver = resolver
+	p.SLogger = slogger
+}
+
+// InitSigner sets the dkim processor, whose signing domain of the From domain
+// is used for DMARC when dkim-domains is empty
+func (p *AlignmentProcessor) InitSigner(
+	ctx context.Context,
+	signer IDKIMSigner,
+) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("AlignmentProcessor InitSigner")
+	p.Signer = signer
+}
+
+func (p *AlignmentProcessor) Index() int {
+	return p.Cfg.Index
+}
+
+// Process evaluates the alignment of the From domain and applies the mode. In
+// reject mode, a mail which is not aligned is a *pmail.RejectedError, or a
+// *pmail.TemporaryError when a lookup failed temporarily.
+func (p *AlignmentProcessor) Process(
+	ctx context.Context,
+	mail *pmail.Mail,
+) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx)
+
+	if mail == nil {
+		return nil, errors.NewError(errors.ErrMailProcessing, "mail cannot be nil", nil)
+	}
+	domain := mail.From.Domain
+	if domain.IsZero() {
+		return nil, errors.NewError(errors.ErrMailProcessing, "from address required for alignment check", nil)
+	}
+
+	result := p.Evaluate(ctx, domain)
+	if result.Pass {
+		logger.Debug().
+			Str("domain", domain.ASCII).
+			Str("detail", result.Detail).
+			Msg("AlignmentProcessor: pass")
+	}
+
+	switch p.AlignmentCfg.Mode {
+	case config.AlignmentModeReject:
+		if !result.Pass {
+			logger.Error().
+				Str("domain", domain.ASCII).
+				Str("detail", result.Detail).
+				Bool("temporary", result.Temporary).
+				Msg("AlignmentProcessor: reject")
+			// A lookup that failed temporarily may pass when sent again
+			if result.Temporary {
+				return nil, &pmail.TemporaryError{
+					Err: errors.NewError(errors.ErrAlignment, "spf or dmarc not evaluated", nil).
+						WithContext("domain", domain.ASCII).
+						WithContext("detail", result.Detail),
+				}
+			}
+			return nil, &pmail.RejectedError{Fields: []pmail.FieldError{{
+				Field:   "from",
+				Message: fmt.Sprintf("spf or dmarc not aligned for %s: %s", domain.ASCII, result.Detail),
+			}}}
+		}
+	case config.AlignmentModeTag:
+		if mail.Metadata == nil {
+			mail.Metadata = make(map[string][]byte)
+		}
+		mail.Metadata[AlignmentResultKey] = []byte(AlignmentResultPass)
+		if !result.Pass {
+			mail.Metadata[AlignmentResultKey] = []byte(AlignmentResultFail)
+		}
+		mail.Metadata[AlignmentDetailKey] = []byte(result.Detail)
+	default:
+		if !result.Pass {
+			logger.Warn().
+				Str("domain", domain.ASCII).
+				Str("detail", result.Detail).
+				Msg("AlignmentProcessor: spf or dmarc not aligned")
+		}
+	}
+	return mail, nil
+}
+
+// Evaluate returns the alignment result of the domain, from the cache if it
+// has not expired. Temporary failures are not cached. Concurrent evaluations
+// of a domain share its lookups, and never wait for those of other domains.
+func (p *AlignmentProcessor) Evaluate(
+	ctx context.Context,
+	domain moxDns.Domain,
+) AlignmentResult {
+	p.mutex.Lock()
+	entry, ok := p.cache[domain.ASCII]
+	p.mutex.Unlock()
+	if ok && p.now().Before(entry.expires) {
+		return entry.result
+	}
+
+	value, _, _ := p.group.Do(domain.ASCII, func() (any, error) {
+		result := p.evaluate(ctx, domain)
+		if !result.Temporary {
+			p.mutex.Lock()
+			p.cache[domain.ASCII] = alignmentCacheEntry{
+				result:  result,
+				expires: p.now().Add(p.AlignmentCfg.CacheTTL),
+			}
+			p.mutex.Unlock()
+		}
+		return result, nil
+	})
+	return value.(AlignmentResult)
+}
+
+func (p *AlignmentProcessor) evaluate(
+	ctx context.Context,
+	domain moxDns.Domain,
+) AlignmentResult {
+	logger := zerolog.Ctx(ctx)
+	result := AlignmentResult{SPF: spf.StatusPass}
+	details := []string{}
+
+	// The sender uses the From domain as envelope and EHLO domain
+	ehloHostname := domain
+	if p.AlignmentCfg.EHLOHostname != "" {
+		ehloHostname = moxDns.Domain{ASCII: p.AlignmentCfg.EHLOHostname}
+	}
+	for _, ip := range p.AlignmentCfg.SourceIPAddrs {
+		received, _, _, _, err := spf.Verify(ctx, p.SLogger, p.Resolver, spf.Args{
+			RemoteIP:          ip,
+			MailFromLocalpart: smtp.Localpart("postmaster"),
+			MailFromDomain:    domain,
+			HelloDomain:       moxDns.IPDomain{Domain: ehloHostname},
+			LocalHostname:     ehloHostname,
+		})
+		if err != nil {
+			logger.Warn().Err(err).Stringer("ip", ip).Msg("AlignmentProcessor: spf.Verify")
+		}
+		if received.Result != spf.StatusPass {
+			result.SPF = received.Result
+			details = append(details, fmt.Sprintf("spf %s for %s", received.Result, ip))
+		}
+		if received.Result == spf.StatusTemperror {
+			result.Temporary = true
+		}
+	}
+	if result.SPF == spf.StatusPass {
+		details = append(details, "spf pass")
+	}
+
+	// Without a signing domain, the mail is not signed, and DMARC only passes
+	// with SPF
+	dkimDomains := p.AlignmentCfg.DKIMDomains
+	if len(dkimDomains) < 1 && p.Signer != nil {
+		if domainCfg, ok := p.Signer.SigningDomainCfg(domain); ok {
+			dkimDomains = []string{domainCfg.Domain.ASCII}
+		}
+	}
+	dkimResults := make([]moxDkim.Result, 0, len(dkimDomains))
+	for _, dkimDomain := range dkimDomains {
+		dkimResults = append(dkimResults, moxDkim.Result{
+			Status: moxDkim.StatusPass,
+			Sig:    &moxDkim.Sig{Domain: moxDns.Domain{ASCII: dkimDomain}},
+		})
+	}
+	_, dmarcResult := dmarc.Verify(
+		ctx, p.SLogger, p.Resolver,
+		domain, dkimResults, result.SPF, &domain, false,
+	)
+	result.DMARC = dmarcResult.Status
+	switch dmarcResult.Status {
+	case dmarc.StatusNone:
+		details = append(details, "no dmarc record")
+	case dmarc.StatusPass:
+		aligned := []string{}
+		if dmarcResult.AlignedSPFPass {
+			aligned = append(aligned, "spf")
+		}
+		if dmarcResult.AlignedDKIMPass {
+			aligned = append(aligned, "dkim")
+		}
+		details = append(details, "dmarc pass aligned with "+strings.Join(aligned, ","))
+	default:
+		if dmarcResult.Err != nil {
+			logger.Warn().Err(dmarcResult.Err).Msg("AlignmentProcessor: dmarc.Verify")
+		}
+		details = append(details, fmt.Sprintf("dmarc %s", dmarcResult.Status))
+	}
+	if dmarcResult.Status == dmarc.StatusTemperror {
+		result.Temporary = true
+	}
+
+	result.Pass = result.SPF == spf.StatusPass &&
+		(result.DMARC == dmarc.StatusPass || result.DMARC == dmarc.StatusNone)
+	result.Detail = strings.Join(details, "; ")
+	return result
+}
@@ -0,0 +1,399 @@
+package intmail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+	"github.com/mjl-/mox/dmarc"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAlignmentProcessorInit(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]any
+		wantMode string
+		wantTTL  time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "defaults",
+			args:     map[string]any{"source-ips": []string{"192.0.2.1"}},
+			wantMode: config.AlignmentModeWarn,
+			wantTTL:  config.DefaultAlignmentCacheTTL,
+		},
+		{
+			name: "reject with ttl",
+			args: map[string]any{
+				"cache-ttl":  "1m",
+				"mode":       "reject",
+				"source-ips": []string{"192.0.2.1"},
+			},
+			wantMode: config.AlignmentModeReject,
+			wantTTL:  time.Minute,
+		},
+		{
+			name:    "missing source ips",
+			args:    map[string]any{"mode": "tag"},
+			wantErr: true,
+		},
+		{
+			name: "unsupported mode",
+			args: map[string]any{
+				"mode":       "drop",
+				"source-ips": []string{"192.0.2.1"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			processor := &AlignmentProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: AlignmentProcessorType,
+				Args: tt.args,
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, processor.AlignmentCfg.Mode)
+			assert.Equal(t, tt.wantTTL, processor.AlignmentCfg.CacheTTL)
+		})
+	}
+}
+
+func TestAlignmentProcessorProcess(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		spf          string
+		dmarc        string
+		wantRejected bool
+		wantMetadata string
+	}{
+		{
+			name:         "tag pass",
+			mode:         config.AlignmentModeTag,
+			spf:          "v=spf1 ip4:192.0.2.1 -all",
+			dmarc:        "v=DMARC1; p=reject",
+			wantMetadata: AlignmentResultPass,
+		},
+		{
+			name:         "tag spf fail",
+			mode:         config.AlignmentModeTag,
+			spf:          "v=spf1 ip4:198.51.100.1 -all",
+			dmarc:        "v=DMARC1; p=reject; aspf=s; adkim=s",
+			wantMetadata: AlignmentResultFail,
+		},
+		{
+			name:  "reject pass",
+			mode:  config.AlignmentModeReject,
+			spf:   "v=spf1 ip4:192.0.2.1 -all",
+			dmarc: "v=DMARC1; p=reject",
+		},
+		{
+			name:         "reject spf fail",
+			mode:         config.AlignmentModeReject,
+			spf:          "v=spf1 -all",
+			dmarc:        "v=DMARC1; p=reject",
+			wantRejected: true,
+		},
+		{
+			name:  "warn spf fail",
+			mode:  config.AlignmentModeWarn,
+			spf:   "v=spf1 -all",
+			dmarc: "v=DMARC1; p=reject",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			txts := map[string][]string{
+				"example.com.":        {tt.spf},
+				"_dmarc.example.com.": {tt.dmarc},
+			}
+			resolver := dns.NewMockResolver(ctrl)
+			// Both lookups happen once, the second mail uses the cache
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, name string) ([]string, adns.Result, error) {
+					result, ok := txts[name]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return result, adns.Result{}, nil
+				}).
+				Times(2)
+
+			processor := &AlignmentProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: AlignmentProcessorType,
+				Args: map[string]any{
+					"mode":       tt.mode,
+					"source-ips": []string{"192.0.2.1"},
+				},
+			})
+			require.NoError(t, err)
+			processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+
+			from, err := smtp.ParseAddress("john@example.com")
+			require.NoError(t, err)
+			for range 2 {
+				mail := &pmail.Mail{From: from}
+				got, err := processor.Process(ctx, mail)
+				if tt.wantRejected {
+					var rejected *pmail.RejectedError
+					require.ErrorAs(t, err, &rejected)
+					require.Len(t, rejected.Fields, 1)
+					assert.Equal(t, "from", rejected.Fields[0].Field)
+					assert.Contains(t, rejected.Fields[0].Message, "example.com")
+					assert.Contains(t, rejected.Fields[0].Message, "spf fail")
+					continue
+				}
+				require.NoError(t, err)
+				if tt.wantMetadata != "" {
+					assert.Equal(t, tt.wantMetadata, string(got.Metadata[AlignmentResultKey]))
+					assert.NotEmpty(t, got.Metadata[AlignmentDetailKey])
+				} else {
+					assert.Empty(t, got.Metadata)
+				}
+			}
+		})
+	}
+}
+
+func TestAlignmentProcessorRejectTemporary(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	servFail := &adns.DNSError{Err: "server misbehaving", IsTemporary: true}
+
+	// Temporary failures are not cached, every mail looks up again
+	resolver := dns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), gomock.Any()).
+		Return(nil, adns.Result{}, servFail).
+		MinTimes(2)
+
+	processor := &AlignmentProcessor{}
+	err := processor.Init(ctx, config.MailProcessorConfig{
+		Type: AlignmentProcessorType,
+		Args: map[string]any{
+			"mode":       config.AlignmentModeReject,
+			"source-ips": []string{"192.0.2.1"},
+		},
+	})
+	require.NoError(t, err)
+	processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+
+	from, err := smtp.ParseAddress("john@example.com")
+	require.NoError(t, err)
+	for range 2 {
+		_, err = processor.Process(ctx, &pmail.Mail{From: from})
+		var temporary *pmail.TemporaryError
+		require.ErrorAs(t, err, &temporary)
+		assert.Contains(t, err.Error(), "ALIGNMENT")
+		var rejected *pmail.RejectedError
+		assert.NotErrorAs(t, err, &rejected)
+	}
+}
+
+func TestAlignmentProcessorCacheExpiry(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+
+	resolver := dns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "example.com.").
+		Return([]string{"v=spf1 ip4:192.0.2.1 -all"}, adns.Result{}, nil).
+		Times(2)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "_dmarc.example.com.").
+		Return(nil, adns.Result{}, notFound).
+		Times(2)
+
+	processor := &AlignmentProcessor{}
+	err := processor.Init(ctx, config.MailProcessorConfig{
+		Type: AlignmentProcessorType,
+		Args: map[string]any{"source-ips": []string{"192.0.2.1"}},
+	})
+	require.NoError(t, err)
+	processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+	now := time.Now()
+	processor.now = func() time.Time { return now }
+
+	domain := moxDns.Domain{ASCII: "example.com"}
+	result := processor.Evaluate(ctx, domain)
+	assert.True(t, result.Pass)
+	assert.Equal(t, "spf pass; no dmarc record", result.Detail)
+	_ = processor.Evaluate(ctx, domain)
+
+	now = now.Add(config.DefaultAlignmentCacheTTL + time.Second)
+	result = processor.Evaluate(ctx, domain)
+	assert.True(t, result.Pass)
+}
+
+func TestAlignmentProcessorDKIMDomains(t *testing.T) {
+	signingDomain := func(name string) *config.DomainConfig {
+		return &config.DomainConfig{Domain: moxDns.Domain{ASCII: name}}
+	}
+	tests := []struct {
+		name        string
+		dkimDomains []string
+		signer      IDKIMSigner
+		wantDMARC   dmarc.Status
+	}{
+		{
+			name:      "unsigned without dkim processor",
+			wantDMARC: dmarc.StatusFail,
+		},
+		{
+			name: "unsigned without a signing domain",
+			signer: &DKIMProcessor{
+				Domains: map[string]*config.DomainConfig{"other.org": signingDomain("other.org")},
+			},
+			wantDMARC: dmarc.StatusFail,
+		},
+		{
+			name: "signed with the from domain",
+			signer: &DKIMProcessor{
+				Domains: map[string]*config.DomainConfig{"example.com": signingDomain("example.com")},
+			},
+			wantDMARC: dmarc.StatusPass,
+		},
+		{
+			name: "signed with the default domain",
+			signer: &DKIMProcessor{
+				Domains:    map[string]*config.DomainConfig{"other.org": signingDomain("other.org")},
+				SigningCfg: config.DKIMSigningConfig{DefaultDomain: "other.org"},
+			},
+			wantDMARC: dmarc.StatusFail,
+		},
+		{
+			name:        "dkim domains",
+			dkimDomains: []string{"example.com"},
+			wantDMARC:   dmarc.StatusPass,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			txts := map[string][]string{
+				"example.com.":        {"v=spf1 ip4:198.51.100.1 -all"},
+				"_dmarc.example.com.": {"v=DMARC1; p=reject"},
+			}
+			resolver := dns.NewMockResolver(ctrl)
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, name string) ([]string, adns.Result, error) {
+					result, ok := txts[name]
+					if !ok {
+						return nil, adns.Result{}, notFound
+					}
+					return result, adns.Result{}, nil
+				}).
+				AnyTimes()
+
+			processor := &AlignmentProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: AlignmentProcessorType,
+				Args: map[string]any{
+					"source-ips":   []string{"192.0.2.1"},
+					"dkim-domains": tt.dkimDomains,
+				},
+			})
+			require.NoError(t, err)
+			processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+			if tt.signer != nil {
+				processor.InitSigner(ctx, tt.signer)
+			}
+
+			result := processor.Evaluate(ctx, moxDns.Domain{ASCII: "example.com"})
+			assert.False(t, result.Pass)
+			assert.Equal(t, tt.wantDMARC, result.DMARC)
+		})
+	}
+}
+
+func TestAlignmentProcessorConcurrentEvaluate(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	notFound := &adns.DNSError{Err: "no such host", IsNotFound: true}
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	resolver := dns.NewMockResolver(ctrl)
+	// The slow domain is looked up once, by the first of its evaluations
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "slow.example.").
+		DoAndReturn(func(context.Context, string) ([]string, adns.Result, error) {
+			close(started)
+			<-release
+			return []string{"v=spf1 ip4:192.0.2.1 -all"}, adns.Result{}, nil
+		})
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "_dmarc.slow.example.").
+		Return(nil, adns.Result{}, notFound)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "example.com.").
+		Return([]string{"v=spf1 ip4:192.0.2.1 -all"}, adns.Result{}, nil)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "_dmarc.example.com.").
+		Return(nil, adns.Result{}, notFound)
+
+	processor := &AlignmentProcessor{}
+	err := processor.Init(ctx, config.MailProcessorConfig{
+		Type: AlignmentProcessorType,
+		Args: map[string]any{"source-ips": []string{"192.0.2.1"}},
+	})
+	require.NoError(t, err)
+	processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+
+	slow := moxDns.Domain{ASCII: "slow.example"}
+	results := make(chan AlignmentResult, 2)
+	go func() { results <- processor.Evaluate(ctx, slow) }()
+	<-started
+	go func() { results <- processor.Evaluate(ctx, slow) }()
+
+	// Another domain is evaluated while the slow one waits for its lookup
+	done := make(chan AlignmentResult)
+	go func() { done <- processor.Evaluate(ctx, moxDns.Domain{ASCII: "example.com"}) }()
+	select {
+	case result := <-done:
+		assert.True(t, result.Pass)
+	case <-time.After(5 * time.Second):
+		t.Fatal("evaluation blocked by the lookup of another domain")
+	}
+
+	// Wait for the second evaluation of the slow domain to join the first
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for range 2 {
+		assert.True(t, (<-results).Pass)
+	}
+}
@@ -0,0 +1,159 @@
+package intmail
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/arc"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/crypto"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	ARCProcessorType = "arc"
+)
+
+// ARCProcessor adds an ARC set (RFC 8617) to relayed mail, chained to the
+// ARC sets already in the mail. The chain is validated on RawHeaders, the
+// header section as received, and the new set signs the header section built
+// by mergeHeaders, which keeps the received ARC sets, so it must run after the
+// last mergeHeaders and before mergeBody.
+type ARCProcessor struct {
+	ARCCfg   config.ARCConfig
+	Cfg      config.MailProcessorConfig
+	Resolver moxDns.Resolver
+	SLogger  *slog.Logger
+
+	now func() time.Time
+}
+
+func (p *ARCProcessor) Init(
+	ctx context.Context,
+	cfg config.MailProcessorConfig,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("ARCProcessor Init")
+	p.Cfg = cfg
+
+	decoder, err := mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			Metadata: nil,
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToTimeHookFunc(time.RFC3339),
+			),
+			Result: &p.ARCCfg,
+		},
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("ARCProcessor: NewDecoder")
+		return err
+	}
+	err = decoder.Decode(p.Cfg.Args)
+	if err != nil {
+		logger.Error().Err(err).Msg("ARCProcessor: decode")
+		return err
+	}
+	err = p.ARCCfg.Transform(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("ARCProcessor: transform")
+		return err
+	}
+
+	p.Resolver = moxDns.StrictResolver{Log: p.SLogger}
+	p.now = time.Now
+	return nil
+}
+
+// InitResolver replaces the resolver used to look up the keys of the ARC
+// sets already in the mail
+func (p *ARCProcessor) InitResolver(
+	ctx context.Context,
+	resolver moxDns.Resolver,
+	slogger *slog.Logger,
+) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("ARCProcessor InitResolver")
+	p.Resolver = resolver
+	p.SLogger = slogger
+}
+
+// InitARCCrypto loads the private keys of the arc selectors
+func (p *ARCProcessor) InitARCCrypto(
+	ctx context.Context,
+	loader crypto.IKeyLoader,
+) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("InitARCCrypto")
+	return loadSelectorKeys(ctx, loader, p.ARCCfg.ARC)
+}
+
+func (p *ARCProcessor) Index() int {
+	return p.Cfg.Index
+}
+
+// Process seals the mail with the first active arc selector. A mail whose
+// chain has already failed, or that has too many ARC sets, is left as is.
+func (p *ARCProcessor) Process(ctx context.Context, mail *pmail.Mail) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx)
+
+	if mail == nil {
+		return nil, errors.NewError(errors.ErrMailProcessing, "mail cannot be nil", nil)
+	}
+
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	selectorNames := p.ARCCfg.ARC.ActiveSelectors(now)
+	if len(selectorNames) < 1 {
+		return nil, errors.NewError(errors.ErrDKIMConfig, "no active arc selector", nil).
+			WithContext("domain", p.ARCCfg.Domain.ASCII)
+	}
+	sealer := &arc.Sealer{
+		AuthServID: p.ARCCfg.AuthServID,
+		Domain:     p.ARCCfg.Domain,
+		Resolver:   p.Resolver,
+		Selector:   p.ARCCfg.ARC.Selectors[selectorNames[0]],
+		Slogger:    p.SLogger,
+		Now:        func() time.Time { return now },
+	}
+
+	// Hash the body as mergeBody writes it
+	body := slices.Concat(bytes.TrimSpace(mail.Body), []byte("\r\n\r\n"))
+	received := mail.RawHeaders
+	if len(received) == 0 {
+		received = mail.Headers
+	}
+	arcHeaders, chain, err := sealer.SealReceived(ctx, received, mail.Headers, body)
+	if stdErrors.Is(err, arc.ErrChainFailed) || stdErrors.Is(err, arc.ErrTooManySets) {
+		logger.Warn().
+			Err(err).
+			Int("instance", chain.MaxInstance).
+			Msg("ARCProcessor: mail not sealed")
+		return mail, nil
+	}
+	if err != nil {
+		return nil, errors.NewError(errors.ErrMailProcessing, "failed to seal mail", err)
+	}
+
+	headers := make([]byte, 0, len(arcHeaders)+len(mail.Headers))
+	headers = append(headers, []byte(arcHeaders)...)
+	headers = append(headers, mail.Headers...)
+	mail.Headers = headers
+
+	logger.Info().
+		Str("cv", string(chain.Status)).
+		Int("instance", chain.MaxInstance+1).
+		Msg("ARCProcessor: Process.Done")
+	return mail, nil
+}
@@ -0,0 +1,276 @@
+package intmail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
+	"github.com/stlimtat/remiges-smtp/internal/arc"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	intDns "github.com/stlimtat/remiges-smtp/internal/dns"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/file_mail"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func arcTestArgs(algorithm string) map[string]any {
+	return map[string]any{
+		"authserv-id": "mx.example.com",
+		"domain-str":  "Example.com",
+		"arc": map[string]any{
+			"selectors": map[string]any{
+				"arc001": map[string]any{
+					"algorithm":        algorithm,
+					"body-relaxed":     true,
+					"hash":             "sha256",
+					"header-relaxed":   true,
+					"headers":          []string{"from", "to", "subject"},
+					"private-key-file": "/tmp/arc001.pem",
+					"selector-domain":  "arc001",
+				},
+			},
+		},
+	}
+}
+
+func TestARCProcessorInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    map[string]any
+		wantErr bool
+	}{
+		{
+			name: "happy",
+			args: arcTestArgs(config.AlgorithmRSA),
+		},
+		{
+			name:    "ed25519 not supported",
+			args:    arcTestArgs(config.AlgorithmED25519),
+			wantErr: true,
+		},
+		{
+			name: "missing authserv-id",
+			args: func() map[string]any {
+				result := arcTestArgs(config.AlgorithmRSA)
+				delete(result, "authserv-id")
+				return result
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "missing selectors",
+			args: map[string]any{
+				"authserv-id": "mx.example.com",
+				"domain-str":  "example.com",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			processor := &ARCProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: ARCProcessorType,
+				Args: tt.args,
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "example.com", processor.ARCCfg.Domain.ASCII)
+			assert.Contains(t, processor.ARCCfg.ARC.Selectors, "arc001")
+		})
+	}
+}
+
+func TestARCProcessorProcess(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := intDns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "arc001._domainkey.example.com.").
+		Return([]string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}, adns.Result{}, nil).
+		AnyTimes()
+
+	processor := &ARCProcessor{}
+	err = processor.Init(ctx, config.MailProcessorConfig{
+		Type: ARCProcessorType,
+		Args: arcTestArgs(config.AlgorithmRSA),
+	})
+	require.NoError(t, err)
+	processor.InitResolver(ctx, resolver, slogger)
+	selector := processor.ARCCfg.ARC.Selectors["arc001"]
+	selector.PrivateKey = privateKey
+	processor.ARCCfg.ARC.Selectors["arc001"] = selector
+
+	mail := &pmail.Mail{
+		Headers: []byte("Authentication-Results: mx.example.com; dkim=pass header.d=sender.example\r\n" +
+			"From: john@sender.example\r\n" +
+			"Subject: hello\r\n" +
+			"To: list@example.com\r\n" +
+			"\r\n"),
+		Body: []byte("hello world\r\n"),
+	}
+	got, err := processor.Process(ctx, mail)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(got.Headers, []byte("ARC-Seal: i=1;")))
+	assert.Contains(t, string(got.Headers), "ARC-Authentication-Results: i=1; mx.example.com; dkim=pass header.d=sender.example\r\n")
+
+	hdrs, err := arc.ParseHeaders(got.Headers)
+	require.NoError(t, err)
+	chain := arc.Validate(ctx, slogger, resolver, hdrs, []byte("hello world\r\n\r\n"))
+	require.NoError(t, chain.Err)
+	assert.Equal(t, arc.ChainStatusPass, chain.Status)
+
+	// Sealed again by the next hop
+	processor.now = func() time.Time { return time.Now().Add(time.Minute) }
+	got, err = processor.Process(ctx, got)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(got.Headers, []byte("ARC-Seal: i=2;")))
+	assert.Contains(t, string(got.Headers), "ARC-Authentication-Results: i=2; mx.example.com; dkim=pass header.d=sender.example; arc=pass\r\n")
+
+	// A failed chain is not sealed again
+	failed := &pmail.Mail{
+		Headers: []byte("ARC-Seal: i=1; a=rsa-sha256; cv=fail; d=example.com; s=arc001; b=\r\n" +
+			"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.com; s=arc001; b=\r\n" +
+			"ARC-Authentication-Results: i=1; mx.example.com; none\r\n" +
+			"From: john@sender.example\r\n\r\n"),
+		Body: []byte("hello world\r\n"),
+	}
+	headers := bytes.Clone(failed.Headers)
+	got, err = processor.Process(ctx, failed)
+	require.NoError(t, err)
+	assert.Equal(t, headers, got.Headers)
+}
+
+// TestARCProcessorProcessEML seals a mail read by the eml transformer with
+// the ARC set of a forwarder, whose headers mergeHeaders rebuilds
+func TestARCProcessorProcessEML(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	slogger := telemetry.GetSLogger(ctx)
+
+	forwarderKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	record := func(key *rsa.PrivateKey) []string {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		return []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)}
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	resolver := intDns.NewMockResolver(ctrl)
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "fwd001._domainkey.forwarder.example.").
+		Return(record(forwarderKey), adns.Result{}, nil).
+		AnyTimes()
+	resolver.EXPECT().
+		LookupTXT(gomock.Any(), "arc001._domainkey.example.com.").
+		Return(record(privateKey), adns.Result{}, nil).
+		AnyTimes()
+
+	// The forwarder sealed the mail, then it was received here
+	header := []byte("From: John <john@sender.example>\r\n" +
+		"To: list@forwarder.example\r\n" +
+		"Subject: hello\r\n" +
+		"Date: Mon, 13 Oct 2025 10:00:00 +0000\r\n" +
+		"\r\n")
+	body := []byte("hello world\r\n")
+	forwarder := &arc.Sealer{
+		AuthServID: "mx.forwarder.example",
+		Domain:     moxDns.Domain{ASCII: "forwarder.example"},
+		Resolver:   resolver,
+		Selector: moxDkim.Selector{
+			BodyRelaxed:   true,
+			Domain:        moxDns.Domain{ASCII: "fwd001"},
+			Hash:          config.HashSHA256,
+			HeaderRelaxed: true,
+			Headers:       []string{"from", "to", "subject", "date"},
+			PrivateKey:    forwarderKey,
+		},
+		Slogger: slogger,
+	}
+	arcHeaders, _, err := forwarder.Seal(ctx, header, body)
+	require.NoError(t, err)
+	eml := slices.Concat(
+		[]byte("Authentication-Results: mx.example.com; dkim=pass header.d=sender.example\r\n"),
+		[]byte(arcHeaders),
+		header,
+		body,
+	)
+	emlPath := filepath.Join(t.TempDir(), "mail.eml")
+	require.NoError(t, os.WriteFile(emlPath, eml, 0o600))
+
+	transformer := &file_mail.EMLTransformer{}
+	require.NoError(t, transformer.Init(ctx, config.FileMailConfig{Type: file_mail.EMLTransformerType}))
+	mail, err := transformer.Transform(ctx, &file.FileInfo{DfFilePath: emlPath}, &pmail.Mail{})
+	require.NoError(t, err)
+
+	// The headers are rebuilt as bodyHeaders does, which breaks the
+	// signature of the forwarder over From and Date
+	mail.HeadersMap = map[string][]byte{
+		"Date":    []byte(time.Now().Format(time.RFC1123Z)),
+		"From":    []byte("john@sender.example"),
+		"Subject": mail.Metadata["Subject"],
+		"To":      []byte("list@forwarder.example"),
+	}
+	mergeHeaders := &MergeHeadersProcessor{}
+	require.NoError(t, mergeHeaders.Init(ctx, config.MailProcessorConfig{}))
+	mail, err = mergeHeaders.Process(ctx, mail)
+	require.NoError(t, err)
+	assert.Contains(t, string(mail.Headers), arcHeaders)
+
+	processor := &ARCProcessor{}
+	err = processor.Init(ctx, config.MailProcessorConfig{
+		Type: ARCProcessorType,
+		Args: arcTestArgs(config.AlgorithmRSA),
+	})
+	require.NoError(t, err)
+	processor.InitResolver(ctx, resolver, slogger)
+	selector := processor.ARCCfg.ARC.Selectors["arc001"]
+	selector.PrivateKey = privateKey
+	processor.ARCCfg.ARC.Selectors["arc001"] = selector
+
+	got, err := processor.Process(ctx, mail)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(got.Headers, []byte("ARC-Seal: i=2; a=rsa-sha256;")))
+	assert.Contains(t, string(got.Headers), "cv=pass;")
+	assert.Contains(t, string(got.Headers),
+		"ARC-Authentication-Results: i=2; mx.example.com; dkim=pass header.d=sender.example; arc=pass\r\n")
+
+	// The next hop validates the mail as sent
+	hdrs, err := arc.ParseHeaders(got.Headers)
+	require.NoError(t, err)
+	chain := arc.Validate(ctx, slogger, resolver, hdrs, []byte("hello world\r\n\r\n"))
+	require.NoError(t, chain.Err)
+	assert.Equal(t, arc.ChainStatusPass, chain.Status)
+	assert.Equal(t, 2, chain.MaxInstance)
+}
@@ -2,8 +2,10 @@ package intmail
 
 import (
 	"context"
+	"strings"
 	"time"
 
+	"github.com/mjl-/mox/smtp"
 	"github.com/rs/zerolog"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/pkg/input"
@@ -12,6 +14,9 @@ import (
 
 const (
 	BodyHeadersProcessorType = "bodyHeaders"
+	// UndisclosedRecipients is the To header of a mail without a visible
+	// recipient, when every recipient is a Bcc
+	UndisclosedRecipients = "undisclosed-recipients:;"
 )
 
 type BodyHeadersProcessor struct {
@@ -58,12 +63,36 @@ func (_ *BodyHeadersProcessor) Process(
 	inMail.HeadersMap[input.HeaderMsgIDKey] = inMail.MsgID
 	inMail.HeadersMap[input.HeaderSubjectKey] = inMail.Subject
 
-	toBytes := []byte{}
+	// The Cc and Bcc recipients are part of To, but not of the To header
+	hidden := make(map[string]bool)
+	for _, addr := range inMail.Cc {
+		hidden[addr.String()] = true
+	}
+	for _, addr := range inMail.Bcc {
+		hidden[addr.String()] = true
+	}
+	visible := make([]smtp.Address, 0, len(inMail.To))
 	for _, to := range inMail.To {
-		toBytes = append(toBytes, to.String()...)
-		toBytes = append(toBytes, ',')
+		if !hidden[to.String()] {
+			visible = append(visible, to)
+		}
+	}
+	inMail.HeadersMap[input.HeaderToKey] = joinAddresses(visible)
+	if len(visible) == 0 && len(inMail.To) > 0 {
+		inMail.HeadersMap[input.HeaderToKey] = []byte(UndisclosedRecipients)
+	}
+	if len(inMail.Cc) > 0 {
+		inMail.HeadersMap[input.HeaderCcKey] = joinAddresses(inMail.Cc)
 	}
-	inMail.HeadersMap[input.HeaderToKey] = toBytes[:len(toBytes)-1]
 
 	return inMail, nil
 }
+
+// joinAddresses returns the addresses separated by commas
+func joinAddresses(addrs []smtp.Address) []byte {
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.String())
+	}
+	return []byte(strings.Join(result, ","))
+}
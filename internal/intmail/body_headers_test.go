@@ -42,6 +42,40 @@ func TestBodyHeadersProcessor(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "happy - cc and bcc",
+			inMail: &pmail.Mail{
+				Bcc:         []smtp.Address{{Localpart: "hidden", Domain: dns.Domain{ASCII: "example.com"}}},
+				Cc:          []smtp.Address{{Localpart: "jane", Domain: dns.Domain{ASCII: "example.com"}}},
+				ContentType: []byte("text/plain"),
+				From:        smtp.Address{Localpart: "sender", Domain: dns.Domain{ASCII: "example.com"}},
+				MsgID:       []byte("1234567890"),
+				Subject:     []byte("test"),
+				To: []smtp.Address{
+					{Localpart: "john", Domain: dns.Domain{ASCII: "example.com"}},
+					{Localpart: "jane", Domain: dns.Domain{ASCII: "example.com"}},
+					{Localpart: "hidden", Domain: dns.Domain{ASCII: "example.com"}},
+				},
+			},
+			wantBodyHeaders: map[string][]byte{
+				input.HeaderCcKey: []byte("jane@example.com"),
+				input.HeaderToKey: []byte("john@example.com"),
+			},
+		},
+		{
+			name: "happy - bcc only",
+			inMail: &pmail.Mail{
+				Bcc:         []smtp.Address{{Localpart: "hidden", Domain: dns.Domain{ASCII: "example.com"}}},
+				ContentType: []byte("text/plain"),
+				From:        smtp.Address{Localpart: "sender", Domain: dns.Domain{ASCII: "example.com"}},
+				MsgID:       []byte("1234567890"),
+				Subject:     []byte("test"),
+				To:          []smtp.Address{{Localpart: "hidden", Domain: dns.Domain{ASCII: "example.com"}}},
+			},
+			wantBodyHeaders: map[string][]byte{
+				input.HeaderToKey: []byte(UndisclosedRecipients),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -3,18 +3,26 @@ package intmail
 import (
 	"bytes"
 	"context"
+	stdCrypto "crypto"
+	"fmt"
 	"log/slog"
 	"maps"
+	"net/http"
+	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-viper/mapstructure/v2"
 	moxDkim "github.com/mjl-/mox/dkim"
+	moxDns "github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mox-"
 	"github.com/rs/zerolog"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/internal/crypto"
+	"github.com/stlimtat/remiges-smtp/internal/dkim"
 	"github.com/stlimtat/remiges-smtp/internal/errors"
 	"github.com/stlimtat/remiges-smtp/internal/utils"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
@@ -22,13 +30,40 @@ import (
 
 const (
 	DKIMProcessorType = "dkim"
+
+	// DKIMDNSRecheckInterval is how long a selector whose DNS record has not
+	// propagated yet is skipped before it is checked again
+	DKIMDNSRecheckInterval = time.Minute
+
+	// Metadata keys set by the verify step in tag mode
+	DKIMVerifyResultKey = "DKIM-Verify-Result"
+	DKIMVerifyDetailKey = "DKIM-Verify-Detail"
+
+	DKIMVerifyResultPass = "pass"
+	DKIMVerifyResultFail = "fail"
 )
 
-// DKIMProcessor handles DKIM signing of emails
+// DKIMProcessor handles DKIM signing of emails.
+// Each mail is signed with the selectors of the domain config chosen by its
+// From domain, from the domain of the args and the domains of SigningCfg.
 type DKIMProcessor struct {
-	Cfg       config.MailProcessorConfig
-	DomainCfg *config.DomainConfig
-	SLogger   *slog.Logger
+	Cfg        config.MailProcessorConfig
+	DomainCfg  *config.DomainConfig
+	Domains    map[string]*config.DomainConfig
+	Resolver   moxDns.Resolver
+	SigningCfg config.DKIMSigningConfig
+	SLogger    *slog.Logger
+
+	// propagated records the DNS check of scheduled selectors, by selector
+	// and domain. Failed checks are retried after DKIMDNSRecheckInterval.
+	propagated map[string]dkimDNSCheck
+	mutex      sync.Mutex
+	now        func() time.Time
+}
+
+type dkimDNSCheck struct {
+	ok      bool
+	checked time.Time
 }
 
 func (p *DKIMProcessor) Init(
@@ -42,9 +77,12 @@ func (p *DKIMProcessor) Init(
 	p.DomainCfg = &config.DomainConfig{}
 	decoder, err := mapstructure.NewDecoder(
 		&mapstructure.DecoderConfig{
-			Metadata:   nil,
-			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
-			Result:     &p.DomainCfg,
+			Metadata: nil,
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToTimeHookFunc(time.RFC3339),
+			),
+			Result: &p.DomainCfg,
 		},
 	)
 	if err != nil {
@@ -63,9 +101,129 @@ func (p *DKIMProcessor) Init(
 		return err
 	}
 
+	decoder, err = mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			Metadata: nil,
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToTimeHookFunc(time.RFC3339),
+			),
+			Result: &p.SigningCfg,
+		},
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("DKIMProcessor: NewDecoder")
+		return err
+	}
+	err = decoder.Decode(p.Cfg.Args)
+	if err != nil {
+		logger.Error().Err(err).Msg("DKIMProcessor: decode signing")
+		return err
+	}
+	err = p.SigningCfg.Transform(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("DKIMProcessor: transform signing")
+		return err
+	}
+	err = p.initDomains()
+	if err != nil {
+		logger.Error().Err(err).Msg("DKIMProcessor: initDomains")
+		return err
+	}
+
+	p.Resolver = moxDns.StrictResolver{Log: p.SLogger}
+	p.propagated = make(map[string]dkimDNSCheck)
+	p.now = time.Now
+	return nil
+}
+
+// initDomains indexes the domain configs by domain
+func (p *DKIMProcessor) initDomains() error {
+	p.Domains = make(map[string]*config.DomainConfig)
+	domainCfgs := slices.Collect(maps.Values(p.SigningCfg.Domains))
+	if p.DomainCfg.DKIM != nil {
+		if p.DomainCfg.Domain.IsZero() {
+			return &errors.ConfigError{
+				Field:   "DomainStr",
+				Message: "domain-str is required with dkim selectors",
+			}
+		}
+		domainCfgs = append(domainCfgs, p.DomainCfg)
+	}
+	for _, domainCfg := range domainCfgs {
+		name := domainCfg.Domain.ASCII
+		if _, ok := p.Domains[name]; ok {
+			return &errors.ConfigError{
+				Field:   "Domains",
+				Message: fmt.Sprintf("domain %s is configured more than once", name),
+			}
+		}
+		p.Domains[name] = domainCfg
+	}
+	if len(p.Domains) < 1 {
+		return &errors.ConfigError{
+			Field:   "Domains",
+			Message: "at least one signing domain must be configured",
+		}
+	}
+	if p.SigningCfg.DefaultDomain != "" {
+		if _, ok := p.Domains[p.SigningCfg.DefaultDomain]; !ok {
+			return &errors.ConfigError{
+				Field:   "DefaultDomain",
+				Message: fmt.Sprintf("default domain %s is not configured", p.SigningCfg.DefaultDomain),
+			}
+		}
+	}
 	return nil
 }
 
+// SigningDomainCfg returns the domain config that signs mail from the domain:
+// the config of the domain itself, else of its closest parent domain with
+// parent-fallback, else of the default domain.
+//
+// Parameters:
+//   - domain: The From domain of the mail
+//
+// Returns:
+//   - *config.DomainConfig: The signing domain config
+//   - bool: False if no domain config signs mail from the domain
+func (p *DKIMProcessor) SigningDomainCfg(domain moxDns.Domain) (*config.DomainConfig, bool) {
+	name := config.NormalizeDomain(domain.ASCII)
+	if domainCfg, ok := p.Domains[name]; ok {
+		return domainCfg, true
+	}
+	if p.SigningCfg.ParentFallback {
+		// Stop before the top level domain
+		for {
+			_, parent, found := strings.Cut(name, ".")
+			if !found || !strings.Contains(parent, ".") {
+				break
+			}
+			if domainCfg, ok := p.Domains[parent]; ok {
+				return domainCfg, true
+			}
+			name = parent
+		}
+	}
+	if p.SigningCfg.DefaultDomain != "" {
+		return p.Domains[p.SigningCfg.DefaultDomain], true
+	}
+	return nil, false
+}
+
+// InitResolver replaces the resolver used to check that the DNS records of
+// scheduled selectors have propagated
+func (p *DKIMProcessor) InitResolver(
+	ctx context.Context,
+	resolver moxDns.Resolver,
+	slogger *slog.Logger,
+) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("DKIMProcessor InitResolver")
+	p.Resolver = resolver
+	p.SLogger = slogger
+}
+
 func (p *DKIMProcessor) Index() int {
 	return p.Cfg.Index
 }
@@ -77,35 +235,98 @@ func (p *DKIMProcessor) InitDKIMCrypto(
 	logger := zerolog.Ctx(ctx)
 	logger.Debug().Msg("InitDKIMCrypto")
 
-	moxSelectors := p.DomainCfg.DKIM.MoxSelectors
+	for _, domainCfg := range p.Domains {
+		err := p.initDomainCrypto(ctx, loader, domainCfg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initDomainCrypto loads the private keys of the selectors of a domain
+func (p *DKIMProcessor) initDomainCrypto(
+	ctx context.Context,
+	loader crypto.IKeyLoader,
+	domainCfg *config.DomainConfig,
+) error {
+	logger := zerolog.Ctx(ctx).With().Str("domain", domainCfg.Domain.ASCII).Logger()
+	return loadSelectorKeys(logger.WithContext(ctx), loader, domainCfg.DKIM)
+}
 
-	for selectorName, moxSelector := range moxSelectors {
-		privateKeyPath, err := utils.ValidateIO(
-			ctx,
-			filepath.Clean(moxSelector.PrivateKeyFile),
-			true, false,
-		)
+// loadSelectorKeys loads the private key of each selector of the config into
+// its mox selector
+func loadSelectorKeys(
+	ctx context.Context,
+	loader crypto.IKeyLoader,
+	dkimCfg *config.DKIMConfig,
+) error {
+	logger := zerolog.Ctx(ctx)
+
+	for selectorName, moxSelector := range dkimCfg.MoxSelectors {
+		selectorLoader, keyRef, err := selectorKeyLoader(ctx, loader, moxSelector)
 		if err != nil {
-			logger.Error().Err(err).Msg("InitDKIMCrypto: ValidateIO")
+			logger.Error().Err(err).Str("selector", selectorName).Msg("InitDKIMCrypto: selectorKeyLoader")
 			return err
 		}
-		signer, err := loader.LoadPrivateKey(
+		signer, err := selectorLoader.LoadPrivateKey(
 			ctx,
 			moxSelector.Algorithm,
-			privateKeyPath,
+			keyRef,
 		)
 		if err != nil {
-			logger.Error().Err(err).Msg("InitDKIMCrypto: LoadPrivateKey")
+			logger.Error().Err(err).Str("selector", selectorName).Msg("InitDKIMCrypto: LoadPrivateKey")
 			return err
 		}
-		moxDkimSelector := p.DomainCfg.DKIM.Selectors[selectorName]
+		moxDkimSelector := dkimCfg.Selectors[selectorName]
 		moxDkimSelector.PrivateKey = signer
-		p.DomainCfg.DKIM.Selectors[selectorName] = moxDkimSelector
+		dkimCfg.Selectors[selectorName] = moxDkimSelector
 	}
 
 	return nil
 }
 
+// selectorKeyLoader returns the loader of the key source of the selector,
+// and the reference of the key for it: the private key path for the file
+// sources, the environment variable for env and the signer url for http.
+// Plain files are loaded with the default loader.
+func selectorKeyLoader(
+	ctx context.Context,
+	loader crypto.IKeyLoader,
+	moxSelector config.MoxSelector,
+) (crypto.IKeyLoader, string, error) {
+	keySource := moxSelector.KeySource
+	switch keySource.Type {
+	case config.KeySourceEnv:
+		return &crypto.EnvKeyLoader{}, keySource.Env, nil
+	case config.KeySourceHTTP:
+		client := &http.Client{Timeout: crypto.DefaultSignerTimeout}
+		if keySource.Timeout > 0 {
+			client.Timeout = keySource.Timeout
+		}
+		return &crypto.HTTPKeyLoader{
+			Client: client,
+			Token:  os.Getenv(keySource.TokenEnv),
+		}, keySource.URL, nil
+	}
+
+	privateKeyPath, err := utils.ValidateIO(
+		ctx,
+		filepath.Clean(moxSelector.PrivateKeyFile),
+		true, false,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if keySource.Type == config.KeySourceEncryptedFile {
+		return &crypto.EncryptedFileKeyLoader{
+			PassphraseEnv:  keySource.PassphraseEnv,
+			PassphraseFile: keySource.PassphraseFile,
+		}, privateKeyPath, nil
+	}
+	return loader, privateKeyPath, nil
+}
+
 // Process handles DKIM signing of an email
 func (p *DKIMProcessor) Process(ctx context.Context, mail *pmail.Mail) (*pmail.Mail, error) {
 	logger := zerolog.Ctx(ctx)
@@ -115,7 +336,7 @@ func (p *DKIMProcessor) Process(ctx context.Context, mail *pmail.Mail) (*pmail.M
 		return nil, errors.NewError(errors.ErrMailProcessing, "mail cannot be nil", nil)
 	}
 
-	if p.DomainCfg == nil || p.DomainCfg.DKIM == nil {
+	if len(p.Domains) < 1 {
 		return nil, errors.NewError(errors.ErrDKIMConfig, "DKIM configuration not initialized", nil)
 	}
 
@@ -124,21 +345,165 @@ func (p *DKIMProcessor) Process(ctx context.Context, mail *pmail.Mail) (*pmail.M
 		return nil, errors.NewError(errors.ErrMailProcessing, "from address required for DKIM signing", nil)
 	}
 
+	domainCfg, ok := p.SigningDomainCfg(mail.From.Domain)
+	if !ok {
+		logger.Warn().
+			Str("from", mail.From.String()).
+			Msg("DKIMProcessor: no dkim key for the from domain, mail not signed")
+		return mail, nil
+	}
+
 	logger.Debug().
 		Str("from", mail.From.String()).
+		Str("domain", domainCfg.Domain.ASCII).
 		Msg("Starting DKIM signing process")
 
 	// Process DKIM signing
-	signedMail, err := p.signMail(ctx, mail)
+	signedMail, err := p.signMail(ctx, mail, domainCfg)
 	if err != nil {
 		return nil, errors.NewError(errors.ErrMailProcessing, "failed to sign mail", err)
 	}
 
+	if p.SigningCfg.Verify.Enabled {
+		signedMail, err = p.verifyMail(ctx, signedMail, domainCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return signedMail, nil
 }
 
-// signMail performs the actual DKIM signing
-func (p *DKIMProcessor) signMail(ctx context.Context, mail *pmail.Mail) (*pmail.Mail, error) {
+// verifyMail verifies the DKIM signatures of a signed mail with the dkim
+// verifier, as a receiver would. In reject mode a failed verification fails
+// the mail, in tag mode the result is set in the metadata.
+func (p *DKIMProcessor) verifyMail(
+	ctx context.Context,
+	mail *pmail.Mail,
+	domainCfg *config.DomainConfig,
+) (*pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx)
+
+	detail, err := p.verifySignatures(ctx, mail, domainCfg)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("domain", domainCfg.Domain.ASCII).
+			Str("detail", detail).
+			Msg("DKIMProcessor: signature verification failed")
+	}
+	if p.SigningCfg.Verify.Mode == config.DKIMVerifyModeReject {
+		if err != nil {
+			return nil, errors.NewError(errors.ErrDKIMVerify, "dkim signature failed verification", err).
+				WithContext("domain", domainCfg.Domain.ASCII).
+				WithContext("detail", detail)
+		}
+		return mail, nil
+	}
+
+	if mail.Metadata == nil {
+		mail.Metadata = make(map[string][]byte)
+	}
+	mail.Metadata[DKIMVerifyResultKey] = []byte(DKIMVerifyResultPass)
+	if err != nil {
+		mail.Metadata[DKIMVerifyResultKey] = []byte(DKIMVerifyResultFail)
+	}
+	mail.Metadata[DKIMVerifyDetailKey] = []byte(detail)
+	return mail, nil
+}
+
+// verifySignatures rebuilds the message as the following mergeHeaders and
+// mergeBody send it, with the DKIM-Signature, and verifies each signature.
+// The public keys are looked up in DNS with verify dns, else they are derived
+// from the private keys of the selectors.
+//
+// Returns:
+//   - string: The status of each signature, e.g. "s=key001 pass"
+//   - error: Non-nil if a signature does not pass
+func (p *DKIMProcessor) verifySignatures(
+	ctx context.Context,
+	mail *pmail.Mail,
+	domainCfg *config.DomainConfig,
+) (string, error) {
+	if _, ok := mail.HeadersMap["DKIM-Signature"]; !ok {
+		return "no dkim signature", fmt.Errorf("no dkim signature")
+	}
+	headers, err := mergeHeaders(mail)
+	if err != nil {
+		return "headers", err
+	}
+	mailMsg := mergeBody(headers, mail.Body)
+
+	resolver := p.Resolver
+	if !p.SigningCfg.Verify.DNS {
+		localResolver, err := selectorKeyResolver(domainCfg)
+		if err != nil {
+			return "public key", err
+		}
+		resolver = localResolver
+	}
+	if resolver == nil {
+		resolver = moxDns.StrictResolver{Log: p.SLogger}
+	}
+
+	results, err := moxDkim.Verify(
+		ctx,
+		p.SLogger,
+		resolver,
+		true,
+		moxDkim.DefaultPolicy,
+		bytes.NewReader(mailMsg),
+		true,
+	)
+	if err != nil {
+		return "verify", err
+	}
+	details := make([]string, 0, len(results))
+	var resultErr error
+	for _, result := range results {
+		selector := "unknown"
+		if result.Sig != nil {
+			selector = result.Sig.Selector.ASCII
+		}
+		detail := fmt.Sprintf("s=%s %s", selector, result.Status)
+		if result.Status != moxDkim.StatusPass {
+			detail += fmt.Sprintf(" (%v)", result.Err)
+			if resultErr == nil {
+				resultErr = fmt.Errorf("selector %s: %s: %w", selector, result.Status, result.Err)
+			}
+		}
+		details = append(details, detail)
+	}
+	if len(results) < 1 {
+		resultErr = fmt.Errorf("no dkim signature verified")
+	}
+	return strings.Join(details, "; "), resultErr
+}
+
+// selectorKeyResolver returns a resolver that answers the DNS records of the
+// selectors of a domain with the public keys of their private keys
+func selectorKeyResolver(domainCfg *config.DomainConfig) (moxDns.Resolver, error) {
+	result := moxDns.MockResolver{TXT: make(map[string][]string)}
+	for _, selector := range domainCfg.DKIM.Selectors {
+		if selector.PrivateKey == nil {
+			continue
+		}
+		txt, err := dkim.PublicKeyRecord(selector.PrivateKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		name := selector.Domain.ASCII + "._domainkey." + domainCfg.Domain.ASCII + "."
+		result.TXT[name] = []string{txt}
+	}
+	return result, nil
+}
+
+// signMail performs the actual DKIM signing, with d= the signing domain
+func (p *DKIMProcessor) signMail(
+	ctx context.Context,
+	mail *pmail.Mail,
+	domainCfg *config.DomainConfig,
+) (*pmail.Mail, error) {
 	logger := zerolog.Ctx(ctx)
 	logger.Debug().
 		Interface("from", mail.From).
@@ -146,24 +511,26 @@ func (p *DKIMProcessor) signMail(ctx context.Context, mail *pmail.Mail) (*pmail.
 
 	canonical := mox.CanonicalLocalpart(
 		mail.From.Localpart,
-		p.DomainCfg.MoxDomain,
+		domainCfg.MoxDomain,
 	)
 
-	mailMsg := mail.Headers
-	mailMsg = append(mailMsg, mail.Body...)
-	mailMsg = append(mailMsg, []byte("\r\n\r\n")...)
-	selectors := slices.Collect(
-		maps.Values(p.DomainCfg.DKIM.Selectors),
-	)
+	// The body is signed as mergeBody sends it
+	mailMsg := mergeBody(mail.Headers, mail.Body)
+	selectors := p.activeSelectors(ctx, domainCfg)
+	if len(selectors) < 1 {
+		logger.Error().Msg("DKIMProcessor: no active selector")
+		return mail, errors.NewError(errors.ErrDKIMConfig, "no active dkim selector", nil).
+			WithContext("domain", domainCfg.Domain.ASCII)
+	}
 
-	dkimHeaders, err := moxDkim.Sign(
+	dkimHeaders, err := dkim.Sign(
 		ctx,
 		p.SLogger,
 		canonical,
-		mail.From.Domain,
+		domainCfg.Domain,
 		selectors,
 		true,
-		bytes.NewReader(mailMsg),
+		mailMsg,
 	)
 	if err != nil {
 		logger.Error().Err(err).Msg("DKIMProcessor: sign")
@@ -186,3 +553,118 @@ func (p *DKIMProcessor) signMail(ctx context.Context, mail *pmail.Mail) (*pmail.
 
 	return mail, nil
 }
+
+// activeSelectors returns the selectors that sign now, in the order of their
+// names. Unless check-dns is disabled, a selector with an active-from is
+// skipped until its DNS record for the domain publishes its public key, so
+// that a new key is only switched to once it has propagated. Until then, the
+// selectors whose active-until has passed since its active-from keep signing,
+// so that the mail is never left unsigned by a slow propagation.
+func (p *DKIMProcessor) activeSelectors(
+	ctx context.Context,
+	domainCfg *config.DomainConfig,
+) []moxDkim.Selector {
+	logger := zerolog.Ctx(ctx)
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	dkimCfg := domainCfg.DKIM
+
+	// pending are the selectors in their window which have not propagated
+	pending := make([]config.MoxSelector, 0)
+	names := make([]string, 0, len(dkimCfg.Selectors))
+	for _, selectorName := range dkimCfg.ActiveSelectors(now) {
+		selector, ok := dkimCfg.Selectors[selectorName]
+		if !ok {
+			continue
+		}
+		moxSelector := dkimCfg.MoxSelectors[selectorName]
+		if dkimCfg.DNSCheck() &&
+			!moxSelector.ActiveFrom.IsZero() &&
+			!p.isPropagated(ctx, now, selector, domainCfg.Domain) {
+			logger.Warn().
+				Str("selector", selectorName).
+				Str("domain", domainCfg.Domain.ASCII).
+				Msg("DKIMProcessor: dns record not propagated, selector skipped")
+			pending = append(pending, moxSelector)
+			continue
+		}
+		names = append(names, selectorName)
+	}
+
+	for selectorName, moxSelector := range dkimCfg.MoxSelectors {
+		if _, ok := dkimCfg.Selectors[selectorName]; !ok ||
+			moxSelector.ActiveUntil.IsZero() ||
+			now.Before(moxSelector.ActiveUntil) {
+			continue
+		}
+		replaced := slices.ContainsFunc(pending, func(next config.MoxSelector) bool {
+			return !next.ActiveFrom.After(moxSelector.ActiveUntil)
+		})
+		if !replaced {
+			continue
+		}
+		logger.Warn().
+			Str("selector", selectorName).
+			Str("domain", domainCfg.Domain.ASCII).
+			Msg("DKIMProcessor: next selector not propagated, expired selector kept")
+		names = append(names, selectorName)
+	}
+	slices.Sort(names)
+
+	result := make([]moxDkim.Selector, 0, len(names))
+	for _, selectorName := range names {
+		result = append(result, dkimCfg.Selectors[selectorName])
+	}
+	return result
+}
+
+// isPropagated checks that the DNS record of the selector holds the public key
+// of its private key. Successful checks are kept, failed ones are retried
+// after DKIMDNSRecheckInterval. The lookup is done without the mutex.
+func (p *DKIMProcessor) isPropagated(
+	ctx context.Context,
+	now time.Time,
+	selector moxDkim.Selector,
+	domain moxDns.Domain,
+) bool {
+	logger := zerolog.Ctx(ctx)
+	name := selector.Domain.ASCII + "._domainkey." + domain.ASCII
+
+	p.mutex.Lock()
+	check, ok := p.propagated[name]
+	p.mutex.Unlock()
+	if ok && (check.ok || now.Sub(check.checked) < DKIMDNSRecheckInterval) {
+		return check.ok
+	}
+
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = moxDns.StrictResolver{Log: p.SLogger}
+	}
+	check = dkimDNSCheck{checked: now}
+	_, record, _, _, err := moxDkim.Lookup(ctx, p.SLogger, resolver, selector.Domain, domain)
+	switch {
+	case err != nil:
+		logger.Debug().Err(err).Str("name", name).Msg("DKIMProcessor: dkim.Lookup")
+	case selector.PrivateKey == nil:
+		logger.Debug().Str("name", name).Msg("DKIMProcessor: no private key loaded")
+	default:
+		public, isPublicKey := selector.PrivateKey.Public().(interface {
+			Equal(x stdCrypto.PublicKey) bool
+		})
+		check.ok = isPublicKey && public.Equal(record.PublicKey)
+	}
+	if check.ok {
+		logger.Info().Str("name", name).Msg("DKIMProcessor: dns record propagated")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.propagated == nil {
+		p.propagated = make(map[string]dkimDNSCheck)
+	}
+	p.propagated[name] = check
+	return check.ok
+}
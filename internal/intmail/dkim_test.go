@@ -9,19 +9,26 @@ import (
 	"crypto/rsa"
 	"crypto/sha1" //nolint:gosec // dkim allows the use of sha1
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"net/http/httptest"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/mjl-/adns"
 	moxDkim "github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/smtp"
 	"github.com/spf13/viper"
 	"github.com/stlimtat/remiges-smtp/internal/config"
+	intCrypto "github.com/stlimtat/remiges-smtp/internal/crypto"
+	intDns "github.com/stlimtat/remiges-smtp/internal/dns"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
 )
 
 func TestDKIMProcessorInit(t *testing.T) {
@@ -390,3 +397,577 @@ func dataHash(
 	}
 	return result
 }
+
+func TestDKIMProcessorRotation(t *testing.T) {
+	switchover := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	_, key001, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, key002, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	dkimRecord := func(key ed25519.PrivateKey) string {
+		return "v=DKIM1; k=ed25519; p=" +
+			base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	}
+
+	tests := []struct {
+		name         string
+		now          time.Time
+		key002Record string
+		// noCheckDNS disables check-dns, which is enabled by default
+		noCheckDNS    bool
+		wantSelectors []string
+	}{
+		{
+			name:          "before switchover",
+			now:           switchover.Add(-time.Hour),
+			wantSelectors: []string{"key001"},
+		},
+		{
+			name:          "overlap, propagated",
+			now:           switchover.Add(time.Hour),
+			key002Record:  dkimRecord(key002),
+			wantSelectors: []string{"key001", "key002"},
+		},
+		{
+			name:          "overlap, not propagated",
+			now:           switchover.Add(time.Hour),
+			key002Record:  dkimRecord(otherKey),
+			wantSelectors: []string{"key001"},
+		},
+		{
+			name:          "after overlap, propagated",
+			now:           switchover.Add(48 * time.Hour),
+			key002Record:  dkimRecord(key002),
+			wantSelectors: []string{"key002"},
+		},
+		{
+			name:          "after overlap, not propagated",
+			now:           switchover.Add(48 * time.Hour),
+			wantSelectors: []string{"key001"},
+		},
+		{
+			name:          "after overlap, wrong record",
+			now:           switchover.Add(48 * time.Hour),
+			key002Record:  dkimRecord(otherKey),
+			wantSelectors: []string{"key001"},
+		},
+		{
+			name:          "overlap, without check-dns",
+			now:           switchover.Add(time.Hour),
+			noCheckDNS:    true,
+			wantSelectors: []string{"key001", "key002"},
+		},
+		{
+			name:          "after overlap, without check-dns",
+			now:           switchover.Add(48 * time.Hour),
+			noCheckDNS:    true,
+			wantSelectors: []string{"key002"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			resolver := intDns.NewMockResolver(ctrl)
+			// Checked once, the result is kept for the second mail
+			resolver.EXPECT().
+				LookupTXT(gomock.Any(), "key002._domainkey.example.com.").
+				DoAndReturn(func(_ context.Context, _ string) ([]string, adns.Result, error) {
+					if tt.key002Record == "" {
+						return nil, adns.Result{}, &adns.DNSError{Err: "no such host", IsNotFound: true}
+					}
+					return []string{tt.key002Record}, adns.Result{}, nil
+				}).
+				MaxTimes(1)
+
+			selectorArgs := func(selectorDomain string) map[string]any {
+				return map[string]any{
+					"algorithm":        "ed25519",
+					"hash":             "sha256",
+					"headers":          []string{"from", "to", "subject"},
+					"private-key-file": "/tmp/" + selectorDomain + ".pem",
+					"selector-domain":  selectorDomain,
+				}
+			}
+			key001Args := selectorArgs("key001")
+			key001Args["active-until"] = switchover.Add(24 * time.Hour).Format(time.RFC3339)
+			key002Args := selectorArgs("key002")
+			key002Args["active-from"] = switchover.Format(time.RFC3339)
+
+			dkimArgs := map[string]any{
+				"selectors": map[string]any{
+					"key001": key001Args,
+					"key002": key002Args,
+				},
+			}
+			if tt.noCheckDNS {
+				dkimArgs["check-dns"] = false
+			}
+
+			processor := &DKIMProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: DKIMProcessorType,
+				Args: map[string]any{
+					"domain-str": "example.com",
+					"dkim":       dkimArgs,
+				},
+			})
+			require.NoError(t, err)
+			for name, key := range map[string]ed25519.PrivateKey{"key001": key001, "key002": key002} {
+				selector := processor.DomainCfg.DKIM.Selectors[name]
+				selector.PrivateKey = key
+				processor.DomainCfg.DKIM.Selectors[name] = selector
+			}
+			processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+			processor.now = func() time.Time { return tt.now }
+
+			for range 2 {
+				mail := &pmail.Mail{
+					From: smtp.Address{Localpart: "sender", Domain: dns.Domain{ASCII: "example.com"}},
+					Headers: []byte("From: sender@example.com\r\n" +
+						"To: recipient@example.com\r\n" +
+						"Subject: test subject\r\n\r\n"),
+					Body: []byte("test body\r\n\r\n"),
+				}
+				gotMail, err := processor.Process(ctx, mail)
+				require.NoError(t, err)
+				signature := string(bytes.ReplaceAll(gotMail.HeadersMap["DKIM-Signature"], []byte("\r\n\t"), []byte{}))
+				for _, selector := range []string{"key001", "key002"} {
+					if slices.Contains(tt.wantSelectors, selector) {
+						assert.Contains(t, signature, "s="+selector+";")
+					} else {
+						assert.NotContains(t, signature, "s="+selector+";")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDKIMProcessorSigningDomain(t *testing.T) {
+	selectorArgs := func(selectorDomain string) map[string]any {
+		return map[string]any{
+			"selectors": map[string]any{
+				selectorDomain: map[string]any{
+					"algorithm":        "ed25519",
+					"hash":             "sha256",
+					"headers":          []string{"from", "to", "subject"},
+					"private-key-file": "/tmp/" + selectorDomain + ".pem",
+					"selector-domain":  selectorDomain,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		parentFallback bool
+		defaultDomain  string
+		from           string
+		wantDomain     string
+		wantSelector   string
+	}{
+		{
+			name:         "args domain",
+			from:         "example.com",
+			wantDomain:   "example.com",
+			wantSelector: "key001",
+		},
+		{
+			name:         "mapped domain",
+			from:         "brand.example",
+			wantDomain:   "brand.example",
+			wantSelector: "brand001",
+		},
+		{
+			name:       "subdomain without fallback",
+			from:       "news.brand.example",
+			wantDomain: "",
+		},
+		{
+			name:           "subdomain with parent fallback",
+			parentFallback: true,
+			from:           "mail.news.brand.example",
+			wantDomain:     "brand.example",
+			wantSelector:   "brand001",
+		},
+		{
+			name:          "default domain",
+			defaultDomain: "signer.example",
+			from:          "other.example",
+			wantDomain:    "signer.example",
+			wantSelector:  "signer001",
+		},
+		{
+			name:       "no matching key",
+			from:       "other.example",
+			wantDomain: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+
+			processor := &DKIMProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: DKIMProcessorType,
+				Args: map[string]any{
+					"domain-str": "example.com",
+					"dkim":       selectorArgs("key001"),
+					"domains": map[string]any{
+						"brand": map[string]any{
+							"domain-str": "Brand.Example",
+							"dkim":       selectorArgs("brand001"),
+						},
+						"signer": map[string]any{
+							"domain-str": "signer.example",
+							"dkim":       selectorArgs("signer001"),
+						},
+					},
+					"default-domain":  tt.defaultDomain,
+					"parent-fallback": tt.parentFallback,
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, processor.Domains, 3)
+			for _, domainCfg := range processor.Domains {
+				for name, selector := range domainCfg.DKIM.Selectors {
+					_, selector.PrivateKey, err = ed25519.GenerateKey(rand.Reader)
+					require.NoError(t, err)
+					domainCfg.DKIM.Selectors[name] = selector
+				}
+			}
+
+			mail := &pmail.Mail{
+				From: smtp.Address{Localpart: "sender", Domain: dns.Domain{ASCII: tt.from}},
+				Headers: []byte("From: sender@" + tt.from + "\r\n" +
+					"To: recipient@example.com\r\n" +
+					"Subject: test subject\r\n\r\n"),
+				Body: []byte("test body\r\n\r\n"),
+			}
+			gotMail, err := processor.Process(ctx, mail)
+			require.NoError(t, err)
+			if tt.wantDomain == "" {
+				assert.NotContains(t, gotMail.HeadersMap, "DKIM-Signature")
+				return
+			}
+			signature := string(bytes.ReplaceAll(gotMail.HeadersMap["DKIM-Signature"], []byte("\r\n\t"), []byte{}))
+			assert.Contains(t, signature, "d="+tt.wantDomain+";")
+			assert.Contains(t, signature, "s="+tt.wantSelector+";")
+		})
+	}
+}
+
+func TestDKIMProcessorInitDomainsErrors(t *testing.T) {
+	selectors := map[string]any{
+		"selectors": map[string]any{
+			"key001": map[string]any{
+				"algorithm":       "ed25519",
+				"hash":            "sha256",
+				"selector-domain": "key001",
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		args map[string]any
+	}{
+		{
+			name: "no domains",
+			args: map[string]any{},
+		},
+		{
+			name: "dkim without domain-str",
+			args: map[string]any{"dkim": selectors},
+		},
+		{
+			name: "duplicate domain",
+			args: map[string]any{
+				"domain-str": "example.com",
+				"dkim":       selectors,
+				"domains": map[string]any{
+					"again": map[string]any{"domain-str": "example.com", "dkim": selectors},
+				},
+			},
+		},
+		{
+			name: "unknown default domain",
+			args: map[string]any{
+				"domain-str":     "example.com",
+				"dkim":           selectors,
+				"default-domain": "other.example",
+			},
+		},
+		{
+			name: "unsupported verify mode",
+			args: map[string]any{
+				"domain-str": "example.com",
+				"dkim":       selectors,
+				"verify":     map[string]any{"enabled": true, "mode": "drop"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			processor := &DKIMProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: DKIMProcessorType,
+				Args: tt.args,
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDKIMProcessorVerify(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		headers      []string
+		mode         string
+		dns          bool
+		dnsKey       ed25519.PrivateKey
+		wantErr      bool
+		wantMetadata string
+		wantDetail   string
+	}{
+		{
+			name:    "loaded key pass",
+			headers: []string{"from", "to", "subject"},
+			mode:    config.DKIMVerifyModeReject,
+		},
+		{
+			name:    "subject not signed",
+			headers: []string{"from", "to"},
+			mode:    config.DKIMVerifyModeReject,
+			wantErr: true,
+		},
+		{
+			name:         "tag pass",
+			headers:      []string{"from", "to", "subject"},
+			mode:         config.DKIMVerifyModeTag,
+			wantMetadata: DKIMVerifyResultPass,
+			wantDetail:   "s=key001 pass",
+		},
+		{
+			name:         "tag subject not signed",
+			headers:      []string{"from", "to"},
+			mode:         config.DKIMVerifyModeTag,
+			wantMetadata: DKIMVerifyResultFail,
+			wantDetail:   "s=key001 policy",
+		},
+		{
+			name:    "dns key pass",
+			headers: []string{"from", "to", "subject"},
+			mode:    config.DKIMVerifyModeReject,
+			dns:     true,
+		},
+		{
+			name:    "dns key mismatch",
+			headers: []string{"from", "to", "subject"},
+			mode:    config.DKIMVerifyModeReject,
+			dns:     true,
+			dnsKey:  otherKey,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+
+			processor := &DKIMProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: DKIMProcessorType,
+				Args: map[string]any{
+					"domain-str": "example.com",
+					"dkim": map[string]any{
+						"selectors": map[string]any{
+							"key001": map[string]any{
+								"algorithm":       "ed25519",
+								"hash":            "sha256",
+								"headers":         tt.headers,
+								"selector-domain": "key001",
+							},
+						},
+					},
+					"verify": map[string]any{
+						"dns":     tt.dns,
+						"enabled": true,
+						"mode":    tt.mode,
+					},
+				},
+			})
+			require.NoError(t, err)
+			domainCfg := processor.Domains["example.com"]
+			selector := domainCfg.DKIM.Selectors["key001"]
+			_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+			require.NoError(t, err)
+			selector.PrivateKey = privateKey
+			domainCfg.DKIM.Selectors["key001"] = selector
+
+			if tt.dns {
+				dnsKey := privateKey
+				if tt.dnsKey != nil {
+					dnsKey = tt.dnsKey
+				}
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				resolver := intDns.NewMockResolver(ctrl)
+				resolver.EXPECT().
+					LookupTXT(gomock.Any(), "key001._domainkey.example.com.").
+					Return([]string{
+						"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(dnsKey.Public().(ed25519.PublicKey)),
+					}, adns.Result{}, nil).
+					Times(1)
+				processor.InitResolver(ctx, resolver, telemetry.GetSLogger(ctx))
+			}
+
+			// The headers are merged before signing, as in the default config,
+			// and the body has the surrounding whitespace that mergeBody trims
+			mail := &pmail.Mail{
+				From: smtp.Address{Localpart: "sender", Domain: dns.Domain{ASCII: "example.com"}},
+				HeadersMap: map[string][]byte{
+					"From":    []byte("sender@example.com"),
+					"To":      []byte("recipient@example.com"),
+					"Subject": []byte("test subject"),
+				},
+				Body: []byte("\r\ntest body\r\n\r\n"),
+			}
+			mergeHeadersProcessor := &MergeHeadersProcessor{}
+			mail, err = mergeHeadersProcessor.Process(ctx, mail)
+			require.NoError(t, err)
+			gotMail, err := processor.Process(ctx, mail)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "DKIM_VERIFY")
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantMetadata != "" {
+				assert.Equal(t, tt.wantMetadata, string(gotMail.Metadata[DKIMVerifyResultKey]))
+				assert.Contains(t, string(gotMail.Metadata[DKIMVerifyDetailKey]), tt.wantDetail)
+			} else {
+				assert.Empty(t, gotMail.Metadata)
+			}
+			if tt.wantMetadata == DKIMVerifyResultFail {
+				return
+			}
+
+			// The message as sent passes as the verify step found
+			gotMail, err = mergeHeadersProcessor.Process(ctx, gotMail)
+			require.NoError(t, err)
+			gotMail, err = (&MergeBodyProcessor{}).Process(ctx, gotMail)
+			require.NoError(t, err)
+			resolver, err := selectorKeyResolver(domainCfg)
+			require.NoError(t, err)
+			results, err := moxDkim.Verify(
+				ctx, telemetry.GetSLogger(ctx), resolver, true, moxDkim.DefaultPolicy,
+				bytes.NewReader(gotMail.FinalBody), true,
+			)
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, moxDkim.StatusPass, results[0].Status, results[0].Err)
+		})
+	}
+}
+
+func TestDKIMProcessorKeySources(t *testing.T) {
+	_, envKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, remoteKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	server := httptest.NewServer(&intCrypto.SignerHandler{Signer: remoteKey, Token: "token"})
+	defer server.Close()
+	t.Setenv("TEST_DKIM_KEY001", base64.StdEncoding.EncodeToString(envKey.Seed()))
+	t.Setenv("TEST_DKIM_TOKEN", "token")
+
+	tests := []struct {
+		name      string
+		keySource map[string]any
+		want      ed25519.PrivateKey
+		wantErr   bool
+	}{
+		{
+			name:      "env",
+			keySource: map[string]any{"type": config.KeySourceEnv, "env": "TEST_DKIM_KEY001"},
+			want:      envKey,
+		},
+		{
+			name:      "env not set",
+			keySource: map[string]any{"type": config.KeySourceEnv, "env": "TEST_DKIM_UNSET"},
+			wantErr:   true,
+		},
+		{
+			name: "http",
+			keySource: map[string]any{
+				"type":      config.KeySourceHTTP,
+				"token-env": "TEST_DKIM_TOKEN",
+				"url":       server.URL,
+			},
+			want: remoteKey,
+		},
+		{
+			name:      "http unauthorized",
+			keySource: map[string]any{"type": config.KeySourceHTTP, "url": server.URL},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			// Only plain files are loaded with the default loader
+			loader := intCrypto.NewMockIKeyLoader(ctrl)
+
+			processor := &DKIMProcessor{}
+			err := processor.Init(ctx, config.MailProcessorConfig{
+				Type: DKIMProcessorType,
+				Args: map[string]any{
+					"domain-str": "example.com",
+					"dkim": map[string]any{
+						"selectors": map[string]any{
+							"key001": map[string]any{
+								"algorithm":       "ed25519",
+								"hash":            "sha256",
+								"headers":         []string{"from", "to", "subject"},
+								"key-source":      tt.keySource,
+								"selector-domain": "key001",
+							},
+						},
+					},
+				},
+			})
+			require.NoError(t, err)
+			err = processor.InitDKIMCrypto(ctx, loader)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			selector := processor.Domains["example.com"].DKIM.Selectors["key001"]
+			assert.Equal(t, tt.want.Public(), selector.PrivateKey.Public())
+
+			mail := &pmail.Mail{
+				From:    smtp.Address{Localpart: "john", Domain: dns.Domain{ASCII: "example.com"}},
+				Headers: []byte("From: john@example.com\r\nTo: jane@example.org\r\nSubject: hello\r\n\r\n"),
+				Body:    []byte("hello world\r\n"),
+			}
+			got, err := processor.Process(ctx, mail)
+			require.NoError(t, err)
+			assert.Contains(t, string(got.HeadersMap["DKIM-Signature"]), "s=key001")
+		})
+	}
+}
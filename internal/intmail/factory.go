@@ -29,6 +29,8 @@ func NewDefaultMailProcessorFactory(
 		CryptoFactory: cryptoFactory,
 	}
 	result.Registry = make(map[string]reflect.Type)
+	result.Registry[AlignmentProcessorType] = reflect.TypeOf(AlignmentProcessor{})
+	result.Registry[ARCProcessorType] = reflect.TypeOf(ARCProcessor{})
 	result.Registry[BodyHeadersProcessorType] = reflect.TypeOf(BodyHeadersProcessor{})
 	result.Registry[BodyProcessorType] = reflect.TypeOf(BodyProcessor{})
 	result.Registry[DKIMProcessorType] = reflect.TypeOf(DKIMProcessor{})
@@ -128,6 +130,16 @@ func (f *DefaultMailProcessorFactory) NewMailProcessor(
 		}
 		result = dkimProcessor
 	}
+	if cfg.Type == ARCProcessorType {
+		arcProcessor, ok := result.(*ARCProcessor)
+		if !ok {
+			return nil, fmt.Errorf("processor is not an ARCProcessor")
+		}
+		err = arcProcessor.InitARCCrypto(ctx, f.CryptoFactory)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return result, nil
 }
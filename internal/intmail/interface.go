@@ -3,6 +3,7 @@ package intmail
 import (
 	"context"
 
+	moxDns "github.com/mjl-/mox/dns"
 	"github.com/stlimtat/remiges-smtp/internal/config"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 )
@@ -21,3 +22,9 @@ type IMailProcessorFactory interface {
 	// Process is a builder function to build through the mail processors in
 	// the order they were created
 }
+
+// IDKIMSigner gives the domain config which signs mail from a domain, as the
+// dkim processor chooses it
+type IDKIMSigner interface {
+	SigningDomainCfg(domain moxDns.Domain) (*config.DomainConfig, bool)
+}
@@ -42,12 +42,20 @@ func (_ *MergeBodyProcessor) Process(
 	logger := zerolog.Ctx(ctx)
 	logger.Debug().Msg("MergeBodyProcessor")
 
-	body := bytes.TrimSpace(inMail.Body)
-	finalBody := inMail.Headers
-	finalBody = append(finalBody, body...)
-	finalBody = append(finalBody, []byte("\r\n\r\n")...)
-	inMail.FinalBody = finalBody
+	inMail.FinalBody = mergeBody(inMail.Headers, inMail.Body)
 
 	logger.Info().Bytes("final_body", inMail.FinalBody).Msg("MergeBodyProcessor")
 	return inMail, nil
 }
+
+// mergeBody returns the message as it is sent, the header section followed
+// by the body without its surrounding whitespace. DKIM signs and verifies
+// these same bytes.
+func mergeBody(headers []byte, body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	result := make([]byte, 0, len(headers)+len(body)+4)
+	result = append(result, headers...)
+	result = append(result, body...)
+	result = append(result, []byte("\r\n\r\n")...)
+	return result
+}
@@ -3,9 +3,12 @@ package intmail
 import (
 	"context"
 	"slices"
+	"strings"
 
 	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/arc"
 	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/errors"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 )
 
@@ -13,6 +16,17 @@ const (
 	MergeHeadersProcessorType = "mergeHeaders"
 )
 
+// ReceivedHeaders are the header fields of RawHeaders that mergeHeaders
+// keeps, with every occurrence, as the ARC sets must reach the next hop
+var ReceivedHeaders = []string{
+	arc.HeaderAS,
+	arc.HeaderAMS,
+	arc.HeaderAAR,
+	"Authentication-Results",
+}
+
+// MergeHeadersProcessor builds the header section of the mail from
+// HeadersMap, after the ReceivedHeaders of RawHeaders, as read
 type MergeHeadersProcessor struct {
 	Cfg config.MailProcessorConfig
 }
@@ -36,7 +50,22 @@ func (_ *MergeHeadersProcessor) Process(
 	logger := zerolog.Ctx(ctx)
 	logger.Info().Msg("MergeHeadersProcessor: Process")
 
-	result := make([]byte, 0)
+	headers, err := mergeHeaders(inMail)
+	if err != nil {
+		return nil, err
+	}
+	inMail.Headers = headers
+
+	return inMail, nil
+}
+
+// mergeHeaders returns the header section of the mail, the ReceivedHeaders
+// of RawHeaders and then HeadersMap in the order of the keys
+func mergeHeaders(inMail *pmail.Mail) ([]byte, error) {
+	result, err := receivedHeaders(inMail)
+	if err != nil {
+		return nil, err
+	}
 	keys := make([]string, 0)
 	for key := range inMail.HeadersMap {
 		keys = append(keys, key)
@@ -50,8 +79,35 @@ func (_ *MergeHeadersProcessor) Process(
 	}
 
 	result = append(result, []byte("\r\n")...)
+	return result, nil
+}
 
-	inMail.Headers = result
-
-	return inMail, nil
+// receivedHeaders returns the ReceivedHeaders of RawHeaders, folded as read,
+// other than those set in HeadersMap
+func receivedHeaders(inMail *pmail.Mail) ([]byte, error) {
+	result := make([]byte, 0)
+	if len(inMail.RawHeaders) == 0 {
+		return result, nil
+	}
+	hdrs, err := arc.ParseHeaders(inMail.RawHeaders)
+	if err != nil {
+		return nil, errors.NewError(errors.ErrMailProcessing, "failed to parse raw headers", err)
+	}
+	for _, h := range hdrs {
+		keep := slices.ContainsFunc(ReceivedHeaders, func(name string) bool {
+			return strings.EqualFold(name, h.Key)
+		})
+		if !keep {
+			continue
+		}
+		set := false
+		for key := range inMail.HeadersMap {
+			set = set || strings.EqualFold(key, h.Key)
+		}
+		if set {
+			continue
+		}
+		result = append(result, h.Raw...)
+	}
+	return result, nil
 }
@@ -15,6 +15,7 @@ func TestMergeHeaders(t *testing.T) {
 	tests := []struct {
 		name        string
 		headersMap  map[string][]byte
+		rawHeaders  []byte
 		wantHeaders []byte
 		wantErr     bool
 	}{
@@ -27,6 +28,27 @@ func TestMergeHeaders(t *testing.T) {
 			wantHeaders: []byte("From: sender@example.com\r\nTo: recipient@example.com\r\n\r\n"),
 			wantErr:     false,
 		},
+		{
+			name: "received arc sets",
+			headersMap: map[string][]byte{
+				"From":                   []byte("sender@example.com"),
+				"Authentication-Results": []byte("mx.example.com; none"),
+			},
+			rawHeaders: []byte("Authentication-Results: mx.forwarder.example; spf=pass\r\n" +
+				"ARC-Seal: i=2; cv=pass\r\n" +
+				"ARC-Seal: i=1; cv=none;\r\n\tb=abc\r\n" +
+				"From: Sender <sender@example.com>\r\n" +
+				"Received: from a by b\r\n"),
+			wantHeaders: []byte("ARC-Seal: i=2; cv=pass\r\n" +
+				"ARC-Seal: i=1; cv=none;\r\n\tb=abc\r\n" +
+				"Authentication-Results: mx.example.com; none\r\n" +
+				"From: sender@example.com\r\n\r\n"),
+		},
+		{
+			name:       "invalid raw headers",
+			rawHeaders: []byte(" folded\r\n"),
+			wantErr:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -38,6 +60,7 @@ func TestMergeHeaders(t *testing.T) {
 
 			got, err := processor.Process(ctx, &pmail.Mail{
 				HeadersMap: tt.headersMap,
+				RawHeaders: tt.rawHeaders,
 			})
 			if tt.wantErr {
 				assert.Error(t, err)
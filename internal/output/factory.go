@@ -19,10 +19,12 @@ import (
 //   - Cfgs: List of output configurations that define how each output should be created
 //   - Outputs: List of initialized output instances
 //   - FileTracker: Interface for tracking file read operations
+//   - ResultStore: Store of the results output, nil without one
 type OutputFactory struct {
 	Cfgs        []config.OutputConfig
 	Outputs     []IOutput
 	FileTracker file.IFileReadTracker
+	ResultStore IResultStore
 }
 
 // NewOutputFactory creates a new instance of OutputFactory.
@@ -30,15 +32,18 @@ type OutputFactory struct {
 // Parameters:
 //   - ctx: Context for logging and cancellation
 //   - fileTracker: Interface for tracking file read operations
+//   - resultStore: Store of the results output
 //
 // Returns:
 //   - *OutputFactory: A new instance of OutputFactory
 func NewOutputFactory(
 	_ context.Context,
 	fileTracker file.IFileReadTracker,
+	resultStore IResultStore,
 ) *OutputFactory {
 	result := &OutputFactory{
 		FileTracker: fileTracker,
+		ResultStore: resultStore,
 	}
 	return result
 }
@@ -100,6 +105,7 @@ func (f *OutputFactory) NewOutputs(
 // Supported output types:
 //   - file: Writes output to files
 //   - file_tracker: Tracks file read operations
+//   - results: Saves the results of each recipient for the messages api
 func (f *OutputFactory) NewOutput(
 	ctx context.Context,
 	cfg config.OutputConfig,
@@ -124,6 +130,14 @@ func (f *OutputFactory) NewOutput(
 		if err != nil {
 			return nil, err
 		}
+	case config.ConfigOutputTypeResults:
+		logger.Debug().
+			Interface("cfg", cfg).
+			Msg("Creating results output")
+		result, err = NewResultsOutput(ctx, cfg, f.ResultStore)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unknown output type: %s", cfg.Type)
 	}
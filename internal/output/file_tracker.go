@@ -49,7 +49,7 @@ func NewFileTrackerOutput(
 
 // Write implements the IOutput interface by updating the file tracker to mark a file as processed.
 // It sets the file status to FILE_STATUS_DONE in the file tracker, indicating that the file
-// has been successfully processed.
+// has been successfully processed, unless it is FILE_STATUS_PARTIAL with recipients left.
 //
 // Parameters:
 //   - ctx: Context for logging and cancellation
@@ -72,6 +72,11 @@ func (f *FileTrackerOutput) Write(
 		Logger()
 	logger.Debug().Msg("FileTrackerOutput: Write")
 
+	// A file with recipients left is not done, it is read again
+	if fileInfo.Status == input.FILE_STATUS_PARTIAL {
+		return nil
+	}
+
 	err := f.FileTracker.UpsertFile(ctx, fileInfo.ID, input.FILE_STATUS_DONE)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to upsert file")
@@ -26,6 +26,7 @@ func TestFileTrackerOutput_Write(t *testing.T) {
 	tests := []struct {
 		name        string
 		fileID      string
+		status      input.FileStatus
 		mail        *pmail.Mail
 		setupMock   func(*file.MockIFileReadTracker)
 		expectError bool
@@ -43,6 +44,16 @@ func TestFileTrackerOutput_Write(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name:   "partial delivery is not done",
+			fileID: "test123",
+			status: input.FILE_STATUS_PARTIAL,
+			mail: &pmail.Mail{
+				MsgID: []byte("test-msg-id"),
+			},
+			setupMock:   func(*file.MockIFileReadTracker) {},
+			expectError: false,
+		},
 		{
 			name:   "tracker error",
 			fileID: "test123",
@@ -77,7 +88,8 @@ func TestFileTrackerOutput_Write(t *testing.T) {
 
 			// Create file info
 			fileInfo := &file.FileInfo{
-				ID: tt.fileID,
+				ID:     tt.fileID,
+				Status: tt.status,
 			}
 
 			// Create responses map
@@ -44,4 +44,29 @@ type IOutputFactory interface {
 	NewOutputs(ctx context.Context, cfgs []config.OutputConfig) ([]IOutput, error)
 }
 
-//go:generate mockgen -destination=mock.go -package=output github.com/stlimtat/remiges-smtp/internal/output IOutput,IOutputFactory
+// IResultStore stores the results of the mails by file id, so that they
+// can be looked up after the delivery, e.g. by the messages api.
+type IResultStore interface {
+	// Save stores the results of a mail, replacing its previous results.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - result: The results of the mail, by recipient
+	//
+	// Returns:
+	//   - error: Non-nil if the results cannot be stored
+	Save(ctx context.Context, result *MessageResult) error
+
+	// Get returns the results of a mail.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeout control
+	//   - id: The id of the file of the mail
+	//
+	// Returns:
+	//   - *MessageResult: The results of the mail, nil if there are none
+	//   - error: Non-nil if the results cannot be read
+	Get(ctx context.Context, id string) (*MessageResult, error)
+}
+
+//go:generate mockgen -destination=mock.go -package=output github.com/stlimtat/remiges-smtp/internal/output IOutput,IOutputFactory,IResultStore
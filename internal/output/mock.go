@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/stlimtat/remiges-smtp/internal/output (interfaces: IOutput,IOutputFactory)
+// Source: github.com/stlimtat/remiges-smtp/internal/output (interfaces: IOutput,IOutputFactory,IResultStore)
 //
 // Generated by this command:
 //
-//	mockgen -destination=mock.go -package=output github.com/stlimtat/remiges-smtp/internal/output IOutput,IOutputFactory
+//	mockgen -destination=mock.go -package=output github.com/stlimtat/remiges-smtp/internal/output IOutput,IOutputFactory,IResultStore
 //
 
 // Package output is a generated GoMock package.
@@ -95,3 +95,56 @@ func (mr *MockIOutputFactoryMockRecorder) NewOutputs(ctx, cfgs any) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewOutputs", reflect.TypeOf((*MockIOutputFactory)(nil).NewOutputs), ctx, cfgs)
 }
+
+// MockIResultStore is a mock of IResultStore interface.
+type MockIResultStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockIResultStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockIResultStoreMockRecorder is the mock recorder for MockIResultStore.
+type MockIResultStoreMockRecorder struct {
+	mock *MockIResultStore
+}
+
+// NewMockIResultStore creates a new mock instance.
+func NewMockIResultStore(ctrl *gomock.Controller) *MockIResultStore {
+	mock := &MockIResultStore{ctrl: ctrl}
+	mock.recorder = &MockIResultStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIResultStore) EXPECT() *MockIResultStoreMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockIResultStore) Get(ctx context.Context, id string) (*MessageResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, id)
+	ret0, _ := ret[0].(*MessageResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockIResultStoreMockRecorder) Get(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockIResultStore)(nil).Get), ctx, id)
+}
+
+// Save mocks base method.
+func (m *MockIResultStore) Save(ctx context.Context, result *MessageResult) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Save", ctx, result)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Save indicates an expected call of Save.
+func (mr *MockIResultStoreMockRecorder) Save(ctx, result any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockIResultStore)(nil).Save), ctx, result)
+}
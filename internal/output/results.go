@@ -0,0 +1,181 @@
+// Package output provides functionality for writing mail processing results to various output destinations.
+// It includes implementations for different output types (e.g., file, HTTP, etc.) and a factory
+// for creating output instances based on configuration.
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	// ResultsKeyPrefix prefixes the redis keys of the results, by file id
+	ResultsKeyPrefix = "results_"
+	// ResultsTTL is how long the results are kept, as long as the tracker
+	// keeps the status of a file
+	ResultsTTL = 6 * time.Hour
+)
+
+// RecipientResult is the result of the delivery of a mail to a recipient
+type RecipientResult struct {
+	Code      int    `json:"code"`
+	Secode    string `json:"secode,omitempty"`
+	Line      string `json:"line"`
+	Permanent bool   `json:"permanent"`
+}
+
+// MessageResult holds the results of a mail, by recipient. The results of
+// a rejected mail are keyed by its invalid fields.
+type MessageResult struct {
+	ID         string                       `json:"id"`
+	MessageID  string                       `json:"message_id,omitempty"`
+	Recipients map[string][]RecipientResult `json:"recipients"`
+	Updated    time.Time                    `json:"updated"`
+}
+
+// RedisResultStore implements IResultStore with redis, where the results
+// expire after ResultsTTL
+type RedisResultStore struct {
+	redisClient *redis.Client
+}
+
+// NewRedisResultStore creates a new instance of RedisResultStore.
+//
+// Parameters:
+//   - ctx: Context for initialization (currently unused)
+//   - redisClient: The Redis client to store the results with
+//
+// Returns:
+//   - *RedisResultStore: A new store instance
+func NewRedisResultStore(
+	_ context.Context,
+	redisClient *redis.Client,
+) *RedisResultStore {
+	return &RedisResultStore{redisClient: redisClient}
+}
+
+func (s *RedisResultStore) Save(ctx context.Context, result *MessageResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.redisClient.Set(ctx, ResultsKeyPrefix+result.ID, data, ResultsTTL).Err()
+}
+
+func (s *RedisResultStore) Get(ctx context.Context, id string) (*MessageResult, error) {
+	data, err := s.redisClient.Get(ctx, ResultsKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &MessageResult{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ResultsOutput implements the IOutput interface by saving the results of
+// each recipient in a result store, for the messages api.
+type ResultsOutput struct {
+	// Cfg contains the output configuration specifying the output type and settings
+	Cfg config.OutputConfig
+
+	// ResultStore stores the results
+	ResultStore IResultStore
+}
+
+// NewResultsOutput creates a new ResultsOutput instance.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation (currently unused)
+//   - cfg: Output configuration
+//   - resultStore: The store of the results
+//
+// Returns:
+//   - *ResultsOutput: A new ResultsOutput instance
+//   - error: Non-nil if there is no result store
+func NewResultsOutput(
+	_ context.Context,
+	cfg config.OutputConfig,
+	resultStore IResultStore,
+) (*ResultsOutput, error) {
+	if resultStore == nil {
+		return nil, errors.New("results output requires a result store")
+	}
+	return &ResultsOutput{
+		Cfg:         cfg,
+		ResultStore: resultStore,
+	}, nil
+}
+
+// Write implements the IOutput interface by saving the responses of the
+// mail under the id of its file, with the results of the recipients written
+// before, e.g. by a partial delivery.
+//
+// Parameters:
+//   - ctx: Context for logging and cancellation
+//   - fileInfo: Information about the source file being processed
+//   - myMail: The mail content being processed
+//   - responses: Map of SMTP responses for different recipients
+//
+// Returns:
+//   - error: Non-nil if saving the results fails
+func (r *ResultsOutput) Write(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	myMail *pmail.Mail,
+	responses map[string][]pmail.Response,
+) error {
+	logger := zerolog.Ctx(ctx).
+		With().
+		Str("fileInfo.id", fileInfo.ID).
+		Logger()
+	logger.Debug().Msg("ResultsOutput: Write")
+
+	result := &MessageResult{
+		ID:         fileInfo.ID,
+		MessageID:  string(myMail.MsgID),
+		Recipients: make(map[string][]RecipientResult, len(responses)),
+		Updated:    time.Now().UTC(),
+	}
+	for to, resp := range responses {
+		for _, response := range resp {
+			result.Recipients[to] = append(result.Recipients[to], RecipientResult{
+				Code:      response.Code,
+				Secode:    response.Secode,
+				Line:      response.Line,
+				Permanent: response.Permanent,
+			})
+		}
+	}
+	previous, err := r.ResultStore.Get(ctx, fileInfo.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("ResultStore.Get")
+		return err
+	}
+	if previous != nil {
+		for to, results := range previous.Recipients {
+			if _, ok := result.Recipients[to]; !ok {
+				result.Recipients[to] = results
+			}
+		}
+	}
+	err = r.ResultStore.Save(ctx, result)
+	if err != nil {
+		logger.Error().Err(err).Msg("ResultStore.Save")
+		return err
+	}
+	return nil
+}
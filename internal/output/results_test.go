@@ -0,0 +1,75 @@
+package output
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/redis/go-redis/v9"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultsOutput(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := NewRedisResultStore(ctx, redisClient)
+
+	got, err := store.Get(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = NewResultsOutput(ctx, config.OutputConfig{}, nil)
+	assert.Error(t, err)
+	output, err := NewResultsOutput(ctx, config.OutputConfig{}, store)
+	require.NoError(t, err)
+
+	err = output.Write(
+		ctx,
+		&file.FileInfo{ID: "abc"},
+		&pmail.Mail{MsgID: []byte("<abc@example.com>")},
+		map[string][]pmail.Response{
+			"john@example.org": {
+				{Response: smtpclient.Response{Code: 250, Secode: "0.0", Line: "250 2.0.0 OK"}},
+			},
+			"jane@example.org": {
+				{Response: smtpclient.Response{Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown", Permanent: true}},
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, ResultsTTL, mr.TTL(ResultsKeyPrefix+"abc"))
+
+	got, err = store.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", got.ID)
+	assert.Equal(t, "<abc@example.com>", got.MessageID)
+	assert.Equal(t, map[string][]RecipientResult{
+		"john@example.org": {{Code: 250, Secode: "0.0", Line: "250 2.0.0 OK"}},
+		"jane@example.org": {{Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown", Permanent: true}},
+	}, got.Recipients)
+	assert.False(t, got.Updated.IsZero())
+
+	// The results of a partial delivery are merged with the later ones
+	err = output.Write(
+		ctx,
+		&file.FileInfo{ID: "abc"},
+		&pmail.Mail{MsgID: []byte("<abc@example.com>")},
+		map[string][]pmail.Response{
+			"jack@example.org": {
+				{Response: smtpclient.Response{Code: 250, Secode: "0.0", Line: "250 2.0.0 OK"}},
+			},
+		},
+	)
+	require.NoError(t, err)
+	got, err = store.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Len(t, got.Recipients, 3)
+	assert.Equal(t, []RecipientResult{{Code: 250, Secode: "0.0", Line: "250 2.0.0 OK"}}, got.Recipients["jack@example.org"])
+}
@@ -0,0 +1,141 @@
+package sendmail
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Lane is a pool of workers sending the mails of a lane of the file reader.
+// A worker of a lane also sends the mails of the more urgent lanes first,
+// within their rates, so that the urgent mails never wait behind the others.
+type Lane struct {
+	// Name is the name of the lane of the file reader
+	Name string
+
+	// Concurrency is the number of workers of the lane
+	Concurrency int
+
+	// limiter limits the rate of the mails of the lane, nil when unlimited
+	limiter *rateLimiter
+}
+
+// NewLane creates a new Lane.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - name: The name of the lane of the file reader
+//   - concurrency: The number of workers of the lane
+//   - rate: The number of mails sent per second, unlimited when 0
+//   - burst: The number of mails sent at once, within the rate
+//
+// Returns:
+//   - *Lane: A new lane
+func NewLane(
+	ctx context.Context,
+	name string,
+	concurrency int,
+	rate float64,
+	burst int,
+) *Lane {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("name", name).
+		Int("concurrency", concurrency).
+		Float64("rate", rate).
+		Int("burst", burst).
+		Msg("NewLane")
+	result := &Lane{
+		Name:        name,
+		Concurrency: concurrency,
+	}
+	if rate > 0 {
+		result.limiter = newRateLimiter(rate, burst)
+	}
+	return result
+}
+
+// rateLimiter is a token bucket, filled with rate tokens per second up to
+// burst tokens
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		burst:  math.Max(1, float64(burst)),
+		tokens: math.Max(1, float64(burst)),
+		now:    time.Now,
+	}
+}
+
+// fill adds the tokens since the last call, and returns the current time
+func (l *rateLimiter) fill() time.Time {
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	return now
+}
+
+// allow takes a token when one is available now
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fill()
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// wait takes a token, waiting until it is available or the context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.fill()
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.refund()
+		return ctx.Err()
+	}
+}
+
+// refund gives back a token that was not used
+func (l *rateLimiter) refund() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fill()
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
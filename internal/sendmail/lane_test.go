@@ -0,0 +1,140 @@
+package sendmail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/file_mail"
+	"github.com/stlimtat/remiges-smtp/internal/intmail"
+	"github.com/stlimtat/remiges-smtp/internal/output"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	now := time.Now()
+	limiter := newRateLimiter(2, 2)
+	limiter.now = func() time.Time { return now }
+
+	// The burst is available at once
+	assert.True(t, limiter.allow())
+	assert.True(t, limiter.allow())
+	assert.False(t, limiter.allow())
+
+	// A token is added every 1/rate
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.allow())
+	assert.False(t, limiter.allow())
+	limiter.refund()
+	assert.True(t, limiter.allow())
+
+	// The waiting token is given back when the context is done
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, limiter.wait(ctx), context.Canceled)
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.allow())
+
+	// An unlimited lane has no limiter
+	var unlimited *rateLimiter
+	assert.True(t, unlimited.allow())
+	assert.NoError(t, unlimited.wait(ctx))
+	assert.Nil(t, NewLane(ctx, "bulk", 1, 0, 0).limiter)
+}
+
+func TestProcessNextLaneMail(t *testing.T) {
+	tests := []struct {
+		name string
+		// index is the lane of the worker
+		index int
+		// exhausted empties the tokens of the transactional lane
+		exhausted bool
+		// files are the files of each lane
+		files    map[string]string
+		wantLane string
+	}{
+		{
+			name:     "bulk worker sends transactional first",
+			index:    1,
+			files:    map[string]string{"transactional": "t1", "bulk": "b1"},
+			wantLane: "transactional",
+		},
+		{
+			name:     "bulk worker sends bulk without transactional",
+			index:    1,
+			files:    map[string]string{"bulk": "b1"},
+			wantLane: "bulk",
+		},
+		{
+			name:      "bulk worker skips transactional above its rate",
+			index:     1,
+			exhausted: true,
+			files:     map[string]string{"transactional": "t1", "bulk": "b1"},
+			wantLane:  "bulk",
+		},
+		{
+			name:  "transactional worker never sends bulk",
+			index: 0,
+			files: map[string]string{"bulk": "b1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			mockLaneReader := file.NewMockIFileLaneReader(ctrl)
+			mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+			mockMailSender := NewMockIMailSender(ctrl)
+			mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+			mockOutput := output.NewMockIOutput(ctrl)
+
+			mockLaneReader.EXPECT().ReadNextLaneFile(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, lane string) (*file.FileInfo, error) {
+					id, ok := tt.files[lane]
+					if !ok {
+						return nil, nil
+					}
+					return &file.FileInfo{ID: id, Lane: lane}, nil
+				}).AnyTimes()
+			mail := &pmail.Mail{}
+			if tt.wantLane != "" {
+				mockMailTransformer.EXPECT().Transform(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, fileInfo *file.FileInfo, _ *pmail.Mail) (*pmail.Mail, error) {
+						assert.Equal(t, tt.wantLane, fileInfo.Lane)
+						return mail, nil
+					})
+				mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(mail, nil)
+				mockMailSender.EXPECT().SendMail(gomock.Any(), mail).Return(map[string][]pmail.Response{}, nil)
+				mockOutput.EXPECT().Write(gomock.Any(), gomock.Any(), mail, gomock.Any()).Return(nil)
+			}
+
+			service := NewSendMailService(
+				ctx,
+				1,
+				mockLaneReader,
+				mockMailProcessor,
+				mockMailSender,
+				mockMailTransformer,
+				mockOutput,
+				time.Second,
+				nil,
+			)
+			service.Lanes = []*Lane{
+				NewLane(ctx, "transactional", 1, 10, 1),
+				NewLane(ctx, "bulk", 1, 0, 0),
+			}
+			if tt.exhausted {
+				require.True(t, service.Lanes[0].limiter.allow())
+			}
+
+			got := service.processNextLaneMail(ctx, mockLaneReader, tt.index)
+			assert.Equal(t, tt.wantLane != "", got)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package sendmail
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+)
+
+const (
+	// HeaderOriginalToKey records the recipients replaced by the sandbox catch-all
+	HeaderOriginalToKey = "X-Original-To"
+
+	// SandboxDroppedLine is the output record line for recipients dropped by the sandbox
+	SandboxDroppedLine = "sandbox: recipient dropped"
+)
+
+// Sandbox restricts the recipients of a mail to an allowlist, so that
+// non-production environments cannot deliver mail to real recipients.
+// It is enforced by SendMailService after all transformers and processors
+// have run, and before the mail is handed to the IMailSender.
+type Sandbox struct {
+	// Cfg holds the allowlist, mode and catch-all address
+	Cfg config.SandboxConfig
+}
+
+// NewSandbox creates a new Sandbox with the specified configuration.
+// The configuration is expected to have been transformed already.
+//
+// Parameters:
+//   - ctx: Context for the sandbox creation
+//   - cfg: Sandbox configuration
+//
+// Returns:
+//   - *Sandbox: A new sandbox instance
+func NewSandbox(
+	_ context.Context,
+	cfg config.SandboxConfig,
+) *Sandbox {
+	result := &Sandbox{
+		Cfg: cfg,
+	}
+	return result
+}
+
+// Allowed reports whether the recipient matches any of the allowlist patterns.
+// Patterns containing an "@" are matched against the full address, all other
+// patterns are matched against the domain. Matching is case-insensitive.
+//
+// Parameters:
+//   - to: Recipient's SMTP address
+//
+// Returns:
+//   - bool: true if the recipient may be delivered to as-is
+func (s *Sandbox) Allowed(to smtp.Address) bool {
+	addr := strings.ToLower(to.String())
+	domain := strings.ToLower(to.Domain.ASCII)
+	for _, pattern := range s.Cfg.Allowlist {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		target := domain
+		if strings.Contains(pattern, "@") {
+			target = addr
+		}
+		matched, err := path.Match(pattern, target)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply enforces the sandbox on the mail. Recipients not on the allowlist are
+// either rewritten to the catch-all address, with the original recipients kept
+// in the X-Original-To header, or dropped with an output record.
+//
+// Parameters:
+//   - ctx: Context for the operation
+//   - myMail: The fully processed mail
+//
+// Returns:
+//   - *pmail.Mail: The mail with its recipients restricted
+//   - map[string][]pmail.Response: Output records for the dropped recipients
+func (s *Sandbox) Apply(
+	ctx context.Context,
+	myMail *pmail.Mail,
+) (*pmail.Mail, map[string][]pmail.Response) {
+	if s == nil || !s.Cfg.Enabled || myMail == nil {
+		return myMail, nil
+	}
+	logger := zerolog.Ctx(ctx).
+		With().
+		Bytes("msgid", myMail.MsgID).
+		Str("mode", s.Cfg.Mode).
+		Logger()
+
+	allowed := make([]smtp.Address, 0, len(myMail.To))
+	denied := make([]string, 0)
+	for _, to := range myMail.To {
+		if s.Allowed(to) {
+			allowed = append(allowed, to)
+			continue
+		}
+		denied = append(denied, to.String())
+	}
+	if len(denied) == 0 {
+		return myMail, nil
+	}
+
+	dropped := make(map[string][]pmail.Response)
+	switch s.Cfg.Mode {
+	case config.SandboxModeRewrite:
+		logger.Warn().
+			Strs("original_to", denied).
+			Str("catch_all", s.Cfg.CatchAllAddr.String()).
+			Msg("Sandbox: rewriting recipients")
+		catchAllFound := false
+		for _, to := range allowed {
+			if to == s.Cfg.CatchAllAddr {
+				catchAllFound = true
+			}
+		}
+		if !catchAllFound {
+			allowed = append(allowed, s.Cfg.CatchAllAddr)
+		}
+		// The header is prepended to the final body, so that it does not
+		// invalidate any signature that has already been added
+		originalTo := []byte(strings.Join(denied, ", "))
+		myMail.SetHeader(HeaderOriginalToKey, originalTo)
+		finalBody := []byte(HeaderOriginalToKey + ": " + string(originalTo) + "\r\n")
+		myMail.FinalBody = append(finalBody, myMail.FinalBody...)
+	default:
+		for _, to := range denied {
+			logger.Warn().
+				Str("to", to).
+				Msg("Sandbox: dropping recipient")
+			dropped[to] = []pmail.Response{
+				{
+					Response: smtpclient.Response{
+						Line: SandboxDroppedLine,
+					},
+				},
+			}
+		}
+	}
+	myMail.To = allowed
+
+	return myMail, dropped
+}
@@ -0,0 +1,158 @@
+package sendmail
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		to        string
+		want      bool
+	}{
+		{"empty_allowlist", []string{}, "john@example.com", false},
+		{"domain_match", []string{"example.com"}, "john@example.com", true},
+		{"domain_mismatch", []string{"example.com"}, "john@example.org", false},
+		{"domain_wildcard", []string{"*.test"}, "john@qa.test", true},
+		{"address_wildcard", []string{"qa-*@example.com"}, "qa-john@example.com", true},
+		{"address_wildcard_mismatch", []string{"qa-*@example.com"}, "john@example.com", false},
+		{"case_insensitive", []string{"*@Example.COM"}, "John@example.com", true},
+		{"invalid_pattern", []string{"["}, "john@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, err := smtp.ParseAddress(tt.to)
+			require.NoError(t, err)
+
+			sandbox := NewSandbox(context.Background(), config.SandboxConfig{
+				Allowlist: tt.allowlist,
+				Enabled:   true,
+			})
+			got := sandbox.Allowed(to)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSandboxApply(t *testing.T) {
+	catchAll, err := smtp.ParseAddress("catchall@stlim.net")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		cfg            config.SandboxConfig
+		to             []string
+		wantTo         []string
+		wantDropped    []string
+		wantOriginalTo string
+	}{
+		{
+			name: "disabled",
+			cfg: config.SandboxConfig{
+				Enabled: false,
+			},
+			to:     []string{"john@example.com"},
+			wantTo: []string{"john@example.com"},
+		},
+		{
+			name: "all_allowed",
+			cfg: config.SandboxConfig{
+				Allowlist: []string{"stlim.net"},
+				Enabled:   true,
+				Mode:      config.SandboxModeDrop,
+			},
+			to:     []string{"john@stlim.net"},
+			wantTo: []string{"john@stlim.net"},
+		},
+		{
+			name: "drop",
+			cfg: config.SandboxConfig{
+				Allowlist: []string{"stlim.net"},
+				Enabled:   true,
+				Mode:      config.SandboxModeDrop,
+			},
+			to:          []string{"john@stlim.net", "jane@example.com"},
+			wantTo:      []string{"john@stlim.net"},
+			wantDropped: []string{"jane@example.com"},
+		},
+		{
+			name: "rewrite",
+			cfg: config.SandboxConfig{
+				Allowlist:    []string{"stlim.net"},
+				CatchAll:     catchAll.String(),
+				CatchAllAddr: catchAll,
+				Enabled:      true,
+				Mode:         config.SandboxModeRewrite,
+			},
+			to:             []string{"jane@example.com", "joe@example.org"},
+			wantTo:         []string{"catchall@stlim.net"},
+			wantOriginalTo: "jane@example.com, joe@example.org",
+		},
+		{
+			name: "rewrite_catchall_already_present",
+			cfg: config.SandboxConfig{
+				Allowlist:    []string{"stlim.net"},
+				CatchAll:     catchAll.String(),
+				CatchAllAddr: catchAll,
+				Enabled:      true,
+				Mode:         config.SandboxModeRewrite,
+			},
+			to:             []string{"catchall@stlim.net", "jane@example.com"},
+			wantTo:         []string{"catchall@stlim.net"},
+			wantOriginalTo: "jane@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+
+			myMail := &pmail.Mail{
+				FinalBody: []byte("Subject: test\r\n\r\nbody"),
+				To:        make([]smtp.Address, 0),
+			}
+			for _, to := range tt.to {
+				addr, err := smtp.ParseAddress(to)
+				require.NoError(t, err)
+				myMail.To = append(myMail.To, addr)
+			}
+
+			sandbox := NewSandbox(ctx, tt.cfg)
+			got, dropped := sandbox.Apply(ctx, myMail)
+			require.NotNil(t, got)
+
+			gotTo := make([]string, 0)
+			for _, to := range got.To {
+				gotTo = append(gotTo, to.String())
+			}
+			assert.Equal(t, tt.wantTo, gotTo)
+
+			assert.Len(t, dropped, len(tt.wantDropped))
+			for _, to := range tt.wantDropped {
+				assert.Contains(t, dropped, to)
+				assert.Equal(t, SandboxDroppedLine, dropped[to][0].Line)
+			}
+
+			if tt.wantOriginalTo != "" {
+				originalTo, ok := got.GetHeader(HeaderOriginalToKey)
+				assert.True(t, ok)
+				assert.Equal(t, []byte(tt.wantOriginalTo), originalTo)
+				assert.Contains(t, string(got.FinalBody),
+					HeaderOriginalToKey+": "+tt.wantOriginalTo+"\r\n")
+			} else {
+				_, ok := got.GetHeader(HeaderOriginalToKey)
+				assert.False(t, ok)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package sendmail
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"time"
@@ -36,16 +38,13 @@ const (
 // MailSender handles the delivery of emails to SMTP servers.
 // It manages connections, retries, and concurrent delivery to multiple recipients.
 type MailSender struct {
-	// CachedMX stores MX records for domains to reduce DNS lookups
-	CachedMX map[string]dn.MXRecord
-
 	// Debug enables debug mode which prevents actual mail sending
 	Debug bool
 
 	// DialerFactory creates network dialers for SMTP connections
 	DialerFactory INetDialerFactory
 
-	// Resolver handles DNS lookups for MX records
+	// Resolver handles DNS lookups for MX records, and caches them
 	Resolver dns.IResolver
 
 	// Slogger is used for structured logging
@@ -109,7 +108,6 @@ func NewMailSender(
 	slogger *slog.Logger,
 ) *MailSender {
 	result := &MailSender{
-		CachedMX:      make(map[string]dn.MXRecord, 0),
 		Debug:         debug,
 		DialerFactory: dialerFactory,
 		Resolver:      resolver,
@@ -225,16 +223,9 @@ func (m *MailSender) SendMail(
 		}
 	}
 
-	// Return combined error if any deliveries failed
+	// Return the error of each recipient which failed
 	if len(errs) > 0 {
-		return results, map[string]error{
-			mail.To[0].String(): rerrors.NewError(
-				rerrors.ErrMailDelivery,
-				"delivery failed for some recipients",
-				nil,
-			).
-				WithContext("errors", errs),
-		}
+		return results, errs
 	}
 
 	return results, nil
@@ -266,12 +257,17 @@ func (m *MailSender) deliverToRecipient(
 		}
 
 		// Lookup MX records for the recipient's domain
-		hosts, err := m.Resolver.LookupMX(ctx, to.Domain)
+		mxRecord, err := m.Resolver.LookupMX(ctx, to.Domain)
 		if err != nil {
-			lastErr = rerrors.NewError(rerrors.ErrDNSLookup, "failed to lookup MX records", err).
-				WithContext("domain", to.Domain)
+			// A null MX or a non-existent domain will not change between
+			// attempts, so fail the recipient immediately
+			if mxRecord != nil && mxRecord.Status.Permanent() {
+				return deliveryResult{nil, mxError(mxRecord, err)}
+			}
+			lastErr = err
 			continue
 		}
+		hosts := mxRecord.Hosts
 
 		// Attempt to establish connection and deliver
 		conn, err := m.NewConn(ctx, hosts)
@@ -283,6 +279,11 @@ func (m *MailSender) deliverToRecipient(
 
 		responses, err := m.Deliver(ctx, conn, mail, to)
 		if err != nil {
+			// A recipient rejected with a 5xx response is not retried
+			var smtpErr smtpclient.Error
+			if errors.As(err, &smtpErr) && smtpErr.Permanent {
+				return deliveryResult{nil, err}
+			}
 			lastErr = err
 			continue
 		}
@@ -392,3 +393,39 @@ func (m *MailSender) Deliver(
 	}
 	return results, nil
 }
+
+// mxError turns the error of an MX lookup that rules out delivery into a
+// permanent error of the recipient, with the status of a rejection
+func mxError(mxRecord *dn.MXRecord, err error) error {
+	code, secode := 550, "1.2"
+	switch mxRecord.Status {
+	case dn.MX_STATUS_NULL:
+		// RFC 7505, the domain does not accept mail
+		code, secode = 556, "1.10"
+	case dn.MX_STATUS_INVALID:
+		secode = "4.4"
+	}
+	return smtpclient.Error{
+		Permanent: true,
+		Code:      code,
+		Secode:    secode,
+		Line:      fmt.Sprintf("%d 5.%s %s", code, secode, err),
+		Err:       err,
+	}
+}
+
+// errorResponse returns the response of a recipient which failed with the
+// error, permanent when the smtp server or the MX lookup ruled out delivery
+func errorResponse(err error) pmail.Response {
+	var smtpErr smtpclient.Error
+	if errors.As(err, &smtpErr) {
+		result := pmail.Response{Response: smtpclient.Response(smtpErr)}
+		// The line describes the error, which may not be written as is
+		result.Err = nil
+		if result.Line == "" {
+			result.Line = err.Error()
+		}
+		return result
+	}
+	return pmail.Response{Response: smtpclient.Response{Line: err.Error()}}
+}
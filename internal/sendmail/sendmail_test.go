@@ -2,16 +2,22 @@ package sendmail
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/smtpclient"
 	"github.com/stlimtat/remiges-smtp/internal/dns"
+	rerrors "github.com/stlimtat/remiges-smtp/internal/errors"
 	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
@@ -52,7 +58,7 @@ func TestNewMailSender(t *testing.T) {
 			} else {
 				assert.NotNil(t, sender)
 				if tt.wantInit {
-					assert.NotNil(t, sender.CachedMX)
+					assert.NotNil(t, sender.Resolver)
 					assert.Equal(t, tt.debug, sender.Debug)
 					assert.NotNil(t, sender.metrics)
 					assert.Equal(t, 3, sender.maxRetries)
@@ -362,3 +368,118 @@ func TestNewConn(t *testing.T) {
 // 			})
 // 	}
 // }
+
+func TestDeliverToRecipientMXStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      *dn.MXRecord
+		err         error
+		wantLookups int
+		wantCode    rerrors.ErrorCode
+	}{
+		{
+			name:        "null_mx_fails_immediately",
+			record:      &dn.MXRecord{Domain: "example.com", Status: dn.MX_STATUS_NULL},
+			err:         rerrors.NewError(rerrors.ErrMXNull, "domain does not accept mail (null MX)", nil),
+			wantLookups: 1,
+			wantCode:    rerrors.ErrMXNull,
+		},
+		{
+			name:        "domain_not_found_fails_immediately",
+			record:      &dn.MXRecord{Domain: "example.com", Status: dn.MX_STATUS_NOT_FOUND},
+			err:         rerrors.NewError(rerrors.ErrDomainNotFound, "domain not found", nil),
+			wantLookups: 1,
+			wantCode:    rerrors.ErrDomainNotFound,
+		},
+		{
+			name:        "temporary_failure_is_retried",
+			record:      &dn.MXRecord{Domain: "example.com", Status: dn.MX_STATUS_TEMP_FAILURE},
+			err:         rerrors.NewError(rerrors.ErrDNSLookup, "failed to lookup MX records", nil),
+			wantLookups: 3,
+			wantCode:    rerrors.ErrMailDelivery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			slogger := telemetry.GetSLogger(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			resolver := dns.NewMockIResolver(ctrl)
+			resolver.EXPECT().
+				LookupMX(gomock.Any(), gomock.Any()).
+				Return(tt.record, tt.err).
+				Times(tt.wantLookups)
+			// No connection may be attempted
+			dialerFactory := NewMockINetDialerFactory(ctrl)
+
+			m := NewMailSender(ctx, false, dialerFactory, resolver, slogger)
+			to, err := smtp.ParseAddress("john@example.com")
+			require.NoError(t, err)
+
+			result := m.deliverToRecipient(ctx, &pmail.Mail{}, to)
+			require.Error(t, result.err)
+			var appErr *rerrors.AppError
+			require.ErrorAs(t, result.err, &appErr)
+			assert.Equal(t, tt.wantCode, appErr.Code)
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	rejected := smtpclient.Error{
+		Permanent: true,
+		Code:      550,
+		Secode:    "1.1",
+		Line:      "550 5.1.1 unknown user",
+		Err:       errors.New("rcpt failed"),
+	}
+	tests := []struct {
+		name          string
+		err           error
+		wantPermanent bool
+		wantCode      int
+		wantLine      string
+	}{
+		{
+			name:          "rejected_recipient",
+			err:           rejected,
+			wantPermanent: true,
+			wantCode:      550,
+			wantLine:      "550 5.1.1 unknown user",
+		},
+		{
+			name:     "transient_after_retries",
+			err:      rerrors.NewError(rerrors.ErrMailDelivery, "max retries exceeded", smtpclient.Error{Code: 451, Line: "451 try later"}),
+			wantCode: 451,
+			wantLine: "451 try later",
+		},
+		{
+			name: "null_mx",
+			err: mxError(
+				&dn.MXRecord{Domain: "example.com", Status: dn.MX_STATUS_NULL},
+				rerrors.NewError(rerrors.ErrMXNull, "null MX", nil),
+			),
+			wantPermanent: true,
+			wantCode:      556,
+			wantLine:      "556 5.1.10 [MX_NULL] null MX",
+		},
+		{
+			name:     "connection_error",
+			err:      errors.New("connection refused"),
+			wantLine: "connection refused",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorResponse(tt.err)
+			assert.Equal(t, tt.wantPermanent, got.Permanent)
+			assert.Equal(t, tt.wantCode, got.Code)
+			assert.Equal(t, tt.wantLine, got.Line)
+			assert.NoError(t, got.Err)
+		})
+	}
+}
@@ -5,10 +5,16 @@ package sendmail
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
 	"github.com/rs/zerolog"
 	"github.com/stlimtat/remiges-smtp/internal/file"
 	"github.com/stlimtat/remiges-smtp/internal/file_mail"
@@ -29,6 +35,17 @@ type SendMailService struct {
 	// FileReader reads mail files from the filesystem
 	FileReader file.IFileReader
 
+	// Lanes replace the Concurrency workers with the workers of each lane,
+	// most urgent first, when the FileReader sorts its files into lanes
+	Lanes []*Lane
+
+	// Lifecycle moves the files once they are processed, nil to leave them
+	Lifecycle file.IFileLifecycle
+
+	// Recipients tracks the recipients done of the files sent again, nil to
+	// send a file again to all its recipients
+	Recipients file.IRecipientTracker
+
 	// MailProcessor handles mail processing tasks (e.g., DKIM signing)
 	MailProcessor intmail.IMailProcessor
 
@@ -44,6 +61,9 @@ type SendMailService struct {
 	// PollInterval specifies how often to check for new mail files
 	PollInterval time.Duration
 
+	// Sandbox restricts recipients after all transformers and processors have run
+	Sandbox *Sandbox
+
 	// ticker is used for periodic file checking
 	ticker *time.Ticker
 }
@@ -60,6 +80,7 @@ type SendMailService struct {
 //   - mailTransformer: Component for converting files to mail objects
 //   - myOutput: Component for writing delivery results
 //   - pollInterval: Interval between file checks
+//   - sandbox: Recipient restrictions applied before sending, nil to disable
 //
 // Returns:
 //   - *SendMailService: A new mail service instance
@@ -72,6 +93,7 @@ func NewSendMailService(
 	mailTransformer file_mail.IMailTransformer,
 	myOutput output.IOutput,
 	pollInterval time.Duration,
+	sandbox *Sandbox,
 ) *SendMailService {
 	result := &SendMailService{
 		Concurrency:     concurrency,
@@ -81,6 +103,7 @@ func NewSendMailService(
 		MailTransformer: mailTransformer,
 		MyOutput:        myOutput,
 		PollInterval:    pollInterval,
+		Sandbox:         sandbox,
 		ticker:          time.NewTicker(pollInterval),
 	}
 	return result
@@ -103,9 +126,40 @@ func (s *SendMailService) Run(
 
 	// Launch worker goroutines for concurrent processing
 	var wg sync.WaitGroup
-	for range s.Concurrency {
+	// A watching reader queues files as they are written, the ticker only
+	// rescans as a safety net
+	if watcher, ok := s.FileReader.(file.IFileWatcher); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := watcher.Run(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("FileReader.Run")
+			}
+		}()
+	}
+	if s.Lifecycle != nil {
 		wg.Add(1)
-		go s.ProcessFileLoop(ctx, &wg)
+		go func() {
+			defer wg.Done()
+			err := s.Lifecycle.Run(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("Lifecycle.Run")
+			}
+		}()
+	}
+	if laneReader, ok := s.FileReader.(file.IFileLaneReader); ok && len(s.Lanes) > 0 {
+		for index, lane := range s.Lanes {
+			for range lane.Concurrency {
+				wg.Add(1)
+				go s.ProcessLaneLoop(ctx, &wg, laneReader, index)
+			}
+		}
+	} else {
+		for range s.Concurrency {
+			wg.Add(1)
+			go s.ProcessFileLoop(ctx, &wg)
+		}
 	}
 
 	// Main loop for checking new files
@@ -144,20 +198,62 @@ func (s *SendMailService) ProcessFileLoop(
 	logger := zerolog.Ctx(ctx)
 	defer wg.Done()
 
+	// Without a watching reader, notify is nil and never receives
+	var notify <-chan struct{}
+	if watcher, ok := s.FileReader.(file.IFileWatcher); ok {
+		notify = watcher.Notify()
+	}
+
 outerloop:
 	for {
 		select {
 		case t := <-s.ticker.C:
 			logger.Info().Time("t", t).Msg("ProcessFileLoop.ticker.C")
-			fileInfo, _, err := s.ReadNextMail(ctx)
-			if err != nil {
-				continue
+			s.processNextMail(ctx)
+		case <-notify:
+			logger.Debug().Msg("ProcessFileLoop.notify")
+			// Read until the queue is empty, a notification may cover several files
+			for s.processNextMail(ctx) {
+				if ctx.Err() != nil {
+					break
+				}
 			}
-			if fileInfo == nil {
-				logger.Debug().Msg("no fileInfo found")
-				continue
+		case <-ctx.Done():
+			logger.Debug().Msg("ctx.Done")
+			break outerloop
+		}
+	}
+}
+
+// ProcessLaneLoop runs in a goroutine and processes the mail files of a lane
+// as they become available, after the files of the more urgent lanes.
+//
+// Parameters:
+//   - ctx: Context for controlling the processing loop
+//   - wg: WaitGroup for coordinating shutdown
+//   - laneReader: The reader of the files of each lane
+//   - index: The index of the lane of the worker in Lanes
+func (s *SendMailService) ProcessLaneLoop(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	laneReader file.IFileLaneReader,
+	index int,
+) {
+	logger := zerolog.Ctx(ctx).With().Str("lane", s.Lanes[index].Name).Logger()
+	ctx = logger.WithContext(ctx)
+	defer wg.Done()
+
+outerloop:
+	for {
+		select {
+		case t := <-s.ticker.C:
+			logger.Info().Time("t", t).Msg("ProcessLaneLoop.ticker.C")
+			// Read until the lanes are empty, within their rates
+			for s.processNextLaneMail(ctx, laneReader, index) {
+				if ctx.Err() != nil {
+					break
+				}
 			}
-			fileInfo.Status = input.FILE_STATUS_DONE
 		case <-ctx.Done():
 			logger.Debug().Msg("ctx.Done")
 			break outerloop
@@ -165,6 +261,63 @@ outerloop:
 	}
 }
 
+// processNextLaneMail processes the next mail file of the more urgent lanes
+// with a file and within their rates, otherwise of the lane of the worker,
+// waiting for its rate, and reports whether a file was processed
+func (s *SendMailService) processNextLaneMail(
+	ctx context.Context,
+	laneReader file.IFileLaneReader,
+	index int,
+) bool {
+	logger := zerolog.Ctx(ctx)
+	for i, lane := range s.Lanes[:index+1] {
+		if i < index {
+			if !lane.limiter.allow() {
+				continue
+			}
+		} else if lane.limiter.wait(ctx) != nil {
+			return false
+		}
+		fileInfo, err := laneReader.ReadNextLaneFile(ctx, lane.Name)
+		if err != nil || fileInfo == nil {
+			lane.limiter.refund()
+			if err != nil {
+				return false
+			}
+			continue
+		}
+		logger.Debug().
+			Str("fileInfo", fileInfo.ID).
+			Str("fileLane", lane.Name).
+			Msg("ReadNextLaneFile")
+		_, _, err = s.sendFile(ctx, fileInfo)
+		if err != nil {
+			s.fail(ctx, fileInfo, err)
+			return false
+		}
+		fileInfo.Status = input.FILE_STATUS_DONE
+		return true
+	}
+	logger.Debug().Msg("no fileInfo found")
+	return false
+}
+
+// processNextMail processes the next mail file, and reports whether a file
+// was processed
+func (s *SendMailService) processNextMail(ctx context.Context) bool {
+	logger := zerolog.Ctx(ctx)
+	fileInfo, _, err := s.ReadNextMail(ctx)
+	if err != nil {
+		return false
+	}
+	if fileInfo == nil {
+		logger.Debug().Msg("no fileInfo found")
+		return false
+	}
+	fileInfo.Status = input.FILE_STATUS_DONE
+	return true
+}
+
 // ReadNextMail processes a single mail file through the complete pipeline:
 // reading the file, transforming it to a mail object, processing it,
 // and sending it via SMTP.
@@ -181,73 +334,328 @@ func (s *SendMailService) ReadNextMail(
 ) (*file.FileInfo, *pmail.Mail, error) {
 	logger := zerolog.Ctx(ctx)
 
-	var fileInfo *file.FileInfo
-	var myMail *pmail.Mail
-	var err error
-
 	// 1. Read the next available mail file
 	// There is a mutex on the file reader to ensure that only one file is read at a time
-	found := false
-	// 2. Loop until a file is successfully processed
-	for !found {
-		fileInfo, err = s.FileReader.ReadNextFile(ctx)
-		if err != nil {
+	fileInfo, err := s.FileReader.ReadNextFile(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fileInfo == nil {
+		return nil, nil, nil
+	}
+	logger.Debug().
+		Str("fileInfo", fileInfo.ID).
+		Msg("ReadNextFile")
+	// 2. Send the mail of the file
+	result, myMail, err := s.sendFile(ctx, fileInfo)
+	if err != nil {
+		s.fail(ctx, fileInfo, err)
+		return nil, nil, err
+	}
+	return result, myMail, nil
+}
+
+// sendFile transforms a file read by the file reader into a mail, processes
+// and sends it, then writes its output
+func (s *SendMailService) sendFile(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+) (*file.FileInfo, *pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx)
+	fileInfo.Status = input.FILE_STATUS_PROCESSING
+
+	// The reader gave up on the file, its reason is the output
+	if fileInfo.Abandoned != "" {
+		return s.writeRejected(ctx, fileInfo, &pmail.RejectedError{
+			Fields: []pmail.FieldError{{Message: fileInfo.Abandoned}},
+		})
+	}
+
+	// Transform file content into a mail object
+	myMail, err := s.MailTransformer.Transform(
+		ctx, fileInfo, &pmail.Mail{},
+	)
+	var rejected *pmail.RejectedError
+	if errors.As(err, &rejected) {
+		// The mail can never be sent, its field errors are the output
+		return s.writeRejected(ctx, fileInfo, rejected)
+	}
+	if err != nil {
+		if !strings.Contains(err.Error(), "ToIgnore") {
+			s.quarantine(ctx, fileInfo, err)
 			return nil, nil, err
 		}
-		if fileInfo == nil {
-			return nil, nil, nil
-		}
-		logger.Debug().
-			Str("fileInfo", fileInfo.ID).
-			Msg("ReadNextFile")
-		fileInfo.Status = input.FILE_STATUS_PROCESSING
+	}
+	fileInfo.Status = input.FILE_STATUS_BODY_READ
 
-		// Transform file content into a mail object
-		myMail, err = s.MailTransformer.Transform(
-			ctx, fileInfo, &pmail.Mail{},
-		)
-		if err != nil {
-			if !strings.Contains(err.Error(), "ToIgnore") {
-				return nil, nil, err
-			}
+	// Process the mail (e.g., DKIM signing)
+	myMail, err = s.MailProcessor.Process(ctx, myMail)
+	if errors.As(err, &rejected) {
+		// A processor refused the mail, e.g. as it is not aligned
+		return s.writeRejected(ctx, fileInfo, rejected)
+	}
+	if err != nil {
+		if !strings.Contains(err.Error(), "ToIgnore") {
+			return nil, nil, err
 		}
-		fileInfo.Status = input.FILE_STATUS_BODY_READ
+	}
+	fileInfo.Status = input.FILE_STATUS_MAIL_PROCESS
 
-		// Process the mail (e.g., DKIM signing)
-		myMail, err = s.MailProcessor.Process(ctx, myMail)
-		if err != nil {
-			if !strings.Contains(err.Error(), "ToIgnore") {
-				return nil, nil, err
+	// Enforce the sandbox after every transformer and processor has run,
+	// so that no configuration can bypass it
+	var sandboxResponses map[string][]pmail.Response
+	myMail, sandboxResponses = s.Sandbox.Apply(ctx, myMail)
+
+	// A mail sent again is only sent to the recipients left
+	done, err := s.recipientsDone(ctx, fileInfo)
+	if err != nil {
+		return nil, nil, &pmail.TemporaryError{Err: err}
+	}
+	myMail.To = slices.DeleteFunc(myMail.To, func(to smtp.Address) bool {
+		_, ok := done[to.String()]
+		return ok
+	})
+	maps.DeleteFunc(sandboxResponses, func(to string, _ []pmail.Response) bool {
+		_, ok := done[to]
+		return ok
+	})
+
+	// Send the mail via SMTP, unless the sandbox dropped every recipient
+	responses := make(map[string][]pmail.Response)
+	temporary := make([]error, 0)
+	if len(myMail.To) > 0 || (len(sandboxResponses) == 0 && len(done) == 0) {
+		var errs map[string]error
+		responses, errs = s.MailSender.SendMail(ctx, myMail)
+		if responses == nil {
+			responses = make(map[string][]pmail.Response)
+		}
+		// The recipients which failed permanently are written with their
+		// error, the mail is sent again to those which may still be delivered
+		for to, sendErr := range errs {
+			response := errorResponse(sendErr)
+			if !response.Permanent {
+				temporary = append(temporary, fmt.Errorf("%s: %w", to, sendErr))
+				continue
 			}
+			responses[to] = append(responses[to], response)
 		}
-		fileInfo.Status = input.FILE_STATUS_MAIL_PROCESS
+	}
 
-		// Send the mail via SMTP
-		responses, errs := s.MailSender.SendMail(ctx, myMail)
-		if errs != nil {
-			return nil, nil, err
-		}
-		found = true
+	// Log delivery results
+	for to, response := range responses {
+		logger.Info().
+			Interface("response", response).
+			Str("to", to).
+			Msg("Delivery done")
+	}
+	fileInfo.Status = input.FILE_STATUS_DELIVERED
+	if len(temporary) > 0 {
+		fileInfo.Status = input.FILE_STATUS_PARTIAL
+	}
+	maps.Copy(responses, sandboxResponses)
 
-		// Log delivery results
-		for to, response := range responses {
-			if errs[to] != nil {
-				continue
+	// The recipients done are recorded before the output, so that they are
+	// never sent again
+	err = s.upsertRecipients(ctx, fileInfo, responses)
+	if err != nil {
+		return nil, nil, &pmail.TemporaryError{Err: err}
+	}
+	if len(temporary) > 0 {
+		// The output of the recipients done is written now, the file is
+		// neither acknowledged nor finished until the others are done
+		if s.Recipients != nil && len(responses) > 0 {
+			err = s.MyOutput.Write(ctx, fileInfo, myMail, responses)
+			if err != nil {
+				logger.Error().Err(err).Msg("MyOutput.Write")
 			}
-			logger.Info().
-				Interface("response", response).
-				Str("to", to).
-				Msg("Delivery done")
 		}
-		fileInfo.Status = input.FILE_STATUS_DELIVERED
+		return nil, nil, &pmail.TemporaryError{Err: errors.Join(temporary...)}
+	}
 
-		// write output to file
-		err = s.MyOutput.Write(ctx, fileInfo, myMail, responses)
-		if err != nil {
-			logger.Error().Err(err).Msg("MyOutput.Write")
-			return nil, nil, err
+	// write output to file
+	err = s.MyOutput.Write(ctx, fileInfo, myMail, responses)
+	if err != nil {
+		logger.Error().Err(err).Msg("MyOutput.Write")
+		return nil, nil, err
+	}
+	err = s.ack(ctx, fileInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The recipients done before are finished with the others
+	for to, recipientDone := range done {
+		responses[to] = append(responses[to], pmail.Response{Response: smtpclient.Response{
+			Code:      recipientDone.Code,
+			Secode:    recipientDone.Secode,
+			Line:      recipientDone.Line,
+			Permanent: recipientDone.Permanent,
+		}})
+	}
+	s.finish(ctx, fileInfo, responses)
+
+	return fileInfo, myMail, nil
+}
+
+// recipientsDone returns the recipients of the file that are done, from a
+// previous attempt
+func (s *SendMailService) recipientsDone(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+) (map[string]file.RecipientDone, error) {
+	if s.Recipients == nil {
+		return nil, nil
+	}
+	done, err := s.Recipients.RecipientsDone(ctx, fileInfo.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Recipients.RecipientsDone")
+		return nil, err
+	}
+	return done, nil
+}
+
+// upsertRecipients records the last response of each recipient done
+func (s *SendMailService) upsertRecipients(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	responses map[string][]pmail.Response,
+) error {
+	if s.Recipients == nil || len(responses) == 0 {
+		return nil
+	}
+	recipients := make(map[string]file.RecipientDone, len(responses))
+	for to, recipientResponses := range responses {
+		if len(recipientResponses) == 0 {
+			continue
 		}
+		last := recipientResponses[len(recipientResponses)-1]
+		recipients[to] = file.RecipientDone{
+			Code:      last.Code,
+			Secode:    last.Secode,
+			Line:      last.Line,
+			Permanent: last.Permanent,
+		}
+	}
+	err := s.Recipients.UpsertRecipients(ctx, fileInfo.ID, recipients)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Recipients.UpsertRecipients")
+		return err
 	}
+	return nil
+}
 
+// writeRejected writes the field errors of a mail that can never be sent to
+// the output, under the id of its file, so that it is not read again
+func (s *SendMailService) writeRejected(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	rejected *pmail.RejectedError,
+) (*file.FileInfo, *pmail.Mail, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Warn().
+		Err(rejected).
+		Str("fileInfo", fileInfo.ID).
+		Msg("Mail rejected")
+	fileInfo.Status = input.FILE_STATUS_ERROR
+	myMail := &pmail.Mail{MsgID: []byte(fileInfo.ID)}
+	err := s.MyOutput.Write(ctx, fileInfo, myMail, rejected.Responses())
+	if err != nil {
+		logger.Error().Err(err).Msg("MyOutput.Write")
+		return nil, nil, err
+	}
+	err = s.ack(ctx, fileInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.quarantine(ctx, fileInfo, rejected)
 	return fileInfo, myMail, nil
 }
+
+// ack acknowledges the file once its output is written, when the reader
+// keeps its files until then
+func (s *SendMailService) ack(ctx context.Context, fileInfo *file.FileInfo) error {
+	acker, ok := s.FileReader.(file.IFileAcker)
+	if !ok {
+		return nil
+	}
+	err := acker.Ack(ctx, fileInfo)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("FileReader.Ack")
+		return err
+	}
+	return nil
+}
+
+// fail records a file that can never be sent, when the reader does not read
+// it again, so that the reader does not wait for it. A file that failed
+// temporarily is not recorded, the reader reads it again.
+func (s *SendMailService) fail(ctx context.Context, fileInfo *file.FileInfo, sendErr error) {
+	failer, ok := s.FileReader.(file.IFileFailer)
+	if !ok {
+		return
+	}
+	// The file is left to the reader, to be read again
+	var temporary *pmail.TemporaryError
+	if errors.As(sendErr, &temporary) {
+		return
+	}
+	err := failer.Fail(ctx, fileInfo, &file.FileError{Error: sendErr.Error()})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("fileInfo", fileInfo.ID).Msg("FileReader.Fail")
+	}
+}
+
+// finish moves the files of a mail once its output is written, to the failed
+// files when a recipient failed permanently, otherwise to the archive. The
+// mail is processed even when the files cannot be moved, the tracker skips
+// them until they are.
+func (s *SendMailService) finish(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	responses map[string][]pmail.Response,
+) {
+	if s.Lifecycle == nil {
+		return
+	}
+	logger := zerolog.Ctx(ctx)
+	failed := make(map[string]file.RecipientError)
+	for to, recipientResponses := range responses {
+		if len(recipientResponses) == 0 {
+			continue
+		}
+		last := recipientResponses[len(recipientResponses)-1]
+		if last.Permanent {
+			failed[to] = file.RecipientError{
+				Code:   last.Code,
+				Secode: last.Secode,
+				Line:   last.Line,
+			}
+		}
+	}
+	var err error
+	if len(failed) > 0 {
+		err = s.Lifecycle.Fail(ctx, fileInfo, &file.FileError{
+			Error:      "permanent failure",
+			Recipients: failed,
+		})
+	} else {
+		err = s.Lifecycle.Archive(ctx, fileInfo)
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("fileInfo", fileInfo.ID).Msg("Lifecycle")
+	}
+}
+
+// quarantine moves the files of a mail that could not be parsed
+func (s *SendMailService) quarantine(
+	ctx context.Context,
+	fileInfo *file.FileInfo,
+	parseErr error,
+) {
+	if s.Lifecycle == nil {
+		return
+	}
+	err := s.Lifecycle.Quarantine(ctx, fileInfo, &file.FileError{Error: parseErr.Error()})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("fileInfo", fileInfo.ID).Msg("Lifecycle.Quarantine")
+	}
+}
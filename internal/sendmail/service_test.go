@@ -3,17 +3,25 @@ package sendmail
 import (
 	"context"
 	"errors"
+	"maps"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/stlimtat/remiges-smtp/internal/dns"
+	rerrors "github.com/stlimtat/remiges-smtp/internal/errors"
 	"github.com/stlimtat/remiges-smtp/internal/file"
 	"github.com/stlimtat/remiges-smtp/internal/file_mail"
 	"github.com/stlimtat/remiges-smtp/internal/intmail"
 	"github.com/stlimtat/remiges-smtp/internal/output"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stlimtat/remiges-smtp/pkg/dn"
 	"github.com/stlimtat/remiges-smtp/pkg/input"
 	"github.com/stlimtat/remiges-smtp/pkg/pmail"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
@@ -64,6 +72,7 @@ func TestNewSendMailService(t *testing.T) {
 				mockMailTransformer,
 				mockOutput,
 				tt.interval,
+				nil,
 			)
 
 			if tt.wantNil {
@@ -139,6 +148,7 @@ func TestRun(t *testing.T) {
 				mockMailTransformer,
 				mockOutput,
 				50*time.Millisecond,
+				nil,
 			)
 
 			ctx := context.Background()
@@ -165,10 +175,11 @@ func TestRun(t *testing.T) {
 
 func TestReadNextMail(t *testing.T) {
 	tests := []struct {
-		name        string
-		setupMocks  func(*file.MockIFileReader, *file_mail.MockIMailTransformer, *intmail.MockIMailProcessor, *MockIMailSender, *output.MockIOutput)
-		expectError bool
-		expectNil   bool
+		name         string
+		setupMocks   func(*file.MockIFileReader, *file_mail.MockIMailTransformer, *intmail.MockIMailProcessor, *MockIMailSender, *output.MockIOutput)
+		expectError  bool
+		expectNil    bool
+		expectStatus input.FileStatus
 	}{
 		{
 			name: "successful_processing",
@@ -201,8 +212,9 @@ func TestReadNextMail(t *testing.T) {
 					Return(nil).
 					Times(1)
 			},
-			expectError: false,
-			expectNil:   false,
+			expectError:  false,
+			expectNil:    false,
+			expectStatus: input.FILE_STATUS_DELIVERED,
 		},
 		{
 			name: "no_file_available",
@@ -268,6 +280,34 @@ func TestReadNextMail(t *testing.T) {
 			expectError: true,
 			expectNil:   true,
 		},
+		{
+			name: "rejected_mail",
+			setupMocks: func(fr *file.MockIFileReader, mt *file_mail.MockIMailTransformer, mp *intmail.MockIMailProcessor, ms *MockIMailSender, mo *output.MockIOutput) {
+				fileInfo := &file.FileInfo{ID: "test-id"}
+				rejected := &pmail.RejectedError{Fields: []pmail.FieldError{
+					{Field: "to[0]", Message: "invalid address"},
+				}}
+
+				fr.EXPECT().
+					ReadNextFile(gomock.Any()).
+					Return(fileInfo, nil).
+					Times(1)
+
+				mt.EXPECT().
+					Transform(gomock.Any(), fileInfo, gomock.Any()).
+					Return(nil, rejected).
+					Times(1)
+
+				// The field errors are written, without processing or sending
+				mo.EXPECT().
+					Write(gomock.Any(), fileInfo, &pmail.Mail{MsgID: []byte("test-id")}, rejected.Responses()).
+					Return(nil).
+					Times(1)
+			},
+			expectError:  false,
+			expectNil:    false,
+			expectStatus: input.FILE_STATUS_ERROR,
+		},
 		// {
 		// 	name: "send_error",
 		// 	setupMocks: func(fr *file.MockIFileReader, mt *file_mail.MockIMailTransformer, mp *intmail.MockIMailProcessor, ms *MockIMailSender) {
@@ -321,6 +361,7 @@ func TestReadNextMail(t *testing.T) {
 				mockMailTransformer,
 				mockOutput,
 				time.Second,
+				nil,
 			)
 
 			fileInfo, mail, err := service.ReadNextMail(context.Background())
@@ -336,7 +377,7 @@ func TestReadNextMail(t *testing.T) {
 				} else {
 					assert.NotNil(t, fileInfo)
 					assert.NotNil(t, mail)
-					assert.Equal(t, input.FILE_STATUS_DELIVERED, fileInfo.Status)
+					assert.Equal(t, tt.expectStatus, fileInfo.Status)
 				}
 			}
 		})
@@ -390,6 +431,7 @@ func TestProcessFileLoop(t *testing.T) {
 				mockMailTransformer,
 				mockOutput,
 				50*time.Millisecond,
+				nil,
 			)
 
 			ctx := context.Background()
@@ -410,3 +452,621 @@ func TestProcessFileLoop(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessFileLoopWatcher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockFileWatcher := file.NewMockIFileWatcher(ctrl)
+	notify := make(chan struct{}, 1)
+	notify <- struct{}{}
+	read := make(chan struct{})
+	mockFileWatcher.EXPECT().
+		Run(gomock.Any()).
+		DoAndReturn(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+	mockFileWatcher.EXPECT().
+		Notify().
+		Return(notify)
+	mockFileWatcher.EXPECT().
+		ReadNextFile(gomock.Any()).
+		DoAndReturn(func(_ context.Context) (*file.FileInfo, error) {
+			close(read)
+			return nil, nil
+		})
+
+	// The poll interval is longer than the test, only the notification reads
+	service := NewSendMailService(
+		context.Background(),
+		1,
+		mockFileWatcher,
+		intmail.NewMockIMailProcessor(ctrl),
+		NewMockIMailSender(ctrl),
+		file_mail.NewMockIMailTransformer(ctrl),
+		output.NewMockIOutput(ctrl),
+		time.Hour,
+		nil,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("file not read on notification")
+	}
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestReadNextMailAck(t *testing.T) {
+	tests := []struct {
+		name        string
+		writeErr    error
+		ackErr      error
+		expectAck   bool
+		expectError bool
+	}{
+		{
+			name:      "ack_after_write",
+			expectAck: true,
+		},
+		{
+			name:        "no_ack_without_write",
+			writeErr:    errors.New("write failed"),
+			expectError: true,
+		},
+		{
+			name:        "ack_error",
+			ackErr:      errors.New("ack failed"),
+			expectAck:   true,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			fileInfo := &file.FileInfo{ID: "test-id"}
+			mail := &pmail.Mail{}
+			mockFileAcker := file.NewMockIFileAcker(ctrl)
+			mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+			mockMailSender := NewMockIMailSender(ctrl)
+			mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+			mockOutput := output.NewMockIOutput(ctrl)
+
+			mockFileAcker.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+			mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(mail, nil)
+			mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(mail, nil)
+			mockMailSender.EXPECT().SendMail(gomock.Any(), mail).Return(map[string][]pmail.Response{}, nil)
+			write := mockOutput.EXPECT().Write(gomock.Any(), fileInfo, mail, gomock.Any()).Return(tt.writeErr)
+			if tt.expectAck {
+				mockFileAcker.EXPECT().Ack(gomock.Any(), fileInfo).Return(tt.ackErr).After(write)
+			}
+
+			service := NewSendMailService(
+				context.Background(),
+				1,
+				mockFileAcker,
+				mockMailProcessor,
+				mockMailSender,
+				mockMailTransformer,
+				mockOutput,
+				time.Second,
+				nil,
+			)
+
+			got, _, err := service.ReadNextMail(context.Background())
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, fileInfo, got)
+			}
+		})
+	}
+}
+
+func TestReadNextMailAbandoned(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fileInfo := &file.FileInfo{ID: "test-id", Abandoned: "not done after 5 deliveries"}
+	mockFileAcker := file.NewMockIFileAcker(ctrl)
+	mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+	mockMailSender := NewMockIMailSender(ctrl)
+	mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+	mockOutput := output.NewMockIOutput(ctrl)
+
+	// The abandoned file is neither transformed nor sent
+	mockFileAcker.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+	write := mockOutput.EXPECT().
+		Write(gomock.Any(), fileInfo, &pmail.Mail{MsgID: []byte("test-id")}, map[string][]pmail.Response{
+			"": {{Response: smtpclient.Response{
+				Permanent: true,
+				Code:      pmail.RejectedCode,
+				Secode:    pmail.RejectedSecode,
+				Line:      "554 5.6.0 not done after 5 deliveries",
+			}}},
+		}).
+		Return(nil)
+	mockFileAcker.EXPECT().Ack(gomock.Any(), fileInfo).Return(nil).After(write)
+
+	service := NewSendMailService(
+		ctx,
+		1,
+		mockFileAcker,
+		mockMailProcessor,
+		mockMailSender,
+		mockMailTransformer,
+		mockOutput,
+		time.Second,
+		nil,
+	)
+
+	got, _, err := service.ReadNextMail(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fileInfo, got)
+	assert.Equal(t, input.FILE_STATUS_ERROR, got.Status)
+}
+
+func TestReadNextMailProcessRejected(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fileInfo := &file.FileInfo{ID: "test-id"}
+	mail := &pmail.Mail{MsgID: []byte("test-id")}
+	rejected := &pmail.RejectedError{Fields: []pmail.FieldError{{
+		Field:   "from",
+		Message: "spf or dmarc not aligned for example.com: spf fail",
+	}}}
+	mockFileAcker := file.NewMockIFileAcker(ctrl)
+	mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+	mockMailSender := NewMockIMailSender(ctrl)
+	mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+	mockOutput := output.NewMockIOutput(ctrl)
+
+	// The rejected mail is not sent, but recorded and acked
+	mockFileAcker.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+	mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(mail, nil)
+	mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(nil, rejected)
+	write := mockOutput.EXPECT().
+		Write(gomock.Any(), fileInfo, &pmail.Mail{MsgID: []byte("test-id")}, map[string][]pmail.Response{
+			"from": {{Response: smtpclient.Response{
+				Permanent: true,
+				Code:      pmail.RejectedCode,
+				Secode:    pmail.RejectedSecode,
+				Line:      "554 5.6.0 from: spf or dmarc not aligned for example.com: spf fail",
+			}}},
+		}).
+		Return(nil)
+	mockFileAcker.EXPECT().Ack(gomock.Any(), fileInfo).Return(nil).After(write)
+
+	service := NewSendMailService(
+		ctx,
+		1,
+		mockFileAcker,
+		mockMailProcessor,
+		mockMailSender,
+		mockMailTransformer,
+		mockOutput,
+		time.Second,
+		nil,
+	)
+
+	got, _, err := service.ReadNextMail(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fileInfo, got)
+	assert.Equal(t, input.FILE_STATUS_ERROR, got.Status)
+}
+
+func TestReadNextMailLifecycle(t *testing.T) {
+	delivered := pmail.Response{Response: smtpclient.Response{Code: 250, Line: "250 OK"}}
+	unknown := pmail.Response{Response: smtpclient.Response{
+		Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown", Permanent: true,
+	}}
+	tests := []struct {
+		name        string
+		transform   error
+		responses   map[string][]pmail.Response
+		setupMocks  func(*file.MockIFileLifecycle, *file.FileInfo)
+		expectError bool
+	}{
+		{
+			name:      "archive_when_delivered",
+			responses: map[string][]pmail.Response{"john@example.org": {delivered}},
+			setupMocks: func(lc *file.MockIFileLifecycle, fileInfo *file.FileInfo) {
+				lc.EXPECT().Archive(gomock.Any(), fileInfo).Return(nil)
+			},
+		},
+		{
+			name: "failed_when_permanent",
+			responses: map[string][]pmail.Response{
+				"john@example.org": {delivered},
+				"jane@example.org": {unknown},
+			},
+			setupMocks: func(lc *file.MockIFileLifecycle, fileInfo *file.FileInfo) {
+				lc.EXPECT().Fail(gomock.Any(), fileInfo, &file.FileError{
+					Error: "permanent failure",
+					Recipients: map[string]file.RecipientError{
+						"jane@example.org": {Code: 550, Secode: "1.1", Line: "550 5.1.1 unknown"},
+					},
+				}).Return(nil)
+			},
+		},
+		{
+			name:      "processed_when_not_moved",
+			responses: map[string][]pmail.Response{"john@example.org": {delivered}},
+			setupMocks: func(lc *file.MockIFileLifecycle, fileInfo *file.FileInfo) {
+				lc.EXPECT().Archive(gomock.Any(), fileInfo).Return(errors.New("move failed"))
+			},
+		},
+		{
+			name:      "quarantine_when_parse_fails",
+			transform: errors.New("invalid qf"),
+			setupMocks: func(lc *file.MockIFileLifecycle, fileInfo *file.FileInfo) {
+				lc.EXPECT().Quarantine(gomock.Any(), fileInfo, &file.FileError{Error: "invalid qf"}).Return(nil)
+			},
+			expectError: true,
+		},
+		{
+			name: "quarantine_when_rejected",
+			transform: &pmail.RejectedError{Fields: []pmail.FieldError{
+				{Field: "to[0]", Message: "invalid address"},
+			}},
+			setupMocks: func(lc *file.MockIFileLifecycle, fileInfo *file.FileInfo) {
+				lc.EXPECT().Quarantine(gomock.Any(), fileInfo, gomock.Any()).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			fileInfo := &file.FileInfo{ID: "test-id"}
+			mail := &pmail.Mail{}
+			mockFileReader := file.NewMockIFileReader(ctrl)
+			mockLifecycle := file.NewMockIFileLifecycle(ctrl)
+			mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+			mockMailSender := NewMockIMailSender(ctrl)
+			mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+			mockOutput := output.NewMockIOutput(ctrl)
+
+			mockFileReader.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+			if tt.transform != nil {
+				mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(nil, tt.transform)
+				var rejected *pmail.RejectedError
+				if errors.As(tt.transform, &rejected) {
+					mockOutput.EXPECT().Write(gomock.Any(), fileInfo, gomock.Any(), gomock.Any()).Return(nil)
+				}
+			} else {
+				mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(mail, nil)
+				mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(mail, nil)
+				mockMailSender.EXPECT().SendMail(gomock.Any(), mail).Return(tt.responses, nil)
+				mockOutput.EXPECT().Write(gomock.Any(), fileInfo, mail, tt.responses).Return(nil)
+			}
+			tt.setupMocks(mockLifecycle, fileInfo)
+
+			service := NewSendMailService(
+				context.Background(),
+				1,
+				mockFileReader,
+				mockMailProcessor,
+				mockMailSender,
+				mockMailTransformer,
+				mockOutput,
+				time.Second,
+				nil,
+			)
+			service.Lifecycle = mockLifecycle
+
+			got, _, err := service.ReadNextMail(context.Background())
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, fileInfo, got)
+			}
+		})
+	}
+}
+
+func TestReadNextMailDeliveryErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        dn.MXStatus
+		lookupErr     error
+		wantLookups   int
+		wantRecipient *file.RecipientError
+	}{
+		{
+			name:        "failed_when_null_mx",
+			status:      dn.MX_STATUS_NULL,
+			lookupErr:   rerrors.NewError(rerrors.ErrMXNull, "domain does not accept mail (null MX)", nil),
+			wantLookups: 1,
+			wantRecipient: &file.RecipientError{
+				Code:   556,
+				Secode: "1.10",
+				Line:   "556 5.1.10 [MX_NULL] domain does not accept mail (null MX)",
+			},
+		},
+		{
+			name:        "failed_when_domain_not_found",
+			status:      dn.MX_STATUS_NOT_FOUND,
+			lookupErr:   rerrors.NewError(rerrors.ErrDomainNotFound, "domain not found", nil),
+			wantLookups: 1,
+			wantRecipient: &file.RecipientError{
+				Code:   550,
+				Secode: "1.2",
+				Line:   "550 5.1.2 [DOMAIN_NOT_FOUND] domain not found",
+			},
+		},
+		{
+			name:        "sent_again_when_temporary",
+			status:      dn.MX_STATUS_TEMP_FAILURE,
+			lookupErr:   rerrors.NewError(rerrors.ErrDNSLookup, "failed to lookup MX records", nil),
+			wantLookups: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			fileInfo := &file.FileInfo{ID: "test-id"}
+			from, err := smtp.ParseAddress("billing@example.com")
+			require.NoError(t, err)
+			to, err := smtp.ParseAddress("john@example.org")
+			require.NoError(t, err)
+			mail := &pmail.Mail{
+				Body:        []byte("body"),
+				Headers:     []byte("Subject: test"),
+				ContentType: []byte("text/plain"),
+				From:        from,
+				To:          []smtp.Address{to},
+			}
+			mockFileReader := file.NewMockIFileReader(ctrl)
+			mockLifecycle := file.NewMockIFileLifecycle(ctrl)
+			mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+			mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+			mockOutput := output.NewMockIOutput(ctrl)
+			resolver := dns.NewMockIResolver(ctrl)
+
+			mockFileReader.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+			mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(mail, nil)
+			mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(mail, nil)
+			resolver.EXPECT().LookupMX(gomock.Any(), gomock.Any()).
+				Return(&dn.MXRecord{Domain: "example.org", Status: tt.status}, tt.lookupErr).
+				Times(tt.wantLookups)
+			if tt.wantRecipient != nil {
+				mockOutput.EXPECT().Write(gomock.Any(), fileInfo, mail, gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ *file.FileInfo, _ *pmail.Mail, responses map[string][]pmail.Response) error {
+						require.Len(t, responses["john@example.org"], 1)
+						response := responses["john@example.org"][0]
+						assert.True(t, response.Permanent)
+						assert.Equal(t, tt.wantRecipient.Code, response.Code)
+						assert.NoError(t, response.Err)
+						return nil
+					})
+				mockLifecycle.EXPECT().Fail(gomock.Any(), fileInfo, &file.FileError{
+					Error:      "permanent failure",
+					Recipients: map[string]file.RecipientError{"john@example.org": *tt.wantRecipient},
+				}).Return(nil)
+			}
+
+			service := NewSendMailService(
+				ctx,
+				1,
+				mockFileReader,
+				mockMailProcessor,
+				NewMailSender(ctx, false, NewMockINetDialerFactory(ctrl), resolver, telemetry.GetSLogger(ctx)),
+				mockMailTransformer,
+				mockOutput,
+				time.Second,
+				nil,
+			)
+			service.Lifecycle = mockLifecycle
+
+			got, _, err := service.ReadNextMail(ctx)
+			if tt.wantRecipient == nil {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, fileInfo, got)
+		})
+	}
+}
+
+func TestReadNextMailFail(t *testing.T) {
+	tests := []struct {
+		name       string
+		processErr error
+		sendErrs   map[string]error
+		failErr    error
+		wantFail   *file.FileError
+		wantErr    bool
+	}{
+		{
+			name: "not_failed_when_sent",
+		},
+		{
+			name:       "failed_when_process_fails",
+			processErr: errors.New("process failed"),
+			wantFail:   &file.FileError{Error: "process failed"},
+			wantErr:    true,
+		},
+		{
+			name:     "not_failed_when_temporary",
+			sendErrs: map[string]error{"john@example.org": errors.New("connection refused")},
+			wantErr:  true,
+		},
+		{
+			name:       "error_kept_when_fail_fails",
+			processErr: errors.New("process failed"),
+			failErr:    errors.New("disk full"),
+			wantFail:   &file.FileError{Error: "process failed"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := telemetry.InitLogger(context.Background())
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			fileInfo := &file.FileInfo{ID: "test-id"}
+			mail := &pmail.Mail{}
+			mockFileFailer := file.NewMockIFileFailer(ctrl)
+			mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+			mockMailSender := NewMockIMailSender(ctrl)
+			mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+			mockOutput := output.NewMockIOutput(ctrl)
+
+			mockFileFailer.EXPECT().ReadNextFile(gomock.Any()).Return(fileInfo, nil)
+			mockMailTransformer.EXPECT().Transform(gomock.Any(), fileInfo, gomock.Any()).Return(mail, nil)
+			mockMailProcessor.EXPECT().Process(gomock.Any(), mail).Return(mail, tt.processErr)
+			if tt.processErr == nil {
+				mockMailSender.EXPECT().SendMail(gomock.Any(), mail).Return(map[string][]pmail.Response{}, tt.sendErrs)
+			}
+			if !tt.wantErr {
+				mockOutput.EXPECT().Write(gomock.Any(), fileInfo, mail, gomock.Any()).Return(nil)
+			}
+			if tt.wantFail != nil {
+				mockFileFailer.EXPECT().Fail(gomock.Any(), fileInfo, tt.wantFail).Return(tt.failErr)
+			}
+
+			service := NewSendMailService(
+				ctx,
+				1,
+				mockFileFailer,
+				mockMailProcessor,
+				mockMailSender,
+				mockMailTransformer,
+				mockOutput,
+				time.Second,
+				nil,
+			)
+
+			got, _, err := service.ReadNextMail(ctx)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, fileInfo, got)
+			}
+		})
+	}
+}
+
+func TestReadNextMailPartialDelivery(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	john, err := smtp.ParseAddress("john@example.org")
+	require.NoError(t, err)
+	jane, err := smtp.ParseAddress("jane@example.org")
+	require.NoError(t, err)
+	delivered := pmail.Response{Response: smtpclient.Response{Code: 250, Line: "250 OK"}}
+	mockFileReader := file.NewMockIFileReader(ctrl)
+	mockRecipients := file.NewMockIRecipientTracker(ctrl)
+	mockLifecycle := file.NewMockIFileLifecycle(ctrl)
+	mockMailProcessor := intmail.NewMockIMailProcessor(ctrl)
+	mockMailSender := NewMockIMailSender(ctrl)
+	mockMailTransformer := file_mail.NewMockIMailTransformer(ctrl)
+	mockOutput := output.NewMockIOutput(ctrl)
+
+	service := NewSendMailService(
+		ctx,
+		1,
+		mockFileReader,
+		mockMailProcessor,
+		mockMailSender,
+		mockMailTransformer,
+		mockOutput,
+		time.Second,
+		nil,
+	)
+	service.Lifecycle = mockLifecycle
+	service.Recipients = mockRecipients
+	recipientsDone := make(map[string]file.RecipientDone)
+	mockRecipients.EXPECT().RecipientsDone(gomock.Any(), "test-id").DoAndReturn(
+		func(context.Context, string) (map[string]file.RecipientDone, error) {
+			return maps.Clone(recipientsDone), nil
+		}).Times(2)
+	mockRecipients.EXPECT().UpsertRecipients(gomock.Any(), "test-id", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, recipients map[string]file.RecipientDone) error {
+			maps.Copy(recipientsDone, recipients)
+			return nil
+		}).Times(2)
+	mockFileReader.EXPECT().ReadNextFile(gomock.Any()).DoAndReturn(func(context.Context) (*file.FileInfo, error) {
+		return &file.FileInfo{ID: "test-id"}, nil
+	}).Times(2)
+	mockMailTransformer.EXPECT().Transform(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(context.Context, *file.FileInfo, *pmail.Mail) (*pmail.Mail, error) {
+			return &pmail.Mail{To: []smtp.Address{john, jane}}, nil
+		}).Times(2)
+	mockMailProcessor.EXPECT().Process(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, mail *pmail.Mail) (*pmail.Mail, error) {
+			return mail, nil
+		}).Times(2)
+
+	// 1. john is delivered, jane fails temporarily: the output of john is
+	// written, the file is not finished
+	first := mockMailSender.EXPECT().SendMail(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, mail *pmail.Mail) (map[string][]pmail.Response, map[string]error) {
+			assert.Equal(t, []smtp.Address{john, jane}, mail.To)
+			return map[string][]pmail.Response{john.String(): {delivered}},
+				map[string]error{jane.String(): errors.New("connection refused")}
+		})
+	mockOutput.EXPECT().Write(gomock.Any(), gomock.Any(), gomock.Any(), map[string][]pmail.Response{
+		john.String(): {delivered},
+	}).DoAndReturn(func(_ context.Context, fileInfo *file.FileInfo, _ *pmail.Mail, _ map[string][]pmail.Response) error {
+		assert.Equal(t, input.FILE_STATUS_PARTIAL, fileInfo.Status)
+		return nil
+	})
+	got, _, err := service.ReadNextMail(ctx)
+	require.Error(t, err)
+	assert.Nil(t, got)
+	assert.Contains(t, err.Error(), "jane@example.org: connection refused")
+
+	// 2. the mail sent again only goes to jane, and is finished with both
+	mockMailSender.EXPECT().SendMail(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, mail *pmail.Mail) (map[string][]pmail.Response, map[string]error) {
+			assert.Equal(t, []smtp.Address{jane}, mail.To)
+			return map[string][]pmail.Response{jane.String(): {delivered}}, nil
+		}).After(first)
+	mockOutput.EXPECT().Write(gomock.Any(), gomock.Any(), gomock.Any(), map[string][]pmail.Response{
+		jane.String(): {delivered},
+	}).DoAndReturn(func(_ context.Context, fileInfo *file.FileInfo, _ *pmail.Mail, _ map[string][]pmail.Response) error {
+		assert.Equal(t, input.FILE_STATUS_DELIVERED, fileInfo.Status)
+		return nil
+	})
+	mockLifecycle.EXPECT().Archive(gomock.Any(), gomock.Any()).Return(nil)
+	got, _, err = service.ReadNextMail(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "test-id", got.ID)
+	assert.Equal(t, map[string]file.RecipientDone{
+		john.String(): {Code: 250, Line: "250 OK"},
+		jane.String(): {Code: 250, Line: "250 OK"},
+	}, recipientsDone)
+}
@@ -0,0 +1,42 @@
+// Package smtpd provides the smtp listener of the server, which accepts the
+// messages of the applications that only speak smtp, and spools them as the
+// df/qf pairs read by the sendmail input.
+package smtpd
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// Message is a message accepted by the smtp listener
+type Message struct {
+	// ID is the id of the df/qf pair of the message
+	ID string
+	// From is the envelope sender
+	From smtp.Address
+	// To are the envelope recipients
+	To []smtp.Address
+	// Data is the message as received, after the Received header
+	Data []byte
+	// Received is when the message was accepted
+	Received time.Time
+}
+
+// ISpooler hands an accepted message to the workers
+type ISpooler interface {
+	// Spool writes the message durably, or enqueues it for the workers.
+	// The message is only acknowledged to the client once Spool succeeds.
+	//
+	// Parameters:
+	//   - ctx: Context for logging and cancellation
+	//   - msg: The accepted message
+	//
+	// Returns:
+	//   - error: Non-nil if the message cannot be spooled, the client
+	//     receives a temporary failure and retries
+	Spool(ctx context.Context, msg *Message) error
+}
+
+//go:generate mockgen -destination=mock.go -package=smtpd . ISpooler
@@ -0,0 +1,55 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/stlimtat/remiges-smtp/internal/smtpd (interfaces: ISpooler)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mock.go -package=smtpd . ISpooler
+//
+
+// Package smtpd is a generated GoMock package.
+package smtpd
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockISpooler is a mock of ISpooler interface.
+type MockISpooler struct {
+	ctrl     *gomock.Controller
+	recorder *MockISpoolerMockRecorder
+	isgomock struct{}
+}
+
+// MockISpoolerMockRecorder is the mock recorder for MockISpooler.
+type MockISpoolerMockRecorder struct {
+	mock *MockISpooler
+}
+
+// NewMockISpooler creates a new mock instance.
+func NewMockISpooler(ctrl *gomock.Controller) *MockISpooler {
+	mock := &MockISpooler{ctrl: ctrl}
+	mock.recorder = &MockISpoolerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockISpooler) EXPECT() *MockISpoolerMockRecorder {
+	return m.recorder
+}
+
+// Spool mocks base method.
+func (m *MockISpooler) Spool(ctx context.Context, msg *Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Spool", ctx, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Spool indicates an expected call of Spool.
+func (mr *MockISpoolerMockRecorder) Spool(ctx, msg any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Spool", reflect.TypeOf((*MockISpooler)(nil).Spool), ctx, msg)
+}
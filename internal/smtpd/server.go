@@ -0,0 +1,118 @@
+package smtpd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/config"
+)
+
+// Server is the smtp listener, which accepts the messages of the allowed
+// clients, and acknowledges each one once its spooler has written it.
+type Server struct {
+	Cfg     config.SMTPServerConfig
+	Spooler ISpooler
+
+	// tlsConfig enables STARTTLS, nil without a certificate
+	tlsConfig *tls.Config
+
+	// wg waits for the sessions on shutdown
+	wg sync.WaitGroup
+}
+
+// NewServer creates a new instance of Server.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - cfg: The smtp server configuration, after Transform
+//   - spooler: The spooler of the accepted messages
+//
+// Returns:
+//   - *Server: A new server instance
+//   - error: Non-nil if the certificate cannot be loaded
+func NewServer(
+	ctx context.Context,
+	cfg config.SMTPServerConfig,
+	spooler ISpooler,
+) (*Server, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().
+		Str("addr", cfg.Addr).
+		Str("mode", cfg.Mode).
+		Msg("NewServer")
+	if spooler == nil {
+		return nil, errors.New("the smtp server requires a spooler")
+	}
+	result := &Server{
+		Cfg:     cfg,
+		Spooler: spooler,
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("tls.LoadX509KeyPair")
+			return nil, err
+		}
+		result.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	return result, nil
+}
+
+// ListenAndServe listens on the configured address, and serves until the
+// context is done.
+//
+// Returns:
+//   - error: Non-nil if the listener cannot be opened or fails
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", s.Cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts the connections of the listener until the context is done,
+// then closes it and waits for the sessions. A session ends after the
+// command it is running, the messages that are not acknowledged yet are
+// retried by their clients.
+//
+// Returns:
+//   - error: Non-nil if accepting fails before the context is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Str("addr", listener.Addr().String()).Msg("smtp server listening")
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = listener.Close()
+	})
+	defer stop()
+	defer s.wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			logger.Error().Err(err).Msg("listener.Accept")
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			newSession(ctx, s, conn).serve()
+		}()
+	}
+}
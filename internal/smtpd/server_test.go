@@ -0,0 +1,357 @@
+package smtpd
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stlimtat/remiges-smtp/internal/config"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1
+func writeTestCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile
+}
+
+// startServer serves on a local port until the end of the test
+func startServer(t *testing.T, cfg config.SMTPServerConfig, spooler ISpooler) string {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	require.NoError(t, cfg.Transform(
+		ctx,
+		config.InputConfig{Type: config.InputTypeSendmail},
+		config.ReadFileConfig{FileMails: config.DefaultQfFileMailConfigs()},
+	))
+	server, err := NewServer(ctx, cfg, spooler)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return listener.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	secureCfg := config.SMTPServerConfig{
+		Enabled:     true,
+		Hostname:    "relay.example.com",
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		RequireTLS:  true,
+		RequireAuth: true,
+		Users:       []config.SMTPUserConfig{{Username: "app", Password: "secret"}},
+		MaxSize:     1024,
+	}
+	const body = "From: app@example.com\r\n" +
+		"To: john@example.org\r\n" +
+		"Subject: test\r\n" +
+		"\r\n" +
+		".leading dot\r\n" +
+		"body\r\n"
+
+	tests := []struct {
+		name      string
+		cfg       config.SMTPServerConfig
+		startTLS  bool
+		password  string
+		body      string
+		spoolErr  error
+		wantErr   string
+		wantSpool bool
+	}{
+		{
+			name:      "happy",
+			cfg:       secureCfg,
+			startTLS:  true,
+			password:  "secret",
+			body:      body,
+			wantSpool: true,
+		},
+		{
+			name:     "without tls",
+			cfg:      secureCfg,
+			password: "",
+			body:     body,
+			wantErr:  "530 5.7.0 must issue a STARTTLS command first",
+		},
+		{
+			name:     "without auth",
+			cfg:      secureCfg,
+			startTLS: true,
+			body:     body,
+			wantErr:  "530 5.7.0 authentication required",
+		},
+		{
+			name:     "wrong password",
+			cfg:      secureCfg,
+			startTLS: true,
+			password: "wrong",
+			body:     body,
+			wantErr:  "535 5.7.8 authentication failed",
+		},
+		{
+			name:     "too large",
+			cfg:      secureCfg,
+			startTLS: true,
+			password: "secret",
+			body:     body + strings.Repeat("a", 1024) + "\r\n",
+			wantErr:  "552 5.3.4 message too large",
+		},
+		{
+			name:      "spool failure",
+			cfg:       secureCfg,
+			startTLS:  true,
+			password:  "secret",
+			body:      body,
+			spoolErr:  assert.AnError,
+			wantErr:   "451 4.3.0 cannot queue the message, try again later",
+			wantSpool: true,
+		},
+		{
+			name:    "client not allowed",
+			cfg:     config.SMTPServerConfig{Enabled: true, AllowedCIDRs: []string{"10.0.0.0/8"}},
+			body:    body,
+			wantErr: "554 5.7.1 client not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			spooler := NewMockISpooler(ctrl)
+			var got *Message
+			if tt.wantSpool {
+				spooler.EXPECT().Spool(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, msg *Message) error {
+						got = msg
+						return tt.spoolErr
+					})
+			}
+			addr := startServer(t, tt.cfg, spooler)
+
+			err := sendTestMail(addr, tt.startTLS, tt.password, tt.body)
+			if tt.wantErr != "" {
+				var protoErr *textproto.Error
+				require.ErrorAs(t, err, &protoErr)
+				assert.Equal(t, tt.wantErr, fmt.Sprintf("%d %s", protoErr.Code, protoErr.Msg))
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, got)
+			assert.Equal(t, "app@example.com", got.From.String())
+			require.Len(t, got.To, 2)
+			assert.Equal(t, "john@example.org", got.To[0].String())
+			assert.Equal(t, "audit@example.com", got.To[1].String())
+			data := string(got.Data)
+			assert.True(t, strings.HasPrefix(data, "Received: from localhost ([127.0.0.1])\r\n\tby relay.example.com with ESMTPSA id "+got.ID+";"), data)
+			// The client stuffs the leading dot, which the server removes
+			assert.True(t, strings.HasSuffix(data, "\r\n"+body), data)
+		})
+	}
+}
+
+// sendTestMail sends a mail to john@example.org with a blind copy to
+// audit@example.com
+func sendTestMail(addr string, startTLS bool, password string, body string) error {
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	if startTLS {
+		err = client.StartTLS(&tls.Config{InsecureSkipVerify: true}) //nolint:gosec // self-signed test certificate
+		if err != nil {
+			return err
+		}
+	}
+	if password != "" {
+		err = client.Auth(smtp.PlainAuth("", "app", password, "127.0.0.1"))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail("app@example.com")
+	if err != nil {
+		return err
+	}
+	for _, to := range []string{"john@example.org", "audit@example.com"} {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte(body))
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func TestServerAuthLogin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	addr := startServer(t, config.SMTPServerConfig{
+		Enabled:           true,
+		AllowInsecureAuth: true,
+		Users:             []config.SMTPUserConfig{{Username: "app", Password: "secret"}},
+	}, NewMockISpooler(ctrl))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	exchange := func(command string) string {
+		if command != "" {
+			_, err := conn.Write([]byte(command + "\r\n"))
+			require.NoError(t, err)
+		}
+		var last string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			last = strings.TrimRight(line, "\r\n")
+			if len(last) < 4 || last[3] != '-' {
+				return last
+			}
+		}
+	}
+
+	assert.True(t, strings.HasPrefix(exchange(""), "220 "))
+	assert.Equal(t, "250 AUTH PLAIN LOGIN", exchange("EHLO client.example.com"))
+	assert.Equal(t, "334 VXNlcm5hbWU6", exchange("AUTH LOGIN"))
+	assert.Equal(t, "334 UGFzc3dvcmQ6", exchange("YXBw"))
+	assert.Equal(t, "235 2.7.0 authenticated", exchange("c2VjcmV0"))
+	assert.Equal(t, "503 5.5.1 already authenticated", exchange("AUTH PLAIN"))
+	assert.Equal(t, "503 5.5.1 send MAIL first", exchange("RCPT TO:<john@example.org>"))
+	assert.Equal(t, "555 5.5.4 unsupported parameter FOO", exchange("MAIL FROM:<app@example.com> FOO=1"))
+	assert.Equal(t, "552 5.3.4 message too large", exchange("MAIL FROM:<app@example.com> SIZE=99999999999"))
+	assert.Equal(t, "250 2.1.0 OK", exchange("MAIL FROM:<app@example.com> SIZE=10 BODY=8BITMIME"))
+	assert.Equal(t, "503 5.5.1 send RCPT first", exchange("DATA"))
+	assert.Equal(t, "501 5.1.3 invalid recipient address", exchange("RCPT TO:<john>"))
+	assert.Equal(t, "221 2.0.0 bye", exchange("QUIT"))
+}
+
+func TestReadData(t *testing.T) {
+	long := strings.Repeat("a", 2*MaxLineLength)
+	tests := []struct {
+		name    string
+		input   string
+		maxSize int64
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "dot stuffing",
+			input:   "a\r\n..b\r\n.\r\nNEXT",
+			maxSize: 100,
+			want:    "a\r\n.b\r\n",
+		},
+		{
+			name:    "long line",
+			input:   long + "\r\n.\r\n",
+			maxSize: int64(len(long) + 2),
+			want:    long + "\r\n",
+		},
+		{
+			name:    "too large",
+			input:   long + "\r\n.\r\nNEXT",
+			maxSize: 100,
+			wantErr: errMessageTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(tt.input), MaxLineLength)
+			got, err := readData(reader, tt.maxSize)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(got))
+			}
+			if strings.HasSuffix(tt.input, "NEXT") {
+				// The reader stops after the end of the data
+				rest, _ := reader.ReadString('\n')
+				assert.Equal(t, "NEXT", rest)
+			}
+		})
+	}
+}
+
+func TestAllowedAddr(t *testing.T) {
+	readFile := config.ReadFileConfig{FileMails: config.DefaultQfFileMailConfigs()}
+	cfg := config.SMTPServerConfig{Enabled: true}
+	require.NoError(t, cfg.Transform(context.Background(), config.InputConfig{Type: config.InputTypeSendmail}, readFile))
+	assert.True(t, cfg.AllowedAddr(netip.MustParseAddr("127.0.0.1")))
+	assert.True(t, cfg.AllowedAddr(netip.MustParseAddr("::ffff:127.0.0.1")))
+	assert.True(t, cfg.AllowedAddr(netip.MustParseAddr("::1")))
+	assert.False(t, cfg.AllowedAddr(netip.MustParseAddr("192.0.2.1")))
+
+	cfg = config.SMTPServerConfig{Enabled: true}
+	assert.Error(t, cfg.Transform(context.Background(), config.InputConfig{Type: config.InputTypeEML}, readFile))
+	cfg = config.SMTPServerConfig{Enabled: true, RequireAuth: true}
+	assert.Error(t, cfg.Transform(context.Background(), config.InputConfig{Type: config.InputTypeSendmail}, readFile))
+	// The envelope would be read from the headers, not from the qf
+	cfg = config.SMTPServerConfig{Enabled: true}
+	assert.Error(t, cfg.Transform(
+		context.Background(),
+		config.InputConfig{Type: config.InputTypeSendmail},
+		config.ReadFileConfig{FileMails: []config.FileMailConfig{
+			{Type: "headers", Args: map[string]any{"prefix": "H??"}},
+			{Type: "header_to", Index: 1},
+		}},
+	))
+}
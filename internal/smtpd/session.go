@@ -0,0 +1,580 @@
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjl-/mox/smtp"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// MaxLineLength is the limit of a command line, and the size of the
+	// buffer the data lines are read with
+	MaxLineLength = 4096
+	// MaxAuthFailures closes the session after as many failed AUTH
+	MaxAuthFailures = 3
+)
+
+var (
+	errLineTooLong     = errors.New("line too long")
+	errMessageTooLarge = errors.New("message too large")
+	errAuthCancelled   = errors.New("authentication cancelled")
+	errAuthInvalid     = errors.New("invalid authentication response")
+)
+
+// session is the smtp conversation with a client
+type session struct {
+	ctx    context.Context
+	logger zerolog.Logger
+	server *Server
+
+	// netConn is the accepted connection, which the deadlines are set on
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+
+	remoteAddr   netip.Addr
+	helo         string
+	tls          bool
+	user         string
+	authFailures int
+
+	// The envelope of the current transaction
+	hasFrom bool
+	from    smtp.Address
+	to      []smtp.Address
+}
+
+func newSession(ctx context.Context, server *Server, conn net.Conn) *session {
+	result := &session{
+		ctx:     ctx,
+		server:  server,
+		netConn: conn,
+		reader:  bufio.NewReaderSize(conn, MaxLineLength),
+		writer:  bufio.NewWriter(conn),
+	}
+	if addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+		result.remoteAddr = addrPort.Addr().Unmap()
+	}
+	result.logger = zerolog.Ctx(ctx).With().
+		Str("remote", result.remoteAddr.String()).
+		Logger()
+	return result
+}
+
+// serve runs the session until the client quits, a read fails, or the
+// context is done
+func (s *session) serve() {
+	defer func() { _ = s.netConn.Close() }()
+	// A past deadline interrupts the read of the next command on shutdown
+	stop := context.AfterFunc(s.ctx, func() {
+		_ = s.netConn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	cfg := s.server.Cfg
+	if !cfg.AllowedAddr(s.remoteAddr) {
+		s.logger.Warn().Msg("smtp client not allowed")
+		s.reply(554, "5.7.1 client not allowed")
+		return
+	}
+	s.logger.Debug().Msg("smtp session")
+	s.reply(220, cfg.Hostname+" ESMTP remiges-smtp")
+
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				s.reply(500, "5.5.2 line too long")
+			}
+			s.logger.Debug().Err(err).Msg("smtp session end")
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !s.handle(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// handle runs a command, and tells whether the session goes on
+func (s *session) handle(verb string, arg string) bool {
+	switch verb {
+	case "HELO", "EHLO":
+		s.hello(verb, arg)
+	case "STARTTLS":
+		return s.startTLS(arg)
+	case "AUTH":
+		return s.auth(arg)
+	case "MAIL":
+		s.mail(arg)
+	case "RCPT":
+		s.rcpt(arg)
+	case "DATA":
+		return s.data()
+	case "RSET":
+		s.reset()
+		s.reply(250, "2.0.0 OK")
+	case "NOOP":
+		s.reply(250, "2.0.0 OK")
+	case "VRFY":
+		s.reply(252, "2.5.0 cannot verify, send some mail")
+	case "QUIT":
+		s.reply(221, "2.0.0 bye")
+		return false
+	default:
+		s.reply(500, "5.5.2 command not recognized")
+	}
+	return true
+}
+
+func (s *session) hello(verb string, arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		s.reply(501, "5.5.4 a hostname is required")
+		return
+	}
+	s.helo = fields[0]
+	s.reset()
+	cfg := s.server.Cfg
+	if verb == "HELO" {
+		s.reply(250, cfg.Hostname)
+		return
+	}
+	lines := []string{
+		cfg.Hostname,
+		"PIPELINING",
+		"8BITMIME",
+		"ENHANCEDSTATUSCODES",
+		"SIZE " + strconv.FormatInt(cfg.MaxSize, 10),
+	}
+	if s.server.tlsConfig != nil && !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if s.authOffered() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	s.replyLines(250, lines)
+}
+
+func (s *session) startTLS(arg string) bool {
+	switch {
+	case s.server.tlsConfig == nil:
+		s.reply(502, "5.5.1 STARTTLS not supported")
+		return true
+	case s.tls:
+		s.reply(503, "5.5.1 TLS already active")
+		return true
+	case arg != "":
+		s.reply(501, "5.5.4 no parameters allowed")
+		return true
+	}
+	s.reply(220, "2.0.0 ready to start TLS")
+	// Commands pipelined before the handshake would be injected into the
+	// encrypted session
+	if s.reader.Buffered() > 0 {
+		s.logger.Warn().Msg("smtp data pipelined before STARTTLS")
+		return false
+	}
+	tlsConn := tls.Server(s.netConn, s.server.tlsConfig)
+	err := s.deadline()
+	if err == nil {
+		err = tlsConn.HandshakeContext(s.ctx)
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("tls handshake")
+		return false
+	}
+	s.reader = bufio.NewReaderSize(tlsConn, MaxLineLength)
+	s.writer = bufio.NewWriter(tlsConn)
+	s.tls = true
+	// The client starts over with EHLO
+	s.helo = ""
+	s.reset()
+	return true
+}
+
+// authOffered tells whether AUTH is offered, only over TLS unless the
+// configuration allows it without
+func (s *session) authOffered() bool {
+	cfg := s.server.Cfg
+	return len(cfg.Users) > 0 && s.user == "" && (s.tls || cfg.AllowInsecureAuth)
+}
+
+func (s *session) auth(arg string) bool {
+	cfg := s.server.Cfg
+	switch {
+	case s.helo == "":
+		s.reply(503, "5.5.1 send EHLO first")
+		return true
+	case s.user != "":
+		s.reply(503, "5.5.1 already authenticated")
+		return true
+	case s.hasFrom:
+		s.reply(503, "5.5.1 AUTH is not allowed during a transaction")
+		return true
+	case len(cfg.Users) == 0:
+		s.reply(502, "5.5.1 AUTH not supported")
+		return true
+	case !s.authOffered():
+		s.reply(538, "5.7.11 encryption required for AUTH")
+		return true
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	var err error
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		var response []byte
+		response, err = s.authResponse(initial, "")
+		if err == nil {
+			// authzid NUL authcid NUL passwd
+			parts := strings.Split(string(response), "\x00")
+			if len(parts) != 3 || (parts[0] != "" && parts[0] != parts[1]) {
+				err = errAuthInvalid
+			} else {
+				username, password = parts[1], parts[2]
+			}
+		}
+	case "LOGIN":
+		var response []byte
+		response, err = s.authResponse(initial, base64.StdEncoding.EncodeToString([]byte("Username:")))
+		if err == nil {
+			username = string(response)
+			response, err = s.authResponse("", base64.StdEncoding.EncodeToString([]byte("Password:")))
+			password = string(response)
+		}
+	default:
+		s.reply(504, "5.5.4 unrecognized authentication mechanism")
+		return true
+	}
+	if errors.Is(err, errAuthCancelled) {
+		s.reply(501, "5.0.0 authentication cancelled")
+		return true
+	}
+	if err != nil && !errors.Is(err, errAuthInvalid) {
+		// The connection failed
+		return false
+	}
+	if err != nil || !s.server.checkUser(username, password) {
+		s.authFailures++
+		s.logger.Warn().Str("username", username).Msg("smtp authentication failed")
+		if s.authFailures >= MaxAuthFailures {
+			s.reply(421, "4.7.0 too many authentication failures")
+			return false
+		}
+		s.reply(535, "5.7.8 authentication failed")
+		return true
+	}
+	s.user = username
+	s.logger = s.logger.With().Str("username", username).Logger()
+	s.reply(235, "2.7.0 authenticated")
+	return true
+}
+
+// authResponse returns the decoded initial response, or prompts the client
+// for its response
+func (s *session) authResponse(initial string, prompt string) ([]byte, error) {
+	response := initial
+	if response == "" {
+		s.reply(334, prompt)
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		response = line
+	}
+	switch response {
+	case "*":
+		return nil, errAuthCancelled
+	case "=":
+		return []byte{}, nil
+	}
+	result, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAuthInvalid, err)
+	}
+	return result, nil
+}
+
+// checkUser compares the credentials with every user in constant time
+func (s *Server) checkUser(username string, password string) bool {
+	found := 0
+	for _, user := range s.Cfg.Users {
+		usernameOK := subtle.ConstantTimeCompare([]byte(user.Username), []byte(username))
+		passwordOK := subtle.ConstantTimeCompare([]byte(user.Password), []byte(password))
+		found |= usernameOK & passwordOK
+	}
+	return found == 1
+}
+
+func (s *session) mail(arg string) {
+	cfg := s.server.Cfg
+	switch {
+	case s.helo == "":
+		s.reply(503, "5.5.1 send EHLO first")
+		return
+	case s.hasFrom:
+		s.reply(503, "5.5.1 nested MAIL command")
+		return
+	case cfg.RequireTLS && !s.tls:
+		s.reply(530, "5.7.0 must issue a STARTTLS command first")
+		return
+	case cfg.RequireAuth && s.user == "":
+		s.reply(530, "5.7.0 authentication required")
+		return
+	}
+	path, params, err := parsePath(arg, "FROM:")
+	if err != nil {
+		s.reply(501, "5.5.4 syntax: MAIL FROM:<address>")
+		return
+	}
+	if path == "" {
+		s.reply(550, "5.1.7 a sender address is required")
+		return
+	}
+	from, err := smtp.ParseAddress(path)
+	if err != nil {
+		s.reply(553, "5.1.7 invalid sender address")
+		return
+	}
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		switch strings.ToUpper(key) {
+		case "SIZE":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				s.reply(501, "5.5.4 invalid SIZE")
+				return
+			}
+			if size > cfg.MaxSize {
+				s.reply(552, "5.3.4 message too large")
+				return
+			}
+		case "BODY":
+			if !strings.EqualFold(value, "7BIT") && !strings.EqualFold(value, "8BITMIME") {
+				s.reply(501, "5.5.4 unsupported BODY")
+				return
+			}
+		default:
+			s.reply(555, "5.5.4 unsupported parameter "+key)
+			return
+		}
+	}
+	s.hasFrom = true
+	s.from = from
+	s.reply(250, "2.1.0 OK")
+}
+
+func (s *session) rcpt(arg string) {
+	if !s.hasFrom {
+		s.reply(503, "5.5.1 send MAIL first")
+		return
+	}
+	if len(s.to) >= s.server.Cfg.MaxRecipients {
+		s.reply(452, "4.5.3 too many recipients")
+		return
+	}
+	path, params, err := parsePath(arg, "TO:")
+	if err != nil {
+		s.reply(501, "5.5.4 syntax: RCPT TO:<address>")
+		return
+	}
+	if len(params) > 0 {
+		s.reply(555, "5.5.4 unsupported parameter "+params[0])
+		return
+	}
+	to, err := smtp.ParseAddress(path)
+	if err != nil {
+		s.reply(501, "5.1.3 invalid recipient address")
+		return
+	}
+	s.to = append(s.to, to)
+	s.reply(250, "2.1.5 OK")
+}
+
+// data reads the message, and acknowledges it once it is spooled
+func (s *session) data() bool {
+	if len(s.to) == 0 {
+		s.reply(503, "5.5.1 send RCPT first")
+		return true
+	}
+	s.reply(354, "end data with <CR><LF>.<CR><LF>")
+	err := s.deadline()
+	if err != nil {
+		return false
+	}
+	data, err := readData(s.reader, s.server.Cfg.MaxSize)
+	if errors.Is(err, errMessageTooLarge) {
+		s.reset()
+		s.reply(552, "5.3.4 message too large")
+		return true
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("readData")
+		return false
+	}
+
+	now := time.Now()
+	msg := &Message{
+		ID:       uuid.NewString(),
+		From:     s.from,
+		To:       s.to,
+		Received: now,
+	}
+	msg.Data = append([]byte(s.received(msg.ID, now)), data...)
+	s.reset()
+
+	logger := s.logger.With().
+		Str("id", msg.ID).
+		Str("from", msg.From.String()).
+		Int("recipients", len(msg.To)).
+		Int("size", len(msg.Data)).
+		Logger()
+	err = s.server.Spooler.Spool(s.ctx, msg)
+	if err != nil {
+		logger.Error().Err(err).Msg("Spooler.Spool")
+		s.reply(451, "4.3.0 cannot queue the message, try again later")
+		return true
+	}
+	logger.Info().Msg("smtp message accepted")
+	s.reply(250, "2.0.0 queued as "+msg.ID)
+	return true
+}
+
+// received returns the Received header of a message
+func (s *session) received(id string, now time.Time) string {
+	protocol := "ESMTP"
+	if s.tls {
+		protocol += "S"
+	}
+	if s.user != "" {
+		protocol += "A"
+	}
+	return fmt.Sprintf("Received: from %s ([%s])\r\n\tby %s with %s id %s;\r\n\t%s\r\n",
+		s.helo, s.remoteAddr, s.server.Cfg.Hostname, protocol, id, now.Format(time.RFC1123Z))
+}
+
+func (s *session) reset() {
+	s.hasFrom = false
+	s.from = smtp.Address{}
+	s.to = nil
+}
+
+// deadline limits the next read, and fails once the context is done, after
+// setting the deadline so that a shutdown in between is not missed
+func (s *session) deadline() error {
+	err := s.netConn.SetDeadline(time.Now().Add(s.server.Cfg.Timeout))
+	if err != nil {
+		return err
+	}
+	return s.ctx.Err()
+}
+
+func (s *session) readLine() (string, error) {
+	err := s.deadline()
+	if err != nil {
+		return "", err
+	}
+	line, err := s.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (s *session) reply(code int, text string) {
+	s.replyLines(code, []string{text})
+}
+
+func (s *session) replyLines(code int, lines []string) {
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		_, _ = fmt.Fprintf(s.writer, "%d%s%s\r\n", code, separator, line)
+	}
+	err := s.writer.Flush()
+	if err != nil {
+		s.logger.Debug().Err(err).Msg("reply")
+	}
+}
+
+// parsePath parses the <path> and the parameters of MAIL FROM: and RCPT TO:
+func parsePath(arg string, prefix string) (string, []string, error) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, fmt.Errorf("missing %s", prefix)
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", nil, errors.New("missing <")
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", nil, errors.New("missing >")
+	}
+	path := rest[1:end]
+	// A source route is ignored, RFC 5321 section 4.1.1.3
+	if strings.HasPrefix(path, "@") {
+		if _, mailbox, found := strings.Cut(path, ":"); found {
+			path = mailbox
+		}
+	}
+	return path, strings.Fields(rest[end+1:]), nil
+}
+
+// readData reads the message of DATA until the line with a single dot,
+// removing the dot stuffing. A message over maxSize is read to its end and
+// discarded, so that the session stays in sync.
+func readData(reader *bufio.Reader, maxSize int64) ([]byte, error) {
+	var buf bytes.Buffer
+	tooLarge := false
+	lineStart := true
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+		complete := err == nil
+		if lineStart {
+			if complete && bytes.Equal(chunk, []byte(".\r\n")) {
+				break
+			}
+			if chunk[0] == '.' {
+				chunk = chunk[1:]
+			}
+		}
+		lineStart = complete
+		if tooLarge {
+			continue
+		}
+		if int64(buf.Len()+len(chunk)) > maxSize {
+			tooLarge = true
+			buf.Reset()
+			continue
+		}
+		buf.Write(chunk)
+	}
+	if tooLarge {
+		return nil, errMessageTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,233 @@
+package smtpd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+)
+
+const (
+	// QfVersion is the version of the qf files written by the listener
+	QfVersion = 8
+	// QfRecipientFlags are the flags of the recipients, a primary address
+	// with the failure and delay notifications
+	QfRecipientFlags = "PFD"
+)
+
+// FormatQueueFile splits a message into the qf file of its envelope and
+// headers, and the df file of its body, as a sendmail queue directory holds
+// them. The headers end at the first empty line, or at the first line that
+// is not a header.
+//
+// Parameters:
+//   - msg: The accepted message
+//
+// Returns:
+//   - []byte: The qf file
+//   - []byte: The df file
+func FormatQueueFile(msg *Message) (qf []byte, df []byte) {
+	var buf bytes.Buffer
+	buf.WriteString("V" + strconv.Itoa(QfVersion) + "\n")
+	buf.WriteString("T" + strconv.FormatInt(msg.Received.Unix(), 10) + "\n")
+	buf.WriteString("N0\n")
+	buf.WriteString("S<" + msg.From.String() + ">\n")
+	for _, to := range msg.To {
+		buf.WriteString("R" + QfRecipientFlags + ":<" + to.String() + ">\n")
+	}
+
+	rest := msg.Data
+	inHeader := false
+headers:
+	for len(rest) > 0 {
+		line, remain, found := bytes.Cut(rest, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if !found {
+			remain = nil
+		}
+		switch {
+		case len(line) == 0:
+			// The empty line between the headers and the body
+			rest = remain
+			break headers
+		case line[0] == ' ' || line[0] == '\t':
+			// A continuation of the previous header
+			if !inHeader {
+				break headers
+			}
+		case isHeaderLine(line):
+			inHeader = true
+			buf.WriteString("H??")
+		default:
+			// Neither a header nor its continuation, the body starts here
+			break headers
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		rest = remain
+	}
+	buf.WriteString(".\n")
+	return buf.Bytes(), rest
+}
+
+// isHeaderLine tells whether the line starts with a header name and a colon
+func isHeaderLine(line []byte) bool {
+	colon := bytes.IndexByte(line, ':')
+	if colon <= 0 {
+		return false
+	}
+	for _, c := range line[:colon] {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// DirSpooler implements ISpooler by writing each message as a df/qf pair
+// into the input directory, which the sendmail input reads
+type DirSpooler struct {
+	inPath string
+}
+
+// NewDirSpooler creates a new instance of DirSpooler.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - inPath: The input directory of the sendmail input
+//
+// Returns:
+//   - *DirSpooler: A new spooler instance
+//   - error: Non-nil if inPath is not a directory
+func NewDirSpooler(ctx context.Context, inPath string) (*DirSpooler, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("inPath", inPath).Msg("NewDirSpooler")
+	info, err := os.Stat(inPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", inPath)
+	}
+	return &DirSpooler{inPath: inPath}, nil
+}
+
+// Spool writes the qf and the df files to hidden temporary files, syncs
+// them, and renames the qf then the df file, so that the readers, which
+// list the df files, never see a partial pair. The directory is synced
+// before the message is acknowledged.
+func (s *DirSpooler) Spool(ctx context.Context, msg *Message) error {
+	logger := zerolog.Ctx(ctx).With().Str("id", msg.ID).Logger()
+	logger.Debug().Msg("DirSpooler.Spool")
+
+	qf, df := FormatQueueFile(msg)
+	qfPath := filepath.Join(s.inPath, "qf"+msg.ID)
+	dfPath := filepath.Join(s.inPath, "df"+msg.ID)
+
+	qfTmp, err := writeTemp(s.inPath, "qf"+msg.ID, qf)
+	if err != nil {
+		logger.Error().Err(err).Msg("writeTemp qf")
+		return err
+	}
+	defer func() { _ = os.Remove(qfTmp) }()
+	dfTmp, err := writeTemp(s.inPath, "df"+msg.ID, df)
+	if err != nil {
+		logger.Error().Err(err).Msg("writeTemp df")
+		return err
+	}
+	defer func() { _ = os.Remove(dfTmp) }()
+
+	err = os.Rename(qfTmp, qfPath)
+	if err != nil {
+		logger.Error().Err(err).Msg("os.Rename qf")
+		return err
+	}
+	err = os.Rename(dfTmp, dfPath)
+	if err != nil {
+		logger.Error().Err(err).Msg("os.Rename df")
+		_ = os.Remove(qfPath)
+		return err
+	}
+	return syncDir(s.inPath)
+}
+
+// writeTemp writes and syncs a hidden temporary file, which the readers skip
+func writeTemp(dir string, name string, data []byte) (string, error) {
+	tmpFile, err := os.CreateTemp(dir, "."+name+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+// syncDir syncs the directory, so that the renames survive a crash
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = dirFile.Sync()
+	closeErr := dirFile.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// QueueSpooler implements ISpooler by handing each message to the workers
+// in memory, it is lost if the server stops before it is sent
+type QueueSpooler struct {
+	inPath    string
+	fileQueue file.IFileQueue
+}
+
+// NewQueueSpooler creates a new instance of QueueSpooler.
+//
+// Parameters:
+//   - ctx: Context for logging
+//   - inPath: The input directory, for the paths of the queued messages
+//   - fileQueue: The queue of the workers
+//
+// Returns:
+//   - *QueueSpooler: A new spooler instance
+//   - error: Non-nil if there is no queue
+func NewQueueSpooler(ctx context.Context, inPath string, fileQueue file.IFileQueue) (*QueueSpooler, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("inPath", inPath).Msg("NewQueueSpooler")
+	if fileQueue == nil {
+		return nil, fmt.Errorf("the queue mode of the smtp server requires a queue")
+	}
+	return &QueueSpooler{inPath: inPath, fileQueue: fileQueue}, nil
+}
+
+// Spool enqueues the qf and the df of the message, read by the qf and the
+// body file mail transformers instead of the files
+func (s *QueueSpooler) Spool(ctx context.Context, msg *Message) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("id", msg.ID).Msg("QueueSpooler.Spool")
+	qf, df := FormatQueueFile(msg)
+	return s.fileQueue.Enqueue(ctx, &file.FileInfo{
+		DfFilePath: filepath.Join(s.inPath, "df"+msg.ID),
+		DfReader:   bytes.NewReader(df),
+		ID:         msg.ID,
+		QfFilePath: filepath.Join(s.inPath, "qf"+msg.ID),
+		QfReader:   bytes.NewReader(qf),
+	})
+}
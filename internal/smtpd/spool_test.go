@@ -0,0 +1,137 @@
+package smtpd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/internal/file"
+	"github.com/stlimtat/remiges-smtp/internal/file_mail"
+	"github.com/stlimtat/remiges-smtp/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func newTestMessage(t *testing.T, data string) *Message {
+	from, err := smtp.ParseAddress("app@example.com")
+	require.NoError(t, err)
+	to, err := smtp.ParseAddress("john@example.org")
+	require.NoError(t, err)
+	return &Message{
+		ID:       "abc",
+		From:     from,
+		To:       []smtp.Address{to},
+		Data:     []byte(data),
+		Received: time.Unix(1700000000, 0),
+	}
+}
+
+func TestFormatQueueFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantHeaders []file_mail.QueueHeader
+		wantDf      string
+	}{
+		{
+			name: "headers and body",
+			data: "Received: from a\r\n\tby b\r\n" +
+				"Subject: Lunch at 12:30\r\n" +
+				"\r\n" +
+				"Key: not a header\r\n",
+			wantHeaders: []file_mail.QueueHeader{
+				{Name: "Received", Value: []byte("from a\tby b")},
+				{Name: "Subject", Value: []byte("Lunch at 12:30")},
+			},
+			wantDf: "Key: not a header\r\n",
+		},
+		{
+			name:        "no headers",
+			data:        "just a body\r\n",
+			wantHeaders: nil,
+			wantDf:      "just a body\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qf, df := FormatQueueFile(newTestMessage(t, tt.data))
+			assert.Equal(t, tt.wantDf, string(df))
+
+			// The qf is read back by the qf file mail transformer
+			got, err := file_mail.ParseQueueFile(bytes.NewReader(qf))
+			require.NoError(t, err)
+			assert.Equal(t, QfVersion, got.Envelope.Version)
+			assert.Equal(t, time.Unix(1700000000, 0), got.Envelope.QueueTime)
+			assert.Equal(t, "app@example.com", got.Envelope.Sender.String())
+			require.Len(t, got.Envelope.Recipients, 1)
+			assert.Equal(t, "john@example.org", got.Envelope.Recipients[0].Address.String())
+			assert.Equal(t, QfRecipientFlags, got.Envelope.Recipients[0].Flags)
+			assert.Equal(t, tt.wantHeaders, got.Headers)
+		})
+	}
+}
+
+func TestDirSpooler(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	_, err := NewDirSpooler(ctx, filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	inPath := t.TempDir()
+	spooler, err := NewDirSpooler(ctx, inPath)
+	require.NoError(t, err)
+	msg := newTestMessage(t, "Subject: test\r\n\r\nbody\r\n")
+	require.NoError(t, spooler.Spool(ctx, msg))
+
+	// Only the pair is left, without the temporary files
+	entries, err := os.ReadDir(inPath)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"dfabc", "qfabc"}, names)
+	qf, df := FormatQueueFile(msg)
+	gotDf, err := os.ReadFile(filepath.Join(inPath, "dfabc"))
+	require.NoError(t, err)
+	assert.Equal(t, df, gotDf)
+	gotQf, err := os.ReadFile(filepath.Join(inPath, "qfabc"))
+	require.NoError(t, err)
+	assert.Equal(t, qf, gotQf)
+}
+
+func TestQueueSpooler(t *testing.T) {
+	ctx, _ := telemetry.InitLogger(context.Background())
+	ctrl := gomock.NewController(t)
+	_, err := NewQueueSpooler(ctx, "/in", nil)
+	assert.Error(t, err)
+
+	queue := file.NewMockIFileQueue(ctrl)
+	var got *file.FileInfo
+	queue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, fileInfo *file.FileInfo) error {
+			got = fileInfo
+			return nil
+		})
+	spooler, err := NewQueueSpooler(ctx, "/in", queue)
+	require.NoError(t, err)
+	msg := newTestMessage(t, "Subject: test\r\n\r\nbody\r\n")
+	require.NoError(t, spooler.Spool(ctx, msg))
+
+	require.NotNil(t, got)
+	assert.Equal(t, "abc", got.ID)
+	assert.Equal(t, "/in/dfabc", got.DfFilePath)
+	assert.Equal(t, "/in/qfabc", got.QfFilePath)
+	qf, df := FormatQueueFile(msg)
+	gotDf, err := io.ReadAll(got.DfReader)
+	require.NoError(t, err)
+	assert.Equal(t, df, gotDf)
+	gotQf, err := io.ReadAll(got.QfReader)
+	require.NoError(t, err)
+	assert.Equal(t, qf, gotQf)
+}
@@ -5,9 +5,53 @@ import (
 	"github.com/mjl-/mox/dns"
 )
 
+// MXStatus is the outcome of an MX lookup
+type MXStatus int
+
+const (
+	// MX_STATUS_FOUND means the domain publishes usable MX records
+	MX_STATUS_FOUND MXStatus = 1
+	// MX_STATUS_IMPLICIT means the domain has no MX records, and mail is
+	// delivered to its A/AAAA records, see RFC 5321 section 5.1
+	MX_STATUS_IMPLICIT MXStatus = 2
+	// MX_STATUS_NULL means the domain does not accept mail, see RFC 7505
+	MX_STATUS_NULL MXStatus = 3
+	// MX_STATUS_NOT_FOUND means the domain does not exist (NXDOMAIN),
+	// or has neither MX nor A/AAAA records
+	MX_STATUS_NOT_FOUND MXStatus = 4
+	// MX_STATUS_INVALID means the MX records of the domain are unusable
+	MX_STATUS_INVALID MXStatus = 5
+	// MX_STATUS_TEMP_FAILURE means the lookup failed and may be retried
+	MX_STATUS_TEMP_FAILURE MXStatus = 0
+)
+
+// Permanent reports whether the status rules out delivery to the domain,
+// so that retrying is pointless
+func (s MXStatus) Permanent() bool {
+	return s == MX_STATUS_NULL || s == MX_STATUS_NOT_FOUND || s == MX_STATUS_INVALID
+}
+
+func (s MXStatus) String() string {
+	switch s {
+	case MX_STATUS_FOUND:
+		return "found"
+	case MX_STATUS_IMPLICIT:
+		return "implicit"
+	case MX_STATUS_NULL:
+		return "null"
+	case MX_STATUS_NOT_FOUND:
+		return "not_found"
+	case MX_STATUS_INVALID:
+		return "invalid"
+	default:
+		return "temp_failure"
+	}
+}
+
 type MXRecord struct {
 	ADNSResult adns.Result
 	Domain     string
 	Entries    []dns.IPDomain
 	Hosts      []string
+	Status     MXStatus
 }
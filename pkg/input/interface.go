@@ -1,5 +1,7 @@
 package input
 
+import "fmt"
+
 type FileStatus int
 
 const (
@@ -10,10 +12,12 @@ const (
 	FILE_STATUS_HEADERS_PARSE FileStatus = 5
 	FILE_STATUS_MAIL_PROCESS  FileStatus = 6
 	FILE_STATUS_DELIVERED     FileStatus = 7
+	FILE_STATUS_PARTIAL       FileStatus = 8 // recipients left to send again
 	FILE_STATUS_DONE          FileStatus = 99
 	FILE_STATUS_ERROR         FileStatus = 0
 	FILE_STATUS_NOT_FOUND     FileStatus = -1
 
+	HeaderCcKey          = "Cc"
 	HeaderContentTypeKey = "Content-Type"
 	HeaderDateKey        = "Date"
 	HeaderFromKey        = "From"
@@ -21,3 +25,33 @@ const (
 	HeaderSubjectKey     = "Subject"
 	HeaderToKey          = "To"
 )
+
+// String returns the name of the status, without the FILE_STATUS_ prefix
+func (s FileStatus) String() string {
+	switch s {
+	case FILE_STATUS_INIT:
+		return "INIT"
+	case FILE_STATUS_PROCESSING:
+		return "PROCESSING"
+	case FILE_STATUS_BODY_READ:
+		return "BODY_READ"
+	case FILE_STATUS_HEADERS_READ:
+		return "HEADERS_READ"
+	case FILE_STATUS_HEADERS_PARSE:
+		return "HEADERS_PARSE"
+	case FILE_STATUS_MAIL_PROCESS:
+		return "MAIL_PROCESS"
+	case FILE_STATUS_DELIVERED:
+		return "DELIVERED"
+	case FILE_STATUS_PARTIAL:
+		return "PARTIAL"
+	case FILE_STATUS_DONE:
+		return "DONE"
+	case FILE_STATUS_ERROR:
+		return "ERROR"
+	case FILE_STATUS_NOT_FOUND:
+		return "NOT_FOUND"
+	default:
+		return fmt.Sprintf("FileStatus(%d)", int(s))
+	}
+}
@@ -0,0 +1,345 @@
+// Package mailjob provides the mail job spool format, a mail to send
+// described as a JSON or YAML document instead of a sendmail qf file.
+// The format is published as a JSON schema in schema.json.
+package mailjob
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mjl-/mox/smtp"
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var (
+	// Schema is the published JSON schema of a mail job
+	//
+	//go:embed schema.json
+	Schema []byte
+
+	// FileExts are the extensions of the mail job files
+	FileExts = []string{".json", ".yaml", ".yml"}
+
+	SupportedPriorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+	// ReservedHeaders are set from the fields of the job, and cannot be set
+	// in its headers
+	ReservedHeaders = []string{
+		"Bcc", "Cc", "Content-Transfer-Encoding", "Content-Type", "Date",
+		"From", "MIME-Version", "Message-ID", "Subject", "To",
+	}
+)
+
+// Job is a mail to send, as described by schema.json
+//
+//	from: sender@example.com
+//	to: [john@example.org]
+//	bcc: [audit@example.com]
+//	subject: Your invoice
+//	text: Please find your invoice attached.
+//	attachments:
+//	  - path: invoices/2024-001.pdf
+//	options:
+//	  priority: high
+type Job struct {
+	From        string            `json:"from"`
+	To          []string          `json:"to,omitempty"`
+	Cc          []string          `json:"cc,omitempty"`
+	Bcc         []string          `json:"bcc,omitempty"`
+	Subject     string            `json:"subject"`
+	Text        string            `json:"text,omitempty"`
+	HTML        string            `json:"html,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Options     Options           `json:"options,omitempty"`
+
+	// Addresses parsed by Validate
+	FromAddress  smtp.Address   `json:"-"`
+	ToAddresses  []smtp.Address `json:"-"`
+	CcAddresses  []smtp.Address `json:"-"`
+	BccAddresses []smtp.Address `json:"-"`
+}
+
+// Attachment is a file attached to the mail, read from its path or given
+// as its content
+type Attachment struct {
+	// Path is relative to the directory of the job, and cannot leave it
+	Path string `json:"path,omitempty"`
+	// Content is the content of the attachment, base64 in the job
+	Content []byte `json:"content,omitempty"`
+	// Filename is the base name of the path by default
+	Filename string `json:"filename,omitempty"`
+	// ContentType is guessed from the extension by default
+	ContentType string `json:"content-type,omitempty"`
+}
+
+// Options are the per message options
+type Options struct {
+	// MessageID is generated by default
+	MessageID string `json:"message-id,omitempty"`
+	// Priority is high, normal or low, normal by default
+	Priority string `json:"priority,omitempty"`
+}
+
+// IsJobFile tells whether the file name has the extension of a job file
+func IsJobFile(name string) bool {
+	return slices.Contains(FileExts, strings.ToLower(filepath.Ext(name)))
+}
+
+// Parse decodes and validates a job, a YAML document when the name has the
+// .yaml or .yml extension, JSON otherwise.
+//
+// Parameters:
+//   - name: The name of the job file, for its extension
+//   - data: The content of the job file
+//
+// Returns:
+//   - *Job: The valid job
+//   - error: A *pmail.RejectedError with the errors of the fields, if the
+//     job cannot be decoded or is invalid
+func Parse(name string, data []byte) (*Job, error) {
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, &pmail.RejectedError{Fields: []pmail.FieldError{
+				{Message: fmt.Sprintf("invalid yaml: %v", err)},
+			}}
+		}
+	}
+
+	result := &Job{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(result)
+	if err != nil {
+		return nil, &pmail.RejectedError{Fields: []pmail.FieldError{decodeFieldError(err)}}
+	}
+
+	fieldErrors := result.Validate()
+	if len(fieldErrors) > 0 {
+		return nil, &pmail.RejectedError{Fields: fieldErrors}
+	}
+	return result, nil
+}
+
+// Validate checks the job against the rules of schema.json, and parses its
+// addresses.
+//
+// Returns:
+//   - []pmail.FieldError: The errors of the fields, in the order of the schema
+func (j *Job) Validate() []pmail.FieldError {
+	result := make([]pmail.FieldError, 0)
+	addError := func(field string, format string, args ...any) {
+		result = append(result, pmail.FieldError{
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	var err error
+	if j.From == "" {
+		addError("from", "is required")
+	} else if j.FromAddress, err = smtp.ParseAddress(j.From); err != nil {
+		addError("from", "invalid address %q", j.From)
+	}
+	parseAddresses := func(field string, values []string) []smtp.Address {
+		addrs := make([]smtp.Address, 0, len(values))
+		for i, value := range values {
+			addr, err := smtp.ParseAddress(value)
+			if err != nil {
+				addError(fmt.Sprintf("%s[%d]", field, i), "invalid address %q", value)
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		return addrs
+	}
+	j.ToAddresses = parseAddresses("to", j.To)
+	j.CcAddresses = parseAddresses("cc", j.Cc)
+	j.BccAddresses = parseAddresses("bcc", j.Bcc)
+	if len(j.To)+len(j.Cc)+len(j.Bcc) == 0 {
+		addError("to", "at least one recipient in to, cc or bcc is required")
+	}
+
+	if j.Subject == "" {
+		addError("subject", "is required")
+	} else if hasNewLine(j.Subject) {
+		addError("subject", "cannot contain a new line")
+	}
+	if j.Text == "" && j.HTML == "" {
+		addError("text", "text or html is required")
+	}
+
+	names := make([]string, 0, len(j.Headers))
+	for name := range j.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "headers." + name
+		switch {
+		case !validHeaderName(name):
+			addError(field, "invalid header name")
+		case slices.ContainsFunc(ReservedHeaders, func(reserved string) bool {
+			return strings.EqualFold(reserved, name)
+		}):
+			addError(field, "is set from the job, and cannot be a header")
+		case hasNewLine(j.Headers[name]):
+			addError(field, "cannot contain a new line")
+		}
+	}
+
+	for i, attachment := range j.Attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+		switch {
+		case attachment.Path == "" && attachment.Content == nil:
+			addError(field+".path", "path or content is required")
+		case attachment.Path != "" && attachment.Content != nil:
+			addError(field+".path", "path and content cannot both be set")
+		case attachment.Path == "" && attachment.Filename == "":
+			addError(field+".filename", "is required with content")
+		}
+		if hasNewLine(attachment.Filename) || strings.Contains(attachment.Filename, `"`) {
+			addError(field+".filename", "cannot contain a new line or a quote")
+		}
+		if hasNewLine(attachment.ContentType) {
+			addError(field+".content-type", "cannot contain a new line")
+		}
+	}
+
+	if hasNewLine(j.Options.MessageID) {
+		addError("options.message-id", "cannot contain a new line")
+	}
+	if j.Options.Priority != "" && !slices.Contains(SupportedPriorities, j.Options.Priority) {
+		addError("options.priority", "unsupported priority %q, supported: %v",
+			j.Options.Priority, SupportedPriorities)
+	}
+	return result
+}
+
+// yamlToJSON converts a YAML document to JSON, so that both are decoded
+// with the same rules
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document any
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+	document, err = jsonValue(document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// jsonValue converts the maps decoded by yaml, which can have keys that
+// are not strings, to maps that can be encoded as JSON
+func jsonValue(value any) (any, error) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, item := range typed {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = converted
+		}
+		return typed, nil
+	case map[any]any:
+		result := make(map[string]any, len(typed))
+		for key, item := range typed {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprint(key)] = converted
+		}
+		return result, nil
+	case []any:
+		for i, item := range typed {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = converted
+		}
+		return typed, nil
+	default:
+		return value, nil
+	}
+}
+
+// decodeFieldError returns the field of a decoding error
+func decodeFieldError(err error) pmail.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return pmail.FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be %s, not %s", jsonType(typeErr.Type.Kind().String()), typeErr.Value),
+		}
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return pmail.FieldError{
+			Message: fmt.Sprintf("invalid json at offset %d: %v", syntaxErr.Offset, err),
+		}
+	}
+	// encoding/json has no type for the unknown fields
+	const unknownPrefix = "json: unknown field "
+	if field, ok := strings.CutPrefix(err.Error(), unknownPrefix); ok {
+		return pmail.FieldError{
+			Field:   strings.Trim(field, `"`),
+			Message: "unknown field",
+		}
+	}
+	return pmail.FieldError{Message: fmt.Sprintf("invalid job: %v", err)}
+}
+
+// jsonType names the JSON type of a go kind
+func jsonType(kind string) string {
+	switch kind {
+	case "slice":
+		return "an array"
+	case "map", "struct":
+		return "an object"
+	case "string":
+		return "a string"
+	default:
+		return kind
+	}
+}
+
+// hasNewLine tells whether a header value would span lines
+func hasNewLine(value string) bool {
+	return strings.ContainsAny(value, "\r\n")
+}
+
+// validHeaderName tells whether the name is printable ASCII without a colon
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < '!' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package mailjob
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stlimtat/remiges-smtp/pkg/pmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		fileName   string
+		data       string
+		wantTo     []string
+		wantFields []pmail.FieldError
+	}{
+		{
+			name:     "json",
+			fileName: "job.json",
+			data: `{
+				"from": "sender@example.com",
+				"to": ["john@example.org"],
+				"bcc": ["audit@example.com"],
+				"subject": "test",
+				"text": "body",
+				"headers": {"X-Campaign": "spring"},
+				"options": {"priority": "high"}
+			}`,
+			wantTo: []string{"john@example.org"},
+		},
+		{
+			name:     "yaml",
+			fileName: "job.YML",
+			data: "from: sender@example.com\n" +
+				"to:\n" +
+				"  - john@example.org\n" +
+				"subject: test\n" +
+				"html: <p>body</p>\n" +
+				"metadata:\n" +
+				"  customer: \"42\"\n",
+			wantTo: []string{"john@example.org"},
+		},
+		{
+			name:     "invalid fields",
+			fileName: "job.json",
+			data: `{
+				"from": "sender",
+				"to": ["john@example.org", "jane"],
+				"subject": "two\nlines",
+				"headers": {"From": "other@example.com", "Bad Name": "x"},
+				"attachments": [{"filename": "a.pdf"}],
+				"options": {"priority": "urgent"}
+			}`,
+			wantFields: []pmail.FieldError{
+				{Field: "from", Message: `invalid address "sender"`},
+				{Field: "to[1]", Message: `invalid address "jane"`},
+				{Field: "subject", Message: "cannot contain a new line"},
+				{Field: "text", Message: "text or html is required"},
+				{Field: "headers.Bad Name", Message: "invalid header name"},
+				{Field: "headers.From", Message: "is set from the job, and cannot be a header"},
+				{Field: "attachments[0].path", Message: "path or content is required"},
+				{Field: "options.priority", Message: `unsupported priority "urgent", supported: [high normal low]`},
+			},
+		},
+		{
+			name:     "no recipient",
+			fileName: "job.json",
+			data:     `{"from": "sender@example.com", "subject": "test", "text": "body"}`,
+			wantFields: []pmail.FieldError{
+				{Field: "to", Message: "at least one recipient in to, cc or bcc is required"},
+			},
+		},
+		{
+			name:     "unknown field",
+			fileName: "job.json",
+			data:     `{"from": "sender@example.com", "reply-to": "a@example.com"}`,
+			wantFields: []pmail.FieldError{
+				{Field: "reply-to", Message: "unknown field"},
+			},
+		},
+		{
+			name:     "wrong type",
+			fileName: "job.yaml",
+			data:     "from: sender@example.com\nto: john@example.org\n",
+			wantFields: []pmail.FieldError{
+				{Field: "to", Message: "must be an array, not string"},
+			},
+		},
+		{
+			name:     "invalid json",
+			fileName: "job.json",
+			data:     `{"from": `,
+			wantFields: []pmail.FieldError{
+				{Message: "invalid job: unexpected EOF"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.fileName, []byte(tt.data))
+			if tt.wantFields != nil {
+				var rejected *pmail.RejectedError
+				require.ErrorAs(t, err, &rejected)
+				assert.Equal(t, tt.wantFields, rejected.Fields)
+				return
+			}
+			require.NoError(t, err)
+			gotTo := make([]string, 0)
+			for _, addr := range got.ToAddresses {
+				gotTo = append(gotTo, addr.String())
+			}
+			assert.Equal(t, tt.wantTo, gotTo)
+		})
+	}
+}
+
+func TestIsJobFile(t *testing.T) {
+	assert.True(t, IsJobFile("a.json"))
+	assert.True(t, IsJobFile("a.YAML"))
+	assert.True(t, IsJobFile("a.yml"))
+	assert.False(t, IsJobFile("a.eml"))
+	assert.False(t, IsJobFile("json"))
+}
+
+// TestSchema keeps the published schema in line with the fields of the job
+func TestSchema(t *testing.T) {
+	var schema struct {
+		Properties map[string]struct {
+			Items struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"items"`
+			Properties   map[string]any `json:"properties"`
+			PropertyName struct {
+				Not struct {
+					Enum []string `json:"enum"`
+				} `json:"not"`
+			} `json:"propertyNames"`
+			Enum []string `json:"enum"`
+		} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(Schema, &schema))
+
+	assert.ElementsMatch(t, jsonFields(reflect.TypeOf(Job{})), keys(schema.Properties))
+	assert.ElementsMatch(t, jsonFields(reflect.TypeOf(Attachment{})),
+		keys(schema.Properties["attachments"].Items.Properties))
+	assert.ElementsMatch(t, jsonFields(reflect.TypeOf(Options{})),
+		keys(schema.Properties["options"].Properties))
+	assert.Equal(t, ReservedHeaders, schema.Properties["headers"].PropertyName.Not.Enum)
+}
+
+func jsonFields(typ reflect.Type) []string {
+	result := make([]string, 0)
+	for i := range typ.NumField() {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name != "-" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	slices.Sort(result)
+	return result
+}
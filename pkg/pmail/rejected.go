@@ -0,0 +1,77 @@
+package pmail
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/mox/smtpclient"
+)
+
+const (
+	// RejectedCode is the response code of the fields of a rejected mail
+	RejectedCode = 554
+	// RejectedSecode is the enhanced status of the fields of a rejected mail,
+	// a syntax error of the message
+	RejectedSecode = "6.0"
+)
+
+// FieldError describes an invalid field of a mail definition
+type FieldError struct {
+	// Field is the path of the field, e.g. to[1] or attachments[0].path
+	Field string `json:"field"`
+	// Message explains why the field is invalid
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// RejectedError is returned for a mail definition that can never be sent,
+// the mail is not retried and its field errors are written to the output
+type RejectedError struct {
+	Fields []FieldError
+}
+
+func (e *RejectedError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, field.String())
+	}
+	return fmt.Sprintf("mail rejected: %s", strings.Join(fields, "; "))
+}
+
+// Responses returns a response per field error, keyed by the field
+func (e *RejectedError) Responses() map[string][]Response {
+	result := make(map[string][]Response, len(e.Fields))
+	for _, field := range e.Fields {
+		result[field.Field] = append(result[field.Field], Response{
+			Response: smtpclient.Response{
+				Permanent: true,
+				Code:      RejectedCode,
+				Secode:    RejectedSecode,
+				Line: fmt.Sprintf("%d 5.%s %s",
+					RejectedCode, RejectedSecode, field.String()),
+			},
+		})
+	}
+	return result
+}
+
+// TemporaryError is returned for a mail that could not be sent for now, e.g.
+// as a recipient was deferred or a lookup timed out. The mail is read again
+// rather than recorded as failed.
+type TemporaryError struct {
+	Err error
+}
+
+func (e *TemporaryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
@@ -21,6 +21,17 @@ type Mail struct {
 	// Value is the header value in raw bytes
 	HeadersMap map[string][]byte `json:"headers_map"`
 
+	// RawHeaders contains the header section as read from the input, with
+	// every occurrence of each header field in order, e.g. the ARC sets
+	// and Authentication-Results that HeadersMap cannot hold
+	RawHeaders []byte `json:"raw_headers"`
+
+	// Bcc are the recipients of To that are not listed in any header
+	Bcc []smtp.Address `json:"bcc"`
+
+	// Cc are the recipients of To that are listed in the Cc header
+	Cc []smtp.Address `json:"cc"`
+
 	// ContentType specifies the MIME type of the message
 	ContentType []byte `validate:"required" json:"content_type"`
 